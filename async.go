@@ -0,0 +1,143 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrPending is returned by Pending.Poll while the response is awaited.
+var ErrPending = errors.New("response pending")
+
+// Pending is a request started with GetAsync, GetNextAsync, GetBulkAsync
+// or SetAsync, whose result an event loop collects when it is ready
+// instead of blocking on it:
+//
+//	p := g.GetAsync(ctx, []string{".1.3.6.1.2.1.1.3.0"})
+//	for {
+//		select {
+//		case <-p.Done():
+//			result, err := p.Poll()
+//			...
+//		case ev := <-otherEvents:
+//			...
+//		}
+//	}
+//
+// Requests run with the timeout and retries of x, and several may be
+// pending at once on a GoSNMP connected with Connect(), see GoSNMP: they
+// share its socket and their responses are matched up as they arrive, so
+// a poller can have many in flight and gather them with Completions.
+type Pending struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	result *SnmpPacket
+	err    error
+}
+
+// GetAsync starts an SNMP GET request, as GetContext, and returns without
+// waiting for the response.
+func (x *GoSNMP) GetAsync(ctx context.Context, oids []string) *Pending {
+	return startPending(ctx, func(ctx context.Context) (*SnmpPacket, error) {
+		return x.GetContext(ctx, oids)
+	})
+}
+
+// GetNextAsync starts an SNMP GETNEXT request, as GetNextContext, and
+// returns without waiting for the response.
+func (x *GoSNMP) GetNextAsync(ctx context.Context, oids []string) *Pending {
+	return startPending(ctx, func(ctx context.Context) (*SnmpPacket, error) {
+		return x.GetNextContext(ctx, oids)
+	})
+}
+
+// GetBulkAsync starts an SNMP GETBULK request, as GetBulkContext, and
+// returns without waiting for the response.
+func (x *GoSNMP) GetBulkAsync(ctx context.Context, oids []string, nonRepeaters uint8, maxRepetitions uint32) *Pending {
+	return startPending(ctx, func(ctx context.Context) (*SnmpPacket, error) {
+		return x.GetBulkContext(ctx, oids, nonRepeaters, maxRepetitions)
+	})
+}
+
+// SetAsync starts an SNMP SET request, as SetContext, and returns without
+// waiting for the response.
+func (x *GoSNMP) SetAsync(ctx context.Context, pdus []SnmpPDU) *Pending {
+	return startPending(ctx, func(ctx context.Context) (*SnmpPacket, error) {
+		return x.SetContext(ctx, pdus)
+	})
+}
+
+func startPending(ctx context.Context, request func(context.Context) (*SnmpPacket, error)) *Pending {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Pending{done: make(chan struct{}), cancel: cancel}
+	go func() {
+		defer close(p.done)
+		defer cancel()
+		p.result, p.err = request(ctx)
+	}()
+	return p
+}
+
+// Done returns a channel closed once the result is ready.
+func (p *Pending) Done() <-chan struct{} {
+	return p.done
+}
+
+// Poll returns the result of the request without blocking, or ErrPending
+// if it is not ready yet.
+func (p *Pending) Poll() (*SnmpPacket, error) {
+	select {
+	case <-p.done:
+		return p.result, p.err
+	default:
+		return nil, ErrPending
+	}
+}
+
+// Wait blocks until the result is ready and returns it.
+func (p *Pending) Wait() (*SnmpPacket, error) {
+	<-p.done
+	return p.result, p.err
+}
+
+// Cancel abandons the request, whose result becomes the context's error
+// unless it was ready already.
+func (p *Pending) Cancel() {
+	p.cancel()
+}
+
+// Completions returns a channel receiving each of pending once its result
+// is ready, in the order they complete, which is closed after the last:
+//
+//	var batch []*gosnmp.Pending
+//	for _, oids := range chunks {
+//		batch = append(batch, g.GetAsync(ctx, oids))
+//	}
+//	for p := range gosnmp.Completions(batch...) {
+//		result, err := p.Wait()
+//		...
+//	}
+func Completions(pending ...*Pending) <-chan *Pending {
+	out := make(chan *Pending, len(pending))
+	var wg sync.WaitGroup
+	wg.Add(len(pending))
+	for _, p := range pending {
+		go func(p *Pending) {
+			defer wg.Done()
+			<-p.done
+			out <- p
+		}(p)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,82 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff is a delay between retries of a request. The zero value retries
+// immediately.
+//
+// The delay before retry n (counting from 1) is Base * Multiplier^(n-1),
+// capped at Max. With Jitter the delay is then shortened by a random
+// fraction of up to Jitter, so 1 gives "full jitter", a delay anywhere
+// between 0 and the computed value.
+type Backoff struct {
+	// Base is the delay before the first retry.
+	Base time.Duration
+
+	// Multiplier scales the delay after each retry. (default: 2)
+	Multiplier float64
+
+	// Max caps the delay. (default: no cap)
+	Max time.Duration
+
+	// Jitter is the randomized fraction of the delay, between 0 and 1.
+	Jitter float64
+}
+
+// maxBackoff is the longest delay, the largest float64 that converts to a
+// time.Duration: float64(math.MaxInt64) is 2^63, which overflows.
+const maxBackoff = float64(math.MaxInt64 - 1<<10)
+
+// delay returns the time to wait before retry number retry.
+func (b Backoff) delay(retry int) time.Duration {
+	if b.Base <= 0 || retry < 1 {
+		return 0
+	}
+	multiplier := b.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	} else if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64(b.Base) * math.Pow(multiplier, float64(retry-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	if jitter := math.Min(math.Max(b.Jitter, 0), 1); jitter > 0 {
+		d -= d * jitter * rand.Float64() //nolint:gosec
+	}
+	return time.Duration(d)
+}
+
+// wait sleeps before retry number retry. It returns early with ctx.Err()
+// when ctx is done, and with context.DeadlineExceeded straight away when
+// the delay would run past the deadline of ctx.
+func (b Backoff) wait(ctx context.Context, retry int) error {
+	d := b.delay(retry)
+	if d <= 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= d {
+		return context.DeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// demux lets concurrent requests share one socket. At most one of them
+// reads at a time, the reader; a message it receives for another request
+// is handed to that request by its ID (request-id for SNMPv1/v2c, msgID
+// for SNMPv3). When the reader is done another waiter takes over, so every
+// read runs under the deadline of the request doing it.
+type demux struct {
+	mu      sync.Mutex
+	reading bool
+	idle    chan struct{} // closed when the reader steps down
+	waiters map[uint32]chan []byte
+}
+
+func newDemux() *demux {
+	return &demux{
+		idle:    make(chan struct{}),
+		waiters: make(map[uint32]chan []byte),
+	}
+}
+
+func (m *demux) register(ids []uint32, ch chan []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		m.waiters[id] = ch
+	}
+}
+
+func (m *demux) unregister(ids []uint32, ch chan []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		if m.waiters[id] == ch {
+			delete(m.waiters, id)
+		}
+	}
+}
+
+// stepDown ends the current read and wakes the waiters. Called with mu held.
+func (m *demux) stepDown() {
+	m.reading = false
+	close(m.idle)
+	m.idle = make(chan struct{})
+}
+
+// receiveFor returns the next message whose ID is in ids, or any message
+// with an ID nobody is waiting for, which the caller then discards as out
+// of order. deadline bounds the wait.
+func (x *GoSNMP) receiveFor(ctx context.Context, ids []uint32, deadline time.Time) ([]byte, error) {
+	m := x.mux
+	if m == nil {
+		// not set up by Connect(): a single request owns the socket
+		if err := x.Conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return x.receive()
+	}
+
+	ch := make(chan []byte, 1)
+	m.register(ids, ch)
+	defer m.unregister(ids, ch)
+
+	for {
+		m.mu.Lock()
+		select {
+		case resp := <-ch:
+			m.mu.Unlock()
+			return resp, nil
+		default:
+		}
+		if m.reading {
+			idle := m.idle
+			m.mu.Unlock()
+			timer := time.NewTimer(time.Until(deadline))
+			select {
+			case resp := <-ch:
+				timer.Stop()
+				return resp, nil
+			case <-idle:
+				timer.Stop()
+				continue
+			case <-timer.C:
+				return nil, fmt.Errorf("Failed to read from socket: %w", os.ErrDeadlineExceeded)
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+		m.reading = true
+		m.mu.Unlock()
+
+		resp, err := x.readFor(ctx, deadline)
+
+		m.mu.Lock()
+		m.stepDown()
+		if err == nil {
+			if id, ok := peekMessageID(resp); ok {
+				if w := m.waiters[id]; w != nil && w != ch {
+					select {
+					case w <- resp:
+					default:
+					}
+					m.mu.Unlock()
+					continue
+				}
+			}
+		}
+		m.mu.Unlock()
+
+		// Another request pulled the read deadline in (its context was
+		// canceled), but ours has not passed yet. On a stream the octets
+		// of a message read in part are kept for the next read.
+		if errors.Is(err, os.ErrDeadlineExceeded) && ctx.Err() == nil && time.Now().Before(deadline) {
+			continue
+		}
+		return resp, err
+	}
+}
+
+// readFor reads one message from the socket under deadline.
+func (x *GoSNMP) readFor(ctx context.Context, deadline time.Time) ([]byte, error) {
+	if err := x.Conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	// ctx may have been canceled while the deadline was being set, in
+	// which case the watcher's deadline was just overwritten.
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return x.receive()
+}
+
+// clearReadDeadline clears the deadline a canceled request pulled into the
+// past on conn, unless another request is reading from it: that one sets
+// its own deadline again once its read is interrupted, see receiveFor.
+func (x *GoSNMP) clearReadDeadline(conn net.Conn) {
+	if m := x.mux; m != nil {
+		// nobody starts reading while mu is held
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.reading {
+			return
+		}
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+}
+
+// peekMessageID returns the msgID of an SNMPv3 message or the request-id of
+// an SNMPv1/v2c one without decoding the rest of it.
+func peekMessageID(msg []byte) (uint32, bool) {
+	// enter steps into a constructed value
+	enter := func(b []byte) ([]byte, bool) {
+		if len(b) < 2 {
+			return nil, false
+		}
+		_, hdr, err := parseLength(b)
+		if err != nil || hdr >= len(b) {
+			return nil, false
+		}
+		return b[hdr:], true
+	}
+	// skip steps over a value
+	skip := func(b []byte) ([]byte, bool) {
+		if len(b) < 2 {
+			return nil, false
+		}
+		total, _, err := parseLength(b)
+		if err != nil || total > len(b) {
+			return nil, false
+		}
+		return b[total:], true
+	}
+	integer := func(b []byte) (int64, bool) {
+		if len(b) < 2 || b[0] != byte(Integer) {
+			return 0, false
+		}
+		total, hdr, err := parseLength(b)
+		if err != nil || total > len(b) {
+			return 0, false
+		}
+		v, err := parseInt64(b[hdr:total])
+		return v, err == nil
+	}
+
+	if len(msg) == 0 || msg[0] != byte(Sequence) {
+		return 0, false
+	}
+	b, ok := enter(msg)
+	if !ok {
+		return 0, false
+	}
+	version, ok := integer(b)
+	if !ok {
+		return 0, false
+	}
+	if b, ok = skip(b); !ok {
+		return 0, false
+	}
+	if SnmpVersion(version) == Version3 {
+		// msgGlobalData starts with msgID
+		if b, ok = enter(b); !ok {
+			return 0, false
+		}
+	} else {
+		// community, then the PDU starting with request-id
+		if b, ok = skip(b); !ok {
+			return 0, false
+		}
+		if b, ok = enter(b); !ok {
+			return 0, false
+		}
+	}
+	id, ok := integer(b)
+	return uint32(id), ok
+}
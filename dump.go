@@ -0,0 +1,131 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// PacketDirection tells OnPacket whether a message was sent or received.
+type PacketDirection int
+
+const (
+	PacketSent PacketDirection = iota
+	PacketReceived
+)
+
+func (d PacketDirection) String() string {
+	if d == PacketReceived {
+		return "received"
+	}
+	return "sent"
+}
+
+// onPacket passes msg to the OnPacket hook, if set.
+func (x *GoSNMP) onPacket(dir PacketDirection, msg []byte) {
+	if x.OnPacket != nil {
+		x.OnPacket(x, dir, msg)
+	}
+}
+
+// DumpBER returns the BER structure of msg, eg a message captured with
+// OnPacket, one element per line and indented by nesting:
+//
+//	Sequence (41)
+//	  Integer 1
+//	  OctetString "public"
+//	  GetResponse (28)
+//	    Integer 1234
+//	    Integer 0
+//	    Integer 0
+//	    Sequence (16)
+//	      Sequence (14)
+//	        ObjectIdentifier .1.3.6.1.2.1.1.3.0
+//	        TimeTicks 4200
+//
+// Constructed elements show the length of their contents. Octet strings
+// that are not printable are shown in hex, as is what follows an element
+// that does not parse, after a line saying what is wrong with it.
+func DumpBER(msg []byte) string {
+	var b strings.Builder
+	dumpBER(&b, msg, 0)
+	return b.String()
+}
+
+func dumpBER(b *strings.Builder, data []byte, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for len(data) > 0 {
+		tag := data[0]
+		total, hdr, err := parseLength(data)
+		if err == nil && (total > len(data) || hdr > total) {
+			err = fmt.Errorf("length %d overruns the %d octets left", total, len(data))
+		}
+		if err != nil {
+			fmt.Fprintf(b, "%smalformed: %v: % X\n", indent, err, data)
+			return
+		}
+		content := data[hdr:total]
+		data = data[total:]
+
+		if tag == byte(Sequence) || tag&0x20 != 0 {
+			name := PDUType(tag).String()
+			if tag != byte(Sequence) && (tag < byte(GetRequest) || tag > byte(Report)) {
+				name = fmt.Sprintf("Constructed(0x%02x)", tag)
+			}
+			fmt.Fprintf(b, "%s%s (%d)\n", indent, name, len(content))
+			dumpBER(b, content, depth+1)
+			continue
+		}
+		fmt.Fprintf(b, "%s%s%s\n", indent, Asn1BER(tag), dumpValue(Asn1BER(tag), content))
+	}
+}
+
+// dumpValue formats the content of a primitive element, with a leading
+// space unless there is nothing to show.
+func dumpValue(tag Asn1BER, content []byte) string {
+	var v interface{}
+	var err error
+	switch tag {
+	case Null, NoSuchObject, NoSuchInstance, EndOfMibView:
+		if len(content) == 0 {
+			return ""
+		}
+	case Integer:
+		v, err = parseInt64(content)
+	case Counter32, Gauge32, TimeTicks, Uinteger32:
+		v, err = parseUint(content)
+	case Counter64:
+		v, err = parseUint64(content)
+	case ObjectIdentifier:
+		v, err = parseObjectIdentifier(content)
+	case IPAddress:
+		if len(content) == net.IPv4len || len(content) == net.IPv6len {
+			v = net.IP(content).String()
+		}
+	case OctetString:
+		if printable(content) {
+			v = fmt.Sprintf("%q", content)
+		}
+	}
+	if v == nil || err != nil {
+		return fmt.Sprintf(" % X", content)
+	}
+	return fmt.Sprint(" ", v)
+}
+
+// printable reports whether b is non-empty text worth showing as a string.
+func printable(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if (c < 0x20 || c > 0x7e) && c != '\t' && c != '\n' && c != '\r' {
+			return false
+		}
+	}
+	return true
+}
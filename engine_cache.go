@@ -0,0 +1,124 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"sync"
+	"time"
+)
+
+// EngineCache shares the results of SNMPv3 engine discovery between GoSNMP
+// values, so that connections to an agent after the first one skip the
+// discovery round trip. Set the same EngineCache on every GoSNMP that
+// should share it; it is safe for concurrent use.
+//
+// Entries are keyed by transport and agent address and hold the
+// authoritative snmpEngineID, snmpEngineBoots and snmpEngineTime last seen
+// from the agent, the time advancing with the local clock as in RFC 3414
+// 2.3. They are replaced whenever a response carries different values, eg
+// after the agent restarted and reports a new boots value, and dropped when
+// a request relying on them fails.
+type EngineCache struct {
+	mu      sync.Mutex
+	engines map[string]cachedEngine
+}
+
+type cachedEngine struct {
+	engineID string
+	boots    uint32
+	time     uint32
+	seen     time.Time
+}
+
+// NewEngineCache returns an empty EngineCache.
+func NewEngineCache() *EngineCache {
+	return &EngineCache{engines: make(map[string]cachedEngine)}
+}
+
+// Len returns the number of agents in the cache.
+func (c *EngineCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.engines)
+}
+
+func (c *EngineCache) get(key string) (cachedEngine, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.engines[key]
+	if ok {
+		if elapsed := time.Since(e.seen) / time.Second; elapsed > 0 {
+			e.time += uint32(elapsed)
+		}
+	}
+	return e, ok
+}
+
+func (c *EngineCache) put(key string, e cachedEngine) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.engines[key] = e
+}
+
+func (c *EngineCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.engines, key)
+}
+
+func (x *GoSNMP) engineCacheKey() string {
+	return x.Transport + "://" + x.targetAddr()
+}
+
+// loadCachedEngine fills in the USM engine parameters of x and packetOut
+// from x.EngineCache, reporting whether it did.
+func (x *GoSNMP) loadCachedEngine(packetOut *SnmpPacket) (bool, error) {
+	if x.EngineCache == nil {
+		return false, nil
+	}
+	usm, ok := packetOut.SecurityParameters.(*UsmSecurityParameters)
+	if !ok || usm.AuthoritativeEngineID != "" {
+		return false, nil
+	}
+	e, ok := x.EngineCache.get(x.engineCacheKey())
+	if !ok {
+		return false, nil
+	}
+	x.Logger.Printf("using cached engine for %s", x.engineCacheKey())
+	cached := &UsmSecurityParameters{
+		AuthoritativeEngineID:    e.engineID,
+		AuthoritativeEngineBoots: e.boots,
+		AuthoritativeEngineTime:  e.time,
+	}
+	if x.ContextEngineID == "" {
+		x.ContextEngineID = e.engineID
+	}
+	if err := x.SecurityParameters.setSecurityParameters(cached); err != nil {
+		return false, err
+	}
+	return true, x.updatePktSecurityParameters(packetOut)
+}
+
+// storeCachedEngine records the USM engine parameters of x in x.EngineCache.
+func (x *GoSNMP) storeCachedEngine() {
+	if x.EngineCache == nil {
+		return
+	}
+	usm, ok := x.SecurityParameters.(*UsmSecurityParameters)
+	if !ok {
+		return
+	}
+	usm.mu.Lock()
+	e := cachedEngine{
+		engineID: usm.AuthoritativeEngineID,
+		boots:    usm.AuthoritativeEngineBoots,
+		time:     usm.AuthoritativeEngineTime,
+		seen:     time.Now(),
+	}
+	usm.mu.Unlock()
+	if e.engineID != "" {
+		x.EngineCache.put(x.engineCacheKey(), e)
+	}
+}
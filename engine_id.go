@@ -0,0 +1,91 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+)
+
+// netSNMPEnterprise is the enterprise number of the engine IDs made up for
+// traps, net-snmp's, as its snmptrap uses.
+const netSNMPEnterprise = 8072
+
+// snmpEngineID formats of RFC 3411, the octet following the enterprise
+// number.
+const (
+	engineIDIPv4 = 1
+	engineIDIPv6 = 2
+	engineIDMAC  = 3
+	engineIDText = 4
+)
+
+// EngineIDFromIP returns the RFC 3411 snmpEngineID of enterprise, the
+// private enterprise number of the engine's vendor, and ip, eg for
+// UsmSecurityParameters.AuthoritativeEngineID when sending traps.
+func EngineIDFromIP(enterprise uint32, ip net.IP) (string, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return newEngineID(enterprise, engineIDIPv4, ip4)
+	}
+	if len(ip) != net.IPv6len {
+		return "", fmt.Errorf("invalid IP address %v for an engine ID", ip)
+	}
+	return newEngineID(enterprise, engineIDIPv6, ip)
+}
+
+// EngineIDFromMAC returns the RFC 3411 snmpEngineID of enterprise and mac,
+// a 48 bit MAC address.
+func EngineIDFromMAC(enterprise uint32, mac net.HardwareAddr) (string, error) {
+	if len(mac) != 6 {
+		return "", fmt.Errorf("invalid MAC address %v for an engine ID", mac)
+	}
+	return newEngineID(enterprise, engineIDMAC, mac)
+}
+
+// EngineIDFromText returns the RFC 3411 snmpEngineID of enterprise and
+// text, of 1 to 27 octets.
+func EngineIDFromText(enterprise uint32, text string) (string, error) {
+	if len(text) == 0 || len(text) > 27 {
+		return "", fmt.Errorf("engine ID text must be 1 to 27 octets, not %d", len(text))
+	}
+	return newEngineID(enterprise, engineIDText, []byte(text))
+}
+
+func newEngineID(enterprise uint32, format byte, data []byte) (string, error) {
+	if enterprise >= 1<<31 {
+		return "", fmt.Errorf("enterprise number %d is out of range", enterprise)
+	}
+	id := make([]byte, 5, 5+len(data))
+	// the first bit set marks the RFC 3411 format
+	binary.BigEndian.PutUint32(id, enterprise|1<<31)
+	id[4] = format
+	return string(append(id, data...)), nil
+}
+
+// defaultTrapEngineID returns the engine ID x originates traps with when none is
+// set: that of the local address of x.Conn, or of the host name when
+// the socket is not bound to one.
+func (x *GoSNMP) defaultTrapEngineID() (string, error) {
+	var ip net.IP
+	switch addr := x.Conn.LocalAddr().(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	}
+	if ip != nil && !ip.IsUnspecified() {
+		return EngineIDFromIP(netSNMPEnterprise, ip)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	if len(host) > 27 {
+		host = host[:27]
+	}
+	return EngineIDFromText(netSNMPEnterprise, host)
+}
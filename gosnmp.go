@@ -11,11 +11,15 @@ package gosnmp
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/big"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -42,23 +46,43 @@ const (
 	// "udp" and "tcp" are used regularly, prevent 'goconst' complaints
 	udp = "udp"
 	tcp = "tcp"
+
+	// tlsTransport is TLS over TCP (RFC 6353)
+	tlsTransport = "tls"
 )
 
 // GoSNMP represents GoSNMP library state.
+//
+// Once connected with Connect(), a GoSNMP may be used by several goroutines
+// at once: requests share the socket and each response is matched to its
+// request by request-id (msgID for SNMPv3). Changing the exported fields
+// while requests are in flight is not safe, and SNMPv3 engine discovery
+// should complete (one request) before requests are run concurrently.
 type GoSNMP struct {
-	// Conn is net connection to use, typically established using GoSNMP.Connect().
+	// Conn is net connection to use, typically established using GoSNMP.Connect()
+	// or supplied with GoSNMP.ConnectConn().
 	Conn net.Conn
 
-	// Target is an ipv4 address.
+	// Target is the agent's host name or IP address. IPv6 literals may be
+	// given bare ("::1") or bracketed ("[::1]").
 	Target string
 
 	// Port is a port.
 	Port uint16
 
-	// Transport is the transport protocol to use ("udp" or "tcp"); if unset "udp" will be used.
+	// Transport is the transport protocol to use ("udp", "tcp" or "tls"); if unset "udp" will be used.
+	// Over TCP one connection is reused for all requests and messages are
+	// framed by their outer BER length (RFC 3430). "tls" is TLS over TCP
+	// (RFC 6353), normally with SecurityModel TransportSecurityModel.
 	Transport string
 
-	// Community is an SNMP Community string.
+	// TLSConfig configures the "tls" transport: client certificates for
+	// mutual authentication, root CAs, ServerName and so on.
+	TLSConfig *tls.Config
+
+	// Community is an SNMP Community string. It is sent as the octets of
+	// the string, which may be any, eg string(b) for a community of
+	// non-ASCII octets b; see WithCommunityBytes.
 	Community string
 
 	// Version is an SNMP Version.
@@ -68,14 +92,61 @@ type GoSNMP struct {
 	Context context.Context
 
 	// Timeout is the timeout for one SNMP request/response.
+	//
+	// With its Retries it bounds how long a request takes, SNMPv3 engine
+	// discovery and the retransmission after a report included: each has
+	// only the time those before it left. With DiscoveryRetries set the
+	// budget of discovery adds to it, see there.
 	Timeout time.Duration
 
 	// Set the number of retries to attempt.
 	Retries int
 
+	// DiscoveryRetries, if above 0, is the number of retries of SNMPv3
+	// engine discovery instead of Retries, eg to retry the probes a flaky
+	// agent drops while not retrying a Set. Discovery then has a timeout
+	// budget of its own, by Timeout and DiscoveryRetries, and a request
+	// needing discovery may take it and the request's budget together:
+	// with a 1s Timeout, DiscoveryRetries 2 and Retries 0, up to 4s
+	// without ExponentialTimeout.
+	// (default: 0, Retries applies)
+	DiscoveryRetries int
+
 	// Double timeout in each retry.
 	ExponentialTimeout bool
 
+	// RetryBackoff is the delay between retries, eg
+	// Backoff{Base: 100 * time.Millisecond, Max: 2 * time.Second, Jitter: 1}.
+	// The delay never runs past the deadline of the request's context.
+	// (default: retry immediately)
+	RetryBackoff Backoff
+
+	// RateLimiter, when set, delays each message sent, retries included,
+	// to keep to its rate. See NewRateLimiter and WithRateLimit.
+	RateLimiter *RateLimiter
+
+	// AutoReconnect closes and re-dials the connection once when a request
+	// fails with a socket error (not a timeout), eg after the local address
+	// went away, and then repeats the request. SNMPv3 engine discovery is
+	// run again on the new connection. Requests running concurrently on the
+	// old connection fail. (default: false)
+	AutoReconnect bool
+
+	// LenientDecoding keeps a response, or a trap, of which some variables
+	// do not decode, eg from a buggy agent, instead of failing it. Those
+	// variables get Type UnknownType and a *VarbindError Value, and the
+	// packet's DecodeErr reports them. A message still fails if its
+	// structure is broken. (default: false)
+	LenientDecoding bool
+
+	// InsecureSkipAuthVerify keeps SNMPv3 responses and traps whose
+	// authentication digest does not verify, with their AuthErr set and a
+	// warning logged, instead of discarding them, eg to confirm that only
+	// the passphrase or the localized key is wrong. Anyone can then forge
+	// messages, so set it only to troubleshoot. Encrypted messages still
+	// fail if the privacy key is wrong. (default: false)
+	InsecureSkipAuthVerify bool
+
 	// Logger is the GoSNMP.Logger to use for debugging.
 	// For verbose logging to stdout:
 	// x.Logger = NewLogger(log.New(os.Stdout, "", 0))
@@ -99,23 +170,85 @@ type GoSNMP struct {
 	// OnRetry is called when a retry attempt is done.
 	OnRetry func(*GoSNMP)
 
+	// OnRetryAttempt is called before each retry of a request with the
+	// number of the attempt about to be sent, 2 for the first retry, and
+	// the error that failed the one before, eg to count requests that only
+	// succeed after retries. Unlike OnRetry it is not called once the
+	// retries are used up.
+	OnRetryAttempt func(x *GoSNMP, attempt int, lastErr error)
+
 	// OnFinish is called when the request completed.
 	OnFinish func(*GoSNMP)
 
+	// OnResponse is called when a request expecting a response completes,
+	// with the request, its round-trip latency (see SnmpPacket.Latency) and
+	// the error if it failed, in which case latency is the time since the
+	// request was first sent. Requests of walks, split Gets and SNMPv3
+	// discovery are reported one by one.
+	OnResponse func(x *GoSNMP, request *SnmpPacket, latency time.Duration, err error)
+
+	// OnPacket is called with the wire bytes of each message sent and of
+	// each one received, before it is decoded, eg to capture messages that
+	// fail to decode for DumpBER. It must not modify b.
+	OnPacket func(x *GoSNMP, dir PacketDirection, b []byte)
+
+	// Metrics, if set, counts requests, retries, timeouts and
+	// authentication failures and observes latencies.
+	Metrics Metrics
+
 	// MaxOids is the maximum number of oids allowed in a Get().
 	// (default: MaxOids)
 	MaxOids int
 
+	// MaxPDUSize, when set, is the largest Get request message in octets.
+	// Get then splits its oids over as many requests as needed to stay
+	// within MaxPDUSize and MaxOids instead of failing with more than
+	// MaxOids, resends requests answered with tooBig in smaller parts, and
+	// merges the results in order. Lower it for agents with small MTUs or
+	// buffers. For SNMPv3 an allowance is made for the header and security
+	// parameters. (default: 0, Get sends a single request)
+	MaxPDUSize int
+
+	// MaxMsgSize is the msgMaxSize advertised in SNMPv3 requests: the
+	// largest response the agent may send. Lower it below the path MTU for
+	// agents behind tunnels. It and the msgMaxSize the agent advertises
+	// also cap the max-repetitions of BulkWalk*, estimated from the size
+	// of the variables received so far. (default: 0, 65535 octets)
+	MaxMsgSize uint32
+
 	// MaxRepetitions sets the GETBULK max-repetitions used by BulkWalk*
 	// Unless MaxRepetitions is specified it will use defaultMaxRepetitions (50)
 	// This may cause issues with some devices, if so set MaxRepetitions lower.
 	// See comments in https://github.com/gosnmp/gosnmp/issues/100
 	MaxRepetitions uint32
 
+	// AdaptiveBulkSize, when set, makes BulkWalk* adapt max-repetitions to
+	// the responses, aiming for responses of up to AdaptiveBulkSize octets,
+	// eg 1400 to stay within an Ethernet MTU without fragmenting. After
+	// each response max-repetitions is set to as many variables of its size
+	// as fit, at most twice the last and at most MaxRepetitions. On tooBig
+	// it is halved and kept below the value that failed for the rest of the
+	// walk. Each walk starts from MaxRepetitions and adapts on its own, so
+	// concurrent walks of a GoSNMP do not affect one another; the latest
+	// value is reported by BulkRepetitions. (default: 0, MaxRepetitions is
+	// used throughout)
+	AdaptiveBulkSize int
+
 	// NonRepeaters sets the GETBULK max-repeaters used by BulkWalk*.
 	// (default: 0 as per RFC 1905)
 	NonRepeaters int
 
+	// OIDCheck is what Walk*, BulkWalk* and WalkRange* do when the agent
+	// answers with an OID that does not come after the one before it.
+	// (default: OIDCheckStrict, fail with ErrOIDNotIncreasing)
+	OIDCheck OIDCheckPolicy
+
+	// OIDFormat is how the names of the variables of responses to Get*,
+	// Set and the walks are written, eg OIDNoLeadingDot to match a MIB
+	// database keyed without the dot. Traps received are not affected.
+	// (default: OIDLeadingDot)
+	OIDFormat OIDFormat
+
 	// UseUnconnectedUDPSocket if set, changes net.Conn to be unconnected UDP socket.
 	// Some multi-homed network gear isn't smart enough to send SNMP responses
 	// from the address it received the requests on. To work around that,
@@ -133,20 +266,29 @@ type GoSNMP struct {
 	// LocalAddr is the local address in the format "address:port" to use when connecting an Target address.
 	// If the port parameter is empty or "0", as in
 	// "127.0.0.1:" or "[::1]:0", a port number is automatically (random) chosen.
+	// The port may also be left out, as in "192.0.2.10" or "::1", to only pick
+	// the source address (and so the interface) on a multi-homed host.
 	LocalAddr string
 
 	// netsnmp has '-C APPOPTS - set various application specific behaviours'
 	//
 	// - 'c: do not check returned OIDs are increasing' - use AppOpts = map[string]interface{"c":true} with
 	//   Walk() or BulkWalk(). The library user needs to implement their own policy for terminating walks.
+	//   It is the same as OIDCheck OIDCheckOff.
 	// - 'p,i,I,t,E' -> pull requests welcome
 	AppOpts map[string]interface{}
 
+	// Rand is the source of the random first request-id and msgID. Set it,
+	// eg to a seeded math/rand.Rand, only for reproducible packets in tests.
+	// (default: crypto/rand)
+	Rand io.Reader
+
 	// Internal - used to sync requests to responses.
 	requestID uint32
 	random    uint32
 
-	rxBuf *[rxBufSize]byte // has to be pointer due to https://github.com/golang/go/issues/11728
+	rxBuf  *[rxBufSize]byte // has to be pointer due to https://github.com/golang/go/issues/11728
+	stream *streamBuffer    // of a stream transport, see readStreamMessage
 
 	// MsgFlags is an SNMPV3 MsgFlags.
 	MsgFlags SnmpV3MsgFlags
@@ -157,19 +299,47 @@ type GoSNMP struct {
 	// SecurityParameters is an SNMPV3 Security Model parameters struct.
 	SecurityParameters SnmpV3SecurityParameters
 
-	// ContextEngineID is SNMPV3 ContextEngineID in ScopedPDU.
+	// ContextEngineID is SNMPV3 ContextEngineID in ScopedPDU. It defaults to
+	// the authoritative engine ID learnt by discovery; set it to reach a
+	// context of another engine through a proxy agent.
 	ContextEngineID string
 
-	// ContextName is SNMPV3 ContextName in ScopedPDU
+	// ContextName is SNMPV3 ContextName in ScopedPDU, selecting one of the
+	// agent's contexts. It is read for every request, so one session can
+	// poll several contexts in turn.
 	ContextName string
 
+	// EngineCache, when set, shares SNMPv3 engine discovery with the other
+	// GoSNMP values using the same cache (USM only).
+	EngineCache *EngineCache
+
 	// Internal - used to sync requests to responses - snmpv3.
 	msgID uint32
 
+	// Internal - the msgMaxSize of the agent's last SNMPv3 response, 0
+	// until one arrives.
+	agentMaxMsgSize uint32
+
+	// Internal - the max-repetitions of the last GetBulk of BulkWalk*.
+	bulkRepetitions uint32
+
 	// Internal - we use to send packets if using unconnected socket.
 	uaddr *net.UDPAddr
 
-	// bk support for multi-user
+	// Internal - dispatches responses to concurrent requests, see receiveFor.
+	mux *demux
+
+	// Internal - set by Close, cleared by Connect.
+	closed uint32
+
+	// Internal - held while Connect, a reconnect or Close replaces or
+	// closes Conn.
+	connMu sync.Mutex
+
+	// BkUsmMap holds the SNMPv3 users a receiver accepts, keyed by user name
+	// and authoritative engine ID. When it is not empty incoming messages
+	// are verified and decrypted with the matching entry and messages from
+	// users or engines not in it are rejected.
 	BkUsmMap BkUsmMap
 }
 
@@ -206,13 +376,30 @@ func (bm *BkUsmMap) GetBkUsmByUserNameEngineID(username, engineID string) *BkUsm
 	if bm.usmMap == nil {
 		return nil
 	}
-	bm.mtx.Lock()
-	defer bm.mtx.Unlock()
+	bm.mtx.RLock()
+	defer bm.mtx.RUnlock()
 	key := username + bm.ParseEngineIDToStr(engineID)
 	return bm.usmMap[key]
 }
 
-// Add BkUsm to BkUsmMap
+// hasUserName reports whether username is configured for any engine.
+func (bm *BkUsmMap) hasUserName(username string) bool {
+	if bm.usmMap == nil {
+		return false
+	}
+	bm.mtx.RLock()
+	defer bm.mtx.RUnlock()
+	for _, bkUsm := range bm.usmMap {
+		if bkUsm.UserName == username {
+			return true
+		}
+	}
+	return false
+}
+
+// AddBkUsm adds sp, the credentials of user sp.UserName at engine
+// sp.AuthoritativeEngineID, to the table. MsgFlag is the security level
+// messages from that user must have.
 func (bm *BkUsmMap) AddBkUsm(MsgFlag SnmpV3MsgFlags, sp *UsmSecurityParameters) error {
 	if bm.mtx == nil {
 		bm.mtx = new(sync.RWMutex)
@@ -222,8 +409,7 @@ func (bm *BkUsmMap) AddBkUsm(MsgFlag SnmpV3MsgFlags, sp *UsmSecurityParameters)
 	if bm.usmMap == nil {
 		bm.usmMap = make(map[string]*BkUsm)
 	}
-	// key := sp.UserName + bm.ParseEngineIDToStr(sp.AuthoritativeEngineID)
-	key := sp.UserName + sp.AuthoritativeEngineID
+	key := sp.UserName + bm.ParseEngineIDToStr(sp.AuthoritativeEngineID)
 	if MsgFlag == NoAuthNoPriv {
 		sp.AuthenticationProtocol = NoAuth
 		sp.PrivacyProtocol = NoPriv
@@ -276,10 +462,36 @@ type SnmpPDU struct {
 	// Name is an oid in string format eg ".1.3.6.1.4.9.27"
 	Name string
 
-	// The type of the value eg Integer
+	// The type of the value eg Integer. In responses it may instead be one
+	// of the SNMPv2 exceptions NoSuchObject, NoSuchInstance or EndOfMibView
+	// (RFC 3416 3), which have a nil Value, or a tag gosnmp does not know,
+	// whose Value is then the content octets as a []byte.
+	//
+	// Decoded integers keep the type the agent sent: a Counter32, Gauge32
+	// or TimeTicks has a uint Value and a Counter64 a uint64, so check Type
+	// (or IsCounter) rather than the Value to tell counters, which wrap,
+	// from gauges, which do not.
 	Type Asn1BER
 }
 
+// IsException reports whether pdu holds one of the SNMPv2 exceptions
+// NoSuchObject, NoSuchInstance or EndOfMibView instead of a value.
+func (pdu SnmpPDU) IsException() bool {
+	switch pdu.Type {
+	case NoSuchObject, NoSuchInstance, EndOfMibView:
+		return true
+	}
+	return false
+}
+
+// IsCounter reports whether pdu is a Counter32 or Counter64, whose value
+// only increases, wrapping to 0 past 2^32-1 or 2^64-1 (RFC 2578 7.1.6,
+// 7.1.10): rates are the difference of two readings modulo that, whereas
+// a Gauge32 or Integer reading is the value itself.
+func (pdu SnmpPDU) IsCounter() bool {
+	return pdu.Type == Counter32 || pdu.Type == Counter64
+}
+
 const AsnContext = 0x80
 const AsnExtensionID = 0x1F
 const AsnExtensionTag = (AsnContext | AsnExtensionID) // 0x9F
@@ -343,6 +555,113 @@ const (
 	InconsistentName                     // The name in a variable binding specifies a variable that does not exist.
 )
 
+// Error makes an SNMPError usable with errors.Is, as the target to match a
+// *RequestError against. It returns the name of e.
+func (e SNMPError) Error() string {
+	return e.String()
+}
+
+// RequestError is the error-status and error-index of a response reporting
+// that the agent failed the request, see SnmpPacket.Err.
+type RequestError struct {
+	Status SNMPError
+
+	// Index is the position, from 1, of the variable the error is about
+	// in the request, or 0 if it is not about one.
+	Index int
+
+	// Name is the OID of that variable, if the response carries it.
+	Name string
+}
+
+// Unwrap returns the error-status, so errors.Is(err, WrongValue) reports
+// whether the agent failed a request with wrongValue.
+func (e *RequestError) Unwrap() error {
+	return e.Status
+}
+
+func (e *RequestError) Error() string {
+	switch {
+	case e.Name != "":
+		return fmt.Sprintf("agent reported %s for %s (variable %d)", e.Status, e.Name, e.Index)
+	case e.Index > 0:
+		return fmt.Sprintf("agent reported %s for variable %d", e.Status, e.Index)
+	}
+	return fmt.Sprintf("agent reported %s", e.Status)
+}
+
+// Err returns a *RequestError if packet, a response, reports an
+// error-status, and nil for NoError:
+//
+//	result, err := g.Set(pdus)
+//	if err == nil {
+//		err = result.Err()
+//	}
+//	var reqErr *gosnmp.RequestError
+//	if errors.As(err, &reqErr) && reqErr.Status == gosnmp.WrongValue {
+//		log.Printf("%s was rejected", reqErr.Name)
+//	}
+func (packet *SnmpPacket) Err() error {
+	if packet.Error == NoError {
+		return nil
+	}
+	e := &RequestError{Status: packet.Error, Index: int(packet.ErrorIndex)}
+	if pdu, ok := packet.ErrorVariable(); ok {
+		e.Name = pdu.Name
+	}
+	return e
+}
+
+// ErrorVariable returns the variable the error-index of packet, a response,
+// points at. Agents return the variables of a failed request unchanged, so
+// this is the offending variable of the request.
+func (packet *SnmpPacket) ErrorVariable() (SnmpPDU, bool) {
+	i := int(packet.ErrorIndex)
+	if packet.Error == NoError || i < 1 || i > len(packet.Variables) {
+		return SnmpPDU{}, false
+	}
+	return packet.Variables[i-1], true
+}
+
+// VarbindError is the Value of a variable that did not decode, kept with
+// LenientDecoding.
+type VarbindError struct {
+	// Index is the position of the variable in the packet, from 1.
+	Index int
+
+	// Name is the OID of the variable, if that much decoded.
+	Name string
+
+	// Raw is the BER encoding of the whole VarBind.
+	Raw []byte
+
+	Err error
+}
+
+func (e *VarbindError) Unwrap() error {
+	return e.Err
+}
+
+func (e *VarbindError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("variable %d (%s): %v", e.Index, e.Name, e.Err)
+	}
+	return fmt.Sprintf("variable %d: %v", e.Index, e.Err)
+}
+
+// DecodeErr returns the *VarbindError of each variable of packet that did
+// not decode, joined, or nil if they all did. Only packets decoded with
+// LenientDecoding have any.
+func (packet *SnmpPacket) DecodeErr() error {
+	var errs []error
+	for _, pdu := range packet.Variables {
+		if e, ok := pdu.Value.(*VarbindError); ok {
+			errs = append(errs, e)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 //
 // Public Functions (main interface)
 //
@@ -357,6 +676,28 @@ func (x *GoSNMP) Connect() error {
 	return x.connect("")
 }
 
+// ErrClosed is returned by requests made on, or in flight during, a Close.
+var ErrClosed = errors.New("gosnmp: connection closed")
+
+// Close closes the connection. Requests in flight, including walks, return
+// ErrClosed rather than waiting for their timeout, as do requests made
+// afterwards until the next Connect. Calling Close again does nothing.
+func (x *GoSNMP) Close() error {
+	if !atomic.CompareAndSwapUint32(&x.closed, 0, 1) {
+		return nil
+	}
+	x.connMu.Lock()
+	defer x.connMu.Unlock()
+	if x.Conn == nil {
+		return nil
+	}
+	return x.Conn.Close()
+}
+
+func (x *GoSNMP) isClosed() bool {
+	return atomic.LoadUint32(&x.closed) == 1
+}
+
 // ConnectIPv4 forces an IPv4-only connection
 func (x *GoSNMP) ConnectIPv4() error {
 	return x.connect("4")
@@ -379,13 +720,41 @@ func (x *GoSNMP) connect(networkSuffix string) error {
 		return err
 	}
 
+	x.connMu.Lock()
+	defer x.connMu.Unlock()
 	x.Transport += networkSuffix
 	if err = x.netConnect(); err != nil {
 		return fmt.Errorf("error establishing connection to host: %w", err)
 	}
+	return x.initConnection()
+}
+
+// ConnectConn is Connect over conn rather than a connection it dials, eg
+// one end of a net.Pipe for unit tests feeding canned responses to and
+// reading requests from the other end. Transport says how conn carries
+// messages: one per Read and Write for "udp", back to back for "tcp".
+// Reconnects, after an EOF on a stream or with AutoReconnect, dial Target.
+func (x *GoSNMP) ConnectConn(conn net.Conn) error {
+	if conn == nil {
+		return errors.New("conn must not be nil")
+	}
+	if err := x.validateParameters(); err != nil {
+		return err
+	}
+	x.connMu.Lock()
+	defer x.connMu.Unlock()
+	x.Conn = conn
+	return x.initConnection()
+}
 
+// initConnection readies x for requests over the newly set x.Conn.
+func (x *GoSNMP) initConnection() error {
 	if x.random == 0 {
-		n, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt32)) // returns a uniform random value in [0, 2147483647].
+		source := x.Rand
+		if source == nil {
+			source = rand.Reader
+		}
+		n, err := rand.Int(source, big.NewInt(math.MaxInt32)) // returns a uniform random value in [0, 2147483647].
 		if err != nil {
 			return fmt.Errorf("error occurred while generating random: %w", err)
 		}
@@ -399,20 +768,32 @@ func (x *GoSNMP) connect(networkSuffix string) error {
 	x.requestID = x.random
 
 	x.rxBuf = new([rxBufSize]byte)
+	x.mux = newDemux()
+	atomic.StoreUint32(&x.closed, 0)
 
 	return nil
 }
 
+// targetAddr returns the host:port of the agent.
+func (x *GoSNMP) targetAddr() string {
+	// accept bracketed IPv6 literals such as "[::1]" as well as bare ones
+	target := x.Target
+	if strings.HasPrefix(target, "[") && strings.HasSuffix(target, "]") {
+		target = target[1 : len(target)-1]
+	}
+	return net.JoinHostPort(target, strconv.Itoa(int(x.Port)))
+}
+
 // Performs the real socket opening network operation. This can be used to do a
 // reconnect (needed for TCP)
 func (x *GoSNMP) netConnect() error {
 	var err error
 	var localAddr net.Addr
-	addr := net.JoinHostPort(x.Target, strconv.Itoa(int(x.Port)))
+	addr := x.targetAddr()
 
 	switch x.Transport {
 	case "udp", "udp4", "udp6":
-		if localAddr, err = net.ResolveUDPAddr(x.Transport, x.LocalAddr); err != nil {
+		if localAddr, err = net.ResolveUDPAddr(x.Transport, x.localAddr()); err != nil {
 			return err
 		}
 		if addr4 := localAddr.(*net.UDPAddr).IP.To4(); addr4 != nil {
@@ -427,18 +808,41 @@ func (x *GoSNMP) netConnect() error {
 			return err
 		}
 	case "tcp", "tcp4", "tcp6":
-		if localAddr, err = net.ResolveTCPAddr(x.Transport, x.LocalAddr); err != nil {
+		if localAddr, err = net.ResolveTCPAddr(x.Transport, x.localAddr()); err != nil {
 			return err
 		}
 		if addr4 := localAddr.(*net.TCPAddr).IP.To4(); addr4 != nil {
 			x.Transport = "tcp4"
 		}
+	case "tls", "tls4", "tls6":
+		if localAddr, err = net.ResolveTCPAddr(tcp+strings.TrimPrefix(x.Transport, tlsTransport), x.localAddr()); err != nil {
+			return err
+		}
+		x.Conn, err = x.dialTLS(addr, localAddr)
+		return err
 	}
 	dialer := net.Dialer{Timeout: x.Timeout, LocalAddr: localAddr, Control: x.Control}
 	x.Conn, err = dialer.DialContext(x.Context, x.Transport, addr)
 	return err
 }
 
+// localAddr returns LocalAddr with port 0 added if it has none.
+func (x *GoSNMP) localAddr() string {
+	if x.LocalAddr == "" {
+		return ""
+	}
+	if _, _, err := net.SplitHostPort(x.LocalAddr); err == nil {
+		return x.LocalAddr
+	}
+	return net.JoinHostPort(strings.Trim(x.LocalAddr, "[]"), "0")
+}
+
+// streamTransport reports whether x.Transport is stream oriented, in which
+// case messages are framed by their BER length and EOF means reconnect.
+func (x *GoSNMP) streamTransport() bool {
+	return strings.HasPrefix(x.Transport, tcp) || strings.HasPrefix(x.Transport, tlsTransport)
+}
+
 func (x *GoSNMP) validateParameters() error {
 	if x.Transport == "" {
 		x.Transport = udp
@@ -450,6 +854,15 @@ func (x *GoSNMP) validateParameters() error {
 		return fmt.Errorf("field MaxOids cannot be less than 0")
 	}
 
+	if x.MaxPDUSize < 0 {
+		return fmt.Errorf("field MaxPDUSize cannot be less than 0")
+	}
+
+	// RFC 3412 msgMaxSize INTEGER (484..2147483647)
+	if x.MaxMsgSize != 0 && (x.MaxMsgSize < 484 || x.MaxMsgSize > rxBufSize) {
+		return fmt.Errorf("field MaxMsgSize must be between 484 and %d, not %d", rxBufSize, x.MaxMsgSize)
+	}
+
 	if x.Version == Version3 {
 		// TODO: setting the Reportable flag violates rfc3412#6.4 if PDU is of type SNMPv2Trap.
 		// See if we can do this smarter and remove bitclear fix from trap.go:57
@@ -471,6 +884,11 @@ func (x *GoSNMP) validateParameters() error {
 	return nil
 }
 
+// MkSnmpPacket builds a packet from the settings of x without sending it.
+// Together with SnmpPacket.MarshalMsg and SnmpDecodePacket this is the
+// stable way to craft packets by hand (eg nonstandard or malformed ones
+// for testing agents): change the packet's fields, marshal it, and send the
+// bytes over x.Conn or another transport.
 func (x *GoSNMP) MkSnmpPacket(pdutype PDUType, pdus []SnmpPDU, nonRepeaters uint8, maxRepetitions uint32) *SnmpPacket {
 	return x.mkSnmpPacket(pdutype, pdus, nonRepeaters, maxRepetitions)
 }
@@ -493,12 +911,24 @@ func (x *GoSNMP) mkSnmpPacket(pdutype PDUType, pdus []SnmpPDU, nonRepeaters uint
 		PDUType:            pdutype,
 		NonRepeaters:       nonRepeaters,
 		MaxRepetitions:     (maxRepetitions & 0x7FFFFFFF),
+		MsgMaxSize:         x.MaxMsgSize,
 		Variables:          pdus,
 	}
 }
 
-// Get sends an SNMP GET request
+// Get sends an SNMP GET request. See MaxPDUSize for splitting large
+// requests.
 func (x *GoSNMP) Get(oids []string) (result *SnmpPacket, err error) {
+	return x.GetContext(x.Context, oids)
+}
+
+// GetContext sends an SNMP GET request bound to ctx instead of x.Context.
+// Canceling ctx aborts the pending read and returns ctx.Err(); Timeout
+// still applies when ctx has no deadline.
+func (x *GoSNMP) GetContext(ctx context.Context, oids []string) (result *SnmpPacket, err error) {
+	if x.MaxPDUSize > 0 {
+		return x.getSplit(ctx, oids)
+	}
 	oidCount := len(oids)
 	if oidCount > x.MaxOids {
 		return nil, fmt.Errorf("oid count (%d) is greater than MaxOids (%d)",
@@ -511,24 +941,54 @@ func (x *GoSNMP) Get(oids []string) (result *SnmpPacket, err error) {
 	}
 	// build up SnmpPacket
 	packetOut := x.mkSnmpPacket(GetRequest, pdus, 0, 0)
-	return x.send(packetOut, true)
+	return x.sendContext(ctx, packetOut, true)
 }
 
 // Set sends an SNMP SET request
+//
+// Each variable is encoded as its Type, which must be the syntax of the
+// object, whatever the Go type of its Value: a Gauge32 may be given as a
+// uint64, or any other integer type, as long as the value fits.
 func (x *GoSNMP) Set(pdus []SnmpPDU) (result *SnmpPacket, err error) {
-	var packetOut *SnmpPacket
-	switch pdus[0].Type {
-	// TODO test Gauge32
-	case Integer, OctetString, Gauge32, IPAddress, ObjectIdentifier, Counter32, Counter64, Null, TimeTicks, Uinteger32, OpaqueFloat, OpaqueDouble:
-		packetOut = x.mkSnmpPacket(SetRequest, pdus, 0, 0)
-	default:
-		return nil, fmt.Errorf("ERR:gosnmp currently only supports SNMP SETs for Integer, OctetString, Gauge32, IPAddress, ObjectIdentifier, Counter32, Counter64, Null, TimeTicks, Uinteger32, OpaqueFloat, and OpaqueDouble. Not %s", pdus[0].Type)
+	return x.SetContext(x.Context, pdus)
+}
+
+// SetContext sends an SNMP SET request bound to ctx instead of x.Context.
+//
+// An Opaque value is sent as the octets given ([]byte or string), which
+// must be the BER encoding the agent expects; OpaqueFloat and OpaqueDouble
+// values (float32 or float64) are wrapped in an Opaque as net-snmp does.
+func (x *GoSNMP) SetContext(ctx context.Context, pdus []SnmpPDU) (result *SnmpPacket, err error) {
+	if len(pdus) == 0 {
+		return nil, fmt.Errorf("ERR:gosnmp SET needs at least one variable")
+	}
+	for _, pdu := range pdus {
+		switch pdu.Type {
+		// TODO test Gauge32
+		case Integer, OctetString, BitString, Gauge32, IPAddress, ObjectIdentifier, Counter32, Counter64, Null, TimeTicks, NsapAddress, Uinteger32, Opaque, OpaqueFloat, OpaqueDouble:
+		default:
+			return nil, fmt.Errorf("ERR:gosnmp currently only supports SNMP SETs for Integer, OctetString, BitString, Gauge32, IPAddress, ObjectIdentifier, Counter32, Counter64, Null, TimeTicks, NsapAddress, Uinteger32, Opaque, OpaqueFloat, and OpaqueDouble. Not %s", pdu.Type)
+		}
 	}
-	return x.send(packetOut, true)
+	packetOut := x.mkSnmpPacket(SetRequest, pdus, 0, 0)
+	return x.sendContext(ctx, packetOut, true)
 }
 
 // GetNext sends an SNMP GETNEXT request
+//
+// All oids go in one request, and the response holds for each of them, in
+// the same order, the variable that follows it. This steps several columns
+// of a table in lockstep: pass the names returned as the oids of the next
+// call. A column that has run out comes back with Type EndOfMibView (or,
+// from SNMPv1 agents, the whole request fails with NoSuchName) while the
+// others advance; drop it from the next call. A response that is not
+// aligned with oids is an error.
 func (x *GoSNMP) GetNext(oids []string) (result *SnmpPacket, err error) {
+	return x.GetNextContext(x.Context, oids)
+}
+
+// GetNextContext sends an SNMP GETNEXT request bound to ctx instead of x.Context.
+func (x *GoSNMP) GetNextContext(ctx context.Context, oids []string) (result *SnmpPacket, err error) {
 	oidCount := len(oids)
 	if oidCount > x.MaxOids {
 		return nil, fmt.Errorf("oid count (%d) is greater than MaxOids (%d)",
@@ -544,13 +1004,22 @@ func (x *GoSNMP) GetNext(oids []string) (result *SnmpPacket, err error) {
 	// Marshal and send the packet
 	packetOut := x.mkSnmpPacket(GetNextRequest, pdus, 0, 0)
 
-	return x.send(packetOut, true)
+	result, err = x.sendContext(ctx, packetOut, true)
+	if err == nil && result.Error == NoError && len(result.Variables) != oidCount {
+		return result, fmt.Errorf("GETNEXT of %d oids returned %d variables", oidCount, len(result.Variables))
+	}
+	return result, err
 }
 
 // GetBulk sends an SNMP GETBULK request
 //
 // For maxRepetitions greater than 255, use BulkWalk() or BulkWalkAll()
 func (x *GoSNMP) GetBulk(oids []string, nonRepeaters uint8, maxRepetitions uint32) (result *SnmpPacket, err error) {
+	return x.GetBulkContext(x.Context, oids, nonRepeaters, maxRepetitions)
+}
+
+// GetBulkContext sends an SNMP GETBULK request bound to ctx instead of x.Context.
+func (x *GoSNMP) GetBulkContext(ctx context.Context, oids []string, nonRepeaters uint8, maxRepetitions uint32) (result *SnmpPacket, err error) {
 	if x.Version == Version1 {
 		return nil, fmt.Errorf("GETBULK not supported in SNMPv1")
 	}
@@ -568,7 +1037,28 @@ func (x *GoSNMP) GetBulk(oids []string, nonRepeaters uint8, maxRepetitions uint3
 
 	// Marshal and send the packet
 	packetOut := x.mkSnmpPacket(GetBulkRequest, pdus, nonRepeaters, maxRepetitions)
-	return x.send(packetOut, true)
+	return x.sendContext(ctx, packetOut, true)
+}
+
+// GetBulkN is GetBulk with the oids last, for building requests that start
+// with a few scalars: the first nonRepeaters oids are fetched once and the
+// rest up to maxRepetitions times. It ignores x.NonRepeaters and
+// x.MaxRepetitions, which only apply to BulkWalk*, and returns the raw
+// response for callers driving their own walk.
+func (x *GoSNMP) GetBulkN(nonRepeaters uint8, maxRepetitions uint32, oids ...string) (result *SnmpPacket, err error) {
+	return x.GetBulkContext(x.Context, oids, nonRepeaters, maxRepetitions)
+}
+
+// GetWithTimeout is Get with timeout used instead of x.Timeout for this call
+// only. See WithRequestTimeout.
+func (x *GoSNMP) GetWithTimeout(oids []string, timeout time.Duration) (result *SnmpPacket, err error) {
+	return x.GetContext(WithRequestTimeout(x.Context, timeout), oids)
+}
+
+// GetBulkWithTimeout is GetBulk with timeout used instead of x.Timeout for
+// this call only. See WithRequestTimeout.
+func (x *GoSNMP) GetBulkWithTimeout(oids []string, nonRepeaters uint8, maxRepetitions uint32, timeout time.Duration) (result *SnmpPacket, err error) {
+	return x.GetBulkContext(WithRequestTimeout(x.Context, timeout), oids, nonRepeaters, maxRepetitions)
 }
 
 // SnmpEncodePacket exposes SNMP packet generation to external callers.
@@ -670,8 +1160,18 @@ type WalkFunc func(dataUnit SnmpPDU) error
 // walked walkFn is called for each new value. The function immediately returns
 // an error if either there is an underlaying SNMP error (e.g. GetBulk fails),
 // or if walkFn returns an error.
+//
+// With SNMPv1, which has no GETBULK, the walk uses GETNEXT. It also falls
+// back to GETNEXT when the agent fails a GETBULK with an error status,
+// after halving max-repetitions down to 1 while the error is tooBig.
 func (x *GoSNMP) BulkWalk(rootOid string, walkFn WalkFunc) error {
-	return x.walk(GetBulkRequest, rootOid, walkFn)
+	return x.walk(x.Context, GetBulkRequest, rootOid, walkFn)
+}
+
+// BulkWalkContext is BulkWalk bound to ctx instead of x.Context. Once ctx is
+// done the walk stops, walkFn is not called again and ctx.Err() is returned.
+func (x *GoSNMP) BulkWalkContext(ctx context.Context, rootOid string, walkFn WalkFunc) error {
+	return x.walk(ctx, GetBulkRequest, rootOid, walkFn)
 }
 
 // BulkWalkAll is similar to BulkWalk but returns a filled array of all values
@@ -679,7 +1179,7 @@ func (x *GoSNMP) BulkWalk(rootOid string, walkFn WalkFunc) error {
 // have set x.AppOpts to 'c', BulkWalkAll may loop indefinitely and cause an
 // Out Of Memory - use BulkWalk instead.
 func (x *GoSNMP) BulkWalkAll(rootOid string) (results []SnmpPDU, err error) {
-	return x.walkAll(GetBulkRequest, rootOid)
+	return x.walkAll(x.Context, GetBulkRequest, rootOid)
 }
 
 // Walk retrieves a subtree of values using GETNEXT - a request is made for each
@@ -688,7 +1188,13 @@ func (x *GoSNMP) BulkWalkAll(rootOid string) (results []SnmpPDU, err error) {
 // an error if either there is an underlaying SNMP error (e.g. GetNext fails),
 // or if walkFn returns an error.
 func (x *GoSNMP) Walk(rootOid string, walkFn WalkFunc) error {
-	return x.walk(GetNextRequest, rootOid, walkFn)
+	return x.walk(x.Context, GetNextRequest, rootOid, walkFn)
+}
+
+// WalkContext is Walk bound to ctx instead of x.Context. Once ctx is done the
+// walk stops, walkFn is not called again and ctx.Err() is returned.
+func (x *GoSNMP) WalkContext(ctx context.Context, rootOid string, walkFn WalkFunc) error {
+	return x.walk(ctx, GetNextRequest, rootOid, walkFn)
 }
 
 // WalkAll is similar to Walk but returns a filled array of all values rather
@@ -696,7 +1202,63 @@ func (x *GoSNMP) Walk(rootOid string, walkFn WalkFunc) error {
 // x.AppOpts to 'c', WalkAll may loop indefinitely and cause an Out Of Memory -
 // use Walk instead.
 func (x *GoSNMP) WalkAll(rootOid string) (results []SnmpPDU, err error) {
-	return x.walkAll(GetNextRequest, rootOid)
+	return x.walkAll(x.Context, GetNextRequest, rootOid)
+}
+
+// WalkChan walks rootOid like Walk, sending each value on the returned
+// channel as it arrives. The walk proceeds only as fast as the channel is
+// read. The channel is closed when the walk is over, after which wait
+// returns its error, if any; wait may also be called sooner, to block until
+// then. A caller that stops reading early must cancel the context, see
+// WalkChanContext, or the walk blocks forever.
+func (x *GoSNMP) WalkChan(rootOid string) (pdus <-chan SnmpPDU, wait func() error) {
+	return x.walkChan(x.Context, GetNextRequest, rootOid)
+}
+
+// WalkChanContext is WalkChan bound to ctx instead of x.Context.
+func (x *GoSNMP) WalkChanContext(ctx context.Context, rootOid string) (pdus <-chan SnmpPDU, wait func() error) {
+	return x.walkChan(ctx, GetNextRequest, rootOid)
+}
+
+// BulkWalkChan is WalkChan using GETBULK, like BulkWalk.
+func (x *GoSNMP) BulkWalkChan(rootOid string) (pdus <-chan SnmpPDU, wait func() error) {
+	return x.walkChan(x.Context, GetBulkRequest, rootOid)
+}
+
+// BulkWalkChanContext is BulkWalkChan bound to ctx instead of x.Context.
+func (x *GoSNMP) BulkWalkChanContext(ctx context.Context, rootOid string) (pdus <-chan SnmpPDU, wait func() error) {
+	return x.walkChan(ctx, GetBulkRequest, rootOid)
+}
+
+// BulkWalkAllParallel walks each of rootOids like BulkWalkAll, at most
+// concurrency of them at a time (0 for all at once) over the one
+// connection, and returns the values found under each root. For SNMPv1
+// agents the walks use GETNEXT. The walks that fail are missing from the
+// results, and the error reports each of them; no further walks are
+// started after one fails. With SNMPv3 make a request first, so the engine
+// is discovered before the walks run concurrently.
+func (x *GoSNMP) BulkWalkAllParallel(rootOids []string, concurrency int) (map[string][]SnmpPDU, error) {
+	return x.walkAllParallel(x.Context, rootOids, concurrency)
+}
+
+// BulkWalkAllParallelContext is BulkWalkAllParallel bound to ctx instead of
+// x.Context.
+func (x *GoSNMP) BulkWalkAllParallelContext(ctx context.Context, rootOids []string, concurrency int) (map[string][]SnmpPDU, error) {
+	return x.walkAllParallel(ctx, rootOids, concurrency)
+}
+
+// WalkRange walks the values after startOid and before stopOid using
+// GETNEXT, calling walkFn for each, eg to poll a window of the rows of a
+// large table. Both bounds are exclusive and compared arc by arc; the walk
+// also ends at the end of the MIB view. It fails if stopOid does not come
+// after startOid.
+func (x *GoSNMP) WalkRange(startOid, stopOid string, walkFn WalkFunc) error {
+	return x.walkRange(x.Context, startOid, stopOid, walkFn)
+}
+
+// WalkRangeContext is WalkRange bound to ctx instead of x.Context.
+func (x *GoSNMP) WalkRangeContext(ctx context.Context, startOid, stopOid string, walkFn WalkFunc) error {
+	return x.walkRange(ctx, startOid, stopOid, walkFn)
 }
 
 //
@@ -773,3 +1335,286 @@ func ToBigInt(value interface{}) *big.Int {
 
 	return big.NewInt(val)
 }
+
+// Int returns the value of an Integer, Counter32, Gauge32, TimeTicks,
+// Uinteger32 or Counter64 variable as an int. It returns an error wrapping
+// ErrWrongValueType for other types, and ErrIntegerTooLarge when an unsigned
+// value does not fit (eg a Counter64, or a Counter32 on 32 bit platforms).
+func (pdu SnmpPDU) Int() (int, error) {
+	switch v := pdu.Value.(type) {
+	case int:
+		if pdu.Type == Integer {
+			return v, nil
+		}
+	case uint, uint32, uint64:
+		if !isUnsigned(pdu.Type) {
+			break
+		}
+		u := ToBigInt(v)
+		if !u.IsInt64() || u.Int64() > math.MaxInt {
+			return 0, fmt.Errorf("%s %s %v: %w", pdu.Name, pdu.Type, v, ErrIntegerTooLarge)
+		}
+		return int(u.Int64()), nil
+	}
+	return 0, pdu.wrongType("an integer")
+}
+
+// Uint64 returns the value of a Counter32, Gauge32, TimeTicks, Uinteger32
+// or Counter64 variable, or of a non-negative Integer, as a uint64. It
+// returns an error wrapping ErrWrongValueType for other types and for
+// negative Integers.
+func (pdu SnmpPDU) Uint64() (uint64, error) {
+	switch v := pdu.Value.(type) {
+	case int:
+		if pdu.Type == Integer && v >= 0 {
+			return uint64(v), nil
+		}
+	case uint, uint32, uint64:
+		if isUnsigned(pdu.Type) {
+			return ToBigInt(v).Uint64(), nil
+		}
+	}
+	return 0, pdu.wrongType("an unsigned integer")
+}
+
+// Text returns the value of an OctetString variable as a string, or the
+// dotted form of an ObjectIdentifier or IPAddress, one held as 4 or 16
+// octets included. It returns an error wrapping ErrWrongValueType for
+// other types. Use fmt.Sprint(pdu.Value) to format a value of any type.
+func (pdu SnmpPDU) Text() (string, error) {
+	switch pdu.Type {
+	case IPAddress:
+		switch v := pdu.Value.(type) {
+		case net.IP:
+			if len(v) == net.IPv4len || len(v) == net.IPv6len {
+				return v.String(), nil
+			}
+		case []byte:
+			if len(v) == net.IPv4len || len(v) == net.IPv6len {
+				return net.IP(v).String(), nil
+			}
+		case string:
+			return v, nil
+		}
+	case OctetString, ObjectIdentifier:
+		switch v := pdu.Value.(type) {
+		case []byte:
+			return string(v), nil
+		case string:
+			return v, nil
+		}
+	}
+	return "", pdu.wrongType("a string")
+}
+
+// Bytes returns the value of an OctetString variable as octets, for binary
+// values that are not text. It returns an error wrapping ErrWrongValueType
+// for other types.
+func (pdu SnmpPDU) Bytes() ([]byte, error) {
+	if pdu.Type == OctetString {
+		switch v := pdu.Value.(type) {
+		case []byte:
+			return v, nil
+		case string:
+			return []byte(v), nil
+		}
+	}
+	return nil, pdu.wrongType("an octet string")
+}
+
+// Bits returns the indices of the bits set in a BitString variable, or in
+// an OctetString holding a BITS value, in increasing order. Bit 0 is the
+// most significant bit of the first octet, as X.690 and RFC 2578 number
+// them. It returns an error wrapping ErrWrongValueType for other types.
+func (pdu SnmpPDU) Bits() ([]int, error) {
+	if v, ok := pdu.Value.(BitStringValue); ok && pdu.Type == BitString {
+		return v.Set(), nil
+	}
+	if pdu.Type == OctetString {
+		if b, err := pdu.Bytes(); err == nil {
+			return BitStringValue{Bytes: b, BitLength: 8 * len(b)}.Set(), nil
+		}
+	}
+	return nil, pdu.wrongType("bits")
+}
+
+// Hex returns the value of an OctetString variable as space separated hex
+// octets, as snmpwalk prints a Hex-STRING, eg "00 1A 2B 3C 4D 5E". It
+// returns an error wrapping ErrWrongValueType for other types.
+func (pdu SnmpPDU) Hex() (string, error) {
+	b, err := pdu.Bytes()
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for i, o := range b {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%02X", o)
+	}
+	return sb.String(), nil
+}
+
+// DisplayString returns the value of an OctetString variable as text when
+// all of it is printable ASCII or white space, and otherwise in the hex
+// form of Hex, as snmpwalk does for an OCTET STRING without a display hint.
+// One trailing NUL, which some agents send, is dropped from text. It
+// returns an error wrapping ErrWrongValueType for other types.
+func (pdu SnmpPDU) DisplayString() (string, error) {
+	b, err := pdu.Bytes()
+	if err != nil {
+		return "", err
+	}
+	text := b
+	if len(text) > 0 && text[len(text)-1] == 0 {
+		text = text[:len(text)-1]
+	}
+	for _, c := range text {
+		if (c < ' ' || c > '~') && !(c >= '\t' && c <= '\r') {
+			return pdu.Hex()
+		}
+	}
+	return string(text), nil
+}
+
+// MAC returns the value of a six octet OctetString variable, a MacAddress
+// or PhysAddress such as ifPhysAddress, as a net.HardwareAddr, whose
+// String method gives the usual colon separated form "00:1a:2b:3c:4d:5e".
+// It returns an error wrapping ErrWrongValueType for other types and
+// lengths, eg the empty ifPhysAddress of a loopback interface.
+func (pdu SnmpPDU) MAC() (net.HardwareAddr, error) {
+	b, err := pdu.Bytes()
+	if err == nil && len(b) == 6 {
+		return net.HardwareAddr(b), nil
+	}
+	return nil, pdu.wrongType("a MAC address")
+}
+
+// OID returns the value of an ObjectIdentifier variable, eg ".1.3.6.1.2.1".
+// It returns an error wrapping ErrWrongValueType for other types.
+func (pdu SnmpPDU) OID() (string, error) {
+	if v, ok := pdu.Value.(string); ok && pdu.Type == ObjectIdentifier {
+		return v, nil
+	}
+	return "", pdu.wrongType("an object identifier")
+}
+
+// IP returns the value of an IPAddress variable as a net.IP. It returns an
+// error wrapping ErrWrongValueType for other types, and for IPAddress
+// variables the agent sent without the four octets of an IPv4 address.
+func (pdu SnmpPDU) IP() (net.IP, error) {
+	if pdu.Type == IPAddress {
+		var ip net.IP
+		switch v := pdu.Value.(type) {
+		case string:
+			ip = net.ParseIP(v)
+		case []byte:
+			if len(v) == net.IPv4len || len(v) == net.IPv6len {
+				ip = net.IP(v)
+			}
+		case net.IP:
+			ip = v
+		}
+		if ip != nil {
+			return ip, nil
+		}
+	}
+	return nil, pdu.wrongType("an IP address")
+}
+
+// TimeTicksToDuration converts a TimeTicks value, in hundredths of a
+// second, to a time.Duration. TimeTicks variables are decoded as uint32
+// with Type TimeTicks, see also SnmpPDU.Duration; the Timestamp of an
+// SNMPv1 trap is a TimeTicks value too.
+func TimeTicksToDuration(ticks uint32) time.Duration {
+	return time.Duration(ticks) * 10 * time.Millisecond
+}
+
+// DurationToTimeTicks converts d to a TimeTicks value, in hundredths of a
+// second, truncating. Durations outside the range of TimeTicks are clamped
+// to it.
+func DurationToTimeTicks(d time.Duration) uint32 {
+	ticks := d / (10 * time.Millisecond)
+	switch {
+	case ticks < 0:
+		return 0
+	case ticks > math.MaxUint32:
+		return math.MaxUint32
+	}
+	return uint32(ticks)
+}
+
+// Duration returns the value of a TimeTicks variable, in hundredths of a
+// second, as a time.Duration. It returns an error wrapping
+// ErrWrongValueType for other types.
+func (pdu SnmpPDU) Duration() (time.Duration, error) {
+	if v, ok := pdu.Value.(uint32); ok && pdu.Type == TimeTicks {
+		return TimeTicksToDuration(v), nil
+	}
+	return 0, pdu.wrongType("TimeTicks")
+}
+
+func (pdu SnmpPDU) wrongType(want string) error {
+	return fmt.Errorf("%s is %s (%T), not %s: %w", pdu.Name, pdu.Type, pdu.Value, want, ErrWrongValueType)
+}
+
+// isUnsigned reports whether variables of type t are decoded as unsigned
+// integers.
+func isUnsigned(t Asn1BER) bool {
+	switch t {
+	case Counter32, Gauge32, TimeTicks, Uinteger32, Counter64:
+		return true
+	}
+	return false
+}
+
+type requestTimeoutKey struct{}
+
+// WithRequestTimeout returns a copy of ctx that makes any *Context request
+// using it wait d per attempt instead of GoSNMP.Timeout. Retries and
+// ExponentialTimeout still apply; the GoSNMP struct is left untouched, so
+// this is safe on a connection shared between goroutines.
+func WithRequestTimeout(ctx context.Context, d time.Duration) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, requestTimeoutKey{}, d)
+}
+
+// requestTimeout returns the WithRequestTimeout override carried by ctx, or
+// x.Timeout.
+func (x *GoSNMP) requestTimeout(ctx context.Context) time.Duration {
+	if d, ok := ctx.Value(requestTimeoutKey{}).(time.Duration); ok && d > 0 {
+		return d
+	}
+	return x.Timeout
+}
+
+type requestRetriesKey struct{}
+
+// requestRetries returns the number of retries for a request bound to ctx,
+// that of engine discovery for its probe, or x.Retries.
+func (x *GoSNMP) requestRetries(ctx context.Context) int {
+	if n, ok := ctx.Value(requestRetriesKey{}).(int); ok {
+		return n
+	}
+	return x.Retries
+}
+
+// requestBudget returns the longest a request bound to ctx may take by
+// the timeout, retries, ExponentialTimeout and RetryBackoff of x, without
+// jitter.
+func (x *GoSNMP) requestBudget(ctx context.Context) time.Duration {
+	backoff := x.RetryBackoff
+	backoff.Jitter = 0
+	timeout := x.requestTimeout(ctx)
+	budget := timeout
+	for retry := 1; retry <= x.requestRetries(ctx); retry++ {
+		if x.ExponentialTimeout {
+			timeout *= 2
+		}
+		budget += backoff.delay(retry) + timeout
+	}
+	return budget
+}
@@ -13,6 +13,7 @@
 package gosnmp_test // force external view
 
 import (
+	"context"
 	"io"
 	"log"
 	"net"
@@ -52,6 +53,18 @@ func TestAPIConnectMethodSignature(t *testing.T) {
 	_ = f
 }
 
+func TestAPIConnectConnMethodSignature(t *testing.T) {
+	var f func(net.Conn) error
+	f = gosnmp.Default.ConnectConn
+	_ = f
+}
+
+func TestAPICloseMethodSignature(t *testing.T) {
+	var f func() error
+	f = gosnmp.Default.Close
+	_ = f
+}
+
 func TestAPIGetMethodSignature(t *testing.T) {
 	var f func([]string) (*gosnmp.SnmpPacket, error)
 	f = gosnmp.Default.Get
@@ -70,6 +83,54 @@ func TestAPIGetNextMethodSignature(t *testing.T) {
 	_ = f
 }
 
+func TestAPIGetContextMethodSignature(t *testing.T) {
+	var f func(context.Context, []string) (*gosnmp.SnmpPacket, error)
+	f = gosnmp.Default.GetContext
+	_ = f
+}
+
+func TestAPISetContextMethodSignature(t *testing.T) {
+	var f func(context.Context, []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error)
+	f = gosnmp.Default.SetContext
+	_ = f
+}
+
+func TestAPIGetNextContextMethodSignature(t *testing.T) {
+	var f func(context.Context, []string) (*gosnmp.SnmpPacket, error)
+	f = gosnmp.Default.GetNextContext
+	_ = f
+}
+
+func TestAPIGetWithTimeoutMethodSignature(t *testing.T) {
+	var f func([]string, time.Duration) (*gosnmp.SnmpPacket, error)
+	f = gosnmp.Default.GetWithTimeout
+	_ = f
+}
+
+func TestAPIGetBulkWithTimeoutMethodSignature(t *testing.T) {
+	var f func([]string, uint8, uint32, time.Duration) (*gosnmp.SnmpPacket, error)
+	f = gosnmp.Default.GetBulkWithTimeout
+	_ = f
+}
+
+func TestAPIWithRequestTimeoutSignature(t *testing.T) {
+	var f func(context.Context, time.Duration) context.Context
+	f = gosnmp.WithRequestTimeout
+	_ = f
+}
+
+func TestAPIGetBulkNMethodSignature(t *testing.T) {
+	var f func(uint8, uint32, ...string) (*gosnmp.SnmpPacket, error)
+	f = gosnmp.Default.GetBulkN
+	_ = f
+}
+
+func TestAPIGetBulkContextMethodSignature(t *testing.T) {
+	var f func(context.Context, []string, uint8, uint32) (*gosnmp.SnmpPacket, error)
+	f = gosnmp.Default.GetBulkContext
+	_ = f
+}
+
 func TestAPIBulkWalkMethodSignature(t *testing.T) {
 	var f func(string, gosnmp.WalkFunc) error
 	f = gosnmp.Default.BulkWalk
@@ -94,8 +155,488 @@ func TestAPIWalkAllMethodSignature(t *testing.T) {
 	_ = f
 }
 
+func TestAPIWalkContextMethodSignature(t *testing.T) {
+	var f func(context.Context, string, gosnmp.WalkFunc) error
+	f = gosnmp.Default.WalkContext
+	_ = f
+}
+
+func TestAPIBulkWalkContextMethodSignature(t *testing.T) {
+	var f func(context.Context, string, gosnmp.WalkFunc) error
+	f = gosnmp.Default.BulkWalkContext
+	_ = f
+}
+
 func TestAPIWalkFuncSignature(t *testing.T) {
 	var f gosnmp.WalkFunc
 	f = func(du gosnmp.SnmpPDU) (err error) { return }
 	_ = f
 }
+
+func TestAPIWalkTableMethodSignature(t *testing.T) {
+	var f func(string) (map[string][]gosnmp.SnmpPDU, error)
+	f = gosnmp.Default.WalkTable
+	_ = f
+}
+
+func TestAPIUnmarshalTableMethodSignature(t *testing.T) {
+	var f func(string, interface{}) error
+	f = gosnmp.Default.UnmarshalTable
+	_ = f
+}
+
+func TestAPIMkSnmpPacketMethodSignature(t *testing.T) {
+	var f func(gosnmp.PDUType, []gosnmp.SnmpPDU, uint8, uint32) *gosnmp.SnmpPacket
+	f = gosnmp.Default.MkSnmpPacket
+	_ = f
+}
+
+func TestAPIMarshalMsgMethodSignature(t *testing.T) {
+	var f func() ([]byte, error)
+	f = (&gosnmp.SnmpPacket{}).MarshalMsg
+	_ = f
+}
+
+func TestAPISnmpDecodePacketMethodSignature(t *testing.T) {
+	var f func([]byte) (*gosnmp.SnmpPacket, error)
+	f = gosnmp.Default.SnmpDecodePacket
+	_ = f
+}
+
+// A packet can be built, changed in ways Get/GetBulk would not allow,
+// marshalled and decoded back.
+func TestAPICraftedPacketRoundTrip(t *testing.T) {
+	g := &gosnmp.GoSNMP{
+		Version:   gosnmp.Version2c,
+		Community: "public",
+		MaxOids:   gosnmp.MaxOids,
+		Logger:    gosnmp.NewLogger(log.New(io.Discard, "", 0)),
+	}
+	pkt := g.MkSnmpPacket(gosnmp.GetBulkRequest, []gosnmp.SnmpPDU{{Name: ".1.3.6.1.2.1.1", Type: gosnmp.Null}}, 0, 10)
+	pkt.RequestID = 1234
+	pkt.NonRepeaters = 5 // more than there are variables
+	pkt.Community = "not-the-session-community"
+
+	out, err := pkt.MarshalMsg()
+	if err != nil {
+		t.Fatalf("MarshalMsg: %v", err)
+	}
+	in, err := g.SnmpDecodePacket(out)
+	if err != nil {
+		t.Fatalf("SnmpDecodePacket: %v", err)
+	}
+	if in.RequestID != 1234 || in.NonRepeaters != 5 || in.MaxRepetitions != 10 ||
+		in.Community != "not-the-session-community" || in.PDUType != gosnmp.GetBulkRequest {
+		t.Errorf("round trip changed the packet: %s", in.SafeString())
+	}
+}
+
+func TestAPINewGoSNMPSignature(t *testing.T) {
+	var f func(string, ...gosnmp.Option) (*gosnmp.GoSNMP, error)
+	f = gosnmp.NewGoSNMP
+	_ = f
+}
+
+func TestAPINewGoSNMP(t *testing.T) {
+	g, err := gosnmp.NewGoSNMP("192.0.2.1")
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if g.Target != "192.0.2.1" || g.Port != 161 || g.Version != gosnmp.Version2c ||
+		g.Community != "public" || g.Timeout != 2*time.Second || g.Retries != 3 || g.MaxOids != gosnmp.MaxOids {
+		t.Errorf("unexpected defaults: %+v", g)
+	}
+
+	g, err = gosnmp.NewGoSNMP("192.0.2.1",
+		gosnmp.WithPort(1161),
+		gosnmp.WithTimeout(5*time.Second),
+		gosnmp.WithRetries(0),
+		gosnmp.WithMaxRepetitions(10),
+		gosnmp.WithV3User("ops", gosnmp.SHA256, "authpassphrase", gosnmp.AES, "privpassphrase"))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	usm, ok := g.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+	if g.Port != 1161 || g.Timeout != 5*time.Second || g.Retries != 0 || g.MaxRepetitions != 10 ||
+		g.Version != gosnmp.Version3 || g.MsgFlags&gosnmp.AuthPriv != gosnmp.AuthPriv ||
+		!ok || usm.UserName != "ops" || usm.PrivacyProtocol != gosnmp.AES {
+		t.Errorf("options not applied: %+v", g)
+	}
+
+	for _, opts := range [][]gosnmp.Option{
+		{gosnmp.WithTimeout(0)},
+		{gosnmp.WithRetries(-1)},
+		{gosnmp.WithVersion(gosnmp.SnmpVersion(9))},
+		{gosnmp.WithV3User("ops", gosnmp.NoAuth, "", gosnmp.AES, "privpassphrase")},
+		{gosnmp.WithV3User("ops", gosnmp.SHA, "", gosnmp.NoPriv, "")},
+	} {
+		if _, err := gosnmp.NewGoSNMP("192.0.2.1", opts...); err == nil {
+			t.Errorf("expected an error for %d options", len(opts))
+		}
+	}
+	if _, err := gosnmp.NewGoSNMP(""); err == nil {
+		t.Error("expected an error for an empty target")
+	}
+}
+
+func TestAPIWithLocalAddr(t *testing.T) {
+	agent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer agent.Close()
+
+	g, err := gosnmp.NewGoSNMP("127.0.0.1",
+		gosnmp.WithPort(uint16(agent.LocalAddr().(*net.UDPAddr).Port)),
+		gosnmp.WithLocalAddr("127.0.0.1"))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = g.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer g.Conn.Close()
+	local := g.Conn.LocalAddr().(*net.UDPAddr)
+	if !local.IP.Equal(net.IPv4(127, 0, 0, 1)) || local.Port == 0 {
+		t.Errorf("bound to %s, want 127.0.0.1 and a chosen port", local)
+	}
+
+	if _, err := gosnmp.NewGoSNMP("127.0.0.1", gosnmp.WithLocalAddr("")); err == nil {
+		t.Error("expected an error for an empty local address")
+	}
+}
+
+func TestAPILocalizeKeySignature(t *testing.T) {
+	var f func(string, string, gosnmp.SnmpV3AuthProtocol) ([]byte, error)
+	f = gosnmp.LocalizeKey
+	_ = f
+}
+
+func TestAPILocalizePrivKeySignature(t *testing.T) {
+	var f func(string, string, gosnmp.SnmpV3AuthProtocol, gosnmp.SnmpV3PrivProtocol) ([]byte, error)
+	f = gosnmp.LocalizePrivKey
+	_ = f
+}
+
+func TestAPINewPoolSignature(t *testing.T) {
+	var f func(func(string) (*gosnmp.GoSNMP, error), int, time.Duration) *gosnmp.Pool
+	f = gosnmp.NewPool
+	_ = f
+}
+
+func TestAPIPoolMethodSignatures(t *testing.T) {
+	var get func(context.Context, string) (*gosnmp.GoSNMP, error)
+	var put func(*gosnmp.GoSNMP, error)
+	var closer func() error
+	p := gosnmp.NewPool(nil, 0, 0)
+	get, put, closer = p.Get, p.Put, p.Close
+	_, _, _ = get, put, closer
+}
+
+func TestAPISetManyMethodSignature(t *testing.T) {
+	var f func(*gosnmp.SetBuilder) (*gosnmp.SnmpPacket, error)
+	f = gosnmp.Default.SetMany
+	_ = f
+}
+
+func TestAPISetBuilder(t *testing.T) {
+	var b *gosnmp.SetBuilder = gosnmp.SetVars().AddInt(".1.3.6.1.2.1.2.2.1.7.3", 2).AddGauge32(".1.3.6.1.4.1.1.2", 50)
+	pdus := b.PDUs()
+	if len(pdus) != 2 || pdus[0].Type != gosnmp.Integer || pdus[1].Type != gosnmp.Gauge32 {
+		t.Errorf("unexpected PDUs %v", pdus)
+	}
+}
+
+func TestAPINewMockAgentSignature(t *testing.T) {
+	var f func(map[string]gosnmp.SnmpPDU) (*gosnmp.MockAgent, error)
+	f = gosnmp.NewMockAgent
+	_ = f
+}
+
+func TestAPIParseSnmpwalkSignature(t *testing.T) {
+	var f func(io.Reader) (map[string]gosnmp.SnmpPDU, error)
+	f = gosnmp.ParseSnmpwalk
+	_ = f
+}
+
+func TestAPISysUpTimeMethodSignature(t *testing.T) {
+	var f func() (time.Duration, error)
+	f = gosnmp.Default.SysUpTime
+	_ = f
+}
+
+func TestAPISysDescrMethodSignature(t *testing.T) {
+	var f func() (string, error)
+	f = gosnmp.Default.SysDescr
+	_ = f
+}
+
+func TestAPITimeTicksToDurationSignature(t *testing.T) {
+	var f func(uint32) time.Duration
+	f = gosnmp.TimeTicksToDuration
+	_ = f
+}
+
+func TestAPIDurationToTimeTicksSignature(t *testing.T) {
+	var f func(time.Duration) uint32
+	f = gosnmp.DurationToTimeTicks
+	_ = f
+}
+
+func TestAPIWalkRangeMethodSignature(t *testing.T) {
+	var f func(string, string, gosnmp.WalkFunc) error
+	f = gosnmp.Default.WalkRange
+	_ = f
+}
+
+func TestAPIBulkWalkAllParallelMethodSignature(t *testing.T) {
+	var f func([]string, int) (map[string][]gosnmp.SnmpPDU, error)
+	f = gosnmp.Default.BulkWalkAllParallel
+	_ = f
+}
+
+func TestAPIWalkChanMethodSignature(t *testing.T) {
+	var f func(string) (<-chan gosnmp.SnmpPDU, func() error)
+	f = gosnmp.Default.WalkChan
+	_ = f
+}
+
+func TestAPIBulkWalkChanMethodSignature(t *testing.T) {
+	var f func(string) (<-chan gosnmp.SnmpPDU, func() error)
+	f = gosnmp.Default.BulkWalkChan
+	_ = f
+}
+
+func TestAPIWithV3ContextSignature(t *testing.T) {
+	var f func(string, string) gosnmp.Option
+	f = gosnmp.WithV3Context
+	_ = f
+}
+
+func TestAPISwitchUserMethodSignature(t *testing.T) {
+	var f func(gosnmp.SnmpV3MsgFlags, *gosnmp.UsmSecurityParameters) error
+	f = gosnmp.Default.SwitchUser
+	_ = f
+}
+
+func TestAPISharedConnMethodSignatures(t *testing.T) {
+	var c func(*gosnmp.GoSNMP) error
+	var s *gosnmp.SharedConn
+	c = s.Connect
+	_ = c
+}
+
+func TestAPIRateLimiterSignatures(t *testing.T) {
+	var n func(float64, int) *gosnmp.RateLimiter
+	n = gosnmp.NewRateLimiter
+	_ = n
+	var o func(float64, int) gosnmp.Option
+	o = gosnmp.WithRateLimit
+	_ = o
+}
+
+func TestAPIDumpBERSignature(t *testing.T) {
+	var f func([]byte) string
+	f = gosnmp.DumpBER
+	_ = f
+}
+
+func TestAPIBitsMethodSignatures(t *testing.T) {
+	var f func() ([]int, error)
+	f = gosnmp.SnmpPDU{}.Bits
+	_ = f
+	var b func(string, gosnmp.BitStringValue) *gosnmp.SetBuilder
+	b = gosnmp.SetVars().AddBitString
+	_ = b
+}
+
+func TestAPILenientDecodingSignatures(t *testing.T) {
+	var o func() gosnmp.Option
+	o = gosnmp.WithLenientDecoding
+	_ = o
+	var f func() error
+	f = (&gosnmp.SnmpPacket{}).DecodeErr
+	_ = f
+}
+
+func TestAPIAdaptiveBulkSizeSignatures(t *testing.T) {
+	var o func(int) gosnmp.Option
+	o = gosnmp.WithAdaptiveBulkSize
+	_ = o
+	var f func() uint32
+	f = gosnmp.Default.BulkRepetitions
+	_ = f
+}
+
+func TestAPIErrOIDNotIncreasing(t *testing.T) {
+	var err error = gosnmp.ErrOIDNotIncreasing
+	_ = err
+}
+
+func TestAPIOIDCheckSignatures(t *testing.T) {
+	var o gosnmp.Option = gosnmp.WithOIDCheck(gosnmp.OIDCheckTolerant)
+	_ = o
+	var p gosnmp.OIDCheckPolicy = gosnmp.Default.OIDCheck
+	_ = p
+	_ = []gosnmp.OIDCheckPolicy{gosnmp.OIDCheckStrict, gosnmp.OIDCheckTolerant, gosnmp.OIDCheckOff}
+}
+
+func TestAPIForwardSignatures(t *testing.T) {
+	var f func(*gosnmp.SnmpPacket, *gosnmp.GoSNMP) (*gosnmp.SnmpPacket, error)
+	f = gosnmp.Forward
+	_ = f
+	var fc func(context.Context, *gosnmp.SnmpPacket, *gosnmp.GoSNMP) (*gosnmp.SnmpPacket, error)
+	fc = gosnmp.ForwardContext
+	_ = fc
+}
+
+func TestAPIEngineIDSignatures(t *testing.T) {
+	var fi func(uint32, net.IP) (string, error)
+	fi = gosnmp.EngineIDFromIP
+	_ = fi
+	var fm func(uint32, net.HardwareAddr) (string, error)
+	fm = gosnmp.EngineIDFromMAC
+	_ = fm
+	var ft func(uint32, string) (string, error)
+	ft = gosnmp.EngineIDFromText
+	_ = ft
+}
+
+func TestAPITrapAccessorSignatures(t *testing.T) {
+	var p gosnmp.SnmpPacket
+	var fo func() (string, bool)
+	fo = p.TrapOID
+	_ = fo
+	var fu func() (uint32, bool)
+	fu = p.TrapUptime
+	_ = fu
+	var fv func() []gosnmp.SnmpPDU
+	fv = p.TrapVariables
+	_ = fv
+}
+
+func TestAPIToV2TrapSignature(t *testing.T) {
+	var p gosnmp.SnmpPacket
+	var f func() *gosnmp.SnmpPacket
+	f = p.ToV2Trap
+	_ = f
+}
+
+func TestAPIBulkWalkTableSignatures(t *testing.T) {
+	var f func(string) (map[string]map[string]gosnmp.SnmpPDU, error)
+	f = gosnmp.Default.BulkWalkTable
+	_ = f
+	var fc func(context.Context, string) (map[string]map[string]gosnmp.SnmpPDU, error)
+	fc = gosnmp.Default.BulkWalkTableContext
+	_ = fc
+}
+
+func TestAPIOnRetryAttemptSignature(t *testing.T) {
+	var f func(*gosnmp.GoSNMP, int, error)
+	f = gosnmp.Default.OnRetryAttempt
+	_ = f
+}
+
+func TestAPIWithV3EngineSignature(t *testing.T) {
+	var o gosnmp.Option = gosnmp.WithV3Engine("\x80\x00\x1f\x88\x04gosnmp", 1, 1234)
+	_ = o
+}
+
+func TestAPIGetNoAuthNoPrivSignatures(t *testing.T) {
+	var f func(string, []string) (*gosnmp.SnmpPacket, error)
+	f = gosnmp.Default.GetNoAuthNoPriv
+	_ = f
+	var fc func(context.Context, string, []string) (*gosnmp.SnmpPacket, error)
+	fc = gosnmp.Default.GetNoAuthNoPrivContext
+	_ = fc
+}
+
+func TestAPIOIDFormatSignatures(t *testing.T) {
+	var format gosnmp.OIDFormat = gosnmp.Default.OIDFormat
+	_ = format
+	var o gosnmp.Option = gosnmp.WithOIDFormat(gosnmp.OIDNoLeadingDot)
+	_ = o
+	var f func(string) string
+	f = gosnmp.AddLeadingDot
+	f = gosnmp.TrimLeadingDot
+	f = gosnmp.OIDLeadingDot.Format
+	_ = f
+}
+
+func TestAPIMIBSignatures(t *testing.T) {
+	var m *gosnmp.MIB = gosnmp.NewMIB()
+	var load func(io.Reader) error
+	load = m.LoadModule
+	load = m.LoadMap
+	_ = load
+	var translate func(string) (string, string)
+	translate = m.TranslateOID
+	_ = translate
+	var reverse func(string) (string, error)
+	reverse = m.TranslateName
+	_ = reverse
+	var add func(string, string) error
+	add = m.Add
+	_ = add
+	var name func(string) string
+	name = m.Name
+	_ = name
+}
+
+func TestAPIDecodeIndexSignature(t *testing.T) {
+	var f func(string, string, ...gosnmp.IndexSyntax) ([]interface{}, error)
+	f = gosnmp.DecodeIndex
+	_ = f
+	_ = []gosnmp.IndexSyntax{gosnmp.IndexInteger, gosnmp.IndexIPAddress, gosnmp.IndexMACAddress,
+		gosnmp.IndexString, gosnmp.IndexImpliedString, gosnmp.IndexOID, gosnmp.IndexImpliedOID}
+}
+
+func TestAPIAsyncSignatures(t *testing.T) {
+	var get func(context.Context, []string) *gosnmp.Pending
+	get = gosnmp.Default.GetAsync
+	get = gosnmp.Default.GetNextAsync
+	_ = get
+	var bulk func(context.Context, []string, uint8, uint32) *gosnmp.Pending
+	bulk = gosnmp.Default.GetBulkAsync
+	_ = bulk
+	var set func(context.Context, []gosnmp.SnmpPDU) *gosnmp.Pending
+	set = gosnmp.Default.SetAsync
+	_ = set
+
+	var p gosnmp.Pending
+	var done func() <-chan struct{}
+	done = p.Done
+	_ = done
+	var result func() (*gosnmp.SnmpPacket, error)
+	result = p.Poll
+	result = p.Wait
+	_ = result
+	var cancel func()
+	cancel = p.Cancel
+	_ = cancel
+	_ = gosnmp.ErrPending
+}
+
+func TestAPICompletionsSignature(t *testing.T) {
+	var f func(...*gosnmp.Pending) <-chan *gosnmp.Pending
+	f = gosnmp.Completions
+	_ = f
+}
+
+func TestAPIWithCommunityBytesSignature(t *testing.T) {
+	var o gosnmp.Option = gosnmp.WithCommunityBytes([]byte{'p', 0, 0xff})
+	_ = o
+}
+
+func TestAPIIsCounterSignature(t *testing.T) {
+	var f func() bool
+	f = gosnmp.SnmpPDU{}.IsCounter
+	_ = f
+}
+
+func TestAPIDiscoveryRetriesSignatures(t *testing.T) {
+	var n int = gosnmp.Default.DiscoveryRetries
+	_ = n
+	var o gosnmp.Option = gosnmp.WithDiscoveryRetries(3)
+	_ = o
+}
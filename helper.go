@@ -36,6 +36,7 @@ var (
 	ErrIntegerTooLarge         = errors.New("integer too large")
 	ErrInvalidOidLength        = errors.New("invalid OID length")
 	ErrInvalidPacketLength     = errors.New("invalid packet length")
+	ErrWrongValueType          = errors.New("wrong value type")
 	ErrZeroByteBuffer          = errors.New("zero byte buffer")
 	ErrZeroLenInteger          = errors.New("zero length integer")
 )
@@ -110,6 +111,34 @@ func (x *GoSNMP) decodeValue(data []byte, retVal *variable) error {
 
 		retVal.Type = OctetString
 		retVal.Value = data[cursor:length]
+	case NsapAddress:
+		// 0x45, an OSI address of up to 21 octets (RFC 1442)
+		x.Logger.Print("decodeValue: type is NsapAddress")
+		length, cursor, err := parseLength(data)
+		if err != nil {
+			return err
+		}
+		if length > len(data) {
+			return fmt.Errorf("not enough data for NsapAddress %x (data %d length %d)", data, len(data), length)
+		}
+		retVal.Type = NsapAddress
+		retVal.Value = data[cursor:length]
+	case BitString:
+		// 0x03
+		x.Logger.Print("decodeValue: type is BitString")
+		length, cursor, err := parseLength(data)
+		if err != nil {
+			return err
+		}
+		if length > len(data) {
+			return fmt.Errorf("bytes: % x err: truncated (data %d length %d)", data, len(data), length)
+		}
+		ret, err := parseBitString(data[cursor:length])
+		if err != nil {
+			return fmt.Errorf("bytes: % x err: %w", data[:length], err)
+		}
+		retVal.Type = BitString
+		retVal.Value = ret
 	case Null:
 		// 0x05
 		x.Logger.Print("decodeValue: type is Null")
@@ -150,7 +179,7 @@ func (x *GoSNMP) decodeValue(data []byte, retVal *variable) error {
 				return fmt.Errorf("not enough data for ipv6 address: %x", data)
 			}
 			d := make(net.IP, 16)
-			copy(d, data[2:17])
+			copy(d, data[2:18])
 			retVal.Value = d.String()
 		default:
 			return fmt.Errorf("got ipaddress len %d, expected 4 or 16", data[1])
@@ -253,9 +282,18 @@ func (x *GoSNMP) decodeValue(data []byte, retVal *variable) error {
 		retVal.Type = EndOfMibView
 		retVal.Value = nil
 	default:
+		// eg a private application tag: keep the tag and the content octets
+		// so that one odd variable does not lose the rest of the PDU
 		x.Logger.Printf("decodeValue: type %x isn't implemented", data[0])
-		retVal.Type = UnknownType
-		retVal.Value = nil
+		length, cursor, err := parseLength(data)
+		if err != nil {
+			return err
+		}
+		if length > len(data) {
+			return fmt.Errorf("bytes: % x err: truncated (data %d length %d)", data, len(data), length)
+		}
+		retVal.Type = Asn1BER(data[0])
+		retVal.Value = data[cursor:length]
 	}
 	x.Logger.Printf("decodeValue: value is %#v", retVal.Value)
 	return nil
@@ -328,12 +366,73 @@ func marshalInt32(value int) ([]byte, error) {
 	}
 }
 
-func marshalUint64(v interface{}) []byte {
-	bs := make([]byte, 8)
-	source := v.(uint64)
-	binary.BigEndian.PutUint64(bs, source) // will panic on failure
-	// truncate leading zeros. Cleaner technique?
-	return bytes.TrimLeft(bs, "\x00")
+// unsignedValue returns v, a Go integer of any type, as a uint64, or an
+// error if it is negative or greater than max.
+func unsignedValue(v interface{}, max uint64) (uint64, error) {
+	var u uint64
+	var i int64
+	switch val := v.(type) {
+	case uint:
+		u = uint64(val)
+	case uint8:
+		u = uint64(val)
+	case uint16:
+		u = uint64(val)
+	case uint32:
+		u = uint64(val)
+	case uint64:
+		u = val
+	case int:
+		i = int64(val)
+	case int8:
+		i = int64(val)
+	case int16:
+		i = int64(val)
+	case int32:
+		i = int64(val)
+	case int64:
+		i = val
+	default:
+		return 0, fmt.Errorf("value %v of type %T is not an integer", v, v)
+	}
+	if i < 0 {
+		return 0, fmt.Errorf("value %d is negative", i)
+	} else if i > 0 {
+		u = uint64(i)
+	}
+	if u > max {
+		return 0, fmt.Errorf("value %d overflows %d", u, max)
+	}
+	return u, nil
+}
+
+func marshalUint64(v interface{}) ([]byte, error) {
+	var source uint64
+	switch val := v.(type) {
+	case uint64:
+		source = val
+	case uint:
+		source = uint64(val)
+	case uint32:
+		source = uint64(val)
+	default:
+		return nil, fmt.Errorf("unable to marshal %T to uint64", v)
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, source)
+	// keep at least one byte, zero is encoded as 0x00
+	var i int
+	for i = 0; i < 7; i++ {
+		if buf[i] != 0 {
+			break
+		}
+	}
+	buf = buf[i:]
+	// if the highest bit is set prepend a zero byte, BER integers are signed
+	if buf[0]&0x80 > 0 {
+		buf = append([]byte{0}, buf...)
+	}
+	return buf, nil
 }
 
 // Counter32, Gauge32, TimeTicks, Unsigned32, SNMPError
@@ -370,14 +469,30 @@ func marshalUint32(v interface{}) ([]byte, error) {
 }
 
 func marshalFloat32(v interface{}) ([]byte, error) {
-	source := v.(float32)
+	var source float32
+	switch v := v.(type) {
+	case float32:
+		source = v
+	case float64:
+		source = float32(v)
+	default:
+		return nil, fmt.Errorf("unable to marshal %T to float32", v)
+	}
 	out := bytes.NewBuffer(nil)
 	err := binary.Write(out, binary.BigEndian, source)
 	return out.Bytes(), err
 }
 
 func marshalFloat64(v interface{}) ([]byte, error) {
-	source := v.(float64)
+	var source float64
+	switch v := v.(type) {
+	case float32:
+		source = float64(v)
+	case float64:
+		source = v
+	default:
+		return nil, fmt.Errorf("unable to marshal %T to float64", v)
+	}
 	out := bytes.NewBuffer(nil)
 	err := binary.Write(out, binary.BigEndian, source)
 	return out.Bytes(), err
@@ -441,6 +556,10 @@ func marshalObjectIdentifier(oid string) ([]byte, error) {
 			val *= 10
 			val += ch
 			j++
+			// subidentifiers are unsigned 32 bit; stop before val overflows
+			if val > MaxObjectSubIdentifierValue {
+				return []byte{}, fmt.Errorf("unable to marshal OID: Value out of range")
+			}
 		}
 		switch i {
 		case 0:
@@ -449,11 +568,15 @@ func marshalObjectIdentifier(oid string) ([]byte, error) {
 			}
 			oidBase = int(val * 40)
 		case 1:
-			if val >= 40 {
+			// X.690 8.19.4: only arc 2 has more than 40 arcs below it
+			if val >= 40 && oidBase != 80 {
 				return []byte{}, fmt.Errorf("unable to marshal OID: Invalid object identifier")
 			}
+			if val > MaxObjectSubIdentifierValue-80 {
+				return []byte{}, fmt.Errorf("unable to marshal OID: Value out of range")
+			}
 			oidBase += int(val)
-			err = out.WriteByte(byte(oidBase))
+			err = marshalBase128Int(out, int64(oidBase))
 			if err != nil {
 				return []byte{}, fmt.Errorf("unable to marshal OID: Invalid object identifier")
 			}
@@ -469,16 +592,41 @@ func marshalObjectIdentifier(oid string) ([]byte, error) {
 		}
 		i++
 	}
-	if i < 2 || i > 128 {
+	// RFC 2578 limits OIDs to 128 subidentifiers, but agents send longer
+	// ones from deeply indexed tables and they encode just the same
+	if i < 2 {
 		return []byte{}, fmt.Errorf("unable to marshal OID: Invalid object identifier")
 	}
 
 	return out.Bytes(), nil
 }
 
-// TODO no tests
-func ipv4toBytes(ip net.IP) []byte {
-	return []byte(ip)[12:]
+// ipAddressBytes returns the octets of an IPAddress value given as a
+// net.IP, its string form or the octets themselves, 4 or 16 of them.
+func ipAddressBytes(value interface{}) ([]byte, error) {
+	var ip net.IP
+	switch v := value.(type) {
+	case []byte:
+		if len(v) != net.IPv4len && len(v) != net.IPv6len {
+			return nil, fmt.Errorf("invalid IP address %x", v)
+		}
+		return v, nil
+	case net.IP:
+		ip = v
+	case string:
+		if ip = net.ParseIP(v); ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", v)
+		}
+	default:
+		return nil, fmt.Errorf("unable to marshal PDU IPAddress; not []byte, string or net.IP")
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, nil
+	}
+	if len(ip) != net.IPv6len {
+		return nil, fmt.Errorf("invalid IP address %x", []byte(ip))
+	}
+	return ip, nil
 }
 
 // parseOpaque  parses a Opaque encoded data
@@ -502,6 +650,9 @@ func parseOpaque(logger Logger, data []byte, retVal *variable) error {
 			if length > len(data) {
 				return fmt.Errorf("not enough data for OpaqueDouble %x (data %d length %d)", data, len(data), length)
 			}
+			if cursor > length {
+				return fmt.Errorf("invalid cursor position for OpaqueDouble %x (data %d length %d cursor %d)", data, len(data), length, cursor)
+			}
 			retVal.Type = OpaqueDouble
 			retVal.Value, err = parseFloat64(data[cursor:length])
 			if err != nil {
@@ -608,19 +759,23 @@ func parseInt(bytes []byte) (int, error) {
 //   - Long form. Two to 127 octets. Bit 8 of first octet has value "1" and bits
 //     7-1 give the number of additional length octets. Second and following
 //     octets give the length, base 256, most significant digit first.
+//
+// The indefinite form, and long forms of more than four octets, which could
+// overflow an int, are rejected.
 func parseLength(bytes []byte) (int, int, error) {
 	var cursor, length int
 	switch {
-	case len(bytes) <= 2:
-		// handle null octet strings ie "0x04 0x00"
-		cursor = len(bytes)
-		length = len(bytes)
+	case len(bytes) < 2:
+		return 0, 0, ErrInvalidPacketLength
 	case int(bytes[1]) <= 127:
 		length = int(bytes[1])
 		length += 2
 		cursor += 2
 	default:
 		numOctets := int(bytes[1]) & 127
+		if numOctets == 0 || numOctets > 4 {
+			return 0, 0, ErrInvalidPacketLength
+		}
 		for i := 0; i < numOctets; i++ {
 			length <<= 8
 			if len(bytes) < 2+i+1 {
@@ -651,31 +806,50 @@ func parseObjectIdentifier(src []byte) (string, error) {
 		return "", ErrInvalidOidLength
 	}
 
-	out := new(bytes.Buffer)
+	// format into scratch space on the stack, leaving the string itself
+	// as the only allocation for OIDs of usual length
+	var scratch [128]byte
+	out := scratch[:0]
 
-	out.WriteByte('.')
-	out.WriteString(strconv.FormatInt(int64(int(src[0])/40), 10))
-	out.WriteByte('.')
-	out.WriteString(strconv.FormatInt(int64(int(src[0])%40), 10))
+	// the first subidentifier holds the first two arcs (X.690 8.19.4)
+	v, offset, err := parseBase128Int(src, 0)
+	if err != nil {
+		return "", err
+	}
+	if v > MaxObjectSubIdentifierValue {
+		return "", ErrBase128IntegerTooLarge
+	}
+	first := v / 40
+	if first > 2 {
+		first = 2
+	}
+	out = append(out, '.')
+	out = strconv.AppendInt(out, first, 10)
+	out = append(out, '.')
+	out = strconv.AppendInt(out, v-first*40, 10)
 
-	var v int64
-	var err error
-	for offset := 1; offset < len(src); {
-		out.WriteByte('.')
+	for offset < len(src) {
+		out = append(out, '.')
 		v, offset, err = parseBase128Int(src, offset)
 		if err != nil {
 			return "", err
 		}
-		out.WriteString(strconv.FormatInt(v, 10))
+		// subidentifiers are unsigned 32 bit (RFC 2578 7.1.3)
+		if v > MaxObjectSubIdentifierValue {
+			return "", ErrBase128IntegerTooLarge
+		}
+		out = strconv.AppendInt(out, v, 10)
 	}
-	return out.String(), nil
+	return string(out), nil
 }
 
 func parseRawField(logger Logger, data []byte, msg string) (interface{}, int, error) {
 	if len(data) == 0 {
 		return nil, 0, fmt.Errorf("empty data passed to parseRawField")
 	}
-	logger.Printf("parseRawField: %s", msg)
+	if logger.enabled() {
+		logger.Printf("parseRawField: %s", msg)
+	}
 	switch Asn1BER(data[0]) {
 	case Integer:
 		length, cursor, err := parseLength(data)
@@ -860,6 +1034,39 @@ func (b BitStringValue) RightAlign() []byte {
 	return a
 }
 
+// parseBitString parses the content of a BIT STRING: the count of unused
+// bits at the end, then the bits. Unused bits need not be zero, as some
+// agents leave them set; they are outside BitLength either way.
+func parseBitString(bytes []byte) (ret BitStringValue, err error) {
+	if len(bytes) == 0 {
+		err = errors.New("zero length BIT STRING")
+		return
+	}
+	paddingBits := int(bytes[0])
+	if paddingBits > 7 || len(bytes) == 1 && paddingBits > 0 {
+		err = errors.New("invalid padding bits in BIT STRING")
+		return
+	}
+	ret.BitLength = (len(bytes)-1)*8 - paddingBits
+	ret.Bytes = bytes[1:]
+	return
+}
+
+// Set returns the indices of the bits that are set, in increasing order.
+func (b BitStringValue) Set() []int {
+	n := b.BitLength
+	if n > 8*len(b.Bytes) {
+		n = 8 * len(b.Bytes)
+	}
+	var set []int
+	for i := 0; i < n; i++ {
+		if b.At(i) == 1 {
+			set = append(set, i)
+		}
+	}
+	return set
+}
+
 // -- SnmpVersion --------------------------------------------------------------
 
 func (s SnmpVersion) String() string {
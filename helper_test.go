@@ -9,6 +9,7 @@ package gosnmp
 
 import (
 	"encoding/base64"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -44,8 +45,39 @@ func TestParseObjectIdentifierWithOtherOid(t *testing.T) {
 	}
 }
 
+func TestObjectIdentifierLargeSubidentifiers(t *testing.T) {
+	for _, test := range []struct {
+		oid     string
+		encoded []byte
+	}{
+		// 4000000000 and 2^32-1 need all five base-128 octets
+		{".1.3.6.1.4.1.9.4000000000", []byte{0x2b, 6, 1, 4, 1, 9, 0x8e, 0xf3, 0xac, 0xd0, 0x00}},
+		{".1.3.6.1.4294967295", []byte{0x2b, 6, 1, 0x8f, 0xff, 0xff, 0xff, 0x7f}},
+		{".1.3.2147483648.1", []byte{0x2b, 0x88, 0x80, 0x80, 0x80, 0x00, 1}},
+		// arc 2 may have more than 40 arcs below it
+		{".2.999.3", []byte{0x88, 0x37, 3}},
+	} {
+		encoded, err := marshalObjectIdentifier(test.oid)
+		if assert.NoError(t, err, test.oid) {
+			assert.Equal(t, test.encoded, encoded, test.oid)
+		}
+		oid, err := parseObjectIdentifier(test.encoded)
+		assert.NoError(t, err, test.oid)
+		assert.Equal(t, test.oid, oid)
+	}
+
+	for _, oid := range []string{".1.3.6.1.4294967296", ".1.3.6.1.99999999999999999999", ".1.40"} {
+		_, err := marshalObjectIdentifier(oid)
+		assert.Error(t, err, oid)
+	}
+	// 2^32 does not fit a subidentifier either when decoding
+	_, err := parseObjectIdentifier([]byte{0x2b, 0x90, 0x80, 0x80, 0x80, 0x00})
+	assert.ErrorIs(t, err, ErrBase128IntegerTooLarge)
+}
+
 func BenchmarkParseObjectIdentifier(b *testing.B) {
 	oid := []byte{43, 6, 3, 30, 11, 1, 10}
+	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		parseObjectIdentifier(oid)
 	}
@@ -135,6 +167,105 @@ func TestParseUint64(t *testing.T) {
 	}
 }
 
+func TestSignedInteger(t *testing.T) {
+	for _, test := range []struct {
+		value   int
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{-1, []byte{0xff}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x00, 0x80}},
+		{-128, []byte{0x80}},
+		{-129, []byte{0xff, 0x7f}},
+		{-32768, []byte{0x80, 0x00}},
+		{-32769, []byte{0xff, 0x7f, 0xff}},
+		{math.MaxInt32, []byte{0x7f, 0xff, 0xff, 0xff}},
+		{math.MinInt32, []byte{0x80, 0x00, 0x00, 0x00}},
+	} {
+		encoded, err := marshalInt32(test.value)
+		if assert.NoError(t, err, test.value) {
+			assert.Equal(t, test.encoded, encoded, test.value)
+		}
+		decoded, err := parseInt(test.encoded)
+		assert.NoError(t, err, test.value)
+		assert.Equal(t, test.value, decoded)
+
+		// and as a varbind, from any signed integer type
+		for _, value := range []interface{}{test.value, int32(test.value), int64(test.value)} {
+			vb, err := marshalVarbind(&SnmpPDU{Name: ".1.3.6.1.4.1.1.1", Type: Integer, Value: value})
+			if assert.NoError(t, err, "%T %v", value, value) {
+				assert.Equal(t, test.encoded, vb[len(vb)-len(test.encoded):], "%T %v", value, value)
+			}
+		}
+	}
+
+	_, err := marshalVarbind(&SnmpPDU{Name: ".1.3.6.1.4.1.1.1", Type: Integer, Value: int64(math.MaxInt32 + 1)})
+	assert.Error(t, err)
+	vb, err := marshalVarbind(&SnmpPDU{Name: ".1.3.6.1.4.1.1.1", Type: Integer, Value: byte(200)})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte{byte(Integer), 2, 0x00, 0xc8}, vb[len(vb)-4:])
+	}
+}
+
+func TestCounter64(t *testing.T) {
+	tests := []struct {
+		n       uint64
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{200, []byte{0x00, 0xc8}},
+		{1<<32 + 5, []byte{0x01, 0x00, 0x00, 0x00, 0x05}},
+		{1<<63 + 1, []byte{0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}},
+		{math.MaxUint64, []byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	}
+	x := &GoSNMP{Logger: NewLogger(nil)}
+	for _, test := range tests {
+		encoded, err := marshalUint64(test.n)
+		assert.NoError(t, err)
+		assert.Equal(t, test.encoded, encoded, "marshalUint64(%d)", test.n)
+
+		varbind, err := marshalVarbind(&SnmpPDU{Name: ".1.2", Type: Counter64, Value: test.n})
+		if !assert.NoError(t, err) {
+			continue
+		}
+		var v variable
+		assert.NoError(t, x.decodeValue(varbind[5:], &v))
+		assert.Equal(t, Counter64, v.Type)
+		// uint64 on every platform, never a native uint
+		assert.Equal(t, test.n, v.Value)
+	}
+
+	_, err := marshalUint64(-1)
+	assert.Error(t, err)
+}
+
+func TestUnsignedVarbindTypes(t *testing.T) {
+	x := &GoSNMP{Logger: NewLogger(nil)}
+	// the type of the PDU decides the encoding, not that of the Go value
+	for _, typ := range []Asn1BER{Counter32, Gauge32, TimeTicks, Uinteger32, Counter64} {
+		for _, value := range []interface{}{uint(50), uint8(50), uint16(50), uint32(50), uint64(50), 50, int64(50)} {
+			varbind, err := marshalVarbind(&SnmpPDU{Name: ".1.2", Type: typ, Value: value})
+			if !assert.NoError(t, err, "%v %T", typ, value) {
+				continue
+			}
+			var v variable
+			assert.NoError(t, x.decodeValue(varbind[5:], &v))
+			assert.Equal(t, typ, v.Type, "%v %T", typ, value)
+		}
+		_, err := marshalVarbind(&SnmpPDU{Name: ".1.2", Type: typ, Value: -1})
+		assert.Error(t, err, typ)
+		_, err = marshalVarbind(&SnmpPDU{Name: ".1.2", Type: typ, Value: "50"})
+		assert.Error(t, err, typ)
+	}
+	_, err := marshalVarbind(&SnmpPDU{Name: ".1.2", Type: Gauge32, Value: uint64(math.MaxUint32 + 1)})
+	assert.Error(t, err)
+	varbind, err := marshalVarbind(&SnmpPDU{Name: ".1.2", Type: Gauge32, Value: uint64(math.MaxUint32)})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte{byte(Gauge32), 5, 0x00, 0xff, 0xff, 0xff, 0xff}, varbind[len(varbind)-7:])
+	}
+}
+
 var testsInvalidSNMPResponses = []string{
 	"MIIHIQIBAQQHcHJpdmF0ZaKCBxECBGwvRyoCAQACAQAwggcBMBgGCCsGAQIBAQIABgwrBgEEAZJRAwE/AQYwEAYIKwYBAgEBAwBDBBU2aN0wDAYIKwYBAgEBBAAEADAMBggrBgECAQEFAAQAMAwGCCsGAQIBAQYABAAwDQYIKwYBAgEBBwACAUgwDQYIKwYBAgECAQACAQAwDwYKKwYBAgECAgEBAQIBATAPBgorBgECAQICAQcBAgEBMA8GCisGAQIBAgIBBwECAQEwDwYKKwYBAgECAgEHAQIBATAPBgorBgECAQICAQcBAgEBMA8GCisGAQIBAgIBBwECAQEwDwYKKwYBAgECAgEHAQIBATAPBgorBgECAQICAQcBAgEBMBAGCCsGAQIBAQMAQwQVNmjdMAwGCCsGAQIBAQQABAAwDAYIKwYBAgEBBQAEADAMBggrBgECAQEGAAQAMA0GCCsGAQIBAQcAAgFIMA0GCCsGAQIBAgEAAgEAMA8GCisGAQIBAgIBAQECAQEwFgYKKwYBAgECAgECAQQIRXRoZXJuZXQwDwYKKwYBAgECAgEIAQIBATAPBgorBgECAQICAQgBAgEBMA8GCisGAQIBAgIBCAECAQEwDwYKKwYBAgECAgEIAQIBATAPBgorBgECAQICAQgBAgEBMA8GCisGAQIBAgIBCAECAQEwDwYKKwYBAgECAgEIAQIBATAMBggrBgECAQEEAAQAMAwGCCsGAQIBAQUABAAwDAYIKwYBAgEBBgAEADANBggrBgECAQEHAAIBSDANBggrBgECAQIBAAIBADAPBgorBgECAQICAQEBAgEBMBYGCisGAQIBAgIBAgEECEV0aGVybmV0MA8GCisGAQIBAgIBAwECAQYwDwYKKwYBAgECAgEJAUMBADAPBgorBgECAQICAQkBQwEAMA8GCisGAQIBAgIBCQFDAQAwDwYKKwYBAgECAgEJAUMBADAPBgorBgECAQICAQkBQwEAMA8GCisGAQIBAgIBCQFDAQAwDwYKKwYBAgECAgEJAUMBADAMBggrBgECAQEFAAQAMAwGCCsGAQIBAQYABAAwDQYIKwYBAgEBBwACAUgwDQYIKwYBAgECAQACAQAwDwYKKwYBAgECAgEBAQIBATAWBgorBgECAQICAQIBBAhFdGhlcm5ldDAPBgorBgECAQICAQMBAgEGMBAGCisGAQIBAgIBBAECAgXqMBIGCisGAQIBAgIBCgFBBQCUMR+2MBIGCisGAQIBAgIBCgFBBQCUMR+2MBIGCisGAQIBAgIBCgFBBQCUMR+2MBIGCisGAQIBAgIBCgFBBQCUMR+2MBIGCisGAQIBAgIBCgFBBQCUMR+2MBIGCisGAQIBAgIBCgFBBQCUMR+2MBIGCisGAQIBAgIBCgFBBQCUMR+2MAwGCCsGAQIBAQYABAAwDQYIKwYBAgEBBwACAUgwDQYIKwYBAgECAQACAQAwDwYKKwYBAgECAgEBAQIBATAWBgorBgECAQICAQIBBAhFdGhlcm5ldDAPBgorBgECAQICAQMBAgEGMBAGCisGAQIBAgIBBAECAgXqMBIGCisGAQIBAgIBBQFCBDuaygAwEQYKKwYBAgECAgELAUEDDQDJMBEGCisGAQIBAgIBCwFBAw0AyTARBgorBgECAQICAQsBQQMNAMkwEQYKKwYBAgECAgELAUEDDQDJMBEGCisGAQIBAgIBCwFBAw0AyTARBgorBgECAQICAQsBQQMNAMkwEQYKKwYBAgECAgELAUEDDQDJMA0GCCsGAQIBAQcAAgFIMA0GCCsGAQIBAgEAAgEAMA8GCisGAQIBAgIBAQECAQEwFgYKKwYBAgECAgECAQQIRXRoZXJuZXQwDwYKKwYBAgECAgEDAQIBBjAQBgorBgECAQICAQQBAgIF6jASBgorBgECAQICAQUBQgQ7msoAMBQGCisGAQIBAgIBBgEEBryxgWZeBTASBgorBgECAQICAQwBQQQAu5coMBIGCisGAQIBAgIBDAFBBAC7lygwEgYKKwYBAgECAgEMAUEEALuXKDASBgorBgECAQICAQwBQQQAu5coMBIGCisGAQIBAgIBDAFBBAC7lygwEgYKKwYBAgECAgEMAUEEALuXKDASBgorBgECAQICAQwBQQQAu5coMA0GCCsGAQIBAgEAAgEAMA8GCisGAQIBAgIBAQECAQEwFgYKKwYBAgECAgECAQQIRXRoZXJuZXQwDwYKKwYBAgECAgEDAQIBBjAQBgorBgECAQICAQQBAgIF6jASBgorBgECAQICAQUBQgQ7msoAMBQGCisGAQIBAgIBBgEEBryxgWZeBTAPBgorBgECAQICAQcBAgEBMBEGCisGAQIBAgIBDQFBAwdNFzARBgorBgECAQICAQ0BQQMHTRcwEQYKKwYBAgECAgE=",
 	"MBoCAQEEB3ByaXZhdGWiDAIESESkywIBBQIBAA==",
@@ -181,3 +312,125 @@ func checkByteEquality2(a, b []byte) bool {
 
 	return true
 }
+
+func TestOpaqueFloatRoundTrip(t *testing.T) {
+	x := &GoSNMP{Logger: NewLogger(nil)}
+	for _, pdu := range []SnmpPDU{
+		{Name: ".1.2", Type: OpaqueFloat, Value: float32(10.5)},
+		{Name: ".1.2", Type: OpaqueFloat, Value: float64(-2.25)},
+		{Name: ".1.2", Type: OpaqueDouble, Value: float64(1e100)},
+		{Name: ".1.2", Type: OpaqueDouble, Value: float32(0.5)},
+	} {
+		varbind, err := marshalVarbind(&pdu)
+		if !assert.NoError(t, err, pdu) {
+			continue
+		}
+		// Sequence, length, OID .1.2, then the Opaque value
+		var v variable
+		assert.NoError(t, x.decodeValue(varbind[5:], &v), pdu)
+		assert.Equal(t, pdu.Type, v.Type)
+		assert.EqualValues(t, pdu.Value, v.Value)
+	}
+
+	_, err := marshalVarbind(&SnmpPDU{Name: ".1.2", Type: OpaqueFloat, Value: 1})
+	assert.Error(t, err)
+
+	// unknown Opaque payloads are returned as raw bytes
+	var v variable
+	assert.NoError(t, x.decodeValue([]byte{0x44, 0x03, 0x9f, 0x70, 0x00}, &v))
+	assert.Equal(t, Opaque, v.Type)
+	assert.Equal(t, []byte{0x9f, 0x70, 0x00}, v.Value)
+
+	// a length that is shorter than its own header
+	assert.Error(t, x.decodeValue([]byte{0x44, 0x03, 0x9f, 0x79, 0x81}, &v))
+}
+
+func TestBitStringRoundTrip(t *testing.T) {
+	x := &GoSNMP{Logger: NewLogger(nil)}
+	pdu := SnmpPDU{Name: ".1.2", Type: BitString, Value: BitStringValue{Bytes: []byte{0xa0, 0x40}, BitLength: 10}}
+	varbind, err := marshalVarbind(&pdu)
+	if err != nil {
+		t.Fatalf("marshalVarbind() err: %v", err)
+	}
+	// Sequence, length, OID .1.2, then the BIT STRING of 6 unused bits
+	assert.Equal(t, []byte{0x03, 0x03, 0x06, 0xa0, 0x40}, varbind[5:])
+	var v variable
+	assert.NoError(t, x.decodeValue(varbind[5:], &v))
+	assert.Equal(t, BitString, v.Type)
+	assert.Equal(t, pdu.Value, v.Value)
+
+	// unused bits that are set are ignored
+	assert.NoError(t, x.decodeValue([]byte{0x03, 0x02, 0x04, 0x8f}, &v))
+	assert.Equal(t, BitStringValue{Bytes: []byte{0x8f}, BitLength: 4}, v.Value)
+	assert.Equal(t, []int{0}, v.Value.(BitStringValue).Set())
+
+	for _, data := range [][]byte{
+		{0x03, 0x00},
+		{0x03, 0x01, 0x01},
+		{0x03, 0x02, 0x08, 0xff},
+		{0x03, 0x03, 0x00, 0xff},
+	} {
+		assert.Error(t, x.decodeValue(data, &v), "%x", data)
+	}
+
+	_, err = marshalVarbind(&SnmpPDU{Name: ".1.2", Type: BitString, Value: BitStringValue{Bytes: []byte{0xff}, BitLength: 9}})
+	assert.Error(t, err)
+	_, err = marshalVarbind(&SnmpPDU{Name: ".1.2", Type: OctetString, Value: BitStringValue{Bytes: []byte{0xff}, BitLength: 8}})
+	assert.Error(t, err)
+}
+
+func TestDecodeOtherApplicationTypes(t *testing.T) {
+	x := &GoSNMP{Logger: NewLogger(nil)}
+	var v variable
+
+	assert.NoError(t, x.decodeValue([]byte{0x45, 0x03, 0x47, 0x00, 0x05}, &v))
+	assert.Equal(t, NsapAddress, v.Type)
+	assert.Equal(t, []byte{0x47, 0x00, 0x05}, v.Value)
+
+	assert.NoError(t, x.decodeValue([]byte{0x47, 0x05, 0x00, 0xff, 0xff, 0xff, 0xff}, &v))
+	assert.Equal(t, Uinteger32, v.Type)
+	assert.Equal(t, uint32(0xffffffff), v.Value)
+
+	// an unknown application tag keeps its tag and content
+	assert.NoError(t, x.decodeValue([]byte{0x4a, 0x02, 0x12, 0x34}, &v))
+	assert.Equal(t, Asn1BER(0x4a), v.Type)
+	assert.Equal(t, []byte{0x12, 0x34}, v.Value)
+	assert.Error(t, x.decodeValue([]byte{0x4a, 0x03, 0x12, 0x34}, &v))
+
+	pdu := SnmpPDU{Name: ".1.2", Type: NsapAddress, Value: []byte{0x47, 0x00, 0x05}}
+	varbind, err := marshalVarbind(&pdu)
+	if err != nil {
+		t.Fatalf("marshalVarbind() err: %v", err)
+	}
+	assert.NoError(t, x.decodeValue(varbind[5:], &v))
+	assert.Equal(t, NsapAddress, v.Type)
+	assert.Equal(t, pdu.Value, v.Value)
+}
+
+func TestParseLength(t *testing.T) {
+	for _, test := range []struct {
+		in             []byte
+		length, cursor int
+	}{
+		{[]byte{0x04, 0x00}, 2, 2},
+		{[]byte{0x04, 0x03}, 5, 2}, // truncated, for the caller to check
+		{[]byte{0x30, 0x81, 0x80}, 131, 3},
+		{[]byte{0x30, 0x84, 0x00, 0x00, 0x01, 0x00}, 262, 6},
+	} {
+		length, cursor, err := parseLength(test.in)
+		assert.NoError(t, err, "% x", test.in)
+		assert.Equal(t, test.length, length, "% x", test.in)
+		assert.Equal(t, test.cursor, cursor, "% x", test.in)
+	}
+	for _, in := range [][]byte{
+		{},
+		{0x04},
+		{0x30, 0x80},                // indefinite
+		{0x30, 0x82, 0x01},          // truncated
+		{0x30, 0x85, 1, 0, 0, 0, 0}, // five octets
+		{0x30, 0x89, 1, 0, 0, 0, 0, 0, 0, 0, 5},
+	} {
+		_, _, err := parseLength(in)
+		assert.ErrorIs(t, err, ErrInvalidPacketLength, "% x", in)
+	}
+}
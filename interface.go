@@ -334,6 +334,6 @@ func (x *snmpHandler) SetContextName(contextName string) {
 }
 
 func (x *snmpHandler) Close() error {
-	// not x.Conn for consistency
-	return x.GoSNMP.Conn.Close()
+	// not x.Close for consistency
+	return x.GoSNMP.Close()
 }
@@ -15,3 +15,9 @@ func (l *Logger) Print(v ...interface{}) {
 
 func (l *Logger) Printf(format string, v ...interface{}) {
 }
+
+// enabled reports whether anything is logged, so callers can skip building
+// expensive arguments.
+func (l *Logger) enabled() bool {
+	return false
+}
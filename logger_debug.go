@@ -18,3 +18,9 @@ func (l *Logger) Printf(format string, v ...interface{}) {
 		l.logger.Printf(format, v...)
 	}
 }
+
+// enabled reports whether anything is logged, so callers can skip building
+// expensive arguments.
+func (l *Logger) enabled() bool {
+	return l.logger != nil
+}
@@ -12,9 +12,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"os"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -38,6 +41,8 @@ const (
 // SnmpPacket struct represents the entire SNMP Message or Sequence at the
 // application layer.
 type SnmpPacket struct {
+	// Version is, in a response, the version the agent answered with,
+	// which some agents make SNMPv1 whatever the request was.
 	Version            SnmpVersion
 	MsgFlags           SnmpV3MsgFlags
 	SecurityModel      SnmpV3SecurityModel
@@ -56,6 +61,19 @@ type SnmpPacket struct {
 	Variables          []SnmpPDU
 	Logger             Logger
 
+	// Latency is set on responses to the time between sending the request
+	// they answer and receiving them, without marshalling and unmarshalling.
+	// With retries it is measured from the attempt the agent answered.
+	Latency time.Duration
+
+	// AuthErr is set, with InsecureSkipAuthVerify, on an SNMPv3 message
+	// whose authentication digest did not verify.
+	AuthErr error
+
+	// varbindsLen is the encoded length of the VarBinds of a decoded
+	// packet, less the header of their list.
+	varbindsLen int
+
 	// v1 traps have a very different format from v2c and v3 traps.
 	//
 	// These fields are set via the SnmpTrap parameter to SendTrap().
@@ -130,13 +148,118 @@ var (
 	ErrWrongDigest           = errors.New("wrong digest")
 )
 
+// A ReportError is returned for a request the agent answered with an
+// SNMPv3 Report PDU instead of a response. It wraps the Err value for the
+// report, so errors.Is(err, ErrWrongDigest) works, and its message says
+// what to check:
+//
+//	var report *gosnmp.ReportError
+//	if errors.As(err, &report) {
+//		log.Printf("%s: %s has counted %d", target, report.Name, report.Count)
+//	}
+type ReportError struct {
+	Err error
+
+	// Name is the OID of the counter reported, eg usmStatsWrongDigests.
+	Name string
+
+	// Count is the value of that counter, the number of such failures
+	// the agent has seen.
+	Count uint32
+}
+
+var reportErrors = map[string]struct { //nolint:gochecknoglobals
+	err  error
+	hint string
+}{
+	usmStatsUnsupportedSecLevels: {ErrUnknownSecurityLevel, "the user is not configured for this security level"},
+	usmStatsNotInTimeWindows:     {ErrNotInTimeWindow, "engine boots and time are out of step with the agent"},
+	usmStatsUnknownUserNames:     {ErrUnknownUsername, "the agent does not know the user"},
+	usmStatsUnknownEngineIDs:     {ErrUnknownEngineID, "the agent does not know the authoritative engine ID"},
+	usmStatsWrongDigests:         {ErrWrongDigest, "check the authentication protocol and passphrase"},
+	usmStatsDecryptionErrors:     {ErrDecryption, "check the privacy protocol and passphrase"},
+	snmpUnknownSecurityModels:    {ErrUnknownSecurityModels, "the agent does not support the security model"},
+	snmpInvalidMsgs:              {ErrInvalidMsgs, "the agent could not parse the message"},
+	snmpUnknownPDUHandlers:       {ErrUnknownPDUHandlers, "the agent has no handler for the PDU type"},
+}
+
+// newReportError returns the *ReportError for result, a Report PDU.
+func newReportError(result *SnmpPacket) error {
+	e := &ReportError{Err: ErrUnknownReportPDU}
+	if len(result.Variables) > 0 {
+		e.Name = result.Variables[0].Name
+		e.Count, _ = result.Variables[0].Value.(uint32)
+		if r, ok := reportErrors[e.Name]; ok {
+			e.Err = r.err
+		}
+	}
+	return e
+}
+
+// Unwrap returns the Err value for the report.
+func (e *ReportError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ReportError) Error() string {
+	if r, ok := reportErrors[e.Name]; ok {
+		return fmt.Sprintf("%s: %s (%s = %d)", e.Err, r.hint, e.Name, e.Count)
+	}
+	return fmt.Sprintf("%s %s", e.Err, e.Name)
+}
+
 const rxBufSize = 65535 // max size of IPv4 & IPv6 packet
 
+// bufPool recycles the scratch buffers of marshalling, so steady polling
+// does not allocate them per request. What is built in one is copied out
+// before it is put back.
+var bufPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }} //nolint:gochecknoglobals
+
+func getBuffer() *bytes.Buffer {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	// don't pin the memory of an unusually large message
+	if buf.Cap() <= rxBufSize {
+		bufPool.Put(buf)
+	}
+}
+
+// wrapBuffer returns a new slice holding tag, the length of buf and the
+// contents of buf.
+func wrapBuffer(tag byte, buf *bytes.Buffer) ([]byte, error) {
+	length, err := marshalLength(buf.Len())
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, 1+len(length)+buf.Len())
+	out = append(out, tag)
+	out = append(out, length...)
+	return append(out, buf.Bytes()...), nil
+}
+
 // Logger is an interface used for debugging. Both Print and
 // Printf have the same interfaces as Package Log in the std library. The
 // Logger interface is small to give you flexibility in how you do
 // your debugging.
 //
+// Everything gosnmp logs is debug tracing, so to route it into a leveled
+// logger implement LoggerInterface with that logger's debug methods, e.g.
+// for zap:
+//
+//	type zapDebug struct{ *zap.SugaredLogger }
+//
+//	func (z zapDebug) Print(v ...interface{})                 { z.Debug(v...) }
+//	func (z zapDebug) Printf(format string, v ...interface{}) { z.Debugf(format, v...) }
+//
+//	x.Logger = gosnmp.NewLogger(zapDebug{sugar})
+//
+// The zero Logger (or NewLogger(nil)) discards everything without
+// formatting; building with the gosnmp_nodebug tag compiles logging out.
+//
 
 // Logger
 // For verbose logging to stdout:
@@ -185,13 +308,69 @@ func (packet *SnmpPacket) SafeString() string {
 // send/receive one snmp request
 func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 	wait bool) (result *SnmpPacket, err error) {
-	allReqIDs := make([]uint32, 0, x.Retries+1)
-	// allMsgIDs := make([]uint32, 0, x.Retries+1) // unused
+	return x.sendOneRequestContext(x.Context, packetOut, wait)
+}
 
-	timeout := x.Timeout
+// sendOneRequestContext is sendOneRequest bound to ctx rather than x.Context.
+// Once ctx is done any pending read is aborted and ctx.Err() is returned.
+func (x *GoSNMP) sendOneRequestContext(ctx context.Context, packetOut *SnmpPacket,
+	wait bool) (result *SnmpPacket, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	metrics := x.metrics()
+	maxRetries := x.requestRetries(ctx)
+	allReqIDs := make([]uint32, 0, maxRetries+1)
+	allMsgIDs := make([]uint32, 0, maxRetries+1)
+	sentAt := make([]time.Time, 0, maxRetries+1) // of each attempt
+	var latency time.Duration
+
+	if wait && x.OnResponse != nil {
+		defer func() {
+			if err != nil && len(sentAt) > 0 {
+				latency = time.Since(sentAt[0])
+			}
+			x.OnResponse(x, packetOut, latency, err)
+		}()
+	}
+
+	// A blocked read only returns on its deadline, so when ctx is done pull
+	// the deadline into the past. The watcher sticks to the connection the
+	// request started on, AutoReconnect may replace x.Conn meanwhile, and
+	// exits with us, after which the deadline it set is cleared.
+	if done := ctx.Done(); done != nil {
+		conn := x.Conn
+		stop := make(chan struct{})
+		exited := make(chan struct{})
+		var fired bool
+		go func() {
+			defer close(exited)
+			select {
+			case <-done:
+				_ = conn.SetReadDeadline(time.Unix(1, 0))
+				fired = true
+			case <-stop:
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-exited
+			if fired {
+				x.clearReadDeadline(conn)
+			}
+		}()
+	}
+
+	timeout := x.requestTimeout(ctx)
 	withContextDeadline := false
 	for retries := 0; ; retries++ {
 		if retries > 0 {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if x.isClosed() {
+				return nil, ErrClosed
+			}
 			if x.OnRetry != nil {
 				x.OnRetry(x)
 			}
@@ -201,7 +380,7 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 				err = context.DeadlineExceeded
 				break
 			}
-			if retries > x.Retries {
+			if retries > maxRetries {
 				if strings.Contains(err.Error(), "timeout") {
 					err = fmt.Errorf("request timeout (after %d retries)", retries-1)
 				}
@@ -211,23 +390,36 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 				// https://www.webnms.com/snmp/help/snmpapi/snmpv3/v1/timeout.html
 				timeout *= 2
 			}
+			if x.OnRetryAttempt != nil {
+				x.OnRetryAttempt(x, retries+1, err)
+			}
+			metrics.IncRetry()
+			if err = x.RetryBackoff.wait(ctx, retries); err != nil {
+				return nil, err
+			}
 			withContextDeadline = false
 		}
 		err = nil
 
-		if x.Context.Err() != nil {
-			return nil, x.Context.Err()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if x.RateLimiter != nil {
+			if err = x.RateLimiter.Wait(ctx); err != nil {
+				return nil, err
+			}
 		}
 
 		reqDeadline := time.Now().Add(timeout)
-		if contextDeadline, ok := x.Context.Deadline(); ok {
+		if contextDeadline, ok := ctx.Deadline(); ok {
 			if contextDeadline.Before(reqDeadline) {
 				reqDeadline = contextDeadline
 				withContextDeadline = true
 			}
 		}
 
-		err = x.Conn.SetDeadline(reqDeadline)
+		// the read deadline is set by whoever reads, see receiveFor
+		err = x.Conn.SetWriteDeadline(reqDeadline)
 		if err != nil {
 			return nil, err
 		}
@@ -235,13 +427,14 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 		// Request ID is an atomic counter that wraps to 0 at max int32.
 		reqID := (atomic.AddUint32(&(x.requestID), 1) & 0x7FFFFFFF)
 		allReqIDs = append(allReqIDs, reqID)
+		sentAt = append(sentAt, time.Time{})
 
 		packetOut.RequestID = reqID
 
 		if x.Version == Version3 {
 			msgID := (atomic.AddUint32(&(x.msgID), 1) & 0x7FFFFFFF)
 
-			// allMsgIDs = append(allMsgIDs, msgID) // unused
+			allMsgIDs = append(allMsgIDs, msgID)
 
 			packetOut.MsgID = msgID
 
@@ -265,7 +458,9 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 		if x.PreSend != nil {
 			x.PreSend(x)
 		}
-		x.Logger.Printf("SENDING PACKET: %s", packetOut.SafeString())
+		if x.Logger.enabled() {
+			x.Logger.Printf("SENDING PACKET: %s", packetOut.SafeString())
+		}
 		// If using UDP and unconnected socket, send packet directly to stored address.
 		if uconn, ok := x.Conn.(net.PacketConn); ok && x.uaddr != nil {
 			_, err = uconn.WriteTo(outBuf, x.uaddr)
@@ -275,6 +470,9 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 		if err != nil {
 			continue
 		}
+		x.onPacket(PacketSent, outBuf)
+		sentAt[len(sentAt)-1] = time.Now()
+		metrics.IncRequest(packetOut.PDUType)
 		if x.OnSent != nil {
 			x.OnSent(x)
 		}
@@ -284,6 +482,12 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 			return &SnmpPacket{}, nil
 		}
 
+		// responses are matched on msgID in SNMPv3, request-id otherwise
+		waitIDs := allReqIDs
+		if x.Version == Version3 {
+			waitIDs = allMsgIDs
+		}
+
 	waitingResponse:
 		for {
 			x.Logger.Print("WAITING RESPONSE...")
@@ -291,8 +495,9 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 			// Let the deadline abort us if we don't receive a valid response.
 
 			var resp []byte
-			resp, err = x.receive()
-			if err == io.EOF && strings.HasPrefix(x.Transport, tcp) {
+			resp, err = x.receiveFor(ctx, waitIDs, reqDeadline)
+			receivedAt := time.Now()
+			if err == io.EOF && x.streamTransport() && !x.isClosed() {
 				// EOF on TCP: reconnect and retry. Do not count
 				// as retry as socket was broken
 				x.Logger.Printf("ERROR: EOF. Performing reconnect")
@@ -303,13 +508,18 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 				retries--
 				break
 			} else if err != nil {
+				if errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(ctx.Err(), context.Canceled) {
+					metrics.IncTimeout()
+				}
 				// receive error. retrying won't help. abort
 				break
 			}
 			if x.OnRecv != nil {
 				x.OnRecv(x)
 			}
-			x.Logger.Printf("GET RESPONSE OK: %+v", resp)
+			if x.Logger.enabled() {
+				x.Logger.Printf("GET RESPONSE OK: %+v", resp)
+			}
 			result = new(SnmpPacket)
 			result.Logger = x.Logger
 
@@ -326,15 +536,18 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 			}
 
 			if x.Version == Version3 {
+				// the answer is to the user and security level of the
+				// request, which need not be those of x
 				useResponseSecurityParameters := false
-				if usp, ok := x.SecurityParameters.(*UsmSecurityParameters); ok {
+				if usp, ok := packetOut.SecurityParameters.(*UsmSecurityParameters); ok {
 					if usp.AuthoritativeEngineID == "" {
 						useResponseSecurityParameters = true
 					}
 				}
-				err = x.testAuthentication(resp, result, useResponseSecurityParameters)
+				err = x.testAuthenticationAs(packetOut.MsgFlags, packetOut.SecurityParameters, resp, result, useResponseSecurityParameters)
 				if err != nil {
 					x.Logger.Printf("ERROR on Test Authentication on v3: %s", err)
+					metrics.IncAuthFailure()
 					break
 				}
 				resp, cursor, err = x.decryptPacket(resp, cursor, result)
@@ -373,33 +586,23 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 			// and will be retransmitted, for others we return the result with an error.
 			if result.Version == Version3 && result.PDUType == Report && len(result.Variables) == 1 {
 				switch result.Variables[0].Name {
-				case usmStatsUnsupportedSecLevels:
-					return result, ErrUnknownSecurityLevel
-				case usmStatsNotInTimeWindows:
-					break waitingResponse
-				case usmStatsUnknownUserNames:
-					return result, ErrUnknownUsername
-				case usmStatsUnknownEngineIDs:
+				case usmStatsUnsupportedSecLevels, usmStatsUnknownUserNames, usmStatsWrongDigests, usmStatsDecryptionErrors:
+					metrics.IncAuthFailure()
+				}
+				switch result.Variables[0].Name {
+				case usmStatsNotInTimeWindows, usmStatsUnknownEngineIDs:
 					break waitingResponse
-				case usmStatsWrongDigests:
-					return result, ErrWrongDigest
-				case usmStatsDecryptionErrors:
-					return result, ErrDecryption
-				case snmpUnknownSecurityModels:
-					return result, ErrUnknownSecurityModels
-				case snmpInvalidMsgs:
-					return result, ErrInvalidMsgs
-				case snmpUnknownPDUHandlers:
-					return result, ErrUnknownPDUHandlers
 				default:
-					return result, ErrUnknownReportPDU
+					return result, newReportError(result)
 				}
 			}
 
 			validID := false
-			for _, id := range allReqIDs {
+			attempt := len(sentAt) - 1
+			for i, id := range allReqIDs {
 				if id == result.RequestID {
 					validID = true
+					attempt = i
 				}
 			}
 			if result.RequestID == 0 {
@@ -409,6 +612,19 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 				x.Logger.Print("ERROR out of order")
 				continue
 			}
+			if x.Version == Version3 {
+				for i, id := range allMsgIDs {
+					if id == result.MsgID {
+						attempt = i
+					}
+				}
+			}
+			if result.Version != x.Version && x.Logger.enabled() {
+				x.Logger.Printf("%s answered SNMP version %s to a version %s request", x.targetAddr(), result.Version, x.Version)
+			}
+			latency = receivedAt.Sub(sentAt[attempt])
+			result.Latency = latency
+			metrics.ObserveLatency(latency)
 
 			break
 		}
@@ -431,6 +647,70 @@ func (x *GoSNMP) sendOneRequest(packetOut *SnmpPacket,
 //
 // all sends wait for the return packet, except for SNMPv2Trap
 func (x *GoSNMP) send(packetOut *SnmpPacket, wait bool) (result *SnmpPacket, err error) {
+	return x.sendContext(x.Context, packetOut, wait)
+}
+
+// sendContext is send bound to ctx rather than x.Context.
+func (x *GoSNMP) sendContext(ctx context.Context, packetOut *SnmpPacket, wait bool) (result *SnmpPacket, err error) {
+	if x.isClosed() {
+		return nil, ErrClosed
+	}
+	result, err = x.sendContextOnce(ctx, packetOut, wait)
+	if err != nil && x.isClosed() {
+		return nil, ErrClosed
+	}
+	if err == nil || !x.AutoReconnect || !socketError(err) || ctx.Err() != nil {
+		return x.formatNames(result), err
+	}
+
+	x.Logger.Printf("ERROR: %v. Performing reconnect", err)
+	if rerr := x.reconnect(ctx, packetOut); rerr != nil {
+		if errors.Is(rerr, ErrClosed) {
+			return nil, ErrClosed
+		}
+		return result, fmt.Errorf("%w (reconnect failed: %v)", err, rerr)
+	}
+	result, err = x.sendContextOnce(ctx, packetOut, wait)
+	return x.formatNames(result), err
+}
+
+// socketError reports whether err came from the socket rather than from
+// a timeout, the context or the agent's answer.
+func socketError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && !opErr.Timeout()
+}
+
+// reconnect replaces x.Conn with a new connection to the agent, for
+// AutoReconnect, and with SNMPv3 rediscovers the agent's engine. It
+// returns ErrClosed, dialing nothing, once x is closed.
+func (x *GoSNMP) reconnect(ctx context.Context, packetOut *SnmpPacket) error {
+	x.connMu.Lock()
+	if x.isClosed() {
+		x.connMu.Unlock()
+		return ErrClosed
+	}
+	_ = x.Conn.Close()
+	err := x.netConnect()
+	x.connMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if packetOut.Version != Version3 {
+		return nil
+	}
+	if x.EngineCache != nil {
+		x.EngineCache.forget(x.engineCacheKey())
+	}
+	if _, ok := x.SecurityParameters.(*UsmSecurityParameters); !ok {
+		return nil
+	}
+	discoveryPacket := (&UsmSecurityParameters{Logger: x.Logger}).discoveryRequired()
+	return x.discoverEngine(ctx, discoveryPacket, packetOut)
+}
+
+// sendContextOnce is sendContext without AutoReconnect.
+func (x *GoSNMP) sendContextOnce(ctx context.Context, packetOut *SnmpPacket, wait bool) (result *SnmpPacket, err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("recover: stacktrace from panic: \n" + string(debug.Stack()))
@@ -447,49 +727,83 @@ func (x *GoSNMP) send(packetOut *SnmpPacket, wait bool) (result *SnmpPacket, err
 	x.Logger.Print("SEND INIT")
 	if packetOut.Version == Version3 {
 		x.Logger.Print("SEND INIT NEGOTIATE SECURITY PARAMS")
-		if err = x.negotiateInitialSecurityParameters(packetOut); err != nil {
+		budget := x.requestBudget(ctx)
+		start := time.Now()
+		var discovered bool
+		if discovered, err = x.negotiateInitialSecurityParameters(ctx, packetOut); err != nil {
 			return &SnmpPacket{}, err
 		}
 		x.Logger.Print("SEND END NEGOTIATE SECURITY PARAMS")
+		switch {
+		case !discovered:
+			// deriving keys is no time the agent takes
+			start = time.Now()
+		case x.DiscoveryRetries > 0:
+			// discovery had a budget of its own
+			budget += x.requestBudget(x.discoveryContext(ctx))
+		}
+		// the request and its retransmission after a report have what
+		// discovery left of the budget
+		parent := ctx
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, start.Add(budget))
+		defer cancel()
+		defer func() {
+			if errors.Is(err, context.DeadlineExceeded) && parent.Err() == nil {
+				err = fmt.Errorf("request timeout (SNMPv3 exchanges took over %v)", budget)
+			}
+		}()
 	}
 
 	// perform request
-	result, err = x.sendOneRequest(packetOut, wait)
+	result, err = x.sendOneRequestContext(ctx, packetOut, wait)
 	if err != nil {
 		x.Logger.Printf("SEND Error on the first Request Error: %s", err)
+		if x.EngineCache != nil && packetOut.Version == Version3 {
+			// the cached engine may be what the agent did not answer to
+			x.EngineCache.forget(x.engineCacheKey())
+		}
 		return result, err
 	}
 
 	if result.Version == Version3 {
-		x.Logger.Printf("SEND STORE SECURITY PARAMS from result: %s", result.SecurityParameters.SafeString())
+		if x.Logger.enabled() {
+			x.Logger.Printf("SEND STORE SECURITY PARAMS from result: %s", result.SecurityParameters.SafeString())
+		}
 		err = x.storeSecurityParameters(result)
+		if err == nil {
+			x.storeCachedEngine()
+		}
 
 		if result.PDUType == Report && len(result.Variables) == 1 {
+			var reportErr error
 			switch result.Variables[0].Name {
 			case usmStatsNotInTimeWindows:
+				// eg the agent restarted and its snmpEngineBoots moved on
 				x.Logger.Print("WARNING detected out-of-time-window ERROR")
-				if err = x.updatePktSecurityParameters(packetOut); err != nil {
-					x.Logger.Printf("ERROR updatePktSecurityParameters error: %s", err)
-					return nil, err
-				}
-				// retransmit with updated auth engine params
-				result, err = x.sendOneRequest(packetOut, wait)
-				if err != nil {
-					x.Logger.Printf("ERROR out-of-time-window retransmit error: %s", err)
-					return result, ErrNotInTimeWindow
-				}
-
+				reportErr = ErrNotInTimeWindow
 			case usmStatsUnknownEngineIDs:
 				x.Logger.Print("WARNING detected unknown engine id ERROR")
+				reportErr = ErrUnknownEngineID
+			}
+			if reportErr != nil {
 				if err = x.updatePktSecurityParameters(packetOut); err != nil {
 					x.Logger.Printf("ERROR updatePktSecurityParameters error: %s", err)
 					return nil, err
 				}
-				// retransmit with updated engine id
-				result, err = x.sendOneRequest(packetOut, wait)
+				// retransmit once with the engine parameters of the report
+				result, err = x.sendOneRequestContext(ctx, packetOut, wait)
 				if err != nil {
-					x.Logger.Printf("ERROR unknown engine id retransmit error: %s", err)
-					return result, ErrUnknownEngineID
+					x.Logger.Printf("ERROR retransmit after report error: %s", err)
+					return result, reportErr
+				}
+				if result.PDUType == Report {
+					return result, newReportError(result)
+				}
+				if result.Version == Version3 {
+					if err = x.storeSecurityParameters(result); err == nil {
+						x.storeCachedEngine()
+					}
 				}
 			}
 		}
@@ -499,7 +813,11 @@ func (x *GoSNMP) send(packetOut *SnmpPacket, wait bool) (result *SnmpPacket, err
 
 // -- Marshalling Logic --------------------------------------------------------
 
-// MarshalMsg marshalls a snmp packet, ready for sending across the wire
+// MarshalMsg marshalls a snmp packet, ready for sending across the wire.
+// The fields are encoded as they are: RequestID and MsgID are not assigned
+// and counts such as NonRepeaters or ErrorIndex are not checked against the
+// variables. For SNMPv3 with authentication call
+// SecurityParameters.InitPacket first, as SnmpEncodePacket does.
 func (packet *SnmpPacket) MarshalMsg() ([]byte, error) {
 	return packet.marshalMsg()
 }
@@ -507,7 +825,8 @@ func (packet *SnmpPacket) MarshalMsg() ([]byte, error) {
 // marshal an SNMP message
 func (packet *SnmpPacket) marshalMsg() ([]byte, error) {
 	var err error
-	buf := new(bytes.Buffer)
+	buf := getBuffer()
+	defer putBuffer(buf)
 
 	// version
 	buf.Write([]byte{2, 1, byte(packet.Version)})
@@ -518,8 +837,13 @@ func (packet *SnmpPacket) marshalMsg() ([]byte, error) {
 			return nil, err
 		}
 	} else {
-		// community
-		buf.Write([]byte{4, uint8(len(packet.Community))})
+		// community, an OCTET STRING of any octets
+		communityLength, err2 := marshalLength(len(packet.Community))
+		if err2 != nil {
+			return nil, err2
+		}
+		buf.WriteByte(byte(OctetString))
+		buf.Write(communityLength)
 		buf.WriteString(packet.Community)
 		// pdu
 		pdu, err2 := packet.marshalPDU()
@@ -530,20 +854,12 @@ func (packet *SnmpPacket) marshalMsg() ([]byte, error) {
 	}
 
 	// build up resulting msg - sequence, length then the tail (buf)
-	msg := new(bytes.Buffer)
-	msg.WriteByte(byte(Sequence))
-
-	bufLengthBytes, err2 := marshalLength(buf.Len())
-	if err2 != nil {
-		return nil, err2
-	}
-	msg.Write(bufLengthBytes)
-	_, err = buf.WriteTo(msg)
+	msg, err := wrapBuffer(byte(Sequence), buf)
 	if err != nil {
 		return nil, err
 	}
 
-	authenticatedMessage, err := packet.authenticate(msg.Bytes())
+	authenticatedMessage, err := packet.authenticate(msg)
 	if err != nil {
 		return nil, err
 	}
@@ -559,14 +875,24 @@ func (packet *SnmpPacket) marshalSNMPV1TrapHeader() ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to marshal OID: %w", err)
 	}
-	buf.Write([]byte{byte(ObjectIdentifier), byte(len(oidBytes))})
+	oidLength, err := marshalLength(len(oidBytes))
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal OID length: %w", err)
+	}
+	buf.WriteByte(byte(ObjectIdentifier))
+	buf.Write(oidLength)
 	buf.Write(oidBytes)
 
 	// marshal AgentAddress (ip address)
-	ip := net.ParseIP(packet.AgentAddress)
-	ipAddressBytes := ipv4toBytes(ip)
-	buf.Write([]byte{byte(IPAddress), byte(len(ipAddressBytes))})
-	buf.Write(ipAddressBytes)
+	agentAddress, err := ipAddressBytes(packet.AgentAddress)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal AgentAddress: %w", err)
+	}
+	if len(agentAddress) != net.IPv4len {
+		return nil, fmt.Errorf("AgentAddress %s is not an IPv4 address", packet.AgentAddress)
+	}
+	buf.Write([]byte{byte(IPAddress), byte(len(agentAddress))})
+	buf.Write(agentAddress)
 
 	// marshal GenericTrap. Could just cast GenericTrap to a single byte as IDs greater than 6 are unknown,
 	// but do it properly. See issue 182.
@@ -600,7 +926,8 @@ func (packet *SnmpPacket) marshalSNMPV1TrapHeader() ([]byte, error) {
 
 // marshal a PDU
 func (packet *SnmpPacket) marshalPDU() ([]byte, error) {
-	buf := new(bytes.Buffer)
+	buf := getBuffer()
+	defer putBuffer(buf)
 
 	switch packet.PDUType {
 	case GetBulkRequest:
@@ -678,138 +1005,135 @@ func (packet *SnmpPacket) marshalPDU() ([]byte, error) {
 	}
 	buf.Write(vbl)
 
-	// build up resulting pdu
-	pdu := new(bytes.Buffer)
-	// calculate pdu length
-	bufLengthBytes, err := marshalLength(buf.Len())
+	// build up resulting pdu: request type, pdu length then the tail (buf)
+	pdu, err := wrapBuffer(byte(packet.PDUType), buf)
 	if err != nil {
 		return nil, fmt.Errorf("marshalPDU: unable to marshal pdu length: %w", err)
 	}
-	// write request type
-	pdu.WriteByte(byte(packet.PDUType))
-	// write pdu length
-	pdu.Write(bufLengthBytes)
-	// write the tail (buf)
-	if _, err = buf.WriteTo(pdu); err != nil {
-		return nil, fmt.Errorf("marshalPDU: unable to marshal pdu: %w", err)
-	}
 
-	return pdu.Bytes(), nil
+	return pdu, nil
 }
 
 // marshal a varbind list
 func (packet *SnmpPacket) marshalVBL() ([]byte, error) {
-	vblBuf := new(bytes.Buffer)
-	for _, pdu := range packet.Variables {
-		pdu := pdu
-		vb, err := marshalVarbind(&pdu)
-		if err != nil {
+	vblBuf := getBuffer()
+	defer putBuffer(vblBuf)
+	for i := range packet.Variables {
+		if err := writeVarbind(vblBuf, &packet.Variables[i]); err != nil {
 			return nil, err
 		}
-		vblBuf.Write(vb)
-	}
-
-	vblBytes := vblBuf.Bytes()
-	vblLengthBytes, err := marshalLength(len(vblBytes))
-	if err != nil {
-		return nil, err
 	}
 
-	// FIX does bytes.Buffer give better performance than byte slices?
-	result := []byte{byte(Sequence)}
-	result = append(result, vblLengthBytes...)
-	result = append(result, vblBytes...)
-	return result, nil
+	return wrapBuffer(byte(Sequence), vblBuf)
 }
 
 // marshal a varbind
 func marshalVarbind(pdu *SnmpPDU) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := writeVarbind(buf, pdu); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeVarbind appends the encoding of pdu to pduBuf.
+func writeVarbind(pduBuf *bytes.Buffer, pdu *SnmpPDU) error {
 	oid, err := marshalObjectIdentifier(pdu.Name)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	// the name, with a long form length for OIDs of 128 octets or more
+	oidLength, err := marshalLength(len(oid))
+	if err != nil {
+		return err
 	}
-	pduBuf := new(bytes.Buffer)
-	tmpBuf := new(bytes.Buffer)
+
+	tmpBuf := getBuffer()
+	defer putBuffer(tmpBuf)
+	tmpBuf.WriteByte(byte(ObjectIdentifier))
+	tmpBuf.Write(oidLength)
+	tmpBuf.Write(oid)
 
 	// Marshal the PDU type into the appropriate BER
 	switch pdu.Type {
 	case Null:
-		ltmp, err2 := marshalLength(len(oid))
-		if err2 != nil {
-			return nil, err2
-		}
-		tmpBuf.Write([]byte{byte(ObjectIdentifier)})
-		tmpBuf.Write(ltmp)
-		tmpBuf.Write(oid)
 		tmpBuf.Write([]byte{byte(Null), byte(EndOfContents)})
 
-		ltmp, err2 = marshalLength(tmpBuf.Len())
+		ltmp, err2 := marshalLength(tmpBuf.Len())
 		if err2 != nil {
-			return nil, err2
+			return err2
 		}
 		pduBuf.Write([]byte{byte(Sequence)})
 		pduBuf.Write(ltmp)
 		_, err2 = tmpBuf.WriteTo(pduBuf)
 		if err2 != nil {
-			return nil, err2
+			return err2
 		}
 
 	case Integer:
-		// Oid
-		tmpBuf.Write([]byte{byte(ObjectIdentifier), byte(len(oid))})
-		tmpBuf.Write(oid)
 
 		// Number
-		var intBytes []byte
-		switch value := pdu.Value.(type) {
-		case byte:
-			intBytes = []byte{byte(pdu.Value.(int))}
+		var value int64
+		switch v := pdu.Value.(type) {
 		case int:
-			if intBytes, err = marshalInt32(value); err != nil {
-				return nil, fmt.Errorf("error mashalling PDU Integer: %w", err)
-			}
+			value = int64(v)
+		case int8:
+			value = int64(v)
+		case int16:
+			value = int64(v)
+		case int32:
+			value = int64(v)
+		case int64:
+			value = v
+		case byte:
+			value = int64(v)
 		default:
-			return nil, fmt.Errorf("unable to marshal PDU Integer; not byte or int")
+			return fmt.Errorf("unable to marshal PDU Integer; not byte or int")
+		}
+		if value < math.MinInt32 || value > math.MaxInt32 {
+			return fmt.Errorf("error mashalling PDU Integer: %d overflows int32", value)
+		}
+		intBytes, err := marshalInt32(int(value))
+		if err != nil {
+			return fmt.Errorf("error mashalling PDU Integer: %w", err)
 		}
 		tmpBuf.Write([]byte{byte(Integer), byte(len(intBytes))})
 		tmpBuf.Write(intBytes)
 
 		// Sequence, length of oid + integer, then oid/integer data
+		length, err := marshalLength(tmpBuf.Len())
+		if err != nil {
+			return fmt.Errorf("unable to marshal PDU data length: %w", err)
+		}
 		pduBuf.WriteByte(byte(Sequence))
-		pduBuf.WriteByte(byte(len(oid) + len(intBytes) + 4))
+		pduBuf.Write(length)
 		pduBuf.Write(tmpBuf.Bytes())
 
 	case Counter32, Gauge32, TimeTicks, Uinteger32:
-		// Oid
-		tmpBuf.Write([]byte{byte(ObjectIdentifier), byte(len(oid))})
-		tmpBuf.Write(oid)
 
-		// Number
-		var intBytes []byte
-		switch value := pdu.Value.(type) {
-		case uint32:
-			if intBytes, err = marshalUint32(value); err != nil {
-				return nil, fmt.Errorf("error marshalling PDU Uinteger32 type from uint32: %w", err)
-			}
-		case uint:
-			if intBytes, err = marshalUint32(value); err != nil {
-				return nil, fmt.Errorf("error marshalling PDU Uinteger32 type from uint: %w", err)
-			}
-		default:
-			return nil, fmt.Errorf("unable to marshal pdu.Type %v; unknown pdu.Value %v[type=%T]", pdu.Type, pdu.Value, pdu.Value)
+		// Number, of any Go integer type: pdu.Type alone decides the
+		// encoding, so eg a uint64 is sent as the Gauge32 asked for
+		value, err := unsignedValue(pdu.Value, math.MaxUint32)
+		if err != nil {
+			return fmt.Errorf("unable to marshal pdu.Type %v: %w", pdu.Type, err)
+		}
+		intBytes, err := marshalUint32(uint32(value))
+		if err != nil {
+			return fmt.Errorf("error marshalling PDU %v: %w", pdu.Type, err)
 		}
 		tmpBuf.Write([]byte{byte(pdu.Type), byte(len(intBytes))})
 		tmpBuf.Write(intBytes)
 
 		// Sequence, length of oid + integer, then oid/integer data
+		length, err := marshalLength(tmpBuf.Len())
+		if err != nil {
+			return fmt.Errorf("unable to marshal PDU data length: %w", err)
+		}
 		pduBuf.WriteByte(byte(Sequence))
-		pduBuf.WriteByte(byte(len(oid) + len(intBytes) + 4))
+		pduBuf.Write(length)
 		pduBuf.Write(tmpBuf.Bytes())
 
-	case OctetString, BitString, Opaque:
-		// Oid
-		tmpBuf.Write([]byte{byte(ObjectIdentifier), byte(len(oid))})
-		tmpBuf.Write(oid)
+	case OctetString, BitString, Opaque, NsapAddress:
 
 		// OctetString
 		var octetStringBytes []byte
@@ -818,14 +1142,24 @@ func marshalVarbind(pdu *SnmpPDU) ([]byte, error) {
 			octetStringBytes = value
 		case string:
 			octetStringBytes = []byte(value)
+		case BitStringValue:
+			if pdu.Type != BitString {
+				return fmt.Errorf("unable to marshal PDU %s; BitStringValue is for BitString", pdu.Type)
+			}
+			unused := 8*len(value.Bytes) - value.BitLength
+			if unused < 0 || unused > 7 {
+				return fmt.Errorf("unable to marshal PDU BitString; BitLength %d does not fit %d octets",
+					value.BitLength, len(value.Bytes))
+			}
+			octetStringBytes = append([]byte{byte(unused)}, value.Bytes...)
 		default:
-			return nil, fmt.Errorf("unable to marshal PDU OctetString; not []byte or string")
+			return fmt.Errorf("unable to marshal PDU OctetString; not []byte or string")
 		}
 
 		var length []byte
 		length, err = marshalLength(len(octetStringBytes))
 		if err != nil {
-			return nil, fmt.Errorf("unable to marshal PDU length: %w", err)
+			return fmt.Errorf("unable to marshal PDU length: %w", err)
 		}
 		tmpBuf.WriteByte(byte(pdu.Type))
 		tmpBuf.Write(length)
@@ -835,7 +1169,7 @@ func marshalVarbind(pdu *SnmpPDU) ([]byte, error) {
 
 		length, err = marshalLength(len(tmpBytes))
 		if err != nil {
-			return nil, fmt.Errorf("unable to marshal PDU data length: %w", err)
+			return fmt.Errorf("unable to marshal PDU data length: %w", err)
 		}
 		// Sequence, length of oid + octetstring, then oid/octetstring data
 		pduBuf.WriteByte(byte(Sequence))
@@ -844,20 +1178,17 @@ func marshalVarbind(pdu *SnmpPDU) ([]byte, error) {
 		pduBuf.Write(tmpBytes)
 
 	case ObjectIdentifier:
-		// Oid
-		tmpBuf.Write([]byte{byte(ObjectIdentifier), byte(len(oid))})
-		tmpBuf.Write(oid)
 		value := pdu.Value.(string)
 		oidBytes, err := marshalObjectIdentifier(value)
 		if err != nil {
-			return nil, fmt.Errorf("error marshalling ObjectIdentifier: %w", err)
+			return fmt.Errorf("error marshalling ObjectIdentifier: %w", err)
 		}
 
 		// Oid data
 		var length []byte
 		length, err = marshalLength(len(oidBytes))
 		if err != nil {
-			return nil, fmt.Errorf("error marshalling ObjectIdentifier length: %w", err)
+			return fmt.Errorf("error marshalling ObjectIdentifier length: %w", err)
 		}
 		tmpBuf.WriteByte(byte(pdu.Type))
 		tmpBuf.Write(length)
@@ -866,7 +1197,7 @@ func marshalVarbind(pdu *SnmpPDU) ([]byte, error) {
 		tmpBytes := tmpBuf.Bytes()
 		length, err = marshalLength(len(tmpBytes))
 		if err != nil {
-			return nil, fmt.Errorf("error marshalling ObjectIdentifier data length: %w", err)
+			return fmt.Errorf("error marshalling ObjectIdentifier data length: %w", err)
 		}
 		// Sequence, length of oid + oid, then oid/oid data
 		pduBuf.WriteByte(byte(Sequence))
@@ -874,25 +1205,20 @@ func marshalVarbind(pdu *SnmpPDU) ([]byte, error) {
 		pduBuf.Write(tmpBytes)
 
 	case IPAddress:
-		// Oid
-		tmpBuf.Write([]byte{byte(ObjectIdentifier), byte(len(oid))})
-		tmpBuf.Write(oid)
 		// OctetString
-		var ipAddressBytes []byte
-		switch value := pdu.Value.(type) {
-		case []byte:
-			ipAddressBytes = value
-		case string:
-			ip := net.ParseIP(value)
-			ipAddressBytes = ipv4toBytes(ip)
-		default:
-			return nil, fmt.Errorf("unable to marshal PDU IPAddress; not []byte or string")
+		ip, err := ipAddressBytes(pdu.Value)
+		if err != nil {
+			return err
 		}
-		tmpBuf.Write([]byte{byte(IPAddress), byte(len(ipAddressBytes))})
-		tmpBuf.Write(ipAddressBytes)
+		tmpBuf.Write([]byte{byte(IPAddress), byte(len(ip))})
+		tmpBuf.Write(ip)
 		// Sequence, length of oid + octetstring, then oid/octetstring data
+		length, err := marshalLength(tmpBuf.Len())
+		if err != nil {
+			return fmt.Errorf("unable to marshal PDU data length: %w", err)
+		}
 		pduBuf.WriteByte(byte(Sequence))
-		pduBuf.WriteByte(byte(len(oid) + len(ipAddressBytes) + 4))
+		pduBuf.Write(length)
 		pduBuf.Write(tmpBuf.Bytes())
 
 	case OpaqueFloat, OpaqueDouble:
@@ -906,28 +1232,26 @@ func marshalVarbind(pdu *SnmpPDU) ([]byte, error) {
 		intBuf.WriteByte(byte(pdu.Type))
 		intBytes, err := converters[pdu.Type](pdu.Value)
 		if err != nil {
-			return nil, fmt.Errorf("error converting PDU value type %v to %v: %w", pdu.Value, pdu.Type, err)
+			return fmt.Errorf("error converting PDU value type %v to %v: %w", pdu.Value, pdu.Type, err)
 		}
 		intLength, err := marshalLength(len(intBytes))
 		if err != nil {
-			return nil, fmt.Errorf("error marshalling Float type length: %w", err)
+			return fmt.Errorf("error marshalling Float type length: %w", err)
 		}
 		intBuf.Write(intLength)
 		intBuf.Write(intBytes)
 
 		opaqueLength, err := marshalLength(len(intBuf.Bytes()))
 		if err != nil {
-			return nil, fmt.Errorf("error marshalling Float type length: %w", err)
+			return fmt.Errorf("error marshalling Float type length: %w", err)
 		}
-		tmpBuf.Write([]byte{byte(ObjectIdentifier), byte(len(oid))})
-		tmpBuf.Write(oid)
 		tmpBuf.WriteByte(byte(Opaque))
 		tmpBuf.Write(opaqueLength)
 		tmpBuf.Write(intBuf.Bytes())
 
 		length, err := marshalLength(len(tmpBuf.Bytes()))
 		if err != nil {
-			return nil, fmt.Errorf("error marshalling Float type length: %w", err)
+			return fmt.Errorf("error marshalling Float type length: %w", err)
 		}
 
 		// Sequence, length of oid + oid, then oid/oid data
@@ -936,16 +1260,21 @@ func marshalVarbind(pdu *SnmpPDU) ([]byte, error) {
 		pduBuf.Write(tmpBuf.Bytes())
 
 	case Counter64:
-		tmpBuf.Write([]byte{byte(ObjectIdentifier), byte(len(oid))})
-		tmpBuf.Write(oid)
 		tmpBuf.WriteByte(byte(pdu.Type))
-		intBytes := marshalUint64(pdu.Value)
+		value, err := unsignedValue(pdu.Value, math.MaxUint64)
+		if err != nil {
+			return fmt.Errorf("unable to marshal pdu.Type %v: %w", pdu.Type, err)
+		}
+		intBytes, err := marshalUint64(value)
+		if err != nil {
+			return fmt.Errorf("error marshalling PDU Counter64: %w", err)
+		}
 		tmpBuf.WriteByte(byte(len(intBytes)))
 		tmpBuf.Write(intBytes)
 		tmpBytes := tmpBuf.Bytes()
 		length, err := marshalLength(len(tmpBytes))
 		if err != nil {
-			return nil, fmt.Errorf("error marshalling Float type length: %w", err)
+			return fmt.Errorf("error marshalling Float type length: %w", err)
 		}
 		// Sequence, length of oid + oid, then oid/oid data
 		pduBuf.WriteByte(byte(Sequence))
@@ -953,14 +1282,12 @@ func marshalVarbind(pdu *SnmpPDU) ([]byte, error) {
 		pduBuf.Write(tmpBytes)
 
 	case NoSuchInstance, NoSuchObject, EndOfMibView:
-		tmpBuf.Write([]byte{byte(ObjectIdentifier), byte(len(oid))})
-		tmpBuf.Write(oid)
 		tmpBuf.WriteByte(byte(pdu.Type))
 		tmpBuf.WriteByte(byte(EndOfContents))
 		tmpBytes := tmpBuf.Bytes()
 		length, err := marshalLength(len(tmpBytes))
 		if err != nil {
-			return nil, fmt.Errorf("error marshalling Null type data length: %w", err)
+			return fmt.Errorf("error marshalling Null type data length: %w", err)
 		}
 		// Sequence, length of oid + oid, then oid/oid data
 		pduBuf.WriteByte(byte(Sequence))
@@ -968,10 +1295,10 @@ func marshalVarbind(pdu *SnmpPDU) ([]byte, error) {
 		pduBuf.Write(tmpBytes)
 
 	default:
-		return nil, fmt.Errorf("unable to marshal PDU: unknown BER type %q", pdu.Type)
+		return fmt.Errorf("unable to marshal PDU: unknown BER type %q", pdu.Type)
 	}
 
-	return pduBuf.Bytes(), nil
+	return nil
 }
 
 // -- Unmarshalling Logic ------------------------------------------------------
@@ -1025,7 +1352,9 @@ func (x *GoSNMP) unmarshalHeader(packet []byte, response *SnmpPacket) (int, erro
 		if err != nil {
 			return 0, err
 		}
-		x.Logger.Printf("UnmarshalV3Header done. [with SecurityParameters]. Header Size %d. Last 4 Bytes=[%v]", cursor-oldcursor, packet[cursor-4:cursor])
+		if x.Logger.enabled() && cursor-oldcursor >= 4 {
+			x.Logger.Printf("UnmarshalV3Header done. [with SecurityParameters]. Header Size %d. Last 4 Bytes=[%v]", cursor-oldcursor, packet[cursor-4:cursor])
+		}
 	} else {
 		// Parse community
 		rawCommunity, count, err := parseRawField(x.Logger, packet[cursor:], "community")
@@ -1263,7 +1592,7 @@ func (x *GoSNMP) unmarshalTrapV1(packet []byte, response *SnmpPacket) error {
 
 // unmarshal a Varbind list
 func (x *GoSNMP) unmarshalVBL(packet []byte, response *SnmpPacket) error {
-	var cursor, cursorInc int
+	var cursor int
 	var vblLength int
 
 	if len(packet) == 0 || cursor > len(packet) {
@@ -1286,6 +1615,7 @@ func (x *GoSNMP) unmarshalVBL(packet []byte, response *SnmpPacket) error {
 		return fmt.Errorf("error verifying: packet length %d vbl length %d", len(packet), vblLength)
 	}
 	x.Logger.Printf("vblLength: %d", vblLength)
+	response.varbindsLen = vblLength - cursor
 
 	// check for an empty response
 	if vblLength == 2 && packet[1] == 0x00 {
@@ -1293,58 +1623,98 @@ func (x *GoSNMP) unmarshalVBL(packet []byte, response *SnmpPacket) error {
 	}
 
 	// Loop & parse Varbinds
-	for cursor < vblLength {
+	for index := 1; cursor < vblLength; index++ {
 		if packet[cursor] != 0x30 {
 			return fmt.Errorf("expected a sequence when unmarshalling a VB, got %x", packet[cursor])
 		}
 
-		_, cursorInc, err = parseLength(packet[cursor:])
+		vbLength, cursorInc, err := parseLength(packet[cursor:])
 		if err != nil {
 			return err
 		}
-		cursor += cursorInc
-		if cursor > len(packet) {
-			return fmt.Errorf("error parsing OID Value: packet %d cursor %d", len(packet), cursor)
-		}
-
-		// Parse OID
-		rawOid, oidLength, err := parseRawField(x.Logger, packet[cursor:], "OID")
+		pdu, next, err := x.unmarshalVarbind(packet, cursor+cursorInc)
 		if err != nil {
-			return fmt.Errorf("error parsing OID Value: %w", err)
-		}
-		cursor += oidLength
-		if cursor > len(packet) {
-			return fmt.Errorf("error parsing OID Value: truncated, packet length %d cursor %d", len(packet), cursor)
-		}
-		oid, ok := rawOid.(string)
-		if !ok {
-			return fmt.Errorf("unable to type assert rawOid |%v| to string", rawOid)
-		}
+			// The length of the VarBind still tells where the next starts,
+			// unless it is wrong too.
+			if !x.LenientDecoding || vbLength <= cursorInc || cursor+vbLength > vblLength {
+				return err
+			}
+			x.Logger.Printf("skipping variable %d: %v", index, err)
+			pdu = SnmpPDU{Name: pdu.Name, Type: UnknownType, Value: &VarbindError{
+				Index: index,
+				Name:  pdu.Name,
+				Raw:   packet[cursor : cursor+vbLength],
+				Err:   err,
+			}}
+			next = cursor + vbLength
+		}
+		response.Variables = append(response.Variables, pdu)
+		cursor = next
+	}
+	return nil
+}
+
+// unmarshalVarbind decodes the name and value of the VarBind whose contents
+// start at cursor in packet, returning it and the cursor after it. If the
+// value does not decode the name is still returned.
+func (x *GoSNMP) unmarshalVarbind(packet []byte, cursor int) (SnmpPDU, int, error) {
+	var pdu SnmpPDU
+	if cursor > len(packet) {
+		return pdu, cursor, fmt.Errorf("error parsing OID Value: packet %d cursor %d", len(packet), cursor)
+	}
+
+	// Parse OID
+	rawOid, oidLength, err := parseRawField(x.Logger, packet[cursor:], "OID")
+	if err != nil {
+		return pdu, cursor, fmt.Errorf("error parsing OID Value: %w", err)
+	}
+	cursor += oidLength
+	if cursor > len(packet) {
+		return pdu, cursor, fmt.Errorf("error parsing OID Value: truncated, packet length %d cursor %d", len(packet), cursor)
+	}
+	oid, ok := rawOid.(string)
+	if !ok {
+		return pdu, cursor, fmt.Errorf("unable to type assert rawOid |%v| to string", rawOid)
+	}
+	if x.Logger.enabled() {
 		x.Logger.Printf("OID: %s", oid)
-		// Parse Value
-		var decodedVal variable
-		if err = x.decodeValue(packet[cursor:], &decodedVal); err != nil {
-			return fmt.Errorf("error decoding value: %w", err)
-		}
+	}
+	pdu.Name = oid
 
-		valueLength, _, err := parseLength(packet[cursor:])
-		if err != nil {
-			return err
-		}
-		cursor += valueLength
-		if cursor > len(packet) {
-			return fmt.Errorf("error decoding OID Value: truncated, packet length %d cursor %d", len(packet), cursor)
-		}
+	// Parse Value
+	var decodedVal variable
+	if err = x.decodeValue(packet[cursor:], &decodedVal); err != nil {
+		return pdu, cursor, fmt.Errorf("error decoding value: %w", err)
+	}
 
-		response.Variables = append(response.Variables, SnmpPDU{Name: oid, Type: decodedVal.Type, Value: decodedVal.Value})
+	valueLength, _, err := parseLength(packet[cursor:])
+	if err != nil {
+		return pdu, cursor, err
 	}
-	return nil
+	cursor += valueLength
+	if cursor > len(packet) {
+		return pdu, cursor, fmt.Errorf("error decoding OID Value: truncated, packet length %d cursor %d", len(packet), cursor)
+	}
+
+	pdu.Type, pdu.Value = decodedVal.Type, decodedVal.Value
+	return pdu, cursor, nil
 }
 
 // receive response from network and read into a byte array
 func (x *GoSNMP) receive() ([]byte, error) {
 	var n int
 	var err error
+	// A stream carries BER messages back to back, so read exactly one.
+	if x.streamTransport() {
+		resp, err := x.readStreamMessage()
+		if err == io.EOF {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("Failed to read from socket: %w", err)
+		}
+		x.onPacket(PacketReceived, resp)
+		return resp, nil
+	}
 	// If we are using UDP and unconnected socket, read the packet and
 	// disregard the source address.
 	if uconn, ok := x.Conn.(net.PacketConn); ok {
@@ -1355,7 +1725,7 @@ func (x *GoSNMP) receive() ([]byte, error) {
 	if err == io.EOF {
 		return nil, err
 	} else if err != nil {
-		return nil, fmt.Errorf("Failed to read from socket: %s", err.Error())
+		return nil, fmt.Errorf("Failed to read from socket: %w", err)
 	}
 
 	if n == rxBufSize {
@@ -1365,9 +1735,121 @@ func (x *GoSNMP) receive() ([]byte, error) {
 
 	resp := make([]byte, n)
 	copy(resp, x.rxBuf[:n])
+	x.onPacket(PacketReceived, resp)
 	return resp, nil
 }
 
+// readBERMessage reads one SNMP message from a stream transport (RFC 3430):
+// the outer SEQUENCE header is decoded first and exactly the length it
+// announces is then read, up to maxLen bytes in total. io.EOF is returned
+// only if the stream ended cleanly before a new message.
+func readBERMessage(r io.Reader, maxLen int) ([]byte, error) {
+	hdr := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	for {
+		length, err := berMessageLength(hdr, maxLen)
+		if err != nil {
+			return nil, err
+		}
+		if length > 0 {
+			msg := make([]byte, length)
+			copy(msg, hdr)
+			if _, err := io.ReadFull(r, msg[len(hdr):]); err != nil {
+				return nil, noEOF(err)
+			}
+			return msg, nil
+		}
+		// the rest of a long form length, which berMessageLength bounds
+		// to four octets
+		hdr = append(hdr, 0)
+		if _, err := io.ReadFull(r, hdr[len(hdr)-1:]); err != nil {
+			return nil, noEOF(err)
+		}
+	}
+}
+
+// streamBuffer holds the octets read from conn, a stream transport, that
+// do not make up a whole message yet.
+type streamBuffer struct {
+	conn  net.Conn
+	buf   []byte
+	chunk []byte
+}
+
+// readStreamMessage is readBERMessage from x.Conn, save that the octets of
+// a message whose read is cut short, eg by the deadline another request's
+// cancellation pulls in, are kept and the next read goes on from them, so
+// such a read does not lose the stream its place.
+func (x *GoSNMP) readStreamMessage() ([]byte, error) {
+	s := x.stream
+	if s == nil || s.conn != x.Conn {
+		// the buffer of another connection, say before a reconnect
+		s = &streamBuffer{conn: x.Conn, chunk: make([]byte, 4096)}
+		x.stream = s
+	}
+	for {
+		length, err := berMessageLength(s.buf, rxBufSize)
+		if err != nil {
+			s.buf = nil
+			return nil, err
+		}
+		if length > 0 && len(s.buf) >= length {
+			msg := append([]byte(nil), s.buf[:length]...)
+			s.buf = append(s.buf[:0], s.buf[length:]...)
+			return msg, nil
+		}
+		n, err := s.conn.Read(s.chunk)
+		s.buf = append(s.buf, s.chunk[:n]...)
+		if err != nil {
+			if len(s.buf) > 0 {
+				return nil, noEOF(err)
+			}
+			return nil, err
+		}
+	}
+}
+
+// berMessageLength returns the length of the message starting b, with its
+// header, or 0 if b is too short to tell. Messages over maxLen octets are
+// an error.
+func berMessageLength(b []byte, maxLen int) (int, error) {
+	if len(b) < 2 {
+		return 0, nil
+	}
+	if b[0] != byte(Sequence) {
+		return 0, fmt.Errorf("invalid message, expected SEQUENCE, got 0x%x", b[0])
+	}
+	hdr, length := 2, int(b[1])
+	if length > 127 {
+		numOctets := length & 127
+		if numOctets == 0 || numOctets > 4 {
+			return 0, ErrInvalidPacketLength
+		}
+		hdr += numOctets
+		if len(b) < hdr {
+			return 0, nil
+		}
+		length = 0
+		for _, c := range b[2:hdr] {
+			length = length<<8 | int(c)
+		}
+	}
+	if length < 0 || hdr+length > maxLen {
+		return 0, fmt.Errorf("message length %d exceeds %d bytes", length, maxLen)
+	}
+	return hdr + length, nil
+}
+
+// noEOF turns io.EOF in the middle of a message into io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
 func shrinkAndWriteUint(buf io.Writer, in int) error {
 	out, err := asn1.Marshal(in)
 	if err != nil {
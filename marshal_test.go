@@ -9,15 +9,20 @@ package gosnmp
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"reflect"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -1715,234 +1720,1621 @@ func TestSendOneRequest_dups(t *testing.T) {
 	}
 }
 
-func BenchmarkSendOneRequest(b *testing.B) {
-	b.StopTimer()
-
+func TestGetContextCanceled(t *testing.T) {
 	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
 	if err != nil {
-		b.Fatalf("udp4 error listening: %s", err)
+		t.Fatalf("udp4 error listening: %s", err)
 	}
 	defer srvr.Close()
 
+	// never answer, so only ctx can end the request before Timeout
 	x := &GoSNMP{
 		Version: Version2c,
 		Target:  srvr.LocalAddr().(*net.UDPAddr).IP.String(),
 		Port:    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
-		Timeout: time.Millisecond * 100,
-		Retries: 2,
+		Timeout: time.Second * 10,
+		Retries: 3,
+		MaxOids: MaxOids,
 	}
 	if err := x.Connect(); err != nil {
-		b.Fatalf("error connecting: %s", err)
+		t.Fatalf("error connecting: %s", err)
 	}
+	defer x.Conn.Close()
 
-	go func() {
-		buf := make([]byte, 256)
-		outBuf := counter64Response()
-		for {
-			_, addr, err := srvr.ReadFrom(buf)
-			if err != nil {
-				return
-			}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
 
-			copy(outBuf[17:21], buf[11:15]) // evil: copy request ID
-			srvr.WriteTo(outBuf, addr)
-		}
-	}()
+	start := time.Now()
+	_, err = x.GetContext(ctx, []string{".1.2"})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
 
-	pdus := []SnmpPDU{{Name: ".1.3.6.1.2.1.31.1.1.1.10.1", Type: Null}}
-	reqPkt := x.mkSnmpPacket(GetRequest, pdus, 0, 0)
+// deadlineConn records the last read deadline set on it.
+type deadlineConn struct {
+	net.Conn
+	mu       sync.Mutex
+	deadline time.Time
+}
 
-	// make sure everything works before starting the test
-	_, err = x.sendOneRequest(reqPkt, true)
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func TestGetContextCanceledClearsDeadline(t *testing.T) {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
 	if err != nil {
-		b.Fatalf("Precheck failed: %s", err)
+		t.Fatalf("udp4 error listening: %s", err)
 	}
+	defer srvr.Close()
 
-	b.StartTimer()
-
-	for n := 0; n < b.N; n++ {
-		_, err = x.sendOneRequest(reqPkt, true)
-		if err != nil {
-			b.Fatalf("error: %s", err)
-			return
+	for _, shared := range []bool{false, true} {
+		x := &GoSNMP{
+			Version: Version2c,
+			Target:  srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+			Port:    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+			Timeout: time.Second * 10,
+			MaxOids: MaxOids,
+		}
+		if err := x.Connect(); err != nil {
+			t.Fatalf("error connecting: %s", err)
+		}
+		conn := &deadlineConn{Conn: x.Conn}
+		x.Conn = conn
+		if !shared {
+			x.mux = nil // the request owns the socket
 		}
-	}
-}
 
-func TestUnconnectedSocket_fail(t *testing.T) {
-	withUnconnectedSocket(t, false)
-}
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		_, err = x.GetContext(ctx, []string{".1.2"})
+		assert.ErrorIs(t, err, context.Canceled, "shared=%v", shared)
 
-func TestUnconnectedSocket_success(t *testing.T) {
-	withUnconnectedSocket(t, true)
+		// nothing is left to time out the next read on the socket
+		conn.mu.Lock()
+		assert.True(t, conn.deadline.IsZero(), "shared=%v: read deadline %v", shared, conn.deadline)
+		conn.mu.Unlock()
+		x.Conn.Close()
+	}
 }
 
-func withUnconnectedSocket(t *testing.T, enable bool) {
-	srvr, err := net.ListenUDP("udp", &net.UDPAddr{})
+func TestGetContextTimeoutFallback(t *testing.T) {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
 	if err != nil {
-		t.Fatalf("udp error listening: %s", err)
+		t.Fatalf("udp4 error listening: %s", err)
 	}
 	defer srvr.Close()
 
 	x := &GoSNMP{
-		Version:                 Version2c,
-		Target:                  srvr.LocalAddr().(*net.UDPAddr).IP.String(),
-		Port:                    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
-		Timeout:                 time.Millisecond * 100,
-		Retries:                 2,
-		UseUnconnectedUDPSocket: enable,
-		LocalAddr:               "0.0.0.0:",
+		Version: Version2c,
+		Target:  srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout: time.Millisecond * 50,
+		Retries: 0,
+		MaxOids: MaxOids,
 	}
 	if err := x.Connect(); err != nil {
 		t.Fatalf("error connecting: %s", err)
 	}
 	defer x.Conn.Close()
 
-	go func() {
-		buf := make([]byte, 256)
-		for {
-			n, addr, err := srvr.ReadFrom(buf)
-			if err != nil {
-				return
-			}
-			buf := buf[:n]
+	_, err = x.GetContext(context.Background(), []string{".1.2"})
+	assert.ErrorContains(t, err, "request timeout")
+}
 
-			var reqPkt SnmpPacket
-			var cursor int
-			cursor, err = x.unmarshalHeader(buf, &reqPkt)
-			if err != nil {
-				t.Errorf("error: %s", err)
-			}
-			err = x.unmarshalPayload(buf, cursor, &reqPkt)
-			if err != nil {
-				t.Errorf("error: %s", err)
-			}
+// walkResponder answers every request after delay with the requested OID
+// extended by one sub-identifier per GETBULK repetition.
+func walkResponder(t *testing.T, x *GoSNMP, srvr *net.UDPConn, delay time.Duration) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := srvr.ReadFrom(buf)
+		if err != nil {
+			return
+		}
 
-			rspPkt := x.mkSnmpPacket(GetResponse, []SnmpPDU{
-				{
-					Name:  ".1.2",
-					Type:  Integer,
-					Value: 123,
-				},
-			}, 0, 0)
-			rspPkt.RequestID = reqPkt.RequestID
-			outBuf, err := rspPkt.marshalMsg()
-			if err != nil {
-				t.Errorf("ERR: %s", err)
+		var reqPkt SnmpPacket
+		cursor, err := x.unmarshalHeader(buf[:n], &reqPkt)
+		if err == nil {
+			err = x.unmarshalPayload(buf[:n], cursor, &reqPkt)
+		}
+		if err != nil {
+			t.Errorf("error: %s", err)
+			return
+		}
+
+		reps := 1
+		if reqPkt.PDUType == GetBulkRequest {
+			reps = int(reqPkt.MaxRepetitions)
+		}
+		name := reqPkt.Variables[0].Name
+		pdus := make([]SnmpPDU, 0, reps)
+		for i := 0; i < reps; i++ {
+			name += ".1"
+			pdus = append(pdus, SnmpPDU{Name: name, Type: Integer, Value: i})
+		}
+		rspPkt := x.mkSnmpPacket(GetResponse, pdus, 0, 0)
+		rspPkt.RequestID = reqPkt.RequestID
+		outBuf, err := rspPkt.marshalMsg()
+		if err != nil {
+			t.Errorf("ERR: %s", err)
+			return
+		}
+		time.Sleep(delay)
+		srvr.WriteTo(outBuf, addr)
+	}
+}
+
+// mibResponder answers Get, GetNext and GetBulk requests from mib, which
+// must be sorted, returning endOfMibView past its last entry. GetBulk
+// honours non-repeaters.
+func mibResponder(t *testing.T, x *GoSNMP, srvr *net.UDPConn, mib []SnmpPDU) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := srvr.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		var reqPkt SnmpPacket
+		cursor, err := x.unmarshalHeader(buf[:n], &reqPkt)
+		if err == nil {
+			err = x.unmarshalPayload(buf[:n], cursor, &reqPkt)
+		}
+		if err != nil {
+			t.Errorf("error: %s", err)
+			return
+		}
+
+		var pdus []SnmpPDU
+		for n, v := range reqPkt.Variables {
+			if reqPkt.PDUType == GetRequest {
+				pdu := SnmpPDU{Name: v.Name, Type: NoSuchObject}
+				for _, entry := range mib {
+					if entry.Name == v.Name {
+						pdu = entry
+					}
+				}
+				pdus = append(pdus, pdu)
+				continue
 			}
-			// Temporary socket will use different source port, it's enough to break
-			// connected socket reply filters.
-			nsock, err := net.ListenUDP("udp", nil)
-			if err != nil {
-				t.Errorf("can't create temporary reply socket: %v", err)
+			reps := 1
+			if reqPkt.PDUType == GetBulkRequest && n >= int(reqPkt.NonRepeaters) {
+				reps = int(reqPkt.MaxRepetitions)
+			}
+			name := v.Name
+			for i := 0; i < reps; i++ {
+				next := sort.Search(len(mib), func(j int) bool { return oidLess(name, mib[j].Name) })
+				if next == len(mib) {
+					pdus = append(pdus, SnmpPDU{Name: name, Type: EndOfMibView})
+					break
+				}
+				pdus = append(pdus, mib[next])
+				name = mib[next].Name
 			}
-			nsock.WriteTo(outBuf, addr)
-			nsock.Close()
 		}
-	}()
+		rspPkt := x.mkSnmpPacket(GetResponse, pdus, 0, 0)
+		rspPkt.RequestID = reqPkt.RequestID
+		outBuf, err := rspPkt.marshalMsg()
+		if err != nil {
+			t.Errorf("ERR: %s", err)
+			return
+		}
+		srvr.WriteTo(outBuf, addr)
+	}
+}
 
-	pdus := []SnmpPDU{{Name: ".1.2", Type: Null}}
-	// This is not actually a GetResponse, but we need something our test server can unmarshal.
-	reqPkt := x.mkSnmpPacket(GetResponse, pdus, 0, 0)
+// nextResponder answers GetNext and GetBulk requests with the OID next
+// returns for the one before it, one per GETBULK repetition.
+func nextResponder(t *testing.T, x *GoSNMP, srvr *net.UDPConn, next func(oid string) string) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := srvr.ReadFrom(buf)
+		if err != nil {
+			return
+		}
 
-	_, err = x.sendOneRequest(reqPkt, true)
-	if err != nil && enable {
-		t.Errorf("with unconnected socket enabled got unexpected error: %v", err)
-	} else if err == nil && !enable {
-		t.Errorf("with unconnected socket disabled didn't get an error")
+		var reqPkt SnmpPacket
+		cursor, err := x.unmarshalHeader(buf[:n], &reqPkt)
+		if err == nil {
+			err = x.unmarshalPayload(buf[:n], cursor, &reqPkt)
+		}
+		if err != nil {
+			t.Errorf("error: %s", err)
+			return
+		}
+
+		reps := 1
+		if reqPkt.PDUType == GetBulkRequest {
+			reps = int(reqPkt.MaxRepetitions)
+		}
+		name := reqPkt.Variables[0].Name
+		pdus := make([]SnmpPDU, 0, reps)
+		for i := 0; i < reps; i++ {
+			name = next(name)
+			pdus = append(pdus, SnmpPDU{Name: name, Type: Integer, Value: i})
+		}
+		rspPkt := x.mkSnmpPacket(GetResponse, pdus, 0, 0)
+		rspPkt.RequestID = reqPkt.RequestID
+		outBuf, err := rspPkt.marshalMsg()
+		if err != nil {
+			t.Errorf("ERR: %s", err)
+			return
+		}
+		srvr.WriteTo(outBuf, addr)
 	}
 }
 
-/*
-$ snmptrap -v 2c -c public 192.168.1.10 '' SNMPv2-MIB::system SNMPv2-MIB::sysDescr.0 s "red laptop" SNMPv2-MIB::sysServices.0 i "5"
+func TestWalkNotIncreasing(t *testing.T) {
+	tests := []struct {
+		name string
+		next func(oid string) string
+	}{
+		{"same OID", func(oid string) string {
+			if oid == ".1.3.6.1.2.1.1" {
+				return ".1.3.6.1.2.1.1.1.0"
+			}
+			return oid
+		}},
+		{"smaller OID", func(oid string) string {
+			if oid == ".1.3.6.1.2.1.1.5.0" {
+				return ".1.3.6.1.2.1.1.2.0"
+			}
+			return ".1.3.6.1.2.1.1.5.0"
+		}},
+	}
 
-Simple Network Management Protocol
-    version: v2c (1)
-    community: public
-    data: snmpV2-trap (7)
-        snmpV2-trap
-            request-id: 1271509950
-            error-status: noError (0)
-            error-index: 0
-            variable-bindings: 5 items
-                1.3.6.1.2.1.1.3.0: 1034156
-                    Object Name: 1.3.6.1.2.1.1.3.0 (iso.3.6.1.2.1.1.3.0)
-                    Value (Timeticks): 1034156
-                1.3.6.1.6.3.1.1.4.1.0: 1.3.6.1.2.1.1 (iso.3.6.1.2.1.1)
-                    Object Name: 1.3.6.1.6.3.1.1.4.1.0 (iso.3.6.1.6.3.1.1.4.1.0)
-                    Value (OID): 1.3.6.1.2.1.1 (iso.3.6.1.2.1.1)
-                1.3.6.1.2.1.1.1.0: 726564206c6170746f70
-                    Object Name: 1.3.6.1.2.1.1.1.0 (iso.3.6.1.2.1.1.1.0)
-                    Value (OctetString): 726564206c6170746f70
-                        Variable-binding-string: red laptop
-                1.3.6.1.2.1.1.7.0:
-                    Object Name: 1.3.6.1.2.1.1.7.0 (iso.3.6.1.2.1.1.7.0)
-                    Value (Integer32): 5
-                1.3.6.1.2.1.1.2: 1.3.6.1.4.1.2.3.4.5 (iso.3.6.1.4.1.2.3.4.5)
-                    Object Name: 1.3.6.1.2.1.1.2 (iso.3.6.1.2.1.1.2)
-                    Value (OID): 1.3.6.1.4.1.2.3.4.5 (iso.3.6.1.4.1.2.3.4.5)
-*/
+	for _, test := range tests {
+		srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
+		if err != nil {
+			t.Fatalf("udp4 error listening: %s", err)
+		}
 
-func trap1() []byte {
-	return []byte{
-		0x30, 0x81, 0x80, 0x02, 0x01, 0x01, 0x04, 0x06, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0xa7, 0x73,
-		0x02, 0x04, 0x72, 0x5c, 0xef, 0x42, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00, 0x30, 0x65, 0x30, 0x10,
-		0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x03, 0x00, 0x43, 0x04, 0x01, 0x1a, 0xef, 0xa5,
-		0x30, 0x14, 0x06, 0x0a, 0x2b, 0x06, 0x01, 0x06, 0x03, 0x01, 0x01, 0x04, 0x01, 0x00, 0x06, 0x06,
-		0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x30, 0x16, 0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01,
-		0x01, 0x00, 0x04, 0x0a, 0x72, 0x65, 0x64, 0x20, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x30, 0x0d,
-		0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x07, 0x00, 0x02, 0x01, 0x05, 0x30, 0x14, 0x06,
-		0x07, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x02, 0x06, 0x09, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x02,
-		0x03, 0x04, 0x05, 0x00, 0x00, 0x00, 0xd0, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x68, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x74, 0x3a, 0x05, 0x00, 0xa1, 0x27, 0x42, 0x0c, 0x46, 0x00,
-		0x00, 0x00, 0x46, 0x00, 0x00, 0x00, 0x10, 0x4a, 0x7d, 0x34, 0x3a, 0xa5, 0x74, 0xda, 0x38, 0x4d,
-		0x6c, 0x6c, 0x08, 0x00, 0x45, 0x00, 0x00, 0x38, 0xcc, 0xdb, 0x40, 0x00, 0xff, 0x01, 0x2b, 0x74,
-		0xc0, 0xa8, 0x01, 0x0a, 0xc0, 0xa8, 0x01, 0x1a, 0x03, 0x03, 0x11, 0x67, 0x00, 0x00, 0x00, 0x00,
-		0x45, 0x00, 0x00, 0x9f, 0xe6, 0x8f, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00, 0xc0, 0xa8, 0x01, 0x1a,
-		0xc0, 0xa8, 0x01, 0x0a, 0xaf, 0x78, 0x00, 0xa2, 0x00, 0x8b, 0x0b, 0x3a, 0x00, 0x00, 0x68, 0x00,
-		0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x6c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x74, 0x3a,
-		0x05, 0x00, 0xca, 0x94, 0x67, 0x0c, 0x01, 0x00, 0x1c, 0x00, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x65,
-		0x72, 0x73, 0x20, 0x70, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x64, 0x20, 0x62, 0x79, 0x20, 0x64,
-		0x75, 0x6d, 0x70, 0x63, 0x61, 0x70, 0x02, 0x00, 0x08, 0x00, 0x74, 0x3a, 0x05, 0x00, 0xdf, 0xba,
-		0x27, 0x0c, 0x03, 0x00, 0x08, 0x00, 0x74, 0x3a, 0x05, 0x00, 0x18, 0x94, 0x67, 0x0c, 0x04, 0x00,
-		0x08, 0x00, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x00, 0x08, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x6c, 0x00, 0x00, 0x00}
-}
+		x := &GoSNMP{
+			Version:        Version2c,
+			Target:         srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+			Port:           uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+			Timeout:        time.Second,
+			Retries:        1,
+			MaxOids:        MaxOids,
+			MaxRepetitions: 3,
+		}
+		if err := x.Connect(); err != nil {
+			t.Fatalf("error connecting: %s", err)
+		}
+		go nextResponder(t, x, srvr, test.next)
+
+		for _, check := range []OIDCheckPolicy{OIDCheckStrict, OIDCheckTolerant} {
+			x.OIDCheck = check
+			var seen int
+			count := func(SnmpPDU) error {
+				seen++
+				return nil
+			}
+			err = x.Walk(".1.3.6.1.2.1.1", count)
+			assert.ErrorIs(t, err, ErrOIDNotIncreasing, "%s check=%d", test.name, check)
+			err = x.BulkWalk(".1.3.6.1.2.1.1", count)
+			assert.ErrorIs(t, err, ErrOIDNotIncreasing, "%s check=%d", test.name, check)
+			err = x.WalkRange(".1.3.6.1.2.1.1", ".1.3.6.1.2.1.2", count)
+			assert.ErrorIs(t, err, ErrOIDNotIncreasing, "%s check=%d", test.name, check)
+			// each walk stops at the first repeat, having reported what came before
+			assert.LessOrEqual(t, seen, 6, "%s check=%d", test.name, check)
+		}
 
-// Simple Network Management Protocol
-//     msgVersion: snmpv3 (3)
-//     msgGlobalData
-//         msgID: 91040642
-//         msgMaxSize: 65507
-//         msgFlags: 04
-//         msgSecurityModel: USM (3)
-//     msgAuthoritativeEngineID: <MISSING>
-//     msgAuthoritativeEngineBoots: 0
-//     msgAuthoritativeEngineTime: 0
-//     msgUserName:
-//     msgAuthenticationParameters: <MISSING>
-//     msgPrivacyParameters: <MISSING>
-//     msgData: plaintext (0)
-//         plaintext
+		// without the check only the WalkFunc ends the walk
+		x.OIDCheck = OIDCheckOff
+		errEnough := errors.New("enough")
+		var seen int
+		err = x.Walk(".1.3.6.1.2.1.1", func(SnmpPDU) error {
+			if seen++; seen == 10 {
+				return errEnough
+			}
+			return nil
+		})
+		assert.ErrorIs(t, err, errEnough, test.name)
 
-func snmpv3HelloRequest() []byte {
-	return []byte{0x30, 0x52, 0x02, 0x01, 0x03, 0x30, 0x11, 0x02,
-		0x04, 0x05, 0x6d, 0x2b, 0x82, 0x02, 0x03, 0x00,
-		0xff, 0xe3, 0x04, 0x01, 0x04, 0x02, 0x01, 0x03,
-		0x04, 0x10, 0x30, 0x0e, 0x04, 0x00, 0x02, 0x01,
-		0x00, 0x02, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00,
-		0x04, 0x00, 0x30, 0x28, 0x04, 0x00, 0x04, 0x14,
-		0x66, 0x6f, 0x72, 0x65, 0x69, 0x67, 0x6e, 0x66,
-		0x6f, 0x72, 0x6d, 0x61, 0x74, 0x73, 0x2f, 0x6c,
-		0x69, 0x6e, 0x75, 0x78, 0xa0, 0x0e, 0x02, 0x04,
-		0x44, 0xfa, 0x16, 0xe1, 0x02, 0x01, 0x00, 0x02,
-		0x01, 0x00, 0x30, 0x00}
+		x.Conn.Close()
+		srvr.Close()
+	}
 }
 
-// msgData: plaintext (0)
-//     plaintext
-//         contextEngineID: 80004fb8054445534b544f502d4a3732533245343ab63bc8
-//             1... .... = Engine ID Conformance: RFC3411 (SNMPv3)
+func TestWalkOIDCheckTolerant(t *testing.T) {
+	mib := []string{".1.3.6.1.2.1.1.1.0", ".1.3.6.1.2.1.1.2.0", ".1.3.6.1.2.1.1.3.0", ".1.3.6.1.2.1.2.1.0"}
+	walks := map[string]func(x *GoSNMP, walkFn WalkFunc) error{
+		"Walk": func(x *GoSNMP, walkFn WalkFunc) error {
+			return x.Walk(".1.3.6.1.2.1.1", walkFn)
+		},
+		"BulkWalk": func(x *GoSNMP, walkFn WalkFunc) error {
+			return x.BulkWalk(".1.3.6.1.2.1.1", walkFn)
+		},
+		"WalkRange": func(x *GoSNMP, walkFn WalkFunc) error {
+			return x.WalkRange(".1.3.6.1.2.1.1", ".1.3.6.1.2.1.2", walkFn)
+		},
+	}
+
+	for name, walk := range walks {
+		srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
+		if err != nil {
+			t.Fatalf("udp4 error listening: %s", err)
+		}
+
+		x := &GoSNMP{
+			Version:        Version2c,
+			Target:         srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+			Port:           uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+			Timeout:        time.Second,
+			Retries:        1,
+			MaxOids:        MaxOids,
+			MaxRepetitions: 3,
+			OIDCheck:       OIDCheckTolerant,
+		}
+		if err := x.Connect(); err != nil {
+			t.Fatalf("error connecting: %s", err)
+		}
+		// the second row is repeated once
+		repeated := false
+		go nextResponder(t, x, srvr, func(oid string) string {
+			if oid == mib[1] && !repeated {
+				repeated = true
+				return oid
+			}
+			next := sort.Search(len(mib), func(j int) bool { return oidLess(oid, mib[j]) })
+			if next == len(mib) {
+				return oid + ".1"
+			}
+			return mib[next]
+		})
+
+		var names []string
+		err = walk(x, func(pdu SnmpPDU) error {
+			names = append(names, pdu.Name)
+			return nil
+		})
+		assert.NoError(t, err, name)
+		assert.Equal(t, mib[:3], names, name)
+
+		x.Conn.Close()
+		srvr.Close()
+	}
+}
+
+func TestWalkAllBoundaries(t *testing.T) {
+	mib := []SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: []byte("descr")},
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(100)},
+		{Name: ".1.3.6.1.2.1.1.10.0", Type: Integer, Value: 10},
+		{Name: ".1.3.6.1.2.1.2.1.0", Type: Integer, Value: 2},
+		{Name: ".1.3.6.1.2.1.2.2.1.1.1", Type: Integer, Value: 1},
+	}
+	tests := []struct {
+		root string
+		want []string
+	}{
+		// stops at the first OID outside the subtree, which is not returned
+		{".1.3.6.1.2.1.1", []string{".1.3.6.1.2.1.1.1.0", ".1.3.6.1.2.1.1.3.0", ".1.3.6.1.2.1.1.10.0"}},
+		{"1.3.6.1.2.1.1.", []string{".1.3.6.1.2.1.1.1.0", ".1.3.6.1.2.1.1.3.0", ".1.3.6.1.2.1.1.10.0"}},
+		// .1.3.6.1.2.1.1.1 is not a prefix of .1.3.6.1.2.1.1.10.0
+		{".1.3.6.1.2.1.1.1", []string{".1.3.6.1.2.1.1.1.0"}},
+		// stops at endOfMibView
+		{".1.3.6.1.2.1.2", []string{".1.3.6.1.2.1.2.1.0", ".1.3.6.1.2.1.2.2.1.1.1"}},
+		// a leaf is fetched with a Get
+		{".1.3.6.1.2.1.1.3.0", []string{".1.3.6.1.2.1.1.3.0"}},
+		{".1.3.6.1.2.1.3", nil},
+	}
+
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:        Version2c,
+		Target:         srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:           uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:        time.Second,
+		Retries:        1,
+		MaxOids:        MaxOids,
+		MaxRepetitions: 2,
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+	go mibResponder(t, x, srvr, mib)
+
+	for _, test := range tests {
+		for _, bulk := range []bool{false, true} {
+			var results []SnmpPDU
+			if bulk {
+				results, err = x.BulkWalkAll(test.root)
+			} else {
+				results, err = x.WalkAll(test.root)
+			}
+			assert.NoError(t, err, "root=%s bulk=%v", test.root, bulk)
+			var names []string
+			for _, pdu := range results {
+				names = append(names, pdu.Name)
+			}
+			assert.Equal(t, test.want, names, "root=%s bulk=%v", test.root, bulk)
+		}
+	}
+}
+
+func TestUnmarshalTable(t *testing.T) {
+	// a sparse ifTable: ifSpeed is missing for row 10
+	mib := []SnmpPDU{
+		{Name: ".1.3.6.1.2.1.2.2.1.1.2", Type: Integer, Value: 2},
+		{Name: ".1.3.6.1.2.1.2.2.1.1.10", Type: Integer, Value: 10},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.2", Type: OctetString, Value: []byte("eth0")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.10", Type: OctetString, Value: []byte("eth1")},
+		{Name: ".1.3.6.1.2.1.2.2.1.5.2", Type: Gauge32, Value: uint(1000000000)},
+		{Name: ".1.3.6.1.2.1.2.2.1.6.2", Type: OctetString, Value: []byte{0, 1, 2, 3, 4, 5}},
+		{Name: ".1.3.6.1.2.1.2.2.1.6.10", Type: OctetString, Value: []byte{0, 1, 2, 3, 4, 6}},
+		{Name: ".1.3.6.1.2.1.2.2.1.8.2", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.2.1.2.2.1.8.10", Type: Integer, Value: 2},
+		{Name: ".1.3.6.1.2.1.2.3.0", Type: Integer, Value: 0},
+	}
+
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:        Version2c,
+		Target:         srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:           uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:        time.Second,
+		Retries:        1,
+		MaxOids:        MaxOids,
+		MaxRepetitions: 4,
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+	go mibResponder(t, x, srvr, mib)
+
+	table, err := x.WalkTable(".1.3.6.1.2.1.2.2.1")
+	assert.NoError(t, err)
+	assert.Len(t, table, 2)
+	assert.Len(t, table["2"], 5)
+	assert.Len(t, table["10"], 4)
+
+	type ifRow struct {
+		Index  string      `snmp:"index"`
+		IfIdx  int32       `snmp:"1"`
+		Descr  string      `snmp:"2"`
+		Speed  uint64      `snmp:"5"`
+		Phys   []byte      `snmp:"6"`
+		Status SnmpPDU     `snmp:"8"`
+		Other  interface{} `snmp:"9"`
+		Unused string
+	}
+	var rows []ifRow
+	assert.NoError(t, x.UnmarshalTable(".1.3.6.1.2.1.2.2.1", &rows))
+	assert.Equal(t, []ifRow{
+		{Index: "2", IfIdx: 2, Descr: "eth0", Speed: 1000000000, Phys: []byte{0, 1, 2, 3, 4, 5}, Status: mib[7]},
+		{Index: "10", IfIdx: 10, Descr: "eth1", Phys: []byte{0, 1, 2, 3, 4, 6}, Status: mib[8]},
+	}, rows)
+
+	var ptrRows []*struct {
+		Descr string `snmp:"2"`
+	}
+	assert.NoError(t, x.UnmarshalTable(".1.3.6.1.2.1.2.2.1", &ptrRows))
+	if assert.Len(t, ptrRows, 2) {
+		assert.Equal(t, "eth1", ptrRows[1].Descr)
+	}
+
+	var badRows []struct {
+		Descr int `snmp:"2"`
+	}
+	assert.ErrorIs(t, x.UnmarshalTable(".1.3.6.1.2.1.2.2.1", &badRows), ErrWrongValueType)
+
+	var notSlice struct{}
+	assert.Error(t, x.UnmarshalTable(".1.3.6.1.2.1.2.2.1", &notSlice))
+	var badTag []struct {
+		Descr string `snmp:"descr"`
+	}
+	assert.Error(t, x.UnmarshalTable(".1.3.6.1.2.1.2.2.1", &badTag))
+}
+
+func TestBulkWalkTable(t *testing.T) {
+	// tcpConnTable, indexed by local address and port and remote address
+	// and port
+	mib := []SnmpPDU{
+		{Name: ".1.3.6.1.2.1.6.13.1.1.10.0.0.1.22.10.0.0.9.50000", Type: Integer, Value: 5},
+		{Name: ".1.3.6.1.2.1.6.13.1.1.10.0.0.1.161.10.0.0.8.40000", Type: Integer, Value: 5},
+		{Name: ".1.3.6.1.2.1.6.13.1.2.10.0.0.1.22.10.0.0.9.50000", Type: IPAddress, Value: "10.0.0.1"},
+		{Name: ".1.3.6.1.2.1.6.13.1.2.10.0.0.1.161.10.0.0.8.40000", Type: IPAddress, Value: "10.0.0.1"},
+		{Name: ".1.3.6.1.2.1.6.13.1.3.10.0.0.1.22.10.0.0.9.50000", Type: Integer, Value: 22},
+		{Name: ".1.3.6.1.2.1.6.14.0", Type: Counter32, Value: uint32(0)},
+	}
+
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:        Version2c,
+		Target:         srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:           uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:        time.Second,
+		Retries:        1,
+		MaxOids:        MaxOids,
+		MaxRepetitions: 4,
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+	go mibResponder(t, x, srvr, mib)
+
+	table, err := x.BulkWalkTable(".1.3.6.1.2.1.6.13")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]SnmpPDU{
+		"10.0.0.1.22.10.0.0.9.50000": {
+			".1.3.6.1.2.1.6.13.1.1": mib[0],
+			".1.3.6.1.2.1.6.13.1.2": mib[2],
+			".1.3.6.1.2.1.6.13.1.3": mib[4],
+		},
+		"10.0.0.1.161.10.0.0.8.40000": {
+			".1.3.6.1.2.1.6.13.1.1": mib[1],
+			".1.3.6.1.2.1.6.13.1.2": mib[3],
+		},
+	}, table)
+
+	table, err = x.BulkWalkTable("1.3.6.1.2.1.6.99")
+	assert.NoError(t, err)
+	assert.Empty(t, table)
+}
+
+func TestGetBulkN(t *testing.T) {
+	mib := []SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(100)},
+		{Name: ".1.3.6.1.2.1.2.1.0", Type: Integer, Value: 4},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: OctetString, Value: []byte("lo")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.2", Type: OctetString, Value: []byte("eth0")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.3", Type: OctetString, Value: []byte("eth1")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.4", Type: OctetString, Value: []byte("eth2")},
+	}
+
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:        Version2c,
+		Target:         srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:           uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:        time.Second,
+		MaxOids:        MaxOids,
+		NonRepeaters:   0,
+		MaxRepetitions: 50,
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+	// its own GoSNMP, as x changes version below
+	go mibResponder(t, &GoSNMP{Version: Version2c}, srvr, mib)
+
+	// sysUpTime and ifNumber once, then two ifDescr
+	result, err := x.GetBulkN(2, 2, ".1.3.6.1.2.1.1.3", ".1.3.6.1.2.1.2.1", ".1.3.6.1.2.1.2.2.1.2")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var names []string
+	for _, pdu := range result.Variables {
+		names = append(names, pdu.Name)
+	}
+	assert.Equal(t, []string{".1.3.6.1.2.1.1.3.0", ".1.3.6.1.2.1.2.1.0", ".1.3.6.1.2.1.2.2.1.2.1", ".1.3.6.1.2.1.2.2.1.2.2"}, names)
+
+	x.Version = Version1
+	_, err = x.GetBulkN(0, 2, ".1.3.6.1.2.1.1.3")
+	assert.Error(t, err)
+}
+
+func TestUnmarshalExceptions(t *testing.T) {
+	x := &GoSNMP{
+		Version:   Version2c,
+		Community: "public",
+		Logger:    NewLogger(log.New(io.Discard, "", 0)),
+	}
+	pkt := x.mkSnmpPacket(GetResponse, []SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: []byte("x")},
+		{Name: ".1.3.6.1.2.1.1.99", Type: NoSuchObject},
+		{Name: ".1.3.6.1.2.1.1.1.1", Type: NoSuchInstance},
+		{Name: ".1.3.6.1.6.3.1", Type: EndOfMibView},
+	}, 0, 0)
+	msg, err := pkt.marshalMsg()
+	if !assert.NoError(t, err) {
+		return
+	}
+	result, err := x.SnmpDecodePacket(msg)
+	if !assert.NoError(t, err) || !assert.Len(t, result.Variables, 4) {
+		return
+	}
+	for i, want := range []Asn1BER{OctetString, NoSuchObject, NoSuchInstance, EndOfMibView} {
+		pdu := result.Variables[i]
+		assert.Equal(t, want, pdu.Type, pdu.Name)
+		assert.Equal(t, i > 0, pdu.IsException(), pdu.Name)
+		if i > 0 {
+			assert.Nil(t, pdu.Value, pdu.Name)
+		}
+	}
+}
+
+func TestGetSplit(t *testing.T) {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:    Version2c,
+		Community:  "public",
+		Target:     srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:       uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:    time.Second,
+		MaxOids:    20,
+		MaxPDUSize: 484,
+		Logger:     NewLogger(log.New(io.Discard, "", 0)),
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+
+	// the agent answers tooBig to more than 7 variables, and noSuchName
+	// for .1.3.6.1.99
+	var maxSize atomic.Int64
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := srvr.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var reqPkt SnmpPacket
+			cursor, err := x.unmarshalHeader(buf[:n], &reqPkt)
+			if err == nil {
+				err = x.unmarshalPayload(buf[:n], cursor, &reqPkt)
+			}
+			if err != nil {
+				t.Errorf("error: %s", err)
+				return
+			}
+			if int64(n) > maxSize.Load() {
+				maxSize.Store(int64(n))
+			}
+
+			rspPkt := x.mkSnmpPacket(GetResponse, nil, 0, 0)
+			rspPkt.RequestID = reqPkt.RequestID
+			if len(reqPkt.Variables) > 7 {
+				rspPkt.Error = TooBig
+				rspPkt.Variables = reqPkt.Variables
+			} else {
+				for i, v := range reqPkt.Variables {
+					if v.Name == ".1.3.6.1.99" {
+						rspPkt.Error = NoSuchName
+						rspPkt.ErrorIndex = uint8(i + 1)
+					}
+					rspPkt.Variables = append(rspPkt.Variables, SnmpPDU{Name: v.Name, Type: OctetString, Value: []byte(v.Name)})
+				}
+			}
+			outBuf, err := rspPkt.marshalMsg()
+			if err != nil {
+				t.Errorf("ERR: %s", err)
+				return
+			}
+			srvr.WriteTo(outBuf, addr)
+		}
+	}()
+
+	var oids []string
+	for i := 0; i < 50; i++ {
+		oids = append(oids, fmt.Sprintf(".1.3.6.1.4.1.2021.11.%d.0", i))
+	}
+	result, err := x.Get(oids)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, NoError, result.Error)
+	if assert.Len(t, result.Variables, len(oids)) {
+		for i, oid := range oids {
+			assert.Equal(t, oid, result.Variables[i].Name)
+		}
+	}
+	assert.LessOrEqual(t, int(maxSize.Load()), x.MaxPDUSize)
+
+	// no oids are sent in one empty request
+	result, err = x.Get(nil)
+	if assert.NoError(t, err) && assert.NotNil(t, result) {
+		assert.Equal(t, NoError, result.Error)
+		assert.Empty(t, result.Variables)
+	}
+
+	// errors are indexed into the whole request
+	oids[12] = ".1.3.6.1.99"
+	result, err = x.Get(oids)
+	if assert.NoError(t, err) {
+		assert.Equal(t, NoSuchName, result.Error)
+		assert.Equal(t, uint8(13), result.ErrorIndex)
+	}
+
+	// an error index into the whole request cannot go past 255
+	oids = oids[:0]
+	for i := 0; i < 300; i++ {
+		oids = append(oids, fmt.Sprintf(".1.3.6.1.4.1.2021.11.%d.0", i))
+	}
+	oids[279] = ".1.3.6.1.99"
+	_, err = x.Get(oids)
+	assert.EqualError(t, err, "agent reported NoSuchName for variable 280, past the error indexes of a PDU")
+
+	x.MaxPDUSize = 40
+	_, err = x.Get(oids)
+	assert.Error(t, err)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	// an agent that never answers
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:      Version2c,
+		Target:       srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:         uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:      20 * time.Millisecond,
+		Retries:      2,
+		MaxOids:      MaxOids,
+		RetryBackoff: Backoff{Base: 50 * time.Millisecond},
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+
+	// three 20ms attempts with 50ms and 100ms between them
+	start := time.Now()
+	_, err = x.Get([]string{".1.2"})
+	assert.Error(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 210*time.Millisecond)
+
+	// the backoff does not sleep past the context deadline
+	x.RetryBackoff.Base = 10 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = x.GetContext(ctx, []string{".1.2"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestOnRetryAttempt(t *testing.T) {
+	agent, err := NewMockAgent(map[string]SnmpPDU{
+		".1.3.6.1.2.1.1.5.0": {Type: OctetString, Value: "core-sw1"},
+	})
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()),
+		WithTimeout(20*time.Millisecond), WithRetries(2))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+
+	var attempts []int
+	x.OnRetryAttempt = func(x *GoSNMP, attempt int, lastErr error) {
+		attempts = append(attempts, attempt)
+		assert.ErrorContains(t, lastErr, "timeout")
+	}
+
+	// first try success
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	assert.NoError(t, err)
+	assert.Empty(t, attempts)
+
+	// all attempts fail, and no retry is announced past the last
+	agent.SetFault(MockTimeout)
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	assert.Error(t, err)
+	assert.Equal(t, []int{2, 3}, attempts)
+
+	// the third attempt succeeds
+	attempts = nil
+	x.OnRetryAttempt = func(x *GoSNMP, attempt int, lastErr error) {
+		attempts = append(attempts, attempt)
+		if attempt == 3 {
+			agent.SetFault(MockNoFault)
+		}
+	}
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, attempts)
+}
+
+func TestRateLimit(t *testing.T) {
+	agent, err := NewMockAgent(map[string]SnmpPDU{
+		".1.3.6.1.2.1.1.5.0": {Type: OctetString, Value: "core-sw1"},
+	})
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()), WithRateLimit(20, 2))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+
+	// a burst of two, then one every 50ms
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if _, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"}); err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+	assert.Equal(t, 6, agent.Requests())
+
+	// retries wait for their tokens too
+	agent.SetFault(MockTimeout)
+	x.Timeout = 10 * time.Millisecond
+	x.Retries = 2
+	x.RateLimiter = NewRateLimiter(10, 1)
+	start = time.Now()
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	assert.Error(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+
+	// a wait that would outlast the context fails straight away
+	x.RateLimiter = NewRateLimiter(0.1, 1)
+	assert.NoError(t, x.RateLimiter.Wait(context.Background()))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start = time.Now()
+	_, err = x.GetContext(ctx, []string{".1.3.6.1.2.1.1.5.0"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// as does one whose context is canceled
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	assert.ErrorIs(t, x.RateLimiter.Wait(ctx), context.Canceled)
+
+	_, err = NewGoSNMP("192.0.2.1", WithRateLimit(0, 1))
+	assert.Error(t, err)
+}
+
+func TestWalkContextCanceled(t *testing.T) {
+	for _, bulk := range []bool{false, true} {
+		srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
+		if err != nil {
+			t.Fatalf("udp4 error listening: %s", err)
+		}
+		defer srvr.Close()
+
+		x := &GoSNMP{
+			Version:        Version2c,
+			Target:         srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+			Port:           uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+			Timeout:        time.Second,
+			Retries:        1,
+			MaxOids:        MaxOids,
+			MaxRepetitions: 5,
+		}
+		if err := x.Connect(); err != nil {
+			t.Fatalf("error connecting: %s", err)
+		}
+		defer x.Conn.Close()
+		go walkResponder(t, x, srvr, 0)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		walkFn := func(SnmpPDU) error {
+			calls++
+			if calls == 3 {
+				cancel()
+			}
+			return nil
+		}
+		if bulk {
+			err = x.BulkWalkContext(ctx, ".1.2", walkFn)
+		} else {
+			err = x.WalkContext(ctx, ".1.2", walkFn)
+		}
+		assert.ErrorIs(t, err, context.Canceled, "bulk=%v", bulk)
+		assert.Equal(t, 3, calls, "bulk=%v", bulk)
+	}
+}
+
+func TestGetWithTimeout(t *testing.T) {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version: Version2c,
+		Target:  srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout: time.Millisecond * 20,
+		Retries: 0,
+		MaxOids: MaxOids,
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+	go walkResponder(t, x, srvr, 100*time.Millisecond)
+
+	_, err = x.GetWithTimeout([]string{".1.2"}, 2*time.Second)
+	assert.NoError(t, err)
+	_, err = x.GetBulkWithTimeout([]string{".1.2"}, 0, 2, 2*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Millisecond*20, x.Timeout)
+
+	_, err = x.Get([]string{".1.2"})
+	assert.ErrorContains(t, err, "request timeout")
+}
+
+func TestReadBERMessage(t *testing.T) {
+	short := []byte{0x30, 0x03, 0x02, 0x01, 0x05}
+	long := append([]byte{0x30, 0x81, 0x80}, make([]byte, 0x80)...)
+
+	// two messages back to back on one stream
+	r := bytes.NewReader(append(append([]byte{}, short...), long...))
+	msg, err := readBERMessage(r, rxBufSize)
+	assert.NoError(t, err)
+	assert.Equal(t, short, msg)
+	msg, err = readBERMessage(r, rxBufSize)
+	assert.NoError(t, err)
+	assert.Equal(t, long, msg)
+	_, err = readBERMessage(r, rxBufSize)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = readBERMessage(bytes.NewReader(short[:4]), rxBufSize)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = readBERMessage(bytes.NewReader([]byte{0x04, 0x00}), rxBufSize)
+	assert.Error(t, err)
+	_, err = readBERMessage(bytes.NewReader(long), 0x80)
+	assert.Error(t, err)
+	_, err = readBERMessage(bytes.NewReader([]byte{0x30, 0x85, 1, 0, 0, 0, 0}), rxBufSize)
+	assert.ErrorIs(t, err, ErrInvalidPacketLength)
+	// indefinite lengths are refused, as when framing a stream
+	_, err = readBERMessage(bytes.NewReader([]byte{0x30, 0x80, 0x02, 0x01, 0x05, 0x00, 0x00}), rxBufSize)
+	assert.ErrorIs(t, err, ErrInvalidPacketLength)
+	_, err = readBERMessage(bytes.NewReader(long[:2]), rxBufSize)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestSendOneRequestTCP(t *testing.T) {
+	l, err := net.ListenTCP("tcp4", &net.TCPAddr{})
+	if err != nil {
+		t.Fatalf("tcp4 error listening: %s", err)
+	}
+	defer l.Close()
+
+	x := &GoSNMP{
+		Version:   Version2c,
+		Transport: "tcp",
+		Target:    l.Addr().(*net.TCPAddr).IP.String(),
+		Port:      uint16(l.Addr().(*net.TCPAddr).Port),
+		Timeout:   time.Second,
+		Retries:   1,
+		MaxOids:   MaxOids,
+	}
+
+	var accepts int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepts, 1)
+			go func() {
+				defer conn.Close()
+				for {
+					req, err := readBERMessage(conn, rxBufSize)
+					if err != nil {
+						return
+					}
+					var reqPkt SnmpPacket
+					cursor, err := x.unmarshalHeader(req, &reqPkt)
+					if err == nil {
+						err = x.unmarshalPayload(req, cursor, &reqPkt)
+					}
+					if err != nil {
+						t.Errorf("error: %s", err)
+						return
+					}
+					rspPkt := x.mkSnmpPacket(GetResponse, []SnmpPDU{{Name: ".1.2", Type: Integer, Value: 123}}, 0, 0)
+					rspPkt.RequestID = reqPkt.RequestID
+					outBuf, err := rspPkt.marshalMsg()
+					if err != nil {
+						t.Errorf("ERR: %s", err)
+						return
+					}
+					// split the response to make sure the client reassembles it
+					conn.Write(outBuf[:3])
+					time.Sleep(10 * time.Millisecond)
+					conn.Write(outBuf[3:])
+				}
+			}()
+		}
+	}()
+
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+
+	for i := 0; i < 3; i++ {
+		result, err := x.Get([]string{".1.2"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 123, result.Variables[0].Value)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&accepts))
+}
+
+func TestStreamReadCanceled(t *testing.T) {
+	client, agent := net.Pipe()
+	defer agent.Close()
+
+	x := &GoSNMP{
+		Version:   Version2c,
+		Community: "public",
+		Transport: "tcp",
+		Timeout:   2 * time.Second,
+		MaxOids:   MaxOids,
+	}
+	if err := x.ConnectConn(client); err != nil {
+		t.Fatalf("ConnectConn: %v", err)
+	}
+	defer x.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := x.GetContext(ctx, []string{".1.3.6.1.2.1.1.1.0"})
+		canceled <- err
+	}()
+	type answer struct {
+		result *SnmpPacket
+		err    error
+	}
+	answered := make(chan answer, 1)
+	go func() {
+		result, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+		answered <- answer{result, err}
+	}()
+
+	var response []byte
+	for i := 0; i < 2; i++ {
+		req, err := readBERMessage(agent, rxBufSize)
+		if err != nil {
+			t.Fatalf("agent read: %v", err)
+		}
+		request, err := x.SnmpDecodePacket(req)
+		if err != nil {
+			t.Fatalf("decoding request: %v", err)
+		}
+		if request.Variables[0].Name != ".1.3.6.1.2.1.1.5.0" {
+			continue
+		}
+		request.PDUType = GetResponse
+		request.Variables[0].Type = OctetString
+		request.Variables[0].Value = "core-sw1"
+		if response, err = request.marshalMsg(); err != nil {
+			t.Fatalf("marshalling response: %v", err)
+		}
+	}
+
+	// the reads are interrupted half way through the response, which the
+	// second request must still get whole
+	if _, err := agent.Write(response[:5]); err != nil {
+		t.Fatalf("agent write: %v", err)
+	}
+	cancel()
+	assert.ErrorIs(t, <-canceled, context.Canceled)
+	go func() {
+		_, _ = agent.Write(response[5:])
+	}()
+	a := <-answered
+	if assert.NoError(t, a.err) {
+		assert.Equal(t, []byte("core-sw1"), a.result.Variables[0].Value)
+	}
+}
+
+func TestLargeSetTCP(t *testing.T) {
+	engineID := "\x80\x00\x1f\x88\x04tcp"
+	usm := func() *UsmSecurityParameters {
+		return &UsmSecurityParameters{
+			AuthoritativeEngineID:    engineID,
+			AuthoritativeEngineBoots: 1,
+			AuthoritativeEngineTime:  100,
+			UserName:                 "user",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "maplesyrup",
+			PrivacyProtocol:          NoPriv,
+			Logger:                   NewLogger(log.New(io.Discard, "", 0)),
+		}
+	}
+	// 5KB of variables, well over a UDP datagram on most links
+	var pdus []SnmpPDU
+	for i := 0; i < 5; i++ {
+		pdus = append(pdus, SnmpPDU{Name: fmt.Sprintf(".1.3.6.1.4.1.99.%d", i), Type: OctetString, Value: bytes.Repeat([]byte{'a' + byte(i)}, 1000)})
+	}
+
+	for _, version := range []SnmpVersion{Version2c, Version3} {
+		l, err := net.ListenTCP("tcp4", &net.TCPAddr{})
+		if err != nil {
+			t.Fatalf("tcp4 error listening: %s", err)
+		}
+
+		agent := &GoSNMP{Version: version, Community: "public", Logger: NewLogger(log.New(io.Discard, "", 0))}
+		if version == Version3 {
+			agent.SecurityModel, agent.MsgFlags, agent.SecurityParameters = UserSecurityModel, AuthNoPriv, usm()
+		}
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for {
+				req, err := readBERMessage(conn, rxBufSize)
+				if err != nil {
+					return
+				}
+				reqPkt, err := agent.SnmpDecodePacket(req)
+				if err != nil {
+					t.Errorf("error: %s", err)
+					return
+				}
+				// answer with the variables set, as agents do
+				rspPkt := agent.mkSnmpPacket(GetResponse, reqPkt.Variables, 0, 0)
+				rspPkt.RequestID, rspPkt.MsgID = reqPkt.RequestID, reqPkt.MsgID
+				if version == Version3 {
+					sp := usm()
+					if err = sp.InitSecurityKeys(); err != nil {
+						t.Errorf("error: %s", err)
+						return
+					}
+					rspPkt.SecurityParameters = sp
+				}
+				outBuf, err := rspPkt.marshalMsg()
+				if err != nil {
+					t.Errorf("ERR: %s", err)
+					return
+				}
+				conn.Write(outBuf)
+			}
+		}()
+
+		x := &GoSNMP{
+			Version:   version,
+			Community: "public",
+			Transport: "tcp",
+			Target:    l.Addr().(*net.TCPAddr).IP.String(),
+			Port:      uint16(l.Addr().(*net.TCPAddr).Port),
+			Timeout:   time.Second,
+			MaxOids:   MaxOids,
+		}
+		if version == Version3 {
+			x.SecurityModel, x.MsgFlags, x.SecurityParameters = UserSecurityModel, AuthNoPriv, usm()
+		}
+		if err := x.Connect(); err != nil {
+			t.Fatalf("error connecting: %s", err)
+		}
+		var sent int
+		x.OnPacket = func(x *GoSNMP, dir PacketDirection, b []byte) {
+			if dir == PacketSent {
+				sent = len(b)
+			}
+		}
+
+		result, err := x.Set(pdus)
+		if assert.NoError(t, err, version) {
+			assert.Greater(t, sent, 5000, version)
+			assert.Equal(t, NoError, result.Error, version)
+			if assert.Len(t, result.Variables, len(pdus), version) {
+				for i, pdu := range pdus {
+					assert.Equal(t, pdu.Value, result.Variables[i].Value, version)
+				}
+			}
+		}
+		x.Close()
+		l.Close()
+	}
+}
+
+func TestConnectIPv6(t *testing.T) {
+	srvr, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %s", err)
+	}
+	defer srvr.Close()
+
+	for _, target := range []string{"::1", "[::1]"} {
+		x := &GoSNMP{
+			Version: Version2c,
+			Target:  target,
+			Port:    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+			Timeout: time.Second,
+			Retries: 1,
+			MaxOids: MaxOids,
+		}
+		if err := x.Connect(); err != nil {
+			t.Fatalf("error connecting to %s: %s", target, err)
+		}
+		go walkResponder(t, x, srvr, 0)
+
+		result, err := x.Get([]string{".1.2"})
+		x.Conn.Close()
+		if assert.NoError(t, err, target) {
+			assert.Equal(t, ".1.2.1", result.Variables[0].Name)
+		}
+	}
+}
+
+func TestPeekMessageID(t *testing.T) {
+	for _, version := range []SnmpVersion{Version1, Version2c, Version3} {
+		x := &GoSNMP{
+			Version:            version,
+			Community:          "public",
+			MsgFlags:           NoAuthNoPriv,
+			SecurityModel:      UserSecurityModel,
+			SecurityParameters: &UsmSecurityParameters{UserName: "user", Logger: NewLogger(log.New(io.Discard, "", 0))},
+			Logger:             NewLogger(log.New(io.Discard, "", 0)),
+		}
+		pkt := x.mkSnmpPacket(GetRequest, []SnmpPDU{{Name: ".1.2", Type: Null}}, 0, 0)
+		pkt.RequestID = 0x12345678
+		pkt.MsgID = 0x2468ace
+		msg, err := pkt.marshalMsg()
+		if !assert.NoError(t, err) {
+			continue
+		}
+		id, ok := peekMessageID(msg)
+		assert.True(t, ok, version)
+		if version == Version3 {
+			assert.Equal(t, pkt.MsgID, id, version)
+		} else {
+			assert.Equal(t, pkt.RequestID, id, version)
+		}
+
+		for i := range msg {
+			_, _ = peekMessageID(msg[:i]) // must not panic on truncation
+		}
+	}
+	_, ok := peekMessageID([]byte{0x04, 0x00})
+	assert.False(t, ok)
+}
+
+func TestConcurrentGets(t *testing.T) {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version: Version2c,
+		Target:  srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout: time.Second * 5,
+		Retries: 0,
+		MaxOids: MaxOids,
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+
+	// answer every request after a varying delay, so that responses
+	// arrive in a different order than the requests were sent
+	go func() {
+		for n := 0; ; n++ {
+			buf := make([]byte, 1500)
+			l, addr, err := srvr.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			go func(req []byte, delay time.Duration) {
+				var reqPkt SnmpPacket
+				cursor, err := x.unmarshalHeader(req, &reqPkt)
+				if err == nil {
+					err = x.unmarshalPayload(req, cursor, &reqPkt)
+				}
+				if err != nil {
+					t.Errorf("error: %s", err)
+					return
+				}
+				name := reqPkt.Variables[0].Name
+				rspPkt := x.mkSnmpPacket(GetResponse, []SnmpPDU{{Name: name, Type: OctetString, Value: name}}, 0, 0)
+				rspPkt.RequestID = reqPkt.RequestID
+				outBuf, err := rspPkt.marshalMsg()
+				if err != nil {
+					t.Errorf("ERR: %s", err)
+					return
+				}
+				time.Sleep(delay)
+				srvr.WriteTo(outBuf, addr)
+			}(buf[:l], time.Duration(n%7)*3*time.Millisecond)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			oid := fmt.Sprintf(".1.3.6.1.2.1.1.%d.0", i)
+			result, err := x.Get([]string{oid})
+			if !assert.NoError(t, err, oid) {
+				return
+			}
+			assert.Equal(t, oid, result.Variables[0].Name)
+			assert.Equal(t, []byte(oid), result.Variables[0].Value)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkSendOneRequest(b *testing.B) {
+	b.StopTimer()
+
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		b.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version: Version2c,
+		Target:  srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout: time.Millisecond * 100,
+		Retries: 2,
+	}
+	if err := x.Connect(); err != nil {
+		b.Fatalf("error connecting: %s", err)
+	}
+
+	go func() {
+		buf := make([]byte, 256)
+		outBuf := counter64Response()
+		for {
+			_, addr, err := srvr.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			copy(outBuf[17:21], buf[11:15]) // evil: copy request ID
+			srvr.WriteTo(outBuf, addr)
+		}
+	}()
+
+	pdus := []SnmpPDU{{Name: ".1.3.6.1.2.1.31.1.1.1.10.1", Type: Null}}
+	reqPkt := x.mkSnmpPacket(GetRequest, pdus, 0, 0)
+
+	// make sure everything works before starting the test
+	_, err = x.sendOneRequest(reqPkt, true)
+	if err != nil {
+		b.Fatalf("Precheck failed: %s", err)
+	}
+
+	b.StartTimer()
+
+	for n := 0; n < b.N; n++ {
+		_, err = x.sendOneRequest(reqPkt, true)
+		if err != nil {
+			b.Fatalf("error: %s", err)
+			return
+		}
+	}
+}
+
+func TestUnconnectedSocket_fail(t *testing.T) {
+	withUnconnectedSocket(t, false)
+}
+
+func TestUnconnectedSocket_success(t *testing.T) {
+	withUnconnectedSocket(t, true)
+}
+
+func withUnconnectedSocket(t *testing.T, enable bool) {
+	srvr, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("udp error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:                 Version2c,
+		Target:                  srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:                    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:                 time.Millisecond * 100,
+		Retries:                 2,
+		UseUnconnectedUDPSocket: enable,
+		LocalAddr:               "0.0.0.0:",
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, addr, err := srvr.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			buf := buf[:n]
+
+			var reqPkt SnmpPacket
+			var cursor int
+			cursor, err = x.unmarshalHeader(buf, &reqPkt)
+			if err != nil {
+				t.Errorf("error: %s", err)
+			}
+			err = x.unmarshalPayload(buf, cursor, &reqPkt)
+			if err != nil {
+				t.Errorf("error: %s", err)
+			}
+
+			rspPkt := x.mkSnmpPacket(GetResponse, []SnmpPDU{
+				{
+					Name:  ".1.2",
+					Type:  Integer,
+					Value: 123,
+				},
+			}, 0, 0)
+			rspPkt.RequestID = reqPkt.RequestID
+			outBuf, err := rspPkt.marshalMsg()
+			if err != nil {
+				t.Errorf("ERR: %s", err)
+			}
+			// Temporary socket will use different source port, it's enough to break
+			// connected socket reply filters.
+			nsock, err := net.ListenUDP("udp", nil)
+			if err != nil {
+				t.Errorf("can't create temporary reply socket: %v", err)
+			}
+			nsock.WriteTo(outBuf, addr)
+			nsock.Close()
+		}
+	}()
+
+	pdus := []SnmpPDU{{Name: ".1.2", Type: Null}}
+	// This is not actually a GetResponse, but we need something our test server can unmarshal.
+	reqPkt := x.mkSnmpPacket(GetResponse, pdus, 0, 0)
+
+	_, err = x.sendOneRequest(reqPkt, true)
+	if err != nil && enable {
+		t.Errorf("with unconnected socket enabled got unexpected error: %v", err)
+	} else if err == nil && !enable {
+		t.Errorf("with unconnected socket disabled didn't get an error")
+	}
+}
+
+/*
+$ snmptrap -v 2c -c public 192.168.1.10 '' SNMPv2-MIB::system SNMPv2-MIB::sysDescr.0 s "red laptop" SNMPv2-MIB::sysServices.0 i "5"
+
+Simple Network Management Protocol
+    version: v2c (1)
+    community: public
+    data: snmpV2-trap (7)
+        snmpV2-trap
+            request-id: 1271509950
+            error-status: noError (0)
+            error-index: 0
+            variable-bindings: 5 items
+                1.3.6.1.2.1.1.3.0: 1034156
+                    Object Name: 1.3.6.1.2.1.1.3.0 (iso.3.6.1.2.1.1.3.0)
+                    Value (Timeticks): 1034156
+                1.3.6.1.6.3.1.1.4.1.0: 1.3.6.1.2.1.1 (iso.3.6.1.2.1.1)
+                    Object Name: 1.3.6.1.6.3.1.1.4.1.0 (iso.3.6.1.6.3.1.1.4.1.0)
+                    Value (OID): 1.3.6.1.2.1.1 (iso.3.6.1.2.1.1)
+                1.3.6.1.2.1.1.1.0: 726564206c6170746f70
+                    Object Name: 1.3.6.1.2.1.1.1.0 (iso.3.6.1.2.1.1.1.0)
+                    Value (OctetString): 726564206c6170746f70
+                        Variable-binding-string: red laptop
+                1.3.6.1.2.1.1.7.0:
+                    Object Name: 1.3.6.1.2.1.1.7.0 (iso.3.6.1.2.1.1.7.0)
+                    Value (Integer32): 5
+                1.3.6.1.2.1.1.2: 1.3.6.1.4.1.2.3.4.5 (iso.3.6.1.4.1.2.3.4.5)
+                    Object Name: 1.3.6.1.2.1.1.2 (iso.3.6.1.2.1.1.2)
+                    Value (OID): 1.3.6.1.4.1.2.3.4.5 (iso.3.6.1.4.1.2.3.4.5)
+*/
+
+func trap1() []byte {
+	return []byte{
+		0x30, 0x81, 0x80, 0x02, 0x01, 0x01, 0x04, 0x06, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0xa7, 0x73,
+		0x02, 0x04, 0x72, 0x5c, 0xef, 0x42, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00, 0x30, 0x65, 0x30, 0x10,
+		0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x03, 0x00, 0x43, 0x04, 0x01, 0x1a, 0xef, 0xa5,
+		0x30, 0x14, 0x06, 0x0a, 0x2b, 0x06, 0x01, 0x06, 0x03, 0x01, 0x01, 0x04, 0x01, 0x00, 0x06, 0x06,
+		0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x30, 0x16, 0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01,
+		0x01, 0x00, 0x04, 0x0a, 0x72, 0x65, 0x64, 0x20, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x30, 0x0d,
+		0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x07, 0x00, 0x02, 0x01, 0x05, 0x30, 0x14, 0x06,
+		0x07, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x02, 0x06, 0x09, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x02,
+		0x03, 0x04, 0x05, 0x00, 0x00, 0x00, 0xd0, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x68, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x74, 0x3a, 0x05, 0x00, 0xa1, 0x27, 0x42, 0x0c, 0x46, 0x00,
+		0x00, 0x00, 0x46, 0x00, 0x00, 0x00, 0x10, 0x4a, 0x7d, 0x34, 0x3a, 0xa5, 0x74, 0xda, 0x38, 0x4d,
+		0x6c, 0x6c, 0x08, 0x00, 0x45, 0x00, 0x00, 0x38, 0xcc, 0xdb, 0x40, 0x00, 0xff, 0x01, 0x2b, 0x74,
+		0xc0, 0xa8, 0x01, 0x0a, 0xc0, 0xa8, 0x01, 0x1a, 0x03, 0x03, 0x11, 0x67, 0x00, 0x00, 0x00, 0x00,
+		0x45, 0x00, 0x00, 0x9f, 0xe6, 0x8f, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00, 0xc0, 0xa8, 0x01, 0x1a,
+		0xc0, 0xa8, 0x01, 0x0a, 0xaf, 0x78, 0x00, 0xa2, 0x00, 0x8b, 0x0b, 0x3a, 0x00, 0x00, 0x68, 0x00,
+		0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x6c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x74, 0x3a,
+		0x05, 0x00, 0xca, 0x94, 0x67, 0x0c, 0x01, 0x00, 0x1c, 0x00, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x65,
+		0x72, 0x73, 0x20, 0x70, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x64, 0x20, 0x62, 0x79, 0x20, 0x64,
+		0x75, 0x6d, 0x70, 0x63, 0x61, 0x70, 0x02, 0x00, 0x08, 0x00, 0x74, 0x3a, 0x05, 0x00, 0xdf, 0xba,
+		0x27, 0x0c, 0x03, 0x00, 0x08, 0x00, 0x74, 0x3a, 0x05, 0x00, 0x18, 0x94, 0x67, 0x0c, 0x04, 0x00,
+		0x08, 0x00, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x00, 0x08, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x6c, 0x00, 0x00, 0x00}
+}
+
+// Simple Network Management Protocol
+//     msgVersion: snmpv3 (3)
+//     msgGlobalData
+//         msgID: 91040642
+//         msgMaxSize: 65507
+//         msgFlags: 04
+//         msgSecurityModel: USM (3)
+//     msgAuthoritativeEngineID: <MISSING>
+//     msgAuthoritativeEngineBoots: 0
+//     msgAuthoritativeEngineTime: 0
+//     msgUserName:
+//     msgAuthenticationParameters: <MISSING>
+//     msgPrivacyParameters: <MISSING>
+//     msgData: plaintext (0)
+//         plaintext
+
+func snmpv3HelloRequest() []byte {
+	return []byte{0x30, 0x52, 0x02, 0x01, 0x03, 0x30, 0x11, 0x02,
+		0x04, 0x05, 0x6d, 0x2b, 0x82, 0x02, 0x03, 0x00,
+		0xff, 0xe3, 0x04, 0x01, 0x04, 0x02, 0x01, 0x03,
+		0x04, 0x10, 0x30, 0x0e, 0x04, 0x00, 0x02, 0x01,
+		0x00, 0x02, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00,
+		0x04, 0x00, 0x30, 0x28, 0x04, 0x00, 0x04, 0x14,
+		0x66, 0x6f, 0x72, 0x65, 0x69, 0x67, 0x6e, 0x66,
+		0x6f, 0x72, 0x6d, 0x61, 0x74, 0x73, 0x2f, 0x6c,
+		0x69, 0x6e, 0x75, 0x78, 0xa0, 0x0e, 0x02, 0x04,
+		0x44, 0xfa, 0x16, 0xe1, 0x02, 0x01, 0x00, 0x02,
+		0x01, 0x00, 0x30, 0x00}
+}
+
+// msgData: plaintext (0)
+//     plaintext
+//         contextEngineID: 80004fb8054445534b544f502d4a3732533245343ab63bc8
+//             1... .... = Engine ID Conformance: RFC3411 (SNMPv3)
 //             Engine Enterprise ID: pysnmp (20408)
 //             Engine ID Format: Octets, administratively assigned (5)
 //             Engine ID Data: 4445534b544f502d4a3732533245343ab63bc8
@@ -1957,86 +3349,1928 @@ func snmpv3HelloRequest() []byte {
 //                         Object Name: 1.3.6.1.6.3.15.1.1.4.0 (iso.3.6.1.6.3.15.1.1.4.0)
 //                         Value (Counter32): 21
 
-func snmpv3HelloResponse() []byte {
-	return []byte{
-		0x30, 0x81, 0x95, 0x02, 0x01, 0x03, 0x30, 0x11,
-		0x02, 0x04, 0x05, 0x6d, 0x2b, 0x82, 0x02, 0x03,
-		0x00, 0xff, 0xe3, 0x04, 0x01, 0x00, 0x02, 0x01,
-		0x03, 0x04, 0x2a, 0x30, 0x28, 0x04, 0x18, 0x80,
-		0x00, 0x4f, 0xb8, 0x05, 0x44, 0x45, 0x53, 0x4b,
-		0x54, 0x4f, 0x50, 0x2d, 0x4a, 0x37, 0x32, 0x53,
-		0x32, 0x45, 0x34, 0x3a, 0xb6, 0x3b, 0xc8, 0x02,
-		0x01, 0x02, 0x02, 0x03, 0x00, 0xc4, 0x7a, 0x04,
-		0x00, 0x04, 0x00, 0x04, 0x00, 0x30, 0x51, 0x04,
-		0x18, 0x80, 0x00, 0x4f, 0xb8, 0x05, 0x44, 0x45,
-		0x53, 0x4b, 0x54, 0x4f, 0x50, 0x2d, 0x4a, 0x37,
-		0x32, 0x53, 0x32, 0x45, 0x34, 0x3a, 0xb6, 0x3b,
-		0xc8, 0x04, 0x14, 0x66, 0x6f, 0x72, 0x65, 0x69,
-		0x67, 0x6e, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74,
-		0x73, 0x2f, 0x6c, 0x69, 0x6e, 0x75, 0x78, 0xa8,
-		0x1f, 0x02, 0x04, 0x44, 0xfa, 0x16, 0xe1, 0x02,
-		0x01, 0x00, 0x02, 0x01, 0x00, 0x30, 0x11, 0x30,
-		0x0f, 0x06, 0x0a, 0x2b, 0x06, 0x01, 0x06, 0x03,
-		0x0f, 0x01, 0x01, 0x04, 0x00, 0x41, 0x01, 0x15,
+func snmpv3HelloResponse() []byte {
+	return []byte{
+		0x30, 0x81, 0x95, 0x02, 0x01, 0x03, 0x30, 0x11,
+		0x02, 0x04, 0x05, 0x6d, 0x2b, 0x82, 0x02, 0x03,
+		0x00, 0xff, 0xe3, 0x04, 0x01, 0x00, 0x02, 0x01,
+		0x03, 0x04, 0x2a, 0x30, 0x28, 0x04, 0x18, 0x80,
+		0x00, 0x4f, 0xb8, 0x05, 0x44, 0x45, 0x53, 0x4b,
+		0x54, 0x4f, 0x50, 0x2d, 0x4a, 0x37, 0x32, 0x53,
+		0x32, 0x45, 0x34, 0x3a, 0xb6, 0x3b, 0xc8, 0x02,
+		0x01, 0x02, 0x02, 0x03, 0x00, 0xc4, 0x7a, 0x04,
+		0x00, 0x04, 0x00, 0x04, 0x00, 0x30, 0x51, 0x04,
+		0x18, 0x80, 0x00, 0x4f, 0xb8, 0x05, 0x44, 0x45,
+		0x53, 0x4b, 0x54, 0x4f, 0x50, 0x2d, 0x4a, 0x37,
+		0x32, 0x53, 0x32, 0x45, 0x34, 0x3a, 0xb6, 0x3b,
+		0xc8, 0x04, 0x14, 0x66, 0x6f, 0x72, 0x65, 0x69,
+		0x67, 0x6e, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74,
+		0x73, 0x2f, 0x6c, 0x69, 0x6e, 0x75, 0x78, 0xa8,
+		0x1f, 0x02, 0x04, 0x44, 0xfa, 0x16, 0xe1, 0x02,
+		0x01, 0x00, 0x02, 0x01, 0x00, 0x30, 0x11, 0x30,
+		0x0f, 0x06, 0x0a, 0x2b, 0x06, 0x01, 0x06, 0x03,
+		0x0f, 0x01, 0x01, 0x04, 0x00, 0x41, 0x01, 0x15,
+	}
+}
+
+// dump bytes in a format similar to Wireshark
+func dumpBytes1(data []byte, msg string, maxlength int) {
+	var buffer bytes.Buffer
+	buffer.WriteString(msg)
+	length := maxlength
+	if len(data) < maxlength {
+		length = len(data)
+	}
+	length *= 2 //One Byte Symbols Two Hex
+	hexStr := hex.EncodeToString(data)
+	for i := 0; length >= i+16; i += 16 {
+		buffer.WriteString("\n")
+		buffer.WriteString(strconv.Itoa(i / 2))
+		buffer.WriteString("\t")
+		buffer.WriteString(hexStr[i : i+2])
+		buffer.WriteString(" ")
+		buffer.WriteString(hexStr[i+2 : i+4])
+		buffer.WriteString(" ")
+		buffer.WriteString(hexStr[i+4 : i+6])
+		buffer.WriteString(" ")
+		buffer.WriteString(hexStr[i+6 : i+8])
+		buffer.WriteString(" ")
+		buffer.WriteString(hexStr[i+8 : i+10])
+		buffer.WriteString(" ")
+		buffer.WriteString(hexStr[i+10 : i+12])
+		buffer.WriteString(" ")
+		buffer.WriteString(hexStr[i+12 : i+14])
+		buffer.WriteString(" ")
+		buffer.WriteString(hexStr[i+14 : i+16])
+	}
+	leftOver := length % 16
+	if leftOver != 0 {
+		buffer.WriteString("\n")
+		buffer.WriteString(strconv.Itoa((length - leftOver) / 2))
+		buffer.WriteString("\t")
+		for i := 0; leftOver >= i+2; i += 2 {
+			buffer.WriteString(hexStr[i : i+2])
+			buffer.WriteString(" ")
+		}
+	}
+	buffer.WriteString("\n")
+}
+
+// dump bytes in one row, up to about screen width. Returns a string
+// rather than (dumpBytes1) writing to debugging log.
+func dumpBytes2(desc string, bb []byte, cursor int) string {
+	cursor = cursor - 4 // give some context to dump
+	if cursor < 0 {
+		cursor = 0
+	}
+	result := desc
+	for i, b := range bb[cursor:] {
+		if i > 30 { // about screen width...
+			break
+		}
+		result += fmt.Sprintf(" %02x", b)
+	}
+	return result
+}
+
+func TestPool(t *testing.T) {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+	mib := []SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(100)}}
+	go mibResponder(t, &GoSNMP{Version: Version2c, Logger: Default.Logger}, srvr, mib)
+
+	var created atomic.Int32
+	pool := NewPool(func(target string) (*GoSNMP, error) {
+		created.Add(1)
+		return NewGoSNMP(target, WithPort(uint16(srvr.LocalAddr().(*net.UDPAddr).Port)),
+			WithTimeout(time.Second), WithRetries(0))
+	}, 2, 50*time.Millisecond)
+	defer pool.Close()
+	ctx := context.Background()
+
+	g, err := pool.Get(ctx, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	_, err = g.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	pool.Put(g, err)
+
+	// the idle session is reused
+	reused, err := pool.Get(ctx, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	assert.Same(t, g, reused)
+	second, err := pool.Get(ctx, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	assert.NotSame(t, g, second)
+	assert.Equal(t, int32(2), created.Load())
+
+	// both are checked out, so a third waits
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = pool.Get(short, "127.0.0.1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// a failed session is not reused
+	pool.Put(reused, errors.New("request failed"))
+	pool.Put(second, nil)
+	g, err = pool.Get(ctx, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	assert.Same(t, second, g)
+	pool.Put(g, nil)
+
+	// nor one idle for too long
+	time.Sleep(60 * time.Millisecond)
+	g, err = pool.Get(ctx, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	assert.NotSame(t, second, g)
+	assert.Equal(t, int32(3), created.Load())
+	pool.Put(g, nil)
+
+	assert.NoError(t, pool.Close())
+	_, err = pool.Get(ctx, "127.0.0.1")
+	assert.ErrorIs(t, err, ErrPoolClosed)
+}
+
+func TestPoolClosesIdleSessions(t *testing.T) {
+	pool := NewPool(func(target string) (*GoSNMP, error) {
+		return NewGoSNMP(target, WithTimeout(time.Second), WithRetries(0))
+	}, 0, 50*time.Millisecond)
+	defer pool.Close()
+	ctx := context.Background()
+
+	older, err := pool.Get(ctx, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	newer, err := pool.Get(ctx, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	pool.Put(older, nil)
+	time.Sleep(60 * time.Millisecond)
+
+	// the older session expires when the target's sessions are next used,
+	// though only the newer one is ever taken again
+	pool.Put(newer, nil)
+	assert.True(t, older.isClosed())
+	g, err := pool.Get(ctx, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	assert.Same(t, newer, g)
+	pool.Put(g, nil)
+
+	// and the sweep closes sessions of a target no longer used
+	assert.Eventually(t, newer.isClosed, time.Second, 10*time.Millisecond)
+}
+
+func TestPoolCloseWakesGet(t *testing.T) {
+	var created atomic.Int32
+	pool := NewPool(func(target string) (*GoSNMP, error) {
+		created.Add(1)
+		return NewGoSNMP(target, WithTimeout(time.Second), WithRetries(0))
+	}, 1, 0)
+	ctx := context.Background()
+
+	g, err := pool.Get(ctx, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	// a second Get waits for the only slot of the target
+	errs := make(chan error, 1)
+	go func() {
+		waiter, err := pool.Get(ctx, "127.0.0.1")
+		if err == nil {
+			pool.Put(waiter, nil)
+		}
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	assert.NoError(t, pool.Close())
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrPoolClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Get still waiting after Close")
+	}
+
+	// putting the session back frees the slot but dials nothing
+	pool.Put(g, nil)
+	assert.True(t, g.isClosed())
+	_, err = pool.Get(ctx, "127.0.0.1")
+	assert.ErrorIs(t, err, ErrPoolClosed)
+	assert.Equal(t, int32(1), created.Load())
+}
+
+func TestResponseLatency(t *testing.T) {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+	mib := []SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(100)}}
+
+	type report struct {
+		oid     string
+		latency time.Duration
+		err     error
+	}
+	var reports []report
+	x := &GoSNMP{
+		Version: Version2c,
+		Target:  "127.0.0.1",
+		Port:    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout: 100 * time.Millisecond,
+		MaxOids: MaxOids,
+		OnResponse: func(_ *GoSNMP, request *SnmpPacket, latency time.Duration, err error) {
+			reports = append(reports, report{request.Variables[0].Name, latency, err})
+		},
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+
+	// nobody answers: the request times out after its retry
+	x.Retries = 1
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	assert.Error(t, err)
+	if assert.Len(t, reports, 1) {
+		assert.Error(t, reports[0].err)
+		assert.GreaterOrEqual(t, reports[0].latency, 200*time.Millisecond)
+	}
+
+	go mibResponder(t, x, srvr, mib)
+	reports = nil
+	result, err := x.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	assert.Greater(t, result.Latency, time.Duration(0))
+	assert.Less(t, result.Latency, x.Timeout)
+	assert.Equal(t, []report{{".1.3.6.1.2.1.1.3.0", result.Latency, nil}}, reports)
+}
+
+type countingMetrics struct {
+	requests, retries, timeouts, authFailures atomic.Int32
+	latencies                                 []time.Duration
+}
+
+func (m *countingMetrics) IncRequest(PDUType) { m.requests.Add(1) }
+func (m *countingMetrics) IncRetry()          { m.retries.Add(1) }
+func (m *countingMetrics) IncTimeout()        { m.timeouts.Add(1) }
+func (m *countingMetrics) IncAuthFailure()    { m.authFailures.Add(1) }
+func (m *countingMetrics) ObserveLatency(latency time.Duration) {
+	m.latencies = append(m.latencies, latency)
+}
+
+func TestMetrics(t *testing.T) {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+	mib := []SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(100)}}
+
+	metrics := &countingMetrics{}
+	x := &GoSNMP{
+		Version: Version2c,
+		Target:  "127.0.0.1",
+		Port:    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout: 50 * time.Millisecond,
+		Retries: 1,
+		MaxOids: MaxOids,
+		Metrics: metrics,
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), metrics.requests.Load())
+	assert.Equal(t, int32(1), metrics.retries.Load())
+	assert.Equal(t, int32(2), metrics.timeouts.Load())
+	assert.Empty(t, metrics.latencies)
+
+	go mibResponder(t, x, srvr, mib)
+	result, err := x.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	assert.Equal(t, int32(3), metrics.requests.Load())
+	assert.Equal(t, int32(2), metrics.timeouts.Load())
+	assert.Equal(t, []time.Duration{result.Latency}, metrics.latencies)
+	assert.Zero(t, metrics.authFailures.Load())
+}
+
+func TestGetNextLockstep(t *testing.T) {
+	// ifDescr has three rows, ifType two
+	mib := []SnmpPDU{
+		{Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: OctetString, Value: []byte("lo")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.2", Type: OctetString, Value: []byte("eth0")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.3", Type: OctetString, Value: []byte("eth1")},
+		{Name: ".1.3.6.1.2.1.2.2.1.3.1", Type: Integer, Value: 24},
+		{Name: ".1.3.6.1.2.1.2.2.1.3.2", Type: Integer, Value: 6},
+	}
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version: Version2c,
+		Target:  "127.0.0.1",
+		Port:    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout: time.Second,
+		MaxOids: MaxOids,
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+	go mibResponder(t, x, srvr, mib)
+
+	// ifType is the last column and runs out first: its endOfMibView
+	// varbind carries the name it was asked for
+	steps := [][]string{
+		{".1.3.6.1.2.1.2.2.1.2.1", ".1.3.6.1.2.1.2.2.1.3.1"},
+		{".1.3.6.1.2.1.2.2.1.2.2", ".1.3.6.1.2.1.2.2.1.3.2"},
+		{".1.3.6.1.2.1.2.2.1.2.3", ".1.3.6.1.2.1.2.2.1.3.2"},
+	}
+	oids := []string{".1.3.6.1.2.1.2.2.1.2", ".1.3.6.1.2.1.2.2.1.3"}
+	for i, want := range steps {
+		result, err := x.GetNext(oids)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		var names []string
+		for _, pdu := range result.Variables {
+			names = append(names, pdu.Name)
+		}
+		assert.Equal(t, want, names, "step %d", i)
+		oids = names
+	}
+	result, err := x.GetNext(oids)
+	if err != nil {
+		t.Fatalf("GetNext: %v", err)
+	}
+	assert.Equal(t, ".1.3.6.1.2.1.2.2.1.3.1", result.Variables[0].Name, "ifDescr.3 steps into ifType")
+	assert.Equal(t, EndOfMibView, result.Variables[1].Type)
+}
+
+func TestSetBuilder(t *testing.T) {
+	vars := SetVars().
+		AddInt(".1.3.6.1.2.1.2.2.1.7.3", 2).
+		AddString(".1.3.6.1.2.1.1.5.0", "core-sw1").
+		AddBytes(".1.3.6.1.2.1.2.2.1.6.3", []byte{0, 1, 2, 3, 4, 5}).
+		AddOID(".1.3.6.1.2.1.1.2.0", ".1.3.6.1.4.1.8072").
+		AddIPAddress(".1.3.6.1.2.1.4.20.1.1.1", "192.0.2.1").
+		AddCounter32(".1.3.6.1.4.1.1.1", 1).
+		AddGauge32(".1.3.6.1.4.1.1.2", 50).
+		AddTimeTicks(".1.3.6.1.4.1.1.3", 100).
+		AddCounter64(".1.3.6.1.4.1.1.4", 1<<40).
+		AddFloat(".1.3.6.1.4.1.1.5", 1.5).
+		AddDouble(".1.3.6.1.4.1.1.6", 2.5)
+	want := []Asn1BER{Integer, OctetString, OctetString, ObjectIdentifier, IPAddress,
+		Counter32, Gauge32, TimeTicks, Counter64, OpaqueFloat, OpaqueDouble}
+
+	x := &GoSNMP{Version: Version2c, Community: "private", MaxOids: MaxOids}
+	msg, err := x.mkSnmpPacket(SetRequest, vars.PDUs(), 0, 0).marshalMsg()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	decoded, err := x.SnmpDecodePacket(msg)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	assert.Equal(t, SetRequest, decoded.PDUType)
+	if assert.Len(t, decoded.Variables, len(want)) {
+		for i, pdu := range decoded.Variables {
+			assert.Equal(t, want[i], pdu.Type, pdu.Name)
+		}
+		assert.Equal(t, 2, decoded.Variables[0].Value)
+		assert.Equal(t, []byte("core-sw1"), decoded.Variables[1].Value)
+		assert.Equal(t, "192.0.2.1", decoded.Variables[4].Value)
+		assert.Equal(t, uint(50), decoded.Variables[6].Value)
+		assert.Equal(t, uint64(1<<40), decoded.Variables[8].Value)
+	}
+
+	_, err = x.SetMany(SetVars())
+	assert.Error(t, err)
+	x.MaxOids = 2
+	_, err = x.SetMany(vars)
+	assert.Error(t, err)
+}
+
+func TestSetOpaque(t *testing.T) {
+	// an Opaque wrapping an Integer32 42, as a vendor scalar might
+	raw := []byte{byte(Integer), 1, 42}
+	pdus := SetVars().
+		AddOpaque(".1.3.6.1.4.1.1.1", raw).
+		AddFloat(".1.3.6.1.4.1.1.2", 1.5).
+		AddDouble(".1.3.6.1.4.1.1.3", -2.25).
+		PDUs()
+
+	x := &GoSNMP{Version: Version2c, Community: "private", MaxOids: MaxOids}
+	msg, err := x.mkSnmpPacket(SetRequest, pdus, 0, 0).marshalMsg()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	// the float is an Opaque (0x44) wrapping the net-snmp extension tag
+	// (0x9f), OpaqueFloat (0x78) and the 4 octets of the IEEE 754 value
+	assert.True(t, bytes.Contains(msg, []byte{0x44, 0x07, 0x9f, 0x78, 0x04, 0x3f, 0xc0, 0x00, 0x00}), "%x", msg)
+
+	decoded, err := x.SnmpDecodePacket(msg)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if assert.Len(t, decoded.Variables, 3) {
+		assert.Equal(t, Opaque, decoded.Variables[0].Type)
+		assert.Equal(t, raw, decoded.Variables[0].Value)
+		assert.Equal(t, OpaqueFloat, decoded.Variables[1].Type)
+		assert.Equal(t, float32(1.5), decoded.Variables[1].Value)
+		assert.Equal(t, OpaqueDouble, decoded.Variables[2].Type)
+		assert.Equal(t, -2.25, decoded.Variables[2].Value)
+	}
+
+	// every variable is checked, not only the first
+	_, err = x.Set([]SnmpPDU{pdus[0], {Name: ".1.3.6.1.4.1.1.4", Type: NoSuchObject}})
+	assert.Error(t, err)
+	_, err = x.Set(nil)
+	assert.Error(t, err)
+}
+
+func TestLongOIDRoundTrip(t *testing.T) {
+	// 200 subidentifiers, some of which need several octets
+	var sb strings.Builder
+	sb.WriteString(".1.3.6.1.4.1.9")
+	for i := 7; i < 200; i++ {
+		fmt.Fprintf(&sb, ".%d", i*1000)
+	}
+	oid := sb.String()
+	assert.Equal(t, 200, strings.Count(oid, "."))
+
+	x := &GoSNMP{Version: Version2c, Community: "public", MaxOids: MaxOids, Logger: Default.Logger}
+	pdus := []SnmpPDU{
+		{Name: oid, Type: Null},
+		{Name: oid, Type: Integer, Value: 1},
+		{Name: oid, Type: Counter32, Value: uint32(2)},
+		{Name: oid, Type: IPAddress, Value: "192.0.2.1"},
+		{Name: oid, Type: OctetString, Value: []byte("long")},
+		{Name: oid, Type: ObjectIdentifier, Value: oid},
+		{Name: oid, Type: Counter64, Value: uint64(3)},
+		{Name: oid, Type: OpaqueFloat, Value: float32(4)},
+		{Name: oid, Type: EndOfMibView},
+	}
+	msg, err := x.mkSnmpPacket(GetResponse, pdus, 0, 0).marshalMsg()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	decoded, err := x.SnmpDecodePacket(msg)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if assert.Len(t, decoded.Variables, len(pdus)) {
+		for i, pdu := range decoded.Variables {
+			assert.Equal(t, oid, pdu.Name, "variable %d", i)
+			assert.Equal(t, pdus[i].Type, pdu.Type, "variable %d", i)
+		}
+		assert.Equal(t, oid, decoded.Variables[5].Value)
+	}
+}
+
+func TestCloseInFlight(t *testing.T) {
+	// an agent that never answers
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version: Version2c,
+		Target:  "127.0.0.1",
+		Port:    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout: 10 * time.Second,
+		Retries: 3,
+		MaxOids: MaxOids,
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+
+	// one request reads the socket, the other waits for it to
+	errs := make(chan error, 2)
+	go func() {
+		errs <- x.BulkWalk(".1.3.6.1.2.1.2", func(SnmpPDU) error { return nil })
+	}()
+	go func() {
+		_, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+		errs <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	if err := x.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			assert.ErrorIs(t, err, ErrClosed)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("request still blocked after Close")
+		}
+	}
+	assert.Less(t, time.Since(start), time.Second)
+
+	assert.NoError(t, x.Close(), "second Close")
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestCloseDuringReconnect(t *testing.T) {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version: Version2c,
+		Target:  "127.0.0.1",
+		Port:    uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout: time.Second,
+		MaxOids: MaxOids,
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	packet := x.mkSnmpPacket(GetRequest, nil, 0, 0)
+
+	// Close and a reconnect racing each other leave no connection open
+	done := make(chan error, 1)
+	go func() {
+		done <- x.reconnect(context.Background(), packet)
+	}()
+	assert.NoError(t, x.Close())
+	if err := <-done; err != nil {
+		assert.ErrorIs(t, err, ErrClosed)
+	}
+	assert.Error(t, x.Conn.SetReadDeadline(time.Time{}), "the connection is still open")
+
+	// and once closed nothing is dialed
+	conn := x.Conn
+	assert.ErrorIs(t, x.reconnect(context.Background(), packet), ErrClosed)
+	assert.Same(t, conn, x.Conn)
+}
+
+func TestConnectConn(t *testing.T) {
+	client, agent := net.Pipe()
+	defer agent.Close()
+
+	x := &GoSNMP{
+		Version:   Version2c,
+		Community: "public",
+		Timeout:   time.Second,
+		MaxOids:   MaxOids,
+	}
+	if err := x.ConnectConn(client); err != nil {
+		t.Fatalf("ConnectConn: %v", err)
+	}
+	defer x.Close()
+
+	go func() {
+		buf := make([]byte, rxBufSize)
+		n, err := agent.Read(buf)
+		if err != nil {
+			t.Errorf("agent read: %v", err)
+			return
+		}
+		request, err := x.SnmpDecodePacket(buf[:n])
+		if err != nil {
+			t.Errorf("decoding request: %v", err)
+			return
+		}
+		assert.Equal(t, GetRequest, request.PDUType)
+		assert.Equal(t, "public", request.Community)
+		assert.Equal(t, ".1.3.6.1.2.1.1.5.0", request.Variables[0].Name)
+
+		request.PDUType = GetResponse
+		request.Variables[0].Type = OctetString
+		request.Variables[0].Value = "core-sw1"
+		b, err := request.marshalMsg()
+		if err != nil {
+			t.Errorf("marshalling response: %v", err)
+			return
+		}
+		if _, err = agent.Write(b); err != nil {
+			t.Errorf("agent write: %v", err)
+		}
+	}()
+
+	result, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	assert.Equal(t, []byte("core-sw1"), result.Variables[0].Value)
+
+	if err := (&GoSNMP{}).ConnectConn(nil); err == nil {
+		t.Error("ConnectConn(nil) succeeded")
+	}
+}
+
+func TestMockAgent(t *testing.T) {
+	agent, err := NewMockAgent(map[string]SnmpPDU{
+		".1.3.6.1.2.1.1.5.0":      {Type: OctetString, Value: "core-sw1"},
+		".1.3.6.1.2.1.1.3.0":      {Type: TimeTicks, Value: uint32(4200)},
+		".1.3.6.1.2.1.2.2.1.2.1":  {Type: OctetString, Value: "lo"},
+		".1.3.6.1.2.1.2.2.1.2.2":  {Type: OctetString, Value: "eth0"},
+		".1.3.6.1.2.1.2.2.1.2.10": {Type: OctetString, Value: "eth9"},
+	})
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+	agent.SetCommunity("secret")
+
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()), WithCommunity("secret"),
+		WithTimeout(100*time.Millisecond), WithRetries(0))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+
+	result, err := x.Get([]string{".1.3.6.1.2.1.1.5.0", ".1.3.6.1.2.1.1.4.0"})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	assert.Equal(t, []byte("core-sw1"), result.Variables[0].Value)
+	assert.Equal(t, NoSuchObject, result.Variables[1].Type)
+
+	// numeric, not string, order: .2.1, .2.2, .2.10
+	for _, walk := range []func(string) ([]SnmpPDU, error){x.WalkAll, x.BulkWalkAll} {
+		pdus, err := walk(".1.3.6.1.2.1.2.2.1.2")
+		if err != nil {
+			t.Fatalf("walk: %v", err)
+		}
+		var names []string
+		for _, pdu := range pdus {
+			names = append(names, pdu.Name)
+		}
+		assert.Equal(t, []string{".1.3.6.1.2.1.2.2.1.2.1", ".1.3.6.1.2.1.2.2.1.2.2", ".1.3.6.1.2.1.2.2.1.2.10"}, names)
+	}
+
+	result, err = x.Set([]SnmpPDU{{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: "x"}})
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	assert.Equal(t, NotWritable, result.Error)
+
+	agent.SetFault(MockTooBig)
+	result, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	assert.Equal(t, TooBig, result.Error)
+
+	agent.SetFault(MockAuthError)
+	result, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	assert.Equal(t, AuthorizationError, result.Error)
+
+	agent.SetFault(MockV1Answer)
+	result, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	assert.Equal(t, Version1, result.Version)
+	assert.Equal(t, []byte("core-sw1"), result.Variables[0].Value)
+
+	agent.SetFault(MockTimeout)
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	assert.ErrorContains(t, err, "timeout")
+
+	// a wrong community goes unanswered
+	agent.SetFault(MockNoFault)
+	x.Community = "public"
+	before := agent.Requests()
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	assert.Error(t, err)
+	assert.Equal(t, before+1, agent.Requests())
+}
+
+func TestForward(t *testing.T) {
+	agent, err := NewMockAgent(map[string]SnmpPDU{
+		".1.3.6.1.2.1.1.5.0":         {Type: OctetString, Value: "core-sw1"},
+		".1.3.6.1.2.1.1.3.0":         {Type: TimeTicks, Value: uint32(4200)},
+		".1.3.6.1.2.1.31.1.1.1.6.1":  {Type: Counter64, Value: uint64(1) << 40},
+		".1.3.6.1.2.1.31.1.1.1.15.1": {Type: Gauge32, Value: uint(10000)},
+	})
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+
+	upstream, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()),
+		WithTimeout(100*time.Millisecond), WithRetries(0))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = upstream.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer upstream.Close()
+
+	proxy, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer proxy.Close()
+	go func() {
+		front := &GoSNMP{}
+		buf := make([]byte, rxBufSize)
+		for {
+			n, addr, err := proxy.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := front.SnmpDecodePacket(buf[:n])
+			if err != nil {
+				t.Errorf("SnmpDecodePacket: %v", err)
+				return
+			}
+			resp, err := Forward(req, upstream)
+			if err != nil {
+				continue
+			}
+			if msg, err := resp.MarshalMsg(); err == nil {
+				_, _ = proxy.WriteTo(msg, addr)
+			}
+		}
+	}()
+
+	client := func(version SnmpVersion) *GoSNMP {
+		x, err := NewGoSNMP("127.0.0.1", WithPort(uint16(proxy.LocalAddr().(*net.UDPAddr).Port)),
+			WithVersion(version), WithTimeout(time.Second), WithRetries(0))
+		if err != nil {
+			t.Fatalf("NewGoSNMP: %v", err)
+		}
+		if err = x.Connect(); err != nil {
+			t.Fatalf("error connecting: %s", err)
+		}
+		return x
+	}
+
+	v2c := client(Version2c)
+	defer v2c.Close()
+	result, err := v2c.Get([]string{".1.3.6.1.2.1.1.5.0", ".1.3.6.1.2.1.1.4.0"})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	assert.Equal(t, []byte("core-sw1"), result.Variables[0].Value)
+	assert.Equal(t, NoSuchObject, result.Variables[1].Type)
+	pdus, err := v2c.BulkWalkAll(".1.3.6.1.2.1")
+	assert.NoError(t, err)
+	assert.Len(t, pdus, 4)
+	result, err = v2c.Set([]SnmpPDU{{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: "x"}})
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	assert.Equal(t, NotWritable, result.Error)
+
+	// SNMPv1 has no noSuchObject, Counter64 or notWritable
+	v1 := client(Version1)
+	defer v1.Close()
+	result, err = v1.Get([]string{".1.3.6.1.2.1.1.5.0", ".1.3.6.1.2.1.1.4.0"})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	assert.Equal(t, NoSuchName, result.Error)
+	assert.Equal(t, uint8(2), result.ErrorIndex)
+	result, err = v1.Get([]string{".1.3.6.1.2.1.31.1.1.1.6.1"})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	assert.Equal(t, NoSuchName, result.Error)
+	// a walk steps over Counter64 objects
+	pdus, err = v1.WalkAll(".1.3.6.1.2.1")
+	assert.NoError(t, err)
+	if assert.Len(t, pdus, 3) {
+		assert.Equal(t, ".1.3.6.1.2.1.31.1.1.1.15.1", pdus[2].Name)
+	}
+	result, err = v1.Set([]SnmpPDU{{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: "x"}})
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	assert.Equal(t, NoSuchName, result.Error)
+	assert.Equal(t, uint8(1), result.ErrorIndex)
+
+	// more variables than upstream sends at once
+	var oids []string
+	for i := 0; i <= upstream.MaxOids; i++ {
+		oids = append(oids, ".1.3.6.1.2.1.1.5.0")
+	}
+	for _, x := range []*GoSNMP{v2c, v1} {
+		x.MaxOids = len(oids)
+		result, err = x.GetNext(oids)
+		if err != nil {
+			t.Fatalf("GetNext: %v", err)
+		}
+		assert.Equal(t, TooBig, result.Error, x.Version)
+	}
+
+	// upstream timing out leaves the request unanswered
+	agent.SetFault(MockTimeout)
+	v2c.Timeout = 300 * time.Millisecond
+	_, err = v2c.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	assert.ErrorContains(t, err, "request timeout")
+}
+
+func TestParseSnmpwalk(t *testing.T) {
+	walk := `.1.3.6.1.2.1.1.1.0 = STRING: "Cisco IOS Software, C2960 Software
+Technical Support: http://www.cisco.com/techsupport
+Copyright (c) 1986-2012 by \"Cisco Systems\""
+.1.3.6.1.2.1.1.2.0 = OID: .1.3.6.1.4.1.9.1.1208
+.1.3.6.1.2.1.1.3.0 = Timeticks: (4200) 0:00:42.00
+.1.3.6.1.2.1.1.4.0 = ""
+.1.3.6.1.2.1.2.2.1.3.1 = INTEGER: ethernetCsmacd(6)
+.1.3.6.1.2.1.2.2.1.5.1 = Gauge32: 1000000000
+.1.3.6.1.2.1.2.2.1.6.1 = Hex-STRING: 00 1A 2B 3C 4D 5E 
+.1.3.6.1.2.1.2.2.1.10.1 = Counter32: 4294967295
+.1.3.6.1.2.1.4.20.1.1.10.0.0.1 = IpAddress: 10.0.0.1
+.1.3.6.1.2.1.31.1.1.1.6.1 = Counter64: 18446744073709551615
+.1.3.6.1.2.1.47.1.1.1.1.7.1 = Hex-STRING: 31 30 30 30 42 41 53 45 2D 54 20 53 46 50 20 20 
+54 52 41 4E 53 43 45 49 56 45 52 
+.1.3.6.1.4.1.2021.10.1.5.1 = INTEGER: -5
+.1.3.6.1.6.3.10.2.1.3.0 = No more variables left in this MIB View (It is past the end of the MIB tree)
+`
+	mib, err := ParseSnmpwalk(strings.NewReader(walk))
+	if err != nil {
+		t.Fatalf("ParseSnmpwalk: %v", err)
+	}
+	want := map[string]SnmpPDU{
+		".1.3.6.1.2.1.1.1.0": {Type: OctetString, Value: []byte("Cisco IOS Software, C2960 Software\n" +
+			"Technical Support: http://www.cisco.com/techsupport\nCopyright (c) 1986-2012 by \"Cisco Systems\"")},
+		".1.3.6.1.2.1.1.2.0":             {Type: ObjectIdentifier, Value: ".1.3.6.1.4.1.9.1.1208"},
+		".1.3.6.1.2.1.1.3.0":             {Type: TimeTicks, Value: uint32(4200)},
+		".1.3.6.1.2.1.1.4.0":             {Type: OctetString, Value: []byte{}},
+		".1.3.6.1.2.1.2.2.1.3.1":         {Type: Integer, Value: 6},
+		".1.3.6.1.2.1.2.2.1.5.1":         {Type: Gauge32, Value: uint32(1000000000)},
+		".1.3.6.1.2.1.2.2.1.6.1":         {Type: OctetString, Value: []byte{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}},
+		".1.3.6.1.2.1.2.2.1.10.1":        {Type: Counter32, Value: uint32(4294967295)},
+		".1.3.6.1.2.1.4.20.1.1.10.0.0.1": {Type: IPAddress, Value: "10.0.0.1"},
+		".1.3.6.1.2.1.31.1.1.1.6.1":      {Type: Counter64, Value: uint64(18446744073709551615)},
+		".1.3.6.1.2.1.47.1.1.1.1.7.1":    {Type: OctetString, Value: []byte("1000BASE-T SFP  TRANSCEIVER")},
+		".1.3.6.1.4.1.2021.10.1.5.1":     {Type: Integer, Value: -5},
+	}
+	for name, pdu := range want {
+		pdu.Name = name
+		want[name] = pdu
+	}
+	assert.Equal(t, want, mib)
+
+	for _, bad := range []string{
+		".1.3.6.1.2.1.1.3.0 = Opaque: Float: 1.5\n",
+		".1.3.6.1.2.1.1.3.0 = Counter32: many\n",
+		"garbage\n",
+	} {
+		_, err := ParseSnmpwalk(strings.NewReader(bad))
+		assert.Error(t, err, bad)
+	}
+
+	// a replayed walk walks the same
+	agent, err := NewMockAgent(mib)
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+	pdus, err := x.BulkWalkAll(".1.3.6.1.2.1.2.2.1")
+	if err != nil {
+		t.Fatalf("BulkWalkAll: %v", err)
+	}
+	assert.Len(t, pdus, 4)
+	assert.Equal(t, []byte{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}, pdus[2].Value)
+	assert.Equal(t, uint(4294967295), pdus[3].Value)
+}
+
+func TestSystemScalars(t *testing.T) {
+	agent, err := NewMockAgent(map[string]SnmpPDU{
+		".1.3.6.1.2.1.1.1.0": {Type: OctetString, Value: "Linux router 5.10"},
+		".1.3.6.1.2.1.1.2.0": {Type: ObjectIdentifier, Value: ".1.3.6.1.4.1.8072.3.2.10"},
+		".1.3.6.1.2.1.1.3.0": {Type: TimeTicks, Value: uint32(360012)},
+	})
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+
+	descr, err := x.SysDescr()
+	assert.NoError(t, err)
+	assert.Equal(t, "Linux router 5.10", descr)
+	objectID, err := x.SysObjectID()
+	assert.NoError(t, err)
+	assert.Equal(t, ".1.3.6.1.4.1.8072.3.2.10", objectID)
+	upTime, err := x.SysUpTime()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour+120*time.Millisecond, upTime)
+
+	// sysName is not in the MIB
+	_, err = x.SysName()
+	assert.ErrorContains(t, err, "NoSuchObject")
+}
+
+func TestIPAddressVarbind(t *testing.T) {
+	x := &GoSNMP{Version: Version2c}
+	decode := func(pdu SnmpPDU) (SnmpPDU, error) {
+		varbind, err := marshalVarbind(&pdu)
+		if err != nil {
+			return SnmpPDU{}, err
+		}
+		// skip the varbind sequence and name
+		_, cursor, err := parseLength(varbind)
+		if err != nil {
+			return SnmpPDU{}, err
+		}
+		nameLength, _, err := parseLength(varbind[cursor:])
+		if err != nil {
+			return SnmpPDU{}, err
+		}
+		var v variable
+		err = x.decodeValue(varbind[cursor+nameLength:], &v)
+		return SnmpPDU{Name: pdu.Name, Type: v.Type, Value: v.Value}, err
+	}
+
+	for _, value := range []interface{}{net.IPv4(192, 0, 2, 1), net.IP{192, 0, 2, 1}, "192.0.2.1", []byte{192, 0, 2, 1}} {
+		pdu, err := decode(SnmpPDU{Name: ".1.3.6.1.2.1.4.20.1.1", Type: IPAddress, Value: value})
+		if err != nil {
+			t.Fatalf("%v: %v", value, err)
+		}
+		ip, err := pdu.IP()
+		assert.NoError(t, err)
+		assert.Equal(t, "192.0.2.1", ip.String(), "%T", value)
+	}
+
+	pdu, err := decode(SnmpPDU{Name: ".1.3.6.1.2.1.4.34.1.3", Type: IPAddress, Value: net.ParseIP("2001:db8::1")})
+	if err != nil {
+		t.Fatalf("IPv6: %v", err)
+	}
+	assert.Equal(t, "2001:db8::1", pdu.Value)
+
+	for _, value := range []interface{}{"not an address", net.IP{192, 0, 2}, []byte{192, 0, 2}, []byte{}, 42} {
+		_, err := marshalVarbind(&SnmpPDU{Name: ".1.3.6.1.2.1.4.20.1.1", Type: IPAddress, Value: value})
+		assert.Error(t, err, "%v", value)
+	}
+
+	// short addresses from buggy agents are errors, not panics
+	for _, data := range [][]byte{{byte(IPAddress), 3, 192, 0, 2}, {byte(IPAddress), 4, 192, 0}, {byte(IPAddress)}} {
+		var v variable
+		assert.Error(t, x.decodeValue(data, &v), "%x", data)
+	}
+}
+
+func TestWalkRange(t *testing.T) {
+	mib := map[string]SnmpPDU{}
+	for i := 1; i <= 20; i++ {
+		mib[fmt.Sprintf(".1.3.6.1.2.1.2.2.1.2.%d", i)] = SnmpPDU{Type: OctetString, Value: fmt.Sprintf("eth%d", i)}
+	}
+	mib[".1.3.6.1.2.1.2.2.1.3.1"] = SnmpPDU{Type: Integer, Value: 6}
+	agent, err := NewMockAgent(mib)
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+
+	walkRange := func(start, stop string) []string {
+		var names []string
+		err := x.WalkRange(start, stop, func(pdu SnmpPDU) error {
+			names = append(names, pdu.Name)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("WalkRange(%s, %s): %v", start, stop, err)
+		}
+		return names
+	}
+
+	// rows 9 to 11, compared numerically so .10 and .11 are inside
+	before := agent.Requests()
+	assert.Equal(t, []string{".1.3.6.1.2.1.2.2.1.2.9", ".1.3.6.1.2.1.2.2.1.2.10", ".1.3.6.1.2.1.2.2.1.2.11"},
+		walkRange(".1.3.6.1.2.1.2.2.1.2.8", ".1.3.6.1.2.1.2.2.1.2.12"))
+	assert.Equal(t, 4, agent.Requests()-before, "stops at the first OID past the window")
+
+	// into the next column, and past the end of the MIB
+	assert.Equal(t, []string{".1.3.6.1.2.1.2.2.1.2.20", ".1.3.6.1.2.1.2.2.1.3.1"},
+		walkRange("1.3.6.1.2.1.2.2.1.2.19", "1.3.6.1.2.1.2.2.1.4"))
+	assert.Empty(t, walkRange(".1.3.6.1.2.1.2.2.1.3.1", ".1.3.6.1.2.1.3"))
+
+	assert.Error(t, x.WalkRange(".1.3.6.1.2.1.2.2.1.2.5", ".1.3.6.1.2.1.2.2.1.2.5", func(SnmpPDU) error { return nil }))
+}
+
+func TestBulkWalkAllParallel(t *testing.T) {
+	mib := map[string]SnmpPDU{}
+	roots := []string{".1.3.6.1.2.1.2.2.1.2", ".1.3.6.1.2.1.2.2.1.3", ".1.3.6.1.2.1.2.2.1.5", ".1.3.6.1.2.1.2.2.1.7", ".1.3.6.1.2.1.2.2.1.8"}
+	for _, root := range roots {
+		for i := 1; i <= 3; i++ {
+			mib[fmt.Sprintf("%s.%d", root, i)] = SnmpPDU{Type: Integer, Value: i}
+		}
+	}
+	agent, err := NewMockAgent(mib)
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+	agent.SetDelay(20 * time.Millisecond)
+
+	var inFlight, maxInFlight int32
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()), WithTimeout(time.Second), WithMaxRepetitions(2))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	x.OnSent = func(*GoSNMP) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			peak := atomic.LoadInt32(&maxInFlight)
+			if n <= peak || atomic.CompareAndSwapInt32(&maxInFlight, peak, n) {
+				break
+			}
+		}
+	}
+	x.OnResponse = func(*GoSNMP, *SnmpPacket, time.Duration, error) { atomic.AddInt32(&inFlight, -1) }
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+
+	results, err := x.BulkWalkAllParallel(roots, 2)
+	if err != nil {
+		t.Fatalf("BulkWalkAllParallel: %v", err)
+	}
+	assert.Len(t, results, len(roots))
+	for _, root := range roots {
+		if assert.Len(t, results[root], 3, root) {
+			assert.Equal(t, root+".3", results[root][2].Name)
+		}
+	}
+	assert.Equal(t, int32(2), maxInFlight)
+
+	x.Close()
+	results, err = x.BulkWalkAllParallel(roots, 0)
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorContains(t, err, "walk of "+roots[0])
+	assert.Empty(t, results)
+}
+
+func TestBulkWalkFallback(t *testing.T) {
+	mib := map[string]SnmpPDU{}
+	for i := 1; i <= 10; i++ {
+		mib[fmt.Sprintf(".1.3.6.1.2.1.2.2.1.2.%d", i)] = SnmpPDU{Type: OctetString, Value: strings.Repeat("x", 100)}
+	}
+	agent, err := NewMockAgent(mib)
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+
+	for _, version := range []SnmpVersion{Version1, Version2c} {
+		x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()), WithVersion(version), WithTimeout(time.Second))
+		if err != nil {
+			t.Fatalf("NewGoSNMP: %v", err)
+		}
+		if err = x.Connect(); err != nil {
+			t.Fatalf("error connecting: %s", err)
+		}
+		pdus, err := x.BulkWalkAll(".1.3.6.1.2.1.2.2.1.2")
+		assert.NoError(t, err, version.String())
+		assert.Len(t, pdus, 10, version.String())
+		x.Close()
+	}
+
+	// about four rows fit in a response: max-repetitions is halved from
+	// 50 until they do
+	agent.SetMaxSize(600)
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()), WithTimeout(time.Second), WithMaxRepetitions(50))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+	before := agent.Requests()
+	pdus, err := x.BulkWalkAll(".1.3.6.1.2.1.2.2.1.2")
+	assert.NoError(t, err)
+	assert.Len(t, pdus, 10)
+	// tooBig at 50, 25, 12 and 6, then four requests of three rows
+	assert.Equal(t, 8, agent.Requests()-before)
+
+	// even one row is too big: the walk ends as before
+	agent.SetMaxSize(100)
+	pdus, err = x.BulkWalkAll(".1.3.6.1.2.1.2.2.1.2")
+	assert.NoError(t, err)
+	assert.Empty(t, pdus)
+}
+
+func TestAdaptiveBulkSize(t *testing.T) {
+	mib := map[string]SnmpPDU{}
+	for i := 1; i <= 100; i++ {
+		mib[fmt.Sprintf(".1.3.6.1.2.1.2.2.1.2.%d", i)] = SnmpPDU{Type: OctetString, Value: strings.Repeat("x", 100)}
+	}
+	agent, err := NewMockAgent(mib)
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()), WithTimeout(time.Second), WithMaxRepetitions(50))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+	assert.Equal(t, uint32(0), x.BulkRepetitions())
+
+	// rows encode in 116 octets: four fit in 600 after the message header
+	x.AdaptiveBulkSize = 600
+	pdus, err := x.BulkWalkAll(".1.3.6.1.2.1.2.2.1.2")
+	assert.NoError(t, err)
+	assert.Len(t, pdus, 100)
+	assert.Equal(t, uint32(4), x.BulkRepetitions())
+
+	// and eleven in 1400, the next walk starting over from MaxRepetitions
+	// rather than from where the last one ended
+	var sent []uint32
+	x.OnResponse = func(_ *GoSNMP, request *SnmpPacket, _ time.Duration, _ error) {
+		sent = append(sent, request.MaxRepetitions)
+	}
+	x.AdaptiveBulkSize = 1400
+	pdus, err = x.BulkWalkAll(".1.3.6.1.2.1.2.2.1.2")
+	assert.NoError(t, err)
+	assert.Len(t, pdus, 100)
+	assert.Equal(t, uint32(11), x.BulkRepetitions())
+	assert.Equal(t, []uint32{50, 11, 11, 11, 11, 11}, sent)
+	x.OnResponse = nil
+
+	// an agent that cannot send that much: tooBig caps the walk below
+	// what failed
+	agent.SetMaxSize(800)
+	pdus, err = x.BulkWalkAll(".1.3.6.1.2.1.2.2.1.2")
+	assert.NoError(t, err)
+	assert.Len(t, pdus, 100)
+	assert.Equal(t, uint32(6), x.BulkRepetitions())
+
+	// without AdaptiveBulkSize walks use MaxRepetitions, halved on tooBig
+	x.AdaptiveBulkSize = 0
+	agent.SetMaxSize(0)
+	_, err = x.BulkWalkAll(".1.3.6.1.2.1.2.2.1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(50), x.BulkRepetitions())
+}
+
+func TestWalkChan(t *testing.T) {
+	mib := map[string]SnmpPDU{}
+	for i := 1; i <= 10; i++ {
+		mib[fmt.Sprintf(".1.3.6.1.2.1.2.2.1.2.%d", i)] = SnmpPDU{Type: OctetString, Value: fmt.Sprintf("eth%d", i)}
+	}
+	agent, err := NewMockAgent(mib)
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()), WithTimeout(time.Second), WithMaxRepetitions(3))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+
+	for _, walk := range []func(string) (<-chan SnmpPDU, func() error){x.WalkChan, x.BulkWalkChan} {
+		pdus, wait := walk(".1.3.6.1.2.1.2.2.1.2")
+		n := 0
+		for pdu := range pdus {
+			n++
+			assert.Equal(t, fmt.Sprintf(".1.3.6.1.2.1.2.2.1.2.%d", n), pdu.Name)
+		}
+		assert.Equal(t, 10, n)
+		assert.NoError(t, wait())
+	}
+
+	// the walk waits for the reader: after one value only the first
+	// response has been asked for
+	before := agent.Requests()
+	ctx, cancel := context.WithCancel(context.Background())
+	pdus, wait := x.BulkWalkChanContext(ctx, ".1.3.6.1.2.1.2.2.1.2")
+	<-pdus
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, agent.Requests()-before)
+	cancel()
+	for range pdus {
+	}
+	assert.ErrorIs(t, wait(), context.Canceled)
+
+	// errors close the channel too
+	x.Close()
+	pdus, wait = x.WalkChan(".1.3.6.1.2.1.2.2.1.2")
+	for range pdus {
+		t.Error("value from closed session")
 	}
+	assert.ErrorIs(t, wait(), ErrClosed)
 }
 
-// dump bytes in a format similar to Wireshark
-func dumpBytes1(data []byte, msg string, maxlength int) {
-	var buffer bytes.Buffer
-	buffer.WriteString(msg)
-	length := maxlength
-	if len(data) < maxlength {
-		length = len(data)
+func BenchmarkMarshalMsg(b *testing.B) {
+	pdus := []SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: "Linux core-sw1 5.15.0"},
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(123456)},
+		{Name: ".1.3.6.1.2.1.2.2.1.7.3", Type: Integer, Value: 1},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.3", Type: Counter64, Value: uint64(1234567890123)},
+		{Name: ".1.3.6.1.2.1.4.20.1.1.192.0.2.1", Type: IPAddress, Value: "192.0.2.1"},
+		{Name: ".1.3.6.1.2.1.1.2.0", Type: ObjectIdentifier, Value: ".1.3.6.1.4.1.8072.3.2.10"},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.3", Type: Null},
 	}
-	length *= 2 //One Byte Symbols Two Hex
-	hexStr := hex.EncodeToString(data)
-	for i := 0; length >= i+16; i += 16 {
-		buffer.WriteString("\n")
-		buffer.WriteString(strconv.Itoa(i / 2))
-		buffer.WriteString("\t")
-		buffer.WriteString(hexStr[i : i+2])
-		buffer.WriteString(" ")
-		buffer.WriteString(hexStr[i+2 : i+4])
-		buffer.WriteString(" ")
-		buffer.WriteString(hexStr[i+4 : i+6])
-		buffer.WriteString(" ")
-		buffer.WriteString(hexStr[i+6 : i+8])
-		buffer.WriteString(" ")
-		buffer.WriteString(hexStr[i+8 : i+10])
-		buffer.WriteString(" ")
-		buffer.WriteString(hexStr[i+10 : i+12])
-		buffer.WriteString(" ")
-		buffer.WriteString(hexStr[i+12 : i+14])
-		buffer.WriteString(" ")
-		buffer.WriteString(hexStr[i+14 : i+16])
+	logger := NewLogger(log.New(io.Discard, "", 0))
+	v2c := &SnmpPacket{Version: Version2c, Community: "public", PDUType: GetResponse,
+		RequestID: 1234, Variables: pdus, Logger: logger}
+	sp := &UsmSecurityParameters{
+		UserName:                 "user",
+		AuthoritativeEngineID:    "\x80\x00\x1f\x88\x04bench",
+		AuthoritativeEngineBoots: 1,
+		AuthoritativeEngineTime:  1000,
+		AuthenticationProtocol:   SHA,
+		AuthenticationPassphrase: "authpassphrase",
+		PrivacyProtocol:          AES,
+		PrivacyPassphrase:        "privpassphrase",
+		Logger:                   logger,
 	}
-	leftOver := length % 16
-	if leftOver != 0 {
-		buffer.WriteString("\n")
-		buffer.WriteString(strconv.Itoa((length - leftOver) / 2))
-		buffer.WriteString("\t")
-		for i := 0; leftOver >= i+2; i += 2 {
-			buffer.WriteString(hexStr[i : i+2])
-			buffer.WriteString(" ")
+	if err := sp.init(logger); err != nil {
+		b.Fatal(err)
+	}
+	if err := sp.InitSecurityKeys(); err != nil {
+		b.Fatal(err)
+	}
+	v3 := &SnmpPacket{Version: Version3, MsgFlags: AuthPriv, SecurityModel: UserSecurityModel,
+		SecurityParameters: sp, ContextEngineID: sp.AuthoritativeEngineID, PDUType: GetResponse,
+		MsgID: 1, RequestID: 1234, Variables: pdus, Logger: logger}
+
+	for _, packet := range []*SnmpPacket{v2c, v3} {
+		packet := packet
+		b.Run("v"+packet.Version.String(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if packet.Version == Version3 {
+					if err := packet.SecurityParameters.InitPacket(packet); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if _, err := packet.marshalMsg(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSnmpDecodePacket(b *testing.B) {
+	var pdus []SnmpPDU
+	for i := 1; i <= 20; i++ {
+		pdus = append(pdus, SnmpPDU{Name: fmt.Sprintf(".1.3.6.1.2.1.31.1.1.1.6.%d", 1000+i), Type: Counter64, Value: uint64(i) << 40})
+	}
+	msg, err := (&SnmpPacket{Version: Version2c, Community: "public", PDUType: GetResponse,
+		RequestID: 1234, Variables: pdus}).marshalMsg()
+	if err != nil {
+		b.Fatal(err)
+	}
+	x := &GoSNMP{Version: Version2c}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := x.SnmpDecodePacket(msg); err != nil {
+			b.Fatal(err)
 		}
 	}
-	buffer.WriteString("\n")
 }
 
-// dump bytes in one row, up to about screen width. Returns a string
-// rather than (dumpBytes1) writing to debugging log.
-func dumpBytes2(desc string, bb []byte, cursor int) string {
-	cursor = cursor - 4 // give some context to dump
-	if cursor < 0 {
-		cursor = 0
+func TestSharedConn(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
 	}
-	result := desc
-	for i, b := range bb[cursor:] {
-		if i > 30 { // about screen width...
-			break
+	shared := NewSharedConn(pc)
+	defer shared.Close()
+
+	var sessions []*GoSNMP
+	var names []string
+	for _, name := range []string{"core-sw1", "core-sw2", "edge-rtr1"} {
+		agent, err := NewMockAgent(map[string]SnmpPDU{
+			".1.3.6.1.2.1.1.5.0": {Type: OctetString, Value: name},
+		})
+		if err != nil {
+			t.Fatalf("NewMockAgent: %v", err)
+		}
+		defer agent.Close()
+		// two sessions per agent share its address
+		for i := 0; i < 2; i++ {
+			x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()), WithTimeout(time.Second))
+			if err != nil {
+				t.Fatalf("NewGoSNMP: %v", err)
+			}
+			if err = shared.Connect(x); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+			sessions = append(sessions, x)
+			names = append(names, name)
 		}
-		result += fmt.Sprintf(" %02x", b)
 	}
-	return result
+
+	var wg sync.WaitGroup
+	for i, x := range sessions {
+		wg.Add(1)
+		go func(x *GoSNMP, name string) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				result, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+				if err != nil {
+					t.Errorf("Get %s: %v", name, err)
+					return
+				}
+				assert.Equal(t, []byte(name), result.Variables[0].Value)
+			}
+		}(x, names[i])
+	}
+	wg.Wait()
+
+	// closing a session leaves the socket to the others
+	assert.NoError(t, sessions[0].Close())
+	_, err = sessions[0].Get([]string{".1.3.6.1.2.1.1.5.0"})
+	assert.ErrorIs(t, err, ErrClosed)
+	result, err := sessions[1].Get([]string{".1.3.6.1.2.1.1.5.0"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte(names[1]), result.Variables[0].Value)
+	}
+	for _, x := range sessions[1:] {
+		assert.Equal(t, pc.LocalAddr(), x.Conn.LocalAddr())
+	}
+
+	assert.NoError(t, shared.Close())
+	_, err = sessions[1].Get([]string{".1.3.6.1.2.1.1.5.0"})
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.ErrorIs(t, shared.Connect(&GoSNMP{Target: "127.0.0.1", Port: 161}), net.ErrClosed)
+}
+
+func TestOnPacket(t *testing.T) {
+	agent, err := NewMockAgent(map[string]SnmpPDU{
+		".1.3.6.1.2.1.1.5.0": {Type: OctetString, Value: "core-sw1"},
+	})
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	var dirs []PacketDirection
+	var packets [][]byte
+	x.OnPacket = func(_ *GoSNMP, dir PacketDirection, b []byte) {
+		dirs = append(dirs, dir)
+		packets = append(packets, append([]byte(nil), b...))
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+
+	if _, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"}); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !assert.Equal(t, []PacketDirection{PacketSent, PacketReceived}, dirs) {
+		return
+	}
+	request, err := x.SnmpDecodePacket(packets[0])
+	if assert.NoError(t, err) {
+		assert.Equal(t, GetRequest, request.PDUType)
+	}
+	dump := DumpBER(packets[1])
+	assert.Contains(t, dump, "\n  GetResponse (")
+	assert.Contains(t, dump, "\n        ObjectIdentifier .1.3.6.1.2.1.1.5.0\n        OctetString \"core-sw1\"\n")
+}
+
+func TestDumpBER(t *testing.T) {
+	msg, err := (&SnmpPacket{Version: Version2c, Community: "public", PDUType: GetResponse, RequestID: 1234,
+		Variables: []SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(4200)}}}).marshalMsg()
+	if err != nil {
+		t.Fatalf("marshalMsg: %v", err)
+	}
+	assert.Equal(t, `Sequence (41)
+  Integer 1
+  OctetString "public"
+  GetResponse (28)
+    Integer 1234
+    Integer 0
+    Integer 0
+    Sequence (16)
+      Sequence (14)
+        ObjectIdentifier .1.3.6.1.2.1.1.3.0
+        TimeTicks 4200
+`, DumpBER(msg))
+
+	// what does not parse is shown in hex
+	dump := DumpBER(msg[:len(msg)-2])
+	assert.Contains(t, dump, "malformed: ")
+	assert.Contains(t, DumpBER([]byte{0x04, 0x03, 0x00, 0xff, 0x10}), "OctetString 00 FF 10")
+}
+
+func TestLenientDecoding(t *testing.T) {
+	msg, err := (&SnmpPacket{Version: Version2c, Community: "public", PDUType: GetResponse, RequestID: 1234,
+		Variables: []SnmpPDU{
+			{Name: ".1.3.6.1.2.1.1.4.0", Type: OctetString, Value: "ops"},
+			{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: "sw"},
+			{Name: ".1.3.6.1.2.1.1.6.0", Type: OctetString, Value: "lab"},
+		}}).marshalMsg()
+	if err != nil {
+		t.Fatalf("marshalMsg: %v", err)
+	}
+	// make "sw" an IpAddress of 2 octets
+	i := bytes.Index(msg, []byte{0x04, 0x02, 's', 'w'})
+	if i < 0 {
+		t.Fatalf("value not found in % x", msg)
+	}
+	msg[i] = byte(IPAddress)
+	vbStart := i - 12 // Sequence, length, OID .1.3.6.1.2.1.1.5.0
+
+	x := &GoSNMP{Logger: NewLogger(nil)}
+	_, err = x.SnmpDecodePacket(msg)
+	assert.Error(t, err)
+
+	x.LenientDecoding = true
+	result, err := x.SnmpDecodePacket(msg)
+	if err != nil {
+		t.Fatalf("SnmpDecodePacket: %v", err)
+	}
+	if !assert.Len(t, result.Variables, 3) {
+		return
+	}
+	assert.Equal(t, []byte("ops"), result.Variables[0].Value)
+	assert.Equal(t, []byte("lab"), result.Variables[2].Value)
+	bad := result.Variables[1]
+	assert.Equal(t, ".1.3.6.1.2.1.1.5.0", bad.Name)
+	assert.Equal(t, UnknownType, bad.Type)
+
+	err = result.DecodeErr()
+	var vbErr *VarbindError
+	if assert.ErrorAs(t, err, &vbErr) {
+		assert.Same(t, bad.Value, vbErr)
+		assert.Equal(t, 2, vbErr.Index)
+		assert.Equal(t, ".1.3.6.1.2.1.1.5.0", vbErr.Name)
+		assert.Equal(t, msg[vbStart:i+4], vbErr.Raw)
+	}
+	assert.Contains(t, err.Error(), "variable 2 (.1.3.6.1.2.1.1.5.0): ")
+
+	// a VarBind whose own length is broken cannot be skipped
+	msg[vbStart+1] = 0x7f
+	_, err = x.SnmpDecodePacket(msg)
+	assert.Error(t, err)
+}
+
+func TestOIDFormat(t *testing.T) {
+	mib := []SnmpPDU{
+		{Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: OctetString, Value: []byte("lo")},
+		{Name: ".1.3.6.1.2.1.2.2.1.2.2", Type: OctetString, Value: []byte("eth0")},
+		{Name: ".1.3.6.1.2.1.2.2.1.3.1", Type: Integer, Value: 24},
+		{Name: ".1.3.6.1.2.1.2.2.1.3.2", Type: Integer, Value: 6},
+		{Name: ".1.3.6.1.2.1.2.3.0", Type: Counter32, Value: uint32(0)},
+	}
+
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("udp4 error listening: %s", err)
+	}
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:        Version2c,
+		Target:         srvr.LocalAddr().(*net.UDPAddr).IP.String(),
+		Port:           uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:        time.Second,
+		Retries:        1,
+		MaxOids:        MaxOids,
+		MaxRepetitions: 2,
+		OIDFormat:      OIDNoLeadingDot,
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Conn.Close()
+	go mibResponder(t, x, srvr, mib)
+
+	result, err := x.Get([]string{"1.3.6.1.2.1.2.2.1.2.2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.3.6.1.2.1.2.2.1.2.2", result.Variables[0].Name)
+
+	var names []string
+	err = x.BulkWalk(".1.3.6.1.2.1.2.2.1.2", func(pdu SnmpPDU) error {
+		names = append(names, pdu.Name)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.3.6.1.2.1.2.2.1.2.1", "1.3.6.1.2.1.2.2.1.2.2"}, names)
+
+	table, err := x.BulkWalkTable("1.3.6.1.2.1.2.2")
+	assert.NoError(t, err)
+	assert.Len(t, table, 2)
+	assert.Contains(t, table["2"], "1.3.6.1.2.1.2.2.1.3")
+
+	assert.Equal(t, ".1.3.6.1", AddLeadingDot("1.3.6.1"))
+	assert.Equal(t, ".1.3.6.1", AddLeadingDot(".1.3.6.1"))
+	assert.Equal(t, "1.3.6.1", TrimLeadingDot(".1.3.6.1"))
+	assert.Equal(t, "1.3.6.1", TrimLeadingDot("1.3.6.1"))
+	assert.Equal(t, ".1.3.6.1", OIDLeadingDot.Format("1.3.6.1"))
+}
+
+func TestOIDLess(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		less bool
+	}{
+		{".1.3.6.1.2", ".1.3.6.1.10", true},
+		{".1.3.6.1.10", ".1.3.6.1.2", false},
+		{".1.3.6.1", ".1.3.6.1.1", true},
+		{".1.3.6.1.1", ".1.3.6.1", false},
+		{".1.3.6.1", "1.3.6.1", false},
+		{"1.3.6.1", ".1.3.6.1.", false},
+		{"2.1", "10", true},
+		{".1.3.01", ".1.3.1.5", true},
+		{".1.3.a", ".1.3.b", true},
+		{".1.3.b", ".1.3.a", false},
+		{"", ".1", true},
+		{".1", "", false},
+	} {
+		assert.Equal(t, test.less, oidLess(test.a, test.b), "%s < %s", test.a, test.b)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		oidLess(".1.3.6.1.2.1.2.2.1.10.12", ".1.3.6.1.2.1.2.2.1.10.112")
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestDecodeIndex(t *testing.T) {
+	atTable := ".1.3.6.1.2.1.4.22.1.2"
+	index, err := DecodeIndex(atTable, atTable+".3.10.0.0.1", IndexInteger, IndexIPAddress)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{uint32(3), net.IP{10, 0, 0, 1}}, index)
+
+	// without syntaxes, an integer per arc
+	index, err = DecodeIndex("1.3.6.1.2.1.4.22.1.2.", "1.3.6.1.2.1.4.22.1.2.3.10")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{uint32(3), uint32(10)}, index)
+
+	// strings, with and without a length, around an integer
+	vacm := ".1.3.6.1.6.3.16.1.4.1.4"
+	index, err = DecodeIndex(vacm, vacm+".3.97.98.99.0.4.112.117.98", IndexString, IndexString, IndexInteger, IndexImpliedString)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{[]byte("abc"), []byte{}, uint32(4), []byte("pub")}, index)
+
+	index, err = DecodeIndex(".1.2", ".1.2.2.1.3.1.3.6.0.0.0.0.0.1", IndexOID, IndexImpliedOID, IndexMACAddress)
+	assert.Error(t, err, "IMPLIED must be last")
+	assert.Nil(t, index)
+	index, err = DecodeIndex(".1.2", ".1.2.2.1.3.0.27.33.1.2.3.4.5", IndexOID, IndexMACAddress, IndexImpliedOID)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{".1.3", net.HardwareAddr{0, 27, 33, 1, 2, 3}, ".4.5"}, index)
+
+	for _, tt := range []struct {
+		leaf     string
+		syntaxes []IndexSyntax
+	}{
+		{".1.3.6.1.2.1.2.2.1.3.1", nil},                    // another column
+		{".1.2", nil},                                      // the column itself
+		{".1.2.1.x", nil},                                  // not numeric
+		{".1.2.10.0.0", []IndexSyntax{IndexIPAddress}},     // too short
+		{".1.2.10.0.0.1.5", []IndexSyntax{IndexIPAddress}}, // left over
+		{".1.2.10.0.0.256", []IndexSyntax{IndexIPAddress}}, // not an octet
+		{".1.2.4.97", []IndexSyntax{IndexString}},          // length past the end
+		{".1.2", []IndexSyntax{IndexString}},
+		{".1.2.1", []IndexSyntax{IndexSyntax(99)}},
+	} {
+		_, err := DecodeIndex(".1.2", tt.leaf, tt.syntaxes...)
+		assert.Error(t, err, tt.leaf)
+	}
+}
+
+func TestAsyncRequests(t *testing.T) {
+	agent, err := NewMockAgent(map[string]SnmpPDU{
+		".1.3.6.1.2.1.1.5.0": {Type: OctetString, Value: "core-sw1"},
+		".1.3.6.1.2.1.1.6.0": {Type: OctetString, Value: "rack 4"},
+	})
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+	agent.SetDelay(50 * time.Millisecond)
+
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()),
+		WithTimeout(time.Second), WithRetries(0))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+
+	get := x.GetAsync(context.Background(), []string{".1.3.6.1.2.1.1.5.0"})
+	next := x.GetNextAsync(context.Background(), []string{".1.3.6.1.2.1.1.5.0"})
+	_, err = get.Poll()
+	assert.ErrorIs(t, err, ErrPending)
+
+	<-get.Done()
+	result, err := get.Poll()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("core-sw1"), result.Variables[0].Value)
+	result, err = next.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, ".1.3.6.1.2.1.1.6.0", result.Variables[0].Name)
+
+	canceled := x.GetAsync(context.Background(), []string{".1.3.6.1.2.1.1.5.0"})
+	canceled.Cancel()
+	_, err = canceled.Wait()
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestAsyncCompletions(t *testing.T) {
+	vars := make(map[string]SnmpPDU)
+	for i := 1; i <= 20; i++ {
+		vars[fmt.Sprintf(".1.3.6.1.2.1.2.2.1.2.%d", i)] = SnmpPDU{Type: OctetString, Value: fmt.Sprintf("eth%d", i)}
+	}
+	agent, err := NewMockAgent(vars)
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+	delay := 100 * time.Millisecond
+	agent.SetDelay(delay)
+
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()),
+		WithTimeout(2*time.Second), WithRetries(0))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+
+	start := time.Now()
+	var batch []*Pending
+	for oid := range vars {
+		batch = append(batch, x.GetAsync(context.Background(), []string{oid}))
+	}
+	got := make(map[string]interface{})
+	for p := range Completions(batch...) {
+		result, err := p.Poll()
+		if !assert.NoError(t, err) {
+			continue
+		}
+		got[result.Variables[0].Name] = result.Variables[0].Value
+	}
+	assert.Len(t, got, len(vars))
+	assert.Equal(t, []byte("eth7"), got[".1.3.6.1.2.1.2.2.1.2.7"])
+	// the requests were in flight together, not one after the other
+	assert.Less(t, time.Since(start), 5*delay)
+
+	_, ok := <-Completions()
+	assert.False(t, ok)
+}
+
+func TestBulkWalkEndOfMibView(t *testing.T) {
+	// the tail of the MIB: nothing comes after laLoad.5
+	vars := make(map[string]SnmpPDU)
+	var want []string
+	for i := 1; i <= 5; i++ {
+		oid := fmt.Sprintf(".1.3.6.1.4.1.2021.10.1.3.%d", i)
+		vars[oid] = SnmpPDU{Type: OctetString, Value: "0.42"}
+		want = append(want, oid)
+	}
+	agent, err := NewMockAgent(vars)
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()),
+		WithTimeout(time.Second), WithRetries(0), WithMaxRepetitions(4))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+
+	// the second GetBulk has laLoad.5 and three endOfMibView
+	result, err := x.GetBulk([]string{want[3]}, 0, 4)
+	assert.NoError(t, err)
+	assert.Len(t, result.Variables, 4)
+	assert.Equal(t, want[4], result.Variables[0].Name)
+	for _, pdu := range result.Variables[1:] {
+		assert.Equal(t, EndOfMibView, pdu.Type)
+	}
+
+	for _, check := range []OIDCheckPolicy{OIDCheckStrict, OIDCheckTolerant, OIDCheckOff} {
+		x.OIDCheck = check
+		before := agent.Requests()
+		var got []string
+		err = x.BulkWalk(".1.3.6.1.4.1.2021.10", func(pdu SnmpPDU) error {
+			got = append(got, pdu.Name)
+			return nil
+		})
+		assert.NoError(t, err, check)
+		assert.Equal(t, want, got, check)
+		assert.Equal(t, 2, agent.Requests()-before, "no request past the end of the MIB")
+	}
+}
+
+func TestCommunityOctets(t *testing.T) {
+	for _, community := range []string{
+		"",
+		"pub\x00lic\xff\xfe",
+		strings.Repeat("\x00\x80", 100), // long form length
+	} {
+		packet := &SnmpPacket{
+			Version:   Version2c,
+			Community: community,
+			PDUType:   GetRequest,
+			RequestID: 1,
+			Variables: []SnmpPDU{{Name: ".1.3.6.1.2.1.1.5.0", Type: Null}},
+		}
+		msg, err := packet.marshalMsg()
+		if err != nil {
+			t.Fatalf("marshalMsg: %v", err)
+		}
+		decoded, err := (&GoSNMP{}).SnmpDecodePacket(msg)
+		assert.NoError(t, err)
+		assert.Equal(t, community, decoded.Community)
+		assert.Len(t, decoded.Variables, 1)
+	}
+
+	community := []byte{'r', 0, 0xc3, 0x28, 'w'}
+	agent, err := NewMockAgent(map[string]SnmpPDU{
+		".1.3.6.1.2.1.1.5.0": {Type: OctetString, Value: "core-sw1"},
+	})
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+	agent.SetCommunity(string(community))
+
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()), WithCommunityBytes(community),
+		WithTimeout(time.Second), WithRetries(0))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+	result, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, string(community), result.Community)
+}
+
+func TestMockAgentGetBulkOrder(t *testing.T) {
+	agent, err := NewMockAgent(map[string]SnmpPDU{
+		".1.3.6.1.2.1.1.3.0":     {Type: TimeTicks, Value: uint32(4200)},
+		".1.3.6.1.2.1.2.2.1.2.1": {Type: OctetString, Value: "lo"},
+		".1.3.6.1.2.1.2.2.1.2.2": {Type: OctetString, Value: "eth0"},
+		".1.3.6.1.2.1.2.2.1.3.1": {Type: Integer, Value: 24},
+		".1.3.6.1.2.1.2.2.1.3.2": {Type: Integer, Value: 6},
+	})
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()),
+		WithTimeout(time.Second), WithRetries(0))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+
+	// sysUpTime once, then ifDescr and ifType side by side in each row
+	result, err := x.GetBulk([]string{".1.3.6.1.2.1.1.3", ".1.3.6.1.2.1.2.2.1.2", ".1.3.6.1.2.1.2.2.1.3"}, 1, 2)
+	if err != nil {
+		t.Fatalf("GetBulk: %v", err)
+	}
+	var names []string
+	for _, pdu := range result.Variables {
+		names = append(names, pdu.Name)
+	}
+	assert.Equal(t, []string{
+		".1.3.6.1.2.1.1.3.0",
+		".1.3.6.1.2.1.2.2.1.2.1", ".1.3.6.1.2.1.2.2.1.3.1",
+		".1.3.6.1.2.1.2.2.1.2.2", ".1.3.6.1.2.1.2.2.1.3.2",
+	}, names)
+}
+
+func TestBulkWalkEndOfMibViewColumns(t *testing.T) {
+	// the tail of the MIB: laNames has two rows, laLoad three
+	agent, err := NewMockAgent(map[string]SnmpPDU{
+		".1.3.6.1.4.1.2021.10.1.2.1": {Type: OctetString, Value: "Load-1"},
+		".1.3.6.1.4.1.2021.10.1.2.2": {Type: OctetString, Value: "Load-5"},
+		".1.3.6.1.4.1.2021.10.1.3.1": {Type: OctetString, Value: "0.42"},
+		".1.3.6.1.4.1.2021.10.1.3.2": {Type: OctetString, Value: "0.40"},
+		".1.3.6.1.4.1.2021.10.1.3.3": {Type: OctetString, Value: "0.38"},
+	})
+	if err != nil {
+		t.Fatalf("NewMockAgent: %v", err)
+	}
+	defer agent.Close()
+
+	x, err := NewGoSNMP(agent.Target(), WithPort(agent.Port()),
+		WithTimeout(time.Second), WithRetries(0))
+	if err != nil {
+		t.Fatalf("NewGoSNMP: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer x.Close()
+
+	// the first column walks on from laNames into laLoad, the second runs
+	// out after the second row and has endOfMibView in its place in the
+	// third and fourth
+	result, err := x.GetBulk([]string{".1.3.6.1.4.1.2021.10.1.2.1", ".1.3.6.1.4.1.2021.10.1.3.1"}, 0, 4)
+	if err != nil {
+		t.Fatalf("GetBulk: %v", err)
+	}
+	want := []struct {
+		name string
+		typ  Asn1BER
+	}{
+		{".1.3.6.1.4.1.2021.10.1.2.2", OctetString}, {".1.3.6.1.4.1.2021.10.1.3.2", OctetString},
+		{".1.3.6.1.4.1.2021.10.1.3.1", OctetString}, {".1.3.6.1.4.1.2021.10.1.3.3", OctetString},
+		{".1.3.6.1.4.1.2021.10.1.3.2", OctetString}, {".1.3.6.1.4.1.2021.10.1.3.3", EndOfMibView},
+		{".1.3.6.1.4.1.2021.10.1.3.3", OctetString}, {".1.3.6.1.4.1.2021.10.1.3.3", EndOfMibView},
+	}
+	if !assert.Len(t, result.Variables, len(want)) {
+		return
+	}
+	for i, pdu := range result.Variables {
+		assert.Equal(t, want[i].name, pdu.Name, i)
+		assert.Equal(t, want[i].typ, pdu.Type, i)
+	}
 }
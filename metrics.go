@@ -0,0 +1,49 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import "time"
+
+// Metrics receives the events of requests, eg to feed Prometheus counters
+// and histograms through an adapter. The methods are called from the
+// goroutine making the request, so implementations used by concurrent
+// requests must be safe for concurrent use.
+type Metrics interface {
+	// IncRequest is called for each request message sent, retries and
+	// SNMPv3 discovery included.
+	IncRequest(pduType PDUType)
+
+	// IncRetry is called before each retry.
+	IncRetry()
+
+	// IncTimeout is called when no response arrived in time for a request
+	// message.
+	IncTimeout()
+
+	// IncAuthFailure is called when a response fails authentication, or
+	// the agent reports a wrong digest, unknown user name, unsupported
+	// security level or decryption error.
+	IncAuthFailure()
+
+	// ObserveLatency is called with the round-trip latency of each
+	// response, see SnmpPacket.Latency.
+	ObserveLatency(latency time.Duration)
+}
+
+type noMetrics struct{}
+
+func (noMetrics) IncRequest(PDUType)           {}
+func (noMetrics) IncRetry()                    {}
+func (noMetrics) IncTimeout()                  {}
+func (noMetrics) IncAuthFailure()              {}
+func (noMetrics) ObserveLatency(time.Duration) {}
+
+// metrics returns x.Metrics, or a Metrics doing nothing if it is not set.
+func (x *GoSNMP) metrics() Metrics {
+	if x.Metrics == nil {
+		return noMetrics{}
+	}
+	return x.Metrics
+}
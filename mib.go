@@ -0,0 +1,358 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// MIB translates between OIDs and the names of MIB objects, eg
+// ".1.3.6.1.2.1.2.2.1.10.3" and "ifInOctets.3":
+//
+//	mib := gosnmp.NewMIB()
+//	f, err := os.Open("/usr/share/snmp/mibs/IF-MIB.txt")
+//	if err != nil {
+//		return err
+//	}
+//	defer f.Close()
+//	if err := mib.LoadModule(f); err != nil {
+//		return err
+//	}
+//	name, index := mib.TranslateOID(".1.3.6.1.2.1.2.2.1.10.3")
+//
+// Names come from SMI module sources (LoadModule) or from a precompiled
+// map (LoadMap); the nodes of SNMPv2-SMI, such as mib-2 and enterprises,
+// are known from the start. Names are those of the objects alone, the
+// module they are defined in is not kept.
+//
+// Loading is not safe for concurrent use, translating once loaded is.
+type MIB struct {
+	names   map[string]string // by OID
+	oids    map[string]string // by name
+	pending map[string]mibNode
+}
+
+// mibNode is the value of an OID assignment of a module: arcs under the
+// node parent, or under the root when parent is "".
+type mibNode struct {
+	parent string
+	arcs   []string
+}
+
+// smiMacros are the macros of SMIv2 (and SMIv1 OBJECT-TYPE) whose values
+// are OIDs.
+var smiMacros = map[string]bool{ //nolint:gochecknoglobals
+	"OBJECT-TYPE":        true,
+	"OBJECT-IDENTITY":    true,
+	"MODULE-IDENTITY":    true,
+	"NOTIFICATION-TYPE":  true,
+	"OBJECT-GROUP":       true,
+	"NOTIFICATION-GROUP": true,
+	"MODULE-COMPLIANCE":  true,
+	"AGENT-CAPABILITIES": true,
+}
+
+// NewMIB returns a MIB knowing the nodes of SNMPv2-SMI (RFC 2578).
+func NewMIB() *MIB {
+	m := &MIB{
+		names:   make(map[string]string),
+		oids:    make(map[string]string),
+		pending: make(map[string]mibNode),
+	}
+	for _, node := range [...]struct{ name, oid string }{
+		{"ccitt", ".0"},
+		{"zeroDotZero", ".0.0"},
+		{"iso", ".1"},
+		{"org", ".1.3"},
+		{"dod", ".1.3.6"},
+		{"internet", ".1.3.6.1"},
+		{"directory", ".1.3.6.1.1"},
+		{"mgmt", ".1.3.6.1.2"},
+		{"mib-2", ".1.3.6.1.2.1"},
+		{"transmission", ".1.3.6.1.2.1.10"},
+		{"experimental", ".1.3.6.1.3"},
+		{"private", ".1.3.6.1.4"},
+		{"enterprises", ".1.3.6.1.4.1"},
+		{"security", ".1.3.6.1.5"},
+		{"snmpV2", ".1.3.6.1.6"},
+		{"snmpDomains", ".1.3.6.1.6.1"},
+		{"snmpProxys", ".1.3.6.1.6.2"},
+		{"snmpModules", ".1.3.6.1.6.3"},
+		{"joint-iso-ccitt", ".2"},
+	} {
+		m.names[node.oid] = node.name
+		m.oids[node.name] = node.oid
+	}
+	return m
+}
+
+// Add names the object oid, replacing any name it had.
+func (m *MIB) Add(name, oid string) error {
+	if name == "" || strings.ContainsAny(name, ". \t") {
+		return fmt.Errorf("invalid MIB object name %q", name)
+	}
+	oid = AddLeadingDot(oid)
+	if !numericOID(oid) {
+		return fmt.Errorf("invalid OID %q for %s", oid, name)
+	}
+	m.names[oid] = name
+	m.oids[name] = oid
+	return nil
+}
+
+// LoadMap adds the names of a precompiled map, a line per object with its
+// name and OID separated by white space. Blank lines and lines starting
+// with # are skipped, and quotes around either field are ignored, so the
+// output of net-snmp's "snmptranslate -Tz -m ALL" loads as it is.
+func (m *MIB) LoadMap(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("line %d: want a name and an OID, got %d fields", line, len(fields))
+		}
+		if err := m.Add(strings.Trim(fields[0], `"`), strings.Trim(fields[1], `"`)); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// LoadModule adds the names assigned by the SMIv1 or SMIv2 module source
+// read from r: those of OBJECT IDENTIFIER values and of the OBJECT-TYPE,
+// MODULE-IDENTITY, NOTIFICATION-TYPE and other macros whose values are
+// OIDs. Names under nodes of modules not loaded yet, eg those it imports,
+// are added once these are.
+func (m *MIB) LoadModule(r io.Reader) error {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	tokens := smiTokens(string(src))
+
+	var name string // of the assignment being read
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok == "::=":
+			if name != "" && i+1 < len(tokens) && tokens[i+1] == "{" {
+				end, err := m.parseOIDValue(name, tokens[i+2:])
+				if err != nil {
+					return err
+				}
+				i += 2 + end
+			}
+			name = ""
+		case smiValueName(tok) && i+1 < len(tokens):
+			next := tokens[i+1]
+			if smiMacros[next] || next == "OBJECT" && i+2 < len(tokens) && tokens[i+2] == "IDENTIFIER" {
+				name = tok
+			}
+		}
+	}
+	m.resolve()
+	return nil
+}
+
+// parseOIDValue reads the components of the OID value of name from
+// tokens, which follow its opening brace, and returns the index of the
+// closing one. Components may be numbers, a name and number, as in
+// "{ iso(1) org(3) 6 }", or at first the name of the parent node.
+func (m *MIB) parseOIDValue(name string, tokens []string) (int, error) {
+	var node mibNode
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok == "}" {
+			if len(node.arcs) == 0 {
+				return 0, fmt.Errorf("%s has no OID arcs", name)
+			}
+			m.pending[name] = node
+			return i, nil
+		}
+		if numericArc(tok) {
+			node.arcs = append(node.arcs, tok)
+			continue
+		}
+		if !smiValueName(tok) {
+			return 0, fmt.Errorf("unexpected %q in the OID of %s", tok, name)
+		}
+		if i+3 < len(tokens) && tokens[i+1] == "(" && numericArc(tokens[i+2]) && tokens[i+3] == ")" {
+			// a named arc, a node in its own right
+			node.arcs = append(node.arcs, tokens[i+2])
+			m.pending[tok] = mibNode{parent: node.parent, arcs: append([]string(nil), node.arcs...)}
+			i += 3
+			continue
+		}
+		if i != 0 {
+			return 0, fmt.Errorf("%s, the parent of %s, must come first", tok, name)
+		}
+		node.parent = tok
+	}
+	return 0, fmt.Errorf("the OID of %s is not terminated", name)
+}
+
+// resolve adds the pending nodes whose parents are known, until no more
+// are.
+func (m *MIB) resolve() {
+	for progress := true; progress; {
+		progress = false
+		for name, node := range m.pending {
+			oid := ""
+			if node.parent != "" {
+				var ok bool
+				if oid, ok = m.oids[node.parent]; !ok {
+					continue
+				}
+			}
+			oid += "." + strings.Join(node.arcs, ".")
+			m.names[oid] = name
+			m.oids[name] = oid
+			delete(m.pending, name)
+			progress = true
+		}
+	}
+}
+
+// TranslateOID returns the name of the closest known object at or above
+// oid, and the arcs of oid below it, eg "ifInOctets" and "3" for
+// ".1.3.6.1.2.1.2.2.1.10.3". For an oid not under any known object name
+// is empty and remainingIndex is oid without its leading dot.
+func (m *MIB) TranslateOID(oid string) (name, remainingIndex string) {
+	oid = AddLeadingDot(oid)
+	for prefix := oid; prefix != ""; {
+		if name, ok := m.names[prefix]; ok {
+			return name, TrimLeadingDot(oid[len(prefix):])
+		}
+		i := strings.LastIndexByte(prefix, '.')
+		if i < 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+	return "", TrimLeadingDot(oid)
+}
+
+// Name returns oid as a name and index, eg "ifInOctets.3", or oid itself
+// when it is not under any known object.
+func (m *MIB) Name(oid string) string {
+	name, index := m.TranslateOID(oid)
+	switch {
+	case name == "":
+		return oid
+	case index == "":
+		return name
+	}
+	return name + "." + index
+}
+
+// TranslateName returns the OID of name, an object name followed by any
+// index, eg ".1.3.6.1.2.1.2.2.1.10.3" for "ifInOctets.3". A module
+// prefix, as in "IF-MIB::ifInOctets.3", is ignored, and numeric OIDs are
+// returned as they are, with a leading dot.
+func (m *MIB) TranslateName(name string) (string, error) {
+	if _, object, found := strings.Cut(name, "::"); found {
+		name = object
+	}
+	if numericOID(AddLeadingDot(name)) {
+		return AddLeadingDot(name), nil
+	}
+	object, index, _ := strings.Cut(name, ".")
+	oid, ok := m.oids[object]
+	if !ok {
+		return "", fmt.Errorf("unknown MIB object %q", object)
+	}
+	if index == "" {
+		return oid, nil
+	}
+	if !numericOID("." + index) {
+		return "", fmt.Errorf("invalid index %q of %s", index, object)
+	}
+	return oid + "." + index, nil
+}
+
+// smiTokens splits SMI source into its tokens, less comments. Quoted
+// strings, such as DESCRIPTION clauses, are returned as a lone quote.
+func smiTokens(src string) []string {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case strings.HasPrefix(src[i:], "--"):
+			// a comment ends at the end of the line or at the next "--"
+			end := i + 2
+			for end < len(src) && src[end] != '\n' && !strings.HasPrefix(src[end:], "--") {
+				end++
+			}
+			i = end
+			if strings.HasPrefix(src[end:], "--") {
+				i += 2
+			}
+		case c == '"':
+			end := strings.IndexByte(src[i+1:], '"')
+			if end < 0 {
+				end = len(src) - i - 1
+			}
+			tokens = append(tokens, `"`)
+			i += end + 2
+		case strings.HasPrefix(src[i:], "::="):
+			tokens = append(tokens, "::=")
+			i += 3
+		case c == '-' || c == '_' || c < unicode.MaxASCII && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))):
+			end := i + 1
+			for end < len(src) && (src[end] == '_' || src[end] < unicode.MaxASCII &&
+				(unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end]))) ||
+				src[end] == '-' && !strings.HasPrefix(src[end:], "--")) {
+				end++
+			}
+			tokens = append(tokens, src[i:end])
+			i = end
+		default:
+			tokens = append(tokens, src[i:i+1])
+			i++
+		}
+	}
+	return tokens
+}
+
+// smiValueName reports whether tok is an SMI value reference, a name
+// starting with a lower case letter.
+func smiValueName(tok string) bool {
+	return tok != "" && tok[0] >= 'a' && tok[0] <= 'z'
+}
+
+// numericArc reports whether tok is a decimal OID arc.
+func numericArc(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	for i := 0; i < len(tok); i++ {
+		if tok[i] < '0' || tok[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// numericOID reports whether oid is a numeric OID with a leading dot.
+func numericOID(oid string) bool {
+	if len(oid) < 2 || oid[0] != '.' {
+		return false
+	}
+	for _, arc := range strings.Split(oid[1:], ".") {
+		if !numericArc(arc) {
+			return false
+		}
+	}
+	return true
+}
@@ -12,11 +12,14 @@ import (
 	"crypto"
 	_ "crypto/md5"
 	_ "crypto/sha1"
-	"errors"
+	"fmt"
 	"math"
 	"math/big"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -121,6 +124,210 @@ func TestToBigInt(t *testing.T) {
 
 // ---------------------------------------------------------------------
 
+func TestSnmpPDUAccessors(t *testing.T) {
+	i, err := SnmpPDU{Type: Integer, Value: -5}.Int()
+	assert.NoError(t, err)
+	assert.Equal(t, -5, i)
+	i, err = SnmpPDU{Type: Gauge32, Value: uint(7)}.Int()
+	assert.NoError(t, err)
+	assert.Equal(t, 7, i)
+	i, err = SnmpPDU{Type: Uinteger32, Value: uint32(8)}.Int()
+	assert.NoError(t, err)
+	assert.Equal(t, 8, i)
+	_, err = SnmpPDU{Type: Counter64, Value: uint64(math.MaxUint64)}.Int()
+	assert.ErrorIs(t, err, ErrIntegerTooLarge)
+	_, err = SnmpPDU{Type: OctetString, Value: []byte("5")}.Int()
+	assert.ErrorIs(t, err, ErrWrongValueType)
+
+	u, err := SnmpPDU{Type: Counter64, Value: uint64(math.MaxUint64)}.Uint64()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), u)
+	u, err = SnmpPDU{Type: TimeTicks, Value: uint32(100)}.Uint64()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), u)
+	u, err = SnmpPDU{Type: Integer, Value: 3}.Uint64()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), u)
+	_, err = SnmpPDU{Type: Integer, Value: -3}.Uint64()
+	assert.ErrorIs(t, err, ErrWrongValueType)
+
+	s, err := SnmpPDU{Type: OctetString, Value: []byte("eth0")}.Text()
+	assert.NoError(t, err)
+	assert.Equal(t, "eth0", s)
+	s, err = SnmpPDU{Type: IPAddress, Value: "192.0.2.1"}.Text()
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", s)
+	s, err = SnmpPDU{Type: IPAddress, Value: []byte{192, 0, 2, 1}}.Text()
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", s)
+	s, err = SnmpPDU{Type: IPAddress, Value: net.ParseIP("2001:db8::1")}.Text()
+	assert.NoError(t, err)
+	assert.Equal(t, "2001:db8::1", s)
+	_, err = SnmpPDU{Type: IPAddress, Value: []byte{192, 0, 2}}.Text()
+	assert.ErrorIs(t, err, ErrWrongValueType)
+	_, err = SnmpPDU{Type: Null}.Text()
+	assert.ErrorIs(t, err, ErrWrongValueType)
+
+	oid, err := SnmpPDU{Type: ObjectIdentifier, Value: ".1.3.6.1"}.OID()
+	assert.NoError(t, err)
+	assert.Equal(t, ".1.3.6.1", oid)
+	_, err = SnmpPDU{Type: OctetString, Value: ".1.3.6.1"}.OID()
+	assert.ErrorIs(t, err, ErrWrongValueType)
+
+	physAddress := SnmpPDU{Name: ".1.3.6.1.2.1.2.2.1.6.2", Type: OctetString, Value: []byte{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}}
+	mac, err := physAddress.MAC()
+	assert.NoError(t, err)
+	assert.Equal(t, "00:1a:2b:3c:4d:5e", mac.String())
+	h, err := physAddress.Hex()
+	assert.NoError(t, err)
+	assert.Equal(t, "00 1A 2B 3C 4D 5E", h)
+	b, err := physAddress.Bytes()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}, b)
+	// the loopback interface has an empty ifPhysAddress
+	_, err = SnmpPDU{Type: OctetString, Value: []byte{}}.MAC()
+	assert.ErrorIs(t, err, ErrWrongValueType)
+	h, err = SnmpPDU{Type: OctetString, Value: []byte{}}.Hex()
+	assert.NoError(t, err)
+	assert.Equal(t, "", h)
+	_, err = SnmpPDU{Type: Integer, Value: 6}.Hex()
+	assert.ErrorIs(t, err, ErrWrongValueType)
+
+	bits, err := SnmpPDU{Type: BitString, Value: BitStringValue{Bytes: []byte{0x81, 0x40}, BitLength: 10}}.Bits()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 7, 9}, bits)
+	// a BITS value, eg ifMauAutoNegCapAdvertisedBits
+	bits, err = SnmpPDU{Type: OctetString, Value: []byte{0x00, 0x21}}.Bits()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10, 15}, bits)
+	bits, err = SnmpPDU{Type: OctetString, Value: []byte{}}.Bits()
+	assert.NoError(t, err)
+	assert.Empty(t, bits)
+	_, err = SnmpPDU{Type: Integer, Value: 6}.Bits()
+	assert.ErrorIs(t, err, ErrWrongValueType)
+
+	for value, want := range map[string]string{
+		"Linux router 5.10\r\n": "Linux router 5.10\r\n",
+		"eth0\x00":              "eth0",
+		"\x00\x1a\x2b":          "00 1A 2B",
+		"caf\xc3\xa9":           "63 61 66 C3 A9",
+		"":                      "",
+	} {
+		s, err := SnmpPDU{Type: OctetString, Value: []byte(value)}.DisplayString()
+		assert.NoError(t, err)
+		assert.Equal(t, want, s, "%q", value)
+	}
+	_, err = SnmpPDU{Type: Counter32, Value: uint32(1)}.DisplayString()
+	assert.ErrorIs(t, err, ErrWrongValueType)
+
+	ip, err := SnmpPDU{Type: IPAddress, Value: "192.0.2.1"}.IP()
+	assert.NoError(t, err)
+	assert.True(t, net.IPv4(192, 0, 2, 1).Equal(ip))
+	ip, err = SnmpPDU{Type: IPAddress, Value: []byte{192, 0, 2, 1}}.IP()
+	assert.NoError(t, err)
+	assert.True(t, net.IPv4(192, 0, 2, 1).Equal(ip))
+	// decoded from an agent sending no octets
+	_, err = SnmpPDU{Type: IPAddress, Value: nil}.IP()
+	assert.ErrorIs(t, err, ErrWrongValueType)
+	_, err = SnmpPDU{Type: IPAddress, Value: []byte{192, 0, 2}}.IP()
+	assert.ErrorIs(t, err, ErrWrongValueType)
+	_, err = SnmpPDU{Type: OctetString, Value: "192.0.2.1"}.IP()
+	assert.ErrorIs(t, err, ErrWrongValueType)
+
+	// TimeTicks are hundredths of a second
+	d, err := SnmpPDU{Type: TimeTicks, Value: uint32(4200)}.Duration()
+	assert.NoError(t, err)
+	assert.Equal(t, 42*time.Second, d)
+	_, err = SnmpPDU{Type: Gauge32, Value: uint32(4200)}.Duration()
+	assert.ErrorIs(t, err, ErrWrongValueType)
+}
+
+func TestSnmpPDUIsCounter(t *testing.T) {
+	// the same value, decoded from each type, keeps its type
+	packet := &SnmpPacket{
+		Version:   Version2c,
+		Community: "public",
+		PDUType:   GetResponse,
+		RequestID: 1,
+		Variables: []SnmpPDU{
+			{Name: ".1.3.6.1.2.1.2.2.1.10.1", Type: Counter32, Value: uint32(42)},
+			{Name: ".1.3.6.1.2.1.31.1.1.1.6.1", Type: Counter64, Value: uint64(42)},
+			{Name: ".1.3.6.1.2.1.2.2.1.5.1", Type: Gauge32, Value: uint32(42)},
+			{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(42)},
+			{Name: ".1.3.6.1.2.1.2.2.1.1.1", Type: Integer, Value: 42},
+		},
+	}
+	msg, err := packet.marshalMsg()
+	if err != nil {
+		t.Fatalf("marshalMsg: %v", err)
+	}
+	decoded, err := (&GoSNMP{}).SnmpDecodePacket(msg)
+	if err != nil {
+		t.Fatalf("SnmpDecodePacket: %v", err)
+	}
+	var types []Asn1BER
+	var counters []bool
+	for _, pdu := range decoded.Variables {
+		types = append(types, pdu.Type)
+		counters = append(counters, pdu.IsCounter())
+	}
+	assert.Equal(t, []Asn1BER{Counter32, Counter64, Gauge32, TimeTicks, Integer}, types)
+	assert.Equal(t, []bool{true, true, false, false, false}, counters)
+	assert.Equal(t, uint(42), decoded.Variables[0].Value)
+	assert.Equal(t, uint64(42), decoded.Variables[1].Value)
+}
+
+func TestTimeTicksToDuration(t *testing.T) {
+	assert.Equal(t, time.Duration(0), TimeTicksToDuration(0))
+	assert.Equal(t, 42*time.Second+10*time.Millisecond, TimeTicksToDuration(4201))
+	// 497 days, when TimeTicks wrap
+	assert.Equal(t, 42949672950*time.Millisecond, TimeTicksToDuration(math.MaxUint32))
+
+	assert.Equal(t, uint32(4201), DurationToTimeTicks(42*time.Second+19*time.Millisecond))
+	assert.Equal(t, uint32(0), DurationToTimeTicks(-time.Second))
+	assert.Equal(t, uint32(math.MaxUint32), DurationToTimeTicks(1000*24*time.Hour))
+	for _, ticks := range []uint32{0, 1, 4201, math.MaxUint32} {
+		assert.Equal(t, ticks, DurationToTimeTicks(TimeTicksToDuration(ticks)))
+	}
+}
+
+// ---------------------------------------------------------------------
+
+func TestBackoffDelay(t *testing.T) {
+	assert.Equal(t, time.Duration(0), Backoff{}.delay(1))
+
+	b := Backoff{Base: 100 * time.Millisecond, Max: time.Second}
+	assert.Equal(t, 100*time.Millisecond, b.delay(1))
+	assert.Equal(t, 200*time.Millisecond, b.delay(2))
+	assert.Equal(t, 800*time.Millisecond, b.delay(4))
+	assert.Equal(t, time.Second, b.delay(5))
+	assert.Equal(t, time.Second, b.delay(1000))
+
+	b = Backoff{Base: 100 * time.Millisecond, Multiplier: 1.5}
+	assert.Equal(t, 150*time.Millisecond, b.delay(2))
+
+	// without Max the delay grows to the longest Duration, not past it
+	b = Backoff{Base: 100 * time.Millisecond}
+	for _, retry := range []int{40, 100, 2000} {
+		assert.Greater(t, b.delay(retry), 1000*time.Hour, retry)
+	}
+	b.Jitter = 0.25
+	assert.Greater(t, b.delay(40), 1000*time.Hour)
+
+	b = Backoff{Base: 100 * time.Millisecond, Multiplier: 1, Jitter: 1}
+	for i := 0; i < 100; i++ {
+		d := b.delay(3)
+		assert.True(t, d >= 0 && d <= 100*time.Millisecond, d)
+	}
+	b.Jitter = 0.25
+	for i := 0; i < 100; i++ {
+		d := b.delay(3)
+		assert.True(t, d >= 75*time.Millisecond && d <= 100*time.Millisecond, d)
+	}
+}
+
+// ---------------------------------------------------------------------
+
 var testsSnmpVersionString = []struct {
 	in  SnmpVersion
 	out string
@@ -210,22 +417,186 @@ func TestMarshalTimeticks(t *testing.T) {
 }
 */
 
-// parseBitString parses an ASN.1 bit string from the given byte slice and returns it.
-func parseBitString(bytes []byte) (ret BitStringValue, err error) {
-	if len(bytes) == 0 {
-		err = errors.New("zero length BIT STRING")
-		return
-	}
-	paddingBits := int(bytes[0])
-	if paddingBits > 7 ||
-		len(bytes) == 1 && paddingBits > 0 ||
-		bytes[len(bytes)-1]&((1<<bytes[0])-1) != 0 {
-		err = errors.New("invalid padding bits in BIT STRING")
-		return
+type debugLogger struct{ lines []string }
+
+func (d *debugLogger) Print(v ...interface{}) { d.lines = append(d.lines, fmt.Sprint(v...)) }
+func (d *debugLogger) Printf(format string, v ...interface{}) {
+	d.lines = append(d.lines, fmt.Sprintf(format, v...))
+}
+
+func TestLogger(t *testing.T) {
+	sp := &UsmSecurityParameters{UserName: "user", AuthoritativeEngineID: "engine"}
+
+	// the zero Logger must not even format the message
+	allocs := testing.AllocsPerRun(100, sp.Log)
+	assert.Zero(t, allocs)
+
+	d := &debugLogger{}
+	sp.Logger = NewLogger(d)
+	if !sp.Logger.enabled() {
+		t.Skip("built with gosnmp_nodebug")
 	}
-	ret.BitLength = (len(bytes)-1)*8 - paddingBits
-	ret.Bytes = bytes[1:]
-	return
+	sp.Log()
+	assert.Len(t, d.lines, 1)
+	assert.Contains(t, d.lines[0], "UserName:user")
 }
 
 // ---------------------------------------------------------------------
+
+func TestSnmpPacketErr(t *testing.T) {
+	response := &SnmpPacket{
+		PDUType: GetResponse,
+		Variables: []SnmpPDU{
+			{Name: ".1.3.6.1.2.1.1.4.0", Type: OctetString, Value: "ops"},
+			{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: "core-sw1"},
+			{Name: ".1.3.6.1.2.1.2.2.1.7.3", Type: Integer, Value: 9},
+		},
+	}
+	assert.NoError(t, response.Err())
+	_, ok := response.ErrorVariable()
+	assert.False(t, ok)
+
+	response.Error, response.ErrorIndex = WrongValue, 3
+	err := response.Err()
+	var reqErr *RequestError
+	if assert.ErrorAs(t, err, &reqErr) {
+		assert.Equal(t, RequestError{Status: WrongValue, Index: 3, Name: ".1.3.6.1.2.1.2.2.1.7.3"}, *reqErr)
+	}
+	assert.EqualError(t, err, "agent reported WrongValue for .1.3.6.1.2.1.2.2.1.7.3 (variable 3)")
+	assert.ErrorIs(t, err, WrongValue)
+	assert.NotErrorIs(t, err, WrongType)
+	assert.Equal(t, "WrongValue", fmt.Sprint(WrongValue))
+	pdu, ok := response.ErrorVariable()
+	assert.True(t, ok)
+	assert.Equal(t, 9, pdu.Value)
+
+	// an index past the variables, or none at all
+	response.ErrorIndex = 4
+	assert.EqualError(t, response.Err(), "agent reported WrongValue for variable 4")
+	response.Error, response.ErrorIndex = TooBig, 0
+	assert.EqualError(t, response.Err(), "agent reported TooBig")
+}
+
+// ifMIBExcerpt has the constructs LoadModule must get past: imports,
+// macros, SEQUENCEs, enumerations, comments and quoted strings.
+const ifMIBExcerpt = `
+IF-MIB DEFINITIONS ::= BEGIN
+
+IMPORTS
+    MODULE-IDENTITY, OBJECT-TYPE, Counter32, mib-2 FROM SNMPv2-SMI
+    DisplayString FROM SNMPv2-TC;
+
+ifMIB MODULE-IDENTITY
+    LAST-UPDATED "200006140000Z"
+    DESCRIPTION  "The MIB module ::= { nonsense 1 } to describe
+                  generic objects for network interface sub-layers."
+    ::= { mib-2 31 }
+
+interfaces   OBJECT IDENTIFIER ::= { mib-2 2 } -- see RFC 1213
+
+ifTable OBJECT-TYPE
+    SYNTAX      SEQUENCE OF IfEntry
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    ::= { interfaces 2 }
+
+ifEntry OBJECT-TYPE
+    SYNTAX      IfEntry
+    INDEX       { ifIndex }
+    ::= { ifTable 1 }
+
+IfEntry ::= SEQUENCE {
+    ifIndex     InterfaceIndex,
+    ifDescr     DisplayString,
+    ifSpecific  OBJECT IDENTIFIER
+}
+
+ifIndex OBJECT-TYPE
+    SYNTAX      InterfaceIndex (1..2147483647)
+    ::= { ifEntry 1 }
+
+-- a comment with ifDescr OBJECT-TYPE ::= { ifEntry 99 } in it
+ifDescr OBJECT-TYPE
+    SYNTAX      DisplayString (SIZE (0..255)) -- inline -- STATUS current
+    ::= { ifEntry 2 }
+
+ifAdminStatus OBJECT-TYPE
+    SYNTAX  INTEGER { up(1), down(2), testing(3) }
+    DEFVAL  { up }
+    ::= { ifEntry 7 }
+
+ifInOctets OBJECT-TYPE
+    SYNTAX      Counter32
+    ::= { ifEntry 10 }
+
+END
+`
+
+func TestMIBLoadModule(t *testing.T) {
+	mib := NewMIB()
+	assert.NoError(t, mib.LoadModule(strings.NewReader(ifMIBExcerpt)))
+
+	for _, tt := range []struct {
+		oid, name, index string
+	}{
+		{".1.3.6.1.2.1.2.2.1.10.3", "ifInOctets", "3"},
+		{"1.3.6.1.2.1.2.2.1.2.1", "ifDescr", "1"},
+		{".1.3.6.1.2.1.2.2.1.7", "ifAdminStatus", ""},
+		{".1.3.6.1.2.1.31", "ifMIB", ""},
+		{".1.3.6.1.2.1.2.2.1.99.1", "ifEntry", "99.1"},
+		{".1.3.6.1.4.1.9.9", "enterprises", "9.9"},
+		{".3.1", "", "3.1"},
+	} {
+		name, index := mib.TranslateOID(tt.oid)
+		assert.Equal(t, tt.name, name, tt.oid)
+		assert.Equal(t, tt.index, index, tt.oid)
+	}
+	assert.Equal(t, "ifInOctets.3", mib.Name(".1.3.6.1.2.1.2.2.1.10.3"))
+	assert.Equal(t, "ifTable", mib.Name(".1.3.6.1.2.1.2.2"))
+	assert.Equal(t, ".3.1", mib.Name(".3.1"))
+
+	oid, err := mib.TranslateName("IF-MIB::ifInOctets.3")
+	assert.NoError(t, err)
+	assert.Equal(t, ".1.3.6.1.2.1.2.2.1.10.3", oid)
+	oid, err = mib.TranslateName("ifTable")
+	assert.NoError(t, err)
+	assert.Equal(t, ".1.3.6.1.2.1.2.2", oid)
+	oid, err = mib.TranslateName("1.3.6.1")
+	assert.NoError(t, err)
+	assert.Equal(t, ".1.3.6.1", oid)
+	_, err = mib.TranslateName("ifOutOctets.3")
+	assert.Error(t, err)
+	_, err = mib.TranslateName("ifInOctets.x")
+	assert.Error(t, err)
+
+	assert.Error(t, NewMIB().LoadModule(strings.NewReader("foo OBJECT IDENTIFIER ::= { mib-2 ")))
+}
+
+func TestMIBPendingParents(t *testing.T) {
+	// a module loaded before the one defining its parent
+	mib := NewMIB()
+	assert.NoError(t, mib.LoadModule(strings.NewReader(
+		"ucdavis OBJECT IDENTIFIER ::= { enterprises 2021 }\nlaTable OBJECT-TYPE ::= { ucdavis 10 }")))
+	assert.Equal(t, "laTable.1", mib.Name(".1.3.6.1.4.1.2021.10.1"))
+
+	mib = NewMIB()
+	assert.NoError(t, mib.LoadModule(strings.NewReader("laTable OBJECT-TYPE ::= { ucdavis 10 }")))
+	assert.Equal(t, "enterprises.2021.10.1", mib.Name(".1.3.6.1.4.1.2021.10.1"))
+	assert.NoError(t, mib.LoadModule(strings.NewReader("ucdavis OBJECT IDENTIFIER ::= { iso(1) org(3) 6 1 4 1 2021 }")))
+	assert.Equal(t, "laTable.1", mib.Name(".1.3.6.1.4.1.2021.10.1"))
+}
+
+func TestMIBLoadMap(t *testing.T) {
+	mib := NewMIB()
+	assert.NoError(t, mib.LoadMap(strings.NewReader(`# snmptranslate -Tz
+"sysDescr"			"1.3.6.1.2.1.1.1"
+
+sysUpTime .1.3.6.1.2.1.1.3
+`)))
+	assert.Equal(t, "sysUpTime.0", mib.Name(".1.3.6.1.2.1.1.3.0"))
+	assert.Equal(t, "sysDescr.0", mib.Name(".1.3.6.1.2.1.1.1.0"))
+
+	assert.Error(t, mib.LoadMap(strings.NewReader("sysName\n")))
+	assert.Error(t, mib.LoadMap(strings.NewReader("sysName 1.3.x")))
+	assert.Error(t, mib.Add("sys.Name", ".1.3.6.1.2.1.1.5"))
+}
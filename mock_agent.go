@@ -0,0 +1,446 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockFault is a failure MockAgent simulates, see MockAgent.SetFault.
+type MockFault int
+
+const (
+	MockNoFault   MockFault = iota // answer normally
+	MockTimeout                    // do not answer, so requests time out
+	MockTooBig                     // answer with the tooBig error
+	MockAuthError                  // answer with the authorizationError error
+	MockV1Answer                   // answer in SNMPv1 messages, as some old agents answer v2c
+)
+
+// MockAgent is an SNMPv1/v2c agent on a loopback UDP port serving a fixed
+// MIB, for testing code that polls or walks without a real device:
+//
+//	agent, err := gosnmp.NewMockAgent(map[string]gosnmp.SnmpPDU{
+//		".1.3.6.1.2.1.1.5.0": {Type: gosnmp.OctetString, Value: "core-sw1"},
+//		".1.3.6.1.2.1.1.3.0": {Type: gosnmp.TimeTicks, Value: uint32(4200)},
+//	})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer agent.Close()
+//	g, err := gosnmp.NewGoSNMP(agent.Target(), gosnmp.WithPort(agent.Port()))
+//
+// Get answers with the values of the MIB or noSuchObject, GetNext and
+// GetBulk step through it in OID order and report endOfMibView past its
+// end, so walks terminate: a GetBulk is answered row by row, and a
+// repeater past the end has endOfMibView in its place in each row left.
+// Set fails with notWritable. SNMPv3 is not supported. MockAgent is safe
+// for concurrent use.
+type MockAgent struct {
+	conn *net.UDPConn
+	done chan struct{}
+
+	mu        sync.Mutex
+	mib       []SnmpPDU // ordered by name
+	community string
+	fault     MockFault
+	delay     time.Duration
+	maxSize   int
+	requests  int
+}
+
+// NewMockAgent starts a MockAgent serving mib, which maps OIDs to their
+// type and value; the Name of the SnmpPDU values is ignored.
+func NewMockAgent(mib map[string]SnmpPDU) (*MockAgent, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	a := &MockAgent{conn: conn, done: make(chan struct{})}
+	for name, pdu := range mib {
+		pdu.Name = name
+		a.mib = append(a.mib, pdu)
+	}
+	sort.Slice(a.mib, func(i, j int) bool { return oidLess(a.mib[i].Name, a.mib[j].Name) })
+	go a.serve()
+	return a, nil
+}
+
+// Target returns the address of the agent, for GoSNMP.Target.
+func (a *MockAgent) Target() string {
+	return a.conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// Port returns the port of the agent, for GoSNMP.Port.
+func (a *MockAgent) Port() uint16 {
+	return uint16(a.conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// SetCommunity makes the agent ignore requests from other communities, as
+// agents do. By default any community is answered.
+func (a *MockAgent) SetCommunity(community string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.community = community
+}
+
+// SetFault makes the agent simulate fault for the requests that follow,
+// until it is set back to MockNoFault.
+func (a *MockAgent) SetFault(fault MockFault) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fault = fault
+}
+
+// SetDelay makes the agent wait d before each answer, as a slow agent or
+// link would. Requests are still answered concurrently.
+func (a *MockAgent) SetDelay(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.delay = d
+}
+
+// SetMaxSize makes the agent answer tooBig when a response would be
+// longer than octets, as agents do for responses that exceed their
+// message size limit. 0 removes the limit.
+func (a *MockAgent) SetMaxSize(octets int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.maxSize = octets
+}
+
+// Requests returns the number of requests received, answered or not.
+func (a *MockAgent) Requests() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.requests
+}
+
+// Close stops the agent.
+func (a *MockAgent) Close() error {
+	err := a.conn.Close()
+	<-a.done
+	return err
+}
+
+func (a *MockAgent) serve() {
+	defer close(a.done)
+	decoder := &GoSNMP{}
+	buf := make([]byte, rxBufSize)
+	for {
+		n, addr, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		request, err := decoder.SnmpDecodePacket(buf[:n])
+		if err != nil || request.Version == Version3 {
+			continue
+		}
+		response := a.answer(request)
+		if response == nil {
+			continue
+		}
+		msg, err := response.marshalMsg()
+		if err != nil {
+			continue
+		}
+		a.mu.Lock()
+		delay, maxSize := a.delay, a.maxSize
+		a.mu.Unlock()
+		if maxSize > 0 && len(msg) > maxSize {
+			response.Error, response.ErrorIndex = TooBig, 0
+			response.Variables = request.Variables
+			if msg, err = response.marshalMsg(); err != nil {
+				continue
+			}
+		}
+		if delay > 0 {
+			time.AfterFunc(delay, func() { _, _ = a.conn.WriteToUDP(msg, addr) })
+			continue
+		}
+		_, _ = a.conn.WriteToUDP(msg, addr)
+	}
+}
+
+// answer returns the response to request, or nil to not answer it.
+func (a *MockAgent) answer(request *SnmpPacket) *SnmpPacket {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requests++
+	if a.community != "" && request.Community != a.community {
+		return nil
+	}
+
+	response := &SnmpPacket{
+		Version:   request.Version,
+		Community: request.Community,
+		PDUType:   GetResponse,
+		RequestID: request.RequestID,
+		Variables: request.Variables,
+	}
+	if a.fault == MockV1Answer {
+		response.Version = Version1
+	}
+	switch a.fault {
+	case MockTimeout:
+		return nil
+	case MockTooBig:
+		response.Error = TooBig
+		return response
+	case MockAuthError:
+		response.Error = AuthorizationError
+		return response
+	}
+
+	switch request.PDUType {
+	case GetRequest:
+		response.Variables = make([]SnmpPDU, 0, len(request.Variables))
+		for i, v := range request.Variables {
+			pdu, ok := a.get(v.Name)
+			if !ok && request.Version == Version1 {
+				response.Variables = request.Variables
+				response.Error = NoSuchName
+				response.ErrorIndex = uint8(i + 1)
+				return response
+			}
+			response.Variables = append(response.Variables, pdu)
+		}
+	case GetNextRequest, GetBulkRequest:
+		// the non-repeaters once, then a row per repetition with the next
+		// variable of each repeater (RFC 3416 4.2.3)
+		nonRepeaters, reps := len(request.Variables), 1
+		if request.PDUType == GetBulkRequest {
+			nonRepeaters, reps = int(request.NonRepeaters), int(request.MaxRepetitions)
+			if nonRepeaters > len(request.Variables) {
+				nonRepeaters = len(request.Variables)
+			}
+		}
+		names := make([]string, len(request.Variables))
+		for i, v := range request.Variables {
+			names[i] = v.Name
+		}
+		response.Variables = nil
+		step := func(i int) bool {
+			next, ok := a.next(names[i])
+			if !ok {
+				if request.Version == Version1 {
+					// RFC 1157 has no endOfMibView
+					response.Variables = request.Variables
+					response.Error = NoSuchName
+					response.ErrorIndex = uint8(i + 1)
+					return false
+				}
+				next = SnmpPDU{Name: names[i], Type: EndOfMibView}
+			}
+			response.Variables = append(response.Variables, next)
+			names[i] = next.Name
+			return true
+		}
+		for i := 0; i < nonRepeaters; i++ {
+			if !step(i) {
+				return response
+			}
+		}
+		if nonRepeaters < len(request.Variables) {
+			for r := 0; r < reps; r++ {
+				for i := nonRepeaters; i < len(request.Variables); i++ {
+					if !step(i) {
+						return response
+					}
+				}
+			}
+		}
+	case SetRequest:
+		response.Error = NotWritable
+		if request.Version == Version1 {
+			response.Error = ReadOnly
+		}
+		response.ErrorIndex = 1
+	default:
+		return nil
+	}
+	return response
+}
+
+// get returns the variable called name, or noSuchObject. Called with mu
+// held.
+func (a *MockAgent) get(name string) (SnmpPDU, bool) {
+	i := sort.Search(len(a.mib), func(i int) bool { return !oidLess(a.mib[i].Name, name) })
+	if i < len(a.mib) && a.mib[i].Name == name {
+		return a.mib[i], true
+	}
+	return SnmpPDU{Name: name, Type: NoSuchObject}, false
+}
+
+// next returns the variable following name. Called with mu held.
+func (a *MockAgent) next(name string) (SnmpPDU, bool) {
+	i := sort.Search(len(a.mib), func(i int) bool { return oidLess(name, a.mib[i].Name) })
+	if i == len(a.mib) {
+		return SnmpPDU{}, false
+	}
+	return a.mib[i], true
+}
+
+// ParseSnmpwalk reads the output of snmpwalk -On, lines like
+//
+//	.1.3.6.1.2.1.1.5.0 = STRING: "core-sw1"
+//	.1.3.6.1.2.1.2.2.1.8.1 = INTEGER: up(1)
+//	.1.3.6.1.2.1.1.3.0 = Timeticks: (4200) 0:00:42.00
+//
+// into a MIB for NewMockAgent. INTEGER, STRING, Hex-STRING, OID,
+// IpAddress, Counter32, Counter64, Gauge32, Unsigned32 and Timeticks values
+// are understood, as are strings and hex strings continued over several
+// lines. Lines saying there are no more variables or no such object are
+// skipped; other types are an error.
+func ParseSnmpwalk(r io.Reader) (map[string]SnmpPDU, error) {
+	mib := make(map[string]SnmpPDU)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), rxBufSize)
+
+	var name, value string
+	start := 0
+	flush := func() error {
+		if name == "" {
+			return nil
+		}
+		pdu, ok, err := parseSnmpwalkValue(value)
+		if err != nil {
+			return fmt.Errorf("line %d: %s: %w", start, name, err)
+		}
+		if ok {
+			pdu.Name = name
+			mib[name] = pdu
+		}
+		name = ""
+		return nil
+	}
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		oid, rest, found := strings.Cut(text, " = ")
+		if strings.HasPrefix(oid, "iso.") {
+			oid = ".1." + oid[len("iso."):]
+		}
+		if !found || !strings.HasPrefix(oid, ".") {
+			if name == "" {
+				if strings.TrimSpace(text) == "" {
+					continue
+				}
+				return nil, fmt.Errorf("line %d: expected OID = TYPE: VALUE, not %q", line, text)
+			}
+			// a string or hex string continued from the line before
+			value += "\n" + text
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		if rest == "" {
+			rest = `""`
+		}
+		name, value, start = oid, rest, line
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return mib, nil
+}
+
+// parseSnmpwalkValue parses the "TYPE: VALUE" of a line of snmpwalk
+// output, reporting false for the lines that say there is no value.
+func parseSnmpwalkValue(s string) (SnmpPDU, bool, error) {
+	typ, value, found := strings.Cut(s, ": ")
+	if !found {
+		switch {
+		case strings.HasPrefix(s, `"`):
+			// empty strings have no type
+			typ, value = "STRING", s
+		case strings.HasPrefix(s, "No Such"), strings.HasPrefix(s, "No more variables"):
+			return SnmpPDU{}, false, nil
+		case strings.HasSuffix(s, ":"):
+			typ = strings.TrimSuffix(s, ":")
+		default:
+			return SnmpPDU{}, false, fmt.Errorf("no type in %q", s)
+		}
+	}
+	value = strings.TrimSpace(value)
+
+	// number returns the number of an enumeration like up(1), a Timeticks
+	// value like (4200) 0:00:42.00 or a value followed by units
+	number := func() string {
+		if i := strings.LastIndexByte(value, '('); i >= 0 {
+			if j := strings.IndexByte(value[i:], ')'); j > 0 {
+				return value[i+1 : i+j]
+			}
+		}
+		if fields := strings.Fields(value); len(fields) > 0 {
+			return fields[0]
+		}
+		return value
+	}
+
+	switch typ {
+	case "INTEGER":
+		n, err := strconv.ParseInt(number(), 10, 32)
+		return SnmpPDU{Type: Integer, Value: int(n)}, true, err
+	case "STRING":
+		str, err := unquoteSnmpwalk(value)
+		return SnmpPDU{Type: OctetString, Value: []byte(str)}, true, err
+	case "Hex-STRING":
+		b, err := hex.DecodeString(strings.Join(strings.Fields(value), ""))
+		return SnmpPDU{Type: OctetString, Value: b}, true, err
+	case "OID":
+		return SnmpPDU{Type: ObjectIdentifier, Value: value}, true, nil
+	case "IpAddress":
+		if net.ParseIP(value).To4() == nil {
+			return SnmpPDU{}, false, fmt.Errorf("invalid IpAddress %q", value)
+		}
+		return SnmpPDU{Type: IPAddress, Value: value}, true, nil
+	case "Counter32":
+		n, err := strconv.ParseUint(number(), 10, 32)
+		return SnmpPDU{Type: Counter32, Value: uint32(n)}, true, err
+	case "Gauge32", "Unsigned32":
+		n, err := strconv.ParseUint(number(), 10, 32)
+		return SnmpPDU{Type: Gauge32, Value: uint32(n)}, true, err
+	case "Timeticks":
+		n, err := strconv.ParseUint(number(), 10, 32)
+		return SnmpPDU{Type: TimeTicks, Value: uint32(n)}, true, err
+	case "Counter64":
+		n, err := strconv.ParseUint(number(), 10, 64)
+		return SnmpPDU{Type: Counter64, Value: n}, true, err
+	}
+	return SnmpPDU{}, false, fmt.Errorf("unsupported type %s", typ)
+}
+
+// unquoteSnmpwalk undoes the quoting of a STRING value, which net-snmp
+// only escapes double quotes and backslashes in.
+func unquoteSnmpwalk(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, nil
+	}
+	if len(s) < 2 || !strings.HasSuffix(s, `"`) {
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	s = s[1 : len(s)-1]
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import "strings"
+
+// OIDFormat is how the names of the variables of responses are written,
+// see GoSNMP.OIDFormat. Names are always numeric.
+type OIDFormat int
+
+const (
+	// OIDLeadingDot writes names with a leading dot, ".1.3.6.1.2.1.1.5.0",
+	// as net-snmp does. It is the default.
+	OIDLeadingDot OIDFormat = iota
+	// OIDNoLeadingDot writes names without one, "1.3.6.1.2.1.1.5.0".
+	OIDNoLeadingDot
+)
+
+// Format returns oid written in format f.
+func (f OIDFormat) Format(oid string) string {
+	if f == OIDNoLeadingDot {
+		return TrimLeadingDot(oid)
+	}
+	return AddLeadingDot(oid)
+}
+
+// AddLeadingDot returns oid with a leading dot, adding one if it has none.
+func AddLeadingDot(oid string) string {
+	if oid == "" || strings.HasPrefix(oid, ".") {
+		return oid
+	}
+	return "." + oid
+}
+
+// TrimLeadingDot returns oid without its leading dot, if any.
+func TrimLeadingDot(oid string) string {
+	return strings.TrimPrefix(oid, ".")
+}
+
+// formatNames rewrites the names of the variables of result, as decoded
+// with a leading dot, in x.OIDFormat.
+func (x *GoSNMP) formatNames(result *SnmpPacket) *SnmpPacket {
+	if result == nil || x.OIDFormat == OIDLeadingDot {
+		return result
+	}
+	for i := range result.Variables {
+		result.Variables[i].Name = x.OIDFormat.Format(result.Variables[i].Name)
+	}
+	return result
+}
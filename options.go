@@ -0,0 +1,311 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Option configures a GoSNMP built by NewGoSNMP.
+type Option func(*GoSNMP) error
+
+// NewGoSNMP returns a GoSNMP for target with the defaults of Default (port
+// 161 over udp, community "public", SNMPv2c, a 2 second timeout, 3 retries
+// with exponential timeout and MaxOids) changed by opts, and validated. Call
+// Connect() before use.
+//
+//	g, err := gosnmp.NewGoSNMP("192.0.2.1",
+//		gosnmp.WithV3User("ops", gosnmp.SHA256, "authpass", gosnmp.AES, "privpass"),
+//		gosnmp.WithTimeout(5*time.Second))
+//
+// Filling in a GoSNMP directly is still supported.
+func NewGoSNMP(target string, opts ...Option) (*GoSNMP, error) {
+	if target == "" {
+		return nil, errors.New("target must be set")
+	}
+	x := &GoSNMP{
+		Target:             target,
+		Port:               Default.Port,
+		Transport:          Default.Transport,
+		Community:          Default.Community,
+		Version:            Default.Version,
+		Timeout:            Default.Timeout,
+		Retries:            Default.Retries,
+		ExponentialTimeout: Default.ExponentialTimeout,
+		MaxOids:            Default.MaxOids,
+	}
+	for _, opt := range opts {
+		if err := opt(x); err != nil {
+			return nil, err
+		}
+	}
+	if err := x.validateParameters(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// WithPort sets the agent's port.
+func WithPort(port uint16) Option {
+	return func(x *GoSNMP) error {
+		if port == 0 {
+			return errors.New("port must not be 0")
+		}
+		x.Port = port
+		return nil
+	}
+}
+
+// WithTransport sets the transport, eg "udp", "tcp" or "tls".
+func WithTransport(transport string) Option {
+	return func(x *GoSNMP) error {
+		x.Transport = transport
+		return nil
+	}
+}
+
+// WithLocalAddr sets the local address to send from, eg "192.0.2.10" or
+// "192.0.2.10:5000". See LocalAddr.
+func WithLocalAddr(localAddr string) Option {
+	return func(x *GoSNMP) error {
+		if localAddr == "" {
+			return errors.New("local address must not be empty")
+		}
+		x.LocalAddr = localAddr
+		return nil
+	}
+}
+
+// WithCommunity sets the SNMPv1/v2c community.
+func WithCommunity(community string) Option {
+	return func(x *GoSNMP) error {
+		x.Community = community
+		return nil
+	}
+}
+
+// WithCommunityBytes sets the SNMPv1/v2c community to octets that need
+// not be text, such as non-ASCII or NUL octets.
+func WithCommunityBytes(community []byte) Option {
+	return func(x *GoSNMP) error {
+		x.Community = string(community)
+		return nil
+	}
+}
+
+// WithVersion sets the SNMP version.
+func WithVersion(version SnmpVersion) Option {
+	return func(x *GoSNMP) error {
+		switch version {
+		case Version1, Version2c, Version3:
+		default:
+			return fmt.Errorf("unknown SNMP version %d", version)
+		}
+		x.Version = version
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout for one request/response, which must be
+// positive.
+func WithTimeout(timeout time.Duration) Option {
+	return func(x *GoSNMP) error {
+		if timeout <= 0 {
+			return fmt.Errorf("timeout must be positive, not %s", timeout)
+		}
+		x.Timeout = timeout
+		return nil
+	}
+}
+
+// WithRetries sets the number of retries after the first attempt.
+func WithRetries(retries int) Option {
+	return func(x *GoSNMP) error {
+		if retries < 0 {
+			return fmt.Errorf("retries cannot be less than 0, not %d", retries)
+		}
+		x.Retries = retries
+		return nil
+	}
+}
+
+// WithDiscoveryRetries sets the number of retries of SNMPv3 engine
+// discovery, 0 for those of requests. See GoSNMP.DiscoveryRetries.
+func WithDiscoveryRetries(retries int) Option {
+	return func(x *GoSNMP) error {
+		if retries < 0 {
+			return fmt.Errorf("discovery retries cannot be less than 0, not %d", retries)
+		}
+		x.DiscoveryRetries = retries
+		return nil
+	}
+}
+
+// WithMaxOids sets the maximum number of oids in a Get.
+func WithMaxOids(maxOids int) Option {
+	return func(x *GoSNMP) error {
+		if maxOids <= 0 {
+			return fmt.Errorf("MaxOids must be positive, not %d", maxOids)
+		}
+		x.MaxOids = maxOids
+		return nil
+	}
+}
+
+// WithMaxRepetitions sets the GETBULK max-repetitions used by BulkWalk*.
+func WithMaxRepetitions(maxRepetitions uint32) Option {
+	return func(x *GoSNMP) error {
+		x.MaxRepetitions = maxRepetitions
+		return nil
+	}
+}
+
+// WithAdaptiveBulkSize makes BulkWalk* adapt max-repetitions to keep
+// responses within octets. See GoSNMP.AdaptiveBulkSize.
+func WithAdaptiveBulkSize(octets int) Option {
+	return func(x *GoSNMP) error {
+		if octets <= 0 {
+			return fmt.Errorf("adaptive bulk size must be positive, not %d", octets)
+		}
+		x.AdaptiveBulkSize = octets
+		return nil
+	}
+}
+
+// WithOIDCheck sets what the walks do with OIDs that do not increase. See
+// GoSNMP.OIDCheck.
+func WithOIDCheck(policy OIDCheckPolicy) Option {
+	return func(x *GoSNMP) error {
+		if policy < OIDCheckStrict || policy > OIDCheckOff {
+			return fmt.Errorf("unknown OID check policy %d", policy)
+		}
+		x.OIDCheck = policy
+		return nil
+	}
+}
+
+// WithOIDFormat sets how the names of the variables of responses are
+// written. See GoSNMP.OIDFormat.
+func WithOIDFormat(format OIDFormat) Option {
+	return func(x *GoSNMP) error {
+		if format < OIDLeadingDot || format > OIDNoLeadingDot {
+			return fmt.Errorf("unknown OID format %d", format)
+		}
+		x.OIDFormat = format
+		return nil
+	}
+}
+
+// WithContext sets the context for overall deadlines and cancellation.
+func WithContext(ctx context.Context) Option {
+	return func(x *GoSNMP) error {
+		if ctx == nil {
+			return errors.New("context must not be nil")
+		}
+		x.Context = ctx
+		return nil
+	}
+}
+
+// WithLogger sets the logger.
+func WithLogger(logger Logger) Option {
+	return func(x *GoSNMP) error {
+		x.Logger = logger
+		return nil
+	}
+}
+
+// WithV3User switches to SNMPv3 with the User Security Model. The security
+// level follows from the protocols: NoAuth gives noAuthNoPriv, NoPriv
+// authNoPriv and otherwise authPriv.
+func WithV3User(userName string, authProtocol SnmpV3AuthProtocol, authPassphrase string,
+	privProtocol SnmpV3PrivProtocol, privPassphrase string) Option {
+	return func(x *GoSNMP) error {
+		if userName == "" {
+			return errors.New("SNMPv3 user name must be set")
+		}
+		if authProtocol == 0 {
+			authProtocol = NoAuth
+		}
+		if privProtocol == 0 {
+			privProtocol = NoPriv
+		}
+		flags := NoAuthNoPriv
+		switch {
+		case authProtocol == NoAuth && privProtocol != NoPriv:
+			return errors.New("SNMPv3 privacy requires authentication")
+		case privProtocol != NoPriv:
+			flags = AuthPriv
+		case authProtocol != NoAuth:
+			flags = AuthNoPriv
+		}
+		x.Version = Version3
+		x.SecurityModel = UserSecurityModel
+		x.MsgFlags = flags
+		x.SecurityParameters = &UsmSecurityParameters{
+			UserName:                 userName,
+			AuthenticationProtocol:   authProtocol,
+			AuthenticationPassphrase: authPassphrase,
+			PrivacyProtocol:          privProtocol,
+			PrivacyPassphrase:        privPassphrase,
+		}
+		return nil
+	}
+}
+
+// WithV3Engine sets the agent's snmpEngineID, snmpEngineBoots and
+// snmpEngineTime, eg kept from an earlier poll or provisioned, so that
+// requests are sent without engine discovery. Should they be out of date
+// the agent's notInTimeWindow or unknownEngineID report replaces them and
+// the request is sent again. It must follow WithV3User.
+func WithV3Engine(engineID string, boots, engineTime uint32) Option {
+	return func(x *GoSNMP) error {
+		sp, ok := x.SecurityParameters.(*UsmSecurityParameters)
+		if !ok {
+			return errors.New("WithV3Engine needs the User Security Model, see WithV3User")
+		}
+		if engineID == "" {
+			return errors.New("SNMPv3 engine ID must be set")
+		}
+		sp.AuthoritativeEngineID = engineID
+		sp.AuthoritativeEngineBoots = boots
+		sp.AuthoritativeEngineTime = engineTime
+		return nil
+	}
+}
+
+// WithV3Context sets the SNMPv3 contextEngineID and contextName of the
+// scopedPDU. An empty contextEngineID is filled in by engine discovery.
+func WithV3Context(contextEngineID, contextName string) Option {
+	return func(x *GoSNMP) error {
+		x.ContextEngineID = contextEngineID
+		x.ContextName = contextName
+		return nil
+	}
+}
+
+// WithLenientDecoding keeps the variables of a response that decode when
+// some others do not. See GoSNMP.LenientDecoding.
+func WithLenientDecoding() Option {
+	return func(x *GoSNMP) error {
+		x.LenientDecoding = true
+		return nil
+	}
+}
+
+// WithRateLimit limits the requests sent to perSecond per second on
+// average, in bursts of up to burst. See RateLimiter.
+func WithRateLimit(perSecond float64, burst int) Option {
+	return func(x *GoSNMP) error {
+		if perSecond <= 0 {
+			return fmt.Errorf("rate limit must be positive, not %g", perSecond)
+		}
+		x.RateLimiter = NewRateLimiter(perSecond, burst)
+		return nil
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Pool keeps connected GoSNMP sessions for reuse, up to a maximum per
+// target. Get checks a session out, creating and connecting one if none is
+// idle, and Put checks it back in:
+//
+//	pool := gosnmp.NewPool(func(target string) (*gosnmp.GoSNMP, error) {
+//		return gosnmp.NewGoSNMP(target, gosnmp.WithCommunity("private"))
+//	}, 4, time.Minute)
+//	defer pool.Close()
+//
+//	g, err := pool.Get(ctx, "192.0.2.1")
+//	if err != nil {
+//		return err
+//	}
+//	result, err := g.GetContext(ctx, oids)
+//	pool.Put(g, err)
+//
+// A session is used by one caller at a time. Sessions whose last request
+// failed are closed instead of kept, and those idle for longer than the
+// idle timeout are closed by a sweep run every idle timeout, as well as
+// whenever their target's sessions are checked out or in. SNMPv3
+// sessions share the pool's EngineCache unless they have their own, so
+// the agent's engine is discovered once per target and its time kept in
+// step between connections.
+//
+// Pool is safe for concurrent use.
+type Pool struct {
+	newSession   func(target string) (*GoSNMP, error)
+	maxPerTarget int
+	idleTimeout  time.Duration
+	engines      *EngineCache
+
+	mu      sync.Mutex
+	closed  bool
+	done    chan struct{} // closed by Close, ending the sweep and waking Gets
+	targets map[string]*poolTarget
+	owners  map[*GoSNMP]*poolTarget
+}
+
+type poolTarget struct {
+	slots chan struct{} // one per session checked out
+	idle  []idleSession // most recently used last
+}
+
+type idleSession struct {
+	g     *GoSNMP
+	since time.Time
+}
+
+// NewPool returns a Pool that creates sessions with newSession, which
+// returns an unconnected GoSNMP for target, keeps at most maxPerTarget
+// sessions per target (0 for no limit) and closes sessions idle for longer
+// than idleTimeout (0 to keep them).
+func NewPool(newSession func(target string) (*GoSNMP, error), maxPerTarget int, idleTimeout time.Duration) *Pool {
+	p := &Pool{
+		newSession:   newSession,
+		maxPerTarget: maxPerTarget,
+		idleTimeout:  idleTimeout,
+		engines:      NewEngineCache(),
+		targets:      make(map[string]*poolTarget),
+		owners:       make(map[*GoSNMP]*poolTarget),
+		done:         make(chan struct{}),
+	}
+	if idleTimeout > 0 {
+		go p.sweep()
+	}
+	return p
+}
+
+// ErrPoolClosed is returned by Pool.Get after Close.
+var ErrPoolClosed = errors.New("gosnmp: pool is closed")
+
+// Get checks out a connected session for target, waiting until ctx is done
+// for one to be put back if target has maxPerTarget sessions checked out.
+// Gets waiting when the pool is closed return ErrPoolClosed.
+func (p *Pool) Get(ctx context.Context, target string) (*GoSNMP, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	t := p.targets[target]
+	if t == nil {
+		t = &poolTarget{}
+		if p.maxPerTarget > 0 {
+			t.slots = make(chan struct{}, p.maxPerTarget)
+		}
+		p.targets[target] = t
+	}
+	p.mu.Unlock()
+
+	if t.slots != nil {
+		select {
+		case t.slots <- struct{}{}:
+		case <-p.done:
+			return nil, ErrPoolClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// the pool may have closed while waiting for the slot
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		p.release(t)
+		return nil, ErrPoolClosed
+	}
+
+	if g := p.takeIdle(t); g != nil {
+		return g, nil
+	}
+	g, err := p.connect(target)
+	if err != nil {
+		p.release(t)
+		return nil, err
+	}
+	p.mu.Lock()
+	if p.closed {
+		// or while connecting
+		p.mu.Unlock()
+		_ = g.Close()
+		p.release(t)
+		return nil, ErrPoolClosed
+	}
+	p.owners[g] = t
+	p.mu.Unlock()
+	return g, nil
+}
+
+// Put checks g back in. err is the result of the last request made with g:
+// if it is not nil g is closed rather than reused.
+func (p *Pool) Put(g *GoSNMP, err error) {
+	p.mu.Lock()
+	t, ok := p.owners[g]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	expired := p.expireIdle(t, time.Now())
+	if err != nil || p.closed {
+		delete(p.owners, g)
+		expired = append(expired, g)
+	} else {
+		t.idle = append(t.idle, idleSession{g: g, since: time.Now()})
+	}
+	p.mu.Unlock()
+	closeSessions(expired)
+	p.release(t)
+}
+
+// Close closes the idle sessions, and those checked out as they are put
+// back. Get fails from then on.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		close(p.done)
+	}
+	p.closed = true
+	var err error
+	for _, t := range p.targets {
+		for _, s := range t.idle {
+			delete(p.owners, s.g)
+			if cerr := s.g.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+		t.idle = nil
+	}
+	return err
+}
+
+// takeIdle returns the most recently used idle session of t, closing any
+// that have been idle for too long.
+func (p *Pool) takeIdle(t *poolTarget) *GoSNMP {
+	p.mu.Lock()
+	expired := p.expireIdle(t, time.Now())
+	var g *GoSNMP
+	if len(t.idle) > 0 {
+		g = t.idle[len(t.idle)-1].g
+		t.idle = t.idle[:len(t.idle)-1]
+	}
+	p.mu.Unlock()
+	closeSessions(expired)
+	return g
+}
+
+// expireIdle removes the sessions of t idle for longer than the idle
+// timeout at now, and returns them for the caller to close once p.mu is
+// released. p.mu must be held.
+func (p *Pool) expireIdle(t *poolTarget, now time.Time) []*GoSNMP {
+	if p.idleTimeout <= 0 {
+		return nil
+	}
+	// t.idle is in the order sessions were put back, so the expired ones
+	// come first
+	n := 0
+	for n < len(t.idle) && now.Sub(t.idle[n].since) > p.idleTimeout {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	expired := make([]*GoSNMP, n)
+	for i, s := range t.idle[:n] {
+		delete(p.owners, s.g)
+		expired[i] = s.g
+	}
+	t.idle = append(t.idle[:0], t.idle[n:]...)
+	return expired
+}
+
+// sweep closes expired idle sessions every idle timeout, including those
+// of targets no longer polled, until Close.
+func (p *Pool) sweep() {
+	ticker := time.NewTicker(p.idleTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			var expired []*GoSNMP
+			p.mu.Lock()
+			for _, t := range p.targets {
+				expired = append(expired, p.expireIdle(t, now)...)
+			}
+			p.mu.Unlock()
+			closeSessions(expired)
+		}
+	}
+}
+
+func closeSessions(sessions []*GoSNMP) {
+	for _, g := range sessions {
+		_ = g.Close()
+	}
+}
+
+func (p *Pool) connect(target string) (*GoSNMP, error) {
+	g, err := p.newSession(target)
+	if err != nil {
+		return nil, err
+	}
+	if g.Version == Version3 && g.EngineCache == nil {
+		g.EngineCache = p.engines
+	}
+	if err = g.Connect(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (p *Pool) release(t *poolTarget) {
+	if t.slots != nil {
+		<-t.slots
+	}
+}
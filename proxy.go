@@ -0,0 +1,161 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"context"
+	"fmt"
+)
+
+// Forward is ForwardContext bound to upstream.Context.
+func Forward(req *SnmpPacket, upstream *GoSNMP) (*SnmpPacket, error) {
+	return ForwardContext(upstream.Context, req, upstream)
+}
+
+// ForwardContext relays req, an SNMPv1 or SNMPv2c request received by a
+// proxy, to the agent of upstream, which may use any version and security
+// model, and returns the response to send back, eg:
+//
+//	front := &gosnmp.GoSNMP{}
+//	for {
+//		n, addr, err := conn.ReadFrom(buf)
+//		if err != nil {
+//			return err
+//		}
+//		req, err := front.SnmpDecodePacket(buf[:n])
+//		if err != nil || req.Community != community {
+//			continue
+//		}
+//		resp, err := gosnmp.Forward(req, upstream)
+//		if err != nil {
+//			continue // as an agent that does not answer
+//		}
+//		if msg, err := resp.MarshalMsg(); err == nil {
+//			conn.WriteTo(msg, addr)
+//		}
+//	}
+//
+// The response has the version, community and request-id of req, and the
+// variables and error-status of the upstream response. For an SNMPv1 req
+// these are translated as RFC 3584 describes: the SNMPv2 error-statuses
+// become their SNMPv1 counterparts, a GetNext is sent again past any
+// Counter64 variable in the response, and a noSuchObject, noSuchInstance,
+// endOfMibView or, for other requests, Counter64 variable becomes
+// noSuchName for that variable. A GetBulk req is relayed to an SNMPv1
+// upstream as a GetNext, which answers it with one repetition. A req with
+// more variables than upstream sends at once, see MaxOids, is answered
+// with tooBig.
+//
+// An error is returned for requests that cannot be relayed and when
+// upstream gets no response, eg on timeout.
+func ForwardContext(ctx context.Context, req *SnmpPacket, upstream *GoSNMP) (*SnmpPacket, error) {
+	if req.Version == Version3 {
+		return nil, fmt.Errorf("cannot forward %s requests", req.Version)
+	}
+	response := &SnmpPacket{
+		Version:   req.Version,
+		Community: req.Community,
+		PDUType:   GetResponse,
+		RequestID: req.RequestID,
+		Logger:    req.Logger,
+	}
+	if len(req.Variables) > upstream.MaxOids && (req.PDUType != GetRequest || upstream.MaxPDUSize == 0) {
+		// as an agent whose response would not fit in a message
+		response.Error = TooBig
+		response.Variables = req.Variables
+		return response, nil
+	}
+	oids := make([]string, len(req.Variables))
+	for i, v := range req.Variables {
+		oids[i] = v.Name
+	}
+
+	var result *SnmpPacket
+	var err error
+	switch req.PDUType {
+	case GetRequest:
+		result, err = upstream.GetContext(ctx, oids)
+	case GetNextRequest:
+		if req.Version == Version1 {
+			result, err = getNextV1(ctx, upstream, oids)
+		} else {
+			result, err = upstream.GetNextContext(ctx, oids)
+		}
+	case GetBulkRequest:
+		if upstream.Version == Version1 {
+			result, err = upstream.GetNextContext(ctx, oids)
+		} else {
+			result, err = upstream.GetBulkContext(ctx, oids, req.NonRepeaters, req.MaxRepetitions)
+		}
+	case SetRequest:
+		result, err = upstream.SetContext(ctx, req.Variables)
+	default:
+		return nil, fmt.Errorf("cannot forward %s", req.PDUType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response.Error = result.Error
+	response.ErrorIndex = result.ErrorIndex
+	response.Variables = result.Variables
+	if req.Version == Version1 {
+		toV1Response(response, req)
+	}
+	return response, nil
+}
+
+// getNextV1 is GetNext for an SNMPv1 request, which cannot carry Counter64
+// values: while the response has any, it is sent again with their names in
+// place of the OIDs they follow (RFC 3584 section 4.2.2.1).
+func getNextV1(ctx context.Context, upstream *GoSNMP, oids []string) (*SnmpPacket, error) {
+	for {
+		result, err := upstream.GetNextContext(ctx, oids)
+		if err != nil || result.Error != NoError {
+			return result, err
+		}
+		again := false
+		for i, v := range result.Variables {
+			if v.Type == Counter64 && i < len(oids) {
+				oids[i] = v.Name
+				again = true
+			}
+		}
+		if !again {
+			return result, nil
+		}
+	}
+}
+
+// toV1Response translates response, to req, into what SNMPv1 can express
+// (RFC 3584 section 4.4).
+func toV1Response(response, req *SnmpPacket) {
+	response.Error = v1ErrorStatus(response.Error)
+	if response.Error != NoError {
+		return
+	}
+	for i, v := range response.Variables {
+		if v.IsException() || v.Type == Counter64 {
+			// SNMPv1 agents answer noSuchName with the request's variables
+			response.Error = NoSuchName
+			response.ErrorIndex = uint8(i + 1)
+			response.Variables = req.Variables
+			return
+		}
+	}
+}
+
+// v1ErrorStatus returns the SNMPv1 error-status for an SNMPv2 one.
+func v1ErrorStatus(e SNMPError) SNMPError {
+	switch e {
+	case WrongValue, WrongEncoding, WrongType, WrongLength, InconsistentValue:
+		return BadValue
+	case NoAccess, NotWritable, NoCreation, InconsistentName, AuthorizationError:
+		return NoSuchName
+	case ResourceUnavailable, CommitFailed, UndoFailed:
+		return GenErr
+	}
+	return e
+}
@@ -0,0 +1,130 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// SetBuilder builds the variables of a SET request with the type the MIB
+// gives each of them:
+//
+//	result, err := g.SetMany(gosnmp.SetVars().
+//		AddString(".1.3.6.1.2.1.1.5.0", "core-sw1").
+//		AddInt(".1.3.6.1.2.1.2.2.1.7.3", 2).
+//		AddGauge32(".1.3.6.1.4.1.2021.8.1.100.1", 50))
+//
+// An Integer and a Gauge32 of the same value encode differently, and agents
+// reject a SET whose type does not match the object's syntax with
+// wrongType, so use the method for the object's syntax.
+type SetBuilder struct {
+	pdus []SnmpPDU
+}
+
+// SetVars returns an empty SetBuilder.
+func SetVars() *SetBuilder {
+	return &SetBuilder{}
+}
+
+func (b *SetBuilder) add(oid string, t Asn1BER, value interface{}) *SetBuilder {
+	b.pdus = append(b.pdus, SnmpPDU{Name: oid, Type: t, Value: value})
+	return b
+}
+
+// AddInt adds an INTEGER (Integer32 or an enumeration) value.
+func (b *SetBuilder) AddInt(oid string, value int) *SetBuilder {
+	return b.add(oid, Integer, value)
+}
+
+// AddString adds an OCTET STRING value, eg a DisplayString.
+func (b *SetBuilder) AddString(oid string, value string) *SetBuilder {
+	return b.add(oid, OctetString, value)
+}
+
+// AddBytes adds an OCTET STRING value given as octets, eg a MacAddress.
+func (b *SetBuilder) AddBytes(oid string, value []byte) *SetBuilder {
+	return b.add(oid, OctetString, value)
+}
+
+// AddBitString adds a BIT STRING value.
+func (b *SetBuilder) AddBitString(oid string, value BitStringValue) *SetBuilder {
+	return b.add(oid, BitString, value)
+}
+
+// AddOID adds an OBJECT IDENTIFIER value in dotted form.
+func (b *SetBuilder) AddOID(oid string, value string) *SetBuilder {
+	return b.add(oid, ObjectIdentifier, value)
+}
+
+// AddIPAddress adds an IpAddress value in dotted quad form.
+func (b *SetBuilder) AddIPAddress(oid string, value string) *SetBuilder {
+	return b.add(oid, IPAddress, value)
+}
+
+// AddIP adds an IpAddress value.
+func (b *SetBuilder) AddIP(oid string, value net.IP) *SetBuilder {
+	return b.add(oid, IPAddress, value)
+}
+
+// AddCounter32 adds a Counter32 value.
+func (b *SetBuilder) AddCounter32(oid string, value uint32) *SetBuilder {
+	return b.add(oid, Counter32, value)
+}
+
+// AddGauge32 adds a Gauge32 (or Unsigned32) value.
+func (b *SetBuilder) AddGauge32(oid string, value uint32) *SetBuilder {
+	return b.add(oid, Gauge32, value)
+}
+
+// AddTimeTicks adds a TimeTicks value, in hundredths of a second.
+func (b *SetBuilder) AddTimeTicks(oid string, value uint32) *SetBuilder {
+	return b.add(oid, TimeTicks, value)
+}
+
+// AddCounter64 adds a Counter64 value.
+func (b *SetBuilder) AddCounter64(oid string, value uint64) *SetBuilder {
+	return b.add(oid, Counter64, value)
+}
+
+// AddOpaque adds an Opaque value given as the BER encoding it wraps.
+func (b *SetBuilder) AddOpaque(oid string, value []byte) *SetBuilder {
+	return b.add(oid, Opaque, value)
+}
+
+// AddFloat adds an Opaque float value.
+func (b *SetBuilder) AddFloat(oid string, value float32) *SetBuilder {
+	return b.add(oid, OpaqueFloat, value)
+}
+
+// AddDouble adds an Opaque double value.
+func (b *SetBuilder) AddDouble(oid string, value float64) *SetBuilder {
+	return b.add(oid, OpaqueDouble, value)
+}
+
+// PDUs returns the variables added so far, for use with Set.
+func (b *SetBuilder) PDUs() []SnmpPDU {
+	return append([]SnmpPDU(nil), b.pdus...)
+}
+
+// SetMany sends an SNMP SET request for the variables of vars, which the
+// agent applies all or none of.
+func (x *GoSNMP) SetMany(vars *SetBuilder) (result *SnmpPacket, err error) {
+	return x.SetManyContext(x.Context, vars)
+}
+
+// SetManyContext is SetMany bound to ctx instead of x.Context.
+func (x *GoSNMP) SetManyContext(ctx context.Context, vars *SetBuilder) (result *SnmpPacket, err error) {
+	if vars == nil || len(vars.pdus) == 0 {
+		return nil, errors.New("SetMany needs at least one variable")
+	}
+	if len(vars.pdus) > x.MaxOids {
+		return nil, fmt.Errorf("oid count (%d) is greater than MaxOids (%d)",
+			len(vars.pdus), x.MaxOids)
+	}
+	return x.SetContext(ctx, vars.PDUs())
+}
@@ -0,0 +1,313 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SharedConn lets the GoSNMP sessions of many agents share one UDP socket,
+// so that a poller of thousands of agents does not hold a socket for each:
+//
+//	pc, err := net.ListenPacket("udp4", ":0")
+//	if err != nil {
+//		return err
+//	}
+//	shared := gosnmp.NewSharedConn(pc)
+//	defer shared.Close()
+//
+//	for _, target := range targets {
+//		g, err := gosnmp.NewGoSNMP(target)
+//		if err != nil {
+//			return err
+//		}
+//		if err = shared.Connect(g); err != nil {
+//			return err
+//		}
+//		// use g as usual, and g.Close() it when done
+//	}
+//
+// Datagrams are handed to the session of the address they come from. When
+// several sessions talk to one address, a datagram goes to the one that
+// sent its request-id (SNMPv1/v2c) or msgID (SNMPv3). Each session still
+// discards responses it did not ask for, exactly as on its own socket.
+//
+// SharedConn is safe for concurrent use.
+type SharedConn struct {
+	conn net.PacketConn
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	peers  map[netip.AddrPort][]*sharedPeer
+}
+
+// NewSharedConn returns a SharedConn reading and writing conn, which it
+// owns from then on.
+func NewSharedConn(conn net.PacketConn) *SharedConn {
+	s := &SharedConn{
+		conn:  conn,
+		done:  make(chan struct{}),
+		peers: make(map[netip.AddrPort][]*sharedPeer),
+	}
+	go s.read()
+	return s
+}
+
+// Connect readies x for requests to its Target and Port over the shared
+// socket, in place of x.Connect(). x must use the udp transport. Closing x
+// detaches it from the socket, which stays open for the other sessions.
+func (s *SharedConn) Connect(x *GoSNMP) error {
+	if x.Transport != "" && !strings.HasPrefix(x.Transport, udp) {
+		return errors.New("a SharedConn only carries the udp transport")
+	}
+	raddr, err := net.ResolveUDPAddr(udp, x.targetAddr())
+	if err != nil {
+		return err
+	}
+	ap := raddr.AddrPort()
+	key := netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+	p := &sharedPeer{
+		shared:  s,
+		key:     key,
+		raddr:   raddr,
+		in:      make(chan []byte, 16),
+		closing: make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.peers[key] = append(s.peers[key], p)
+	s.mu.Unlock()
+
+	if err = x.ConnectConn(p); err != nil {
+		p.Close()
+		return err
+	}
+	return nil
+}
+
+// LocalAddr returns the local address of the shared socket.
+func (s *SharedConn) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Close closes the socket. Requests of the sessions still using it fail
+// with net.ErrClosed.
+func (s *SharedConn) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.mu.Unlock()
+	return s.conn.Close()
+}
+
+func (s *SharedConn) read() {
+	buf := make([]byte, rxBufSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				s.Close()
+				return
+			}
+			// eg an ICMP port unreachable for one agent
+			continue
+		}
+		uaddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		ap := uaddr.AddrPort()
+		s.deliver(netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()), append([]byte(nil), buf[:n]...))
+	}
+}
+
+// deliver hands msg, from key, to the session it is for. It is dropped if
+// that session is not reading fast enough, as a socket buffer would.
+func (s *SharedConn) deliver(key netip.AddrPort, msg []byte) {
+	s.mu.Lock()
+	peers := s.peers[key]
+	var to *sharedPeer
+	switch len(peers) {
+	case 0:
+	case 1:
+		to = peers[0]
+	default:
+		to = peers[0]
+		if id, ok := peekMessageID(msg); ok {
+			for _, p := range peers {
+				if p.sent(id) {
+					to = p
+					break
+				}
+			}
+		}
+	}
+	s.mu.Unlock()
+	if to == nil {
+		return
+	}
+	select {
+	case to.in <- msg:
+	default:
+	}
+}
+
+func (s *SharedConn) detach(p *sharedPeer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := s.peers[p.key]
+	for i, q := range peers {
+		if q == p {
+			peers = append(peers[:i], peers[i+1:]...)
+			break
+		}
+	}
+	if len(peers) == 0 {
+		delete(s.peers, p.key)
+	} else {
+		s.peers[p.key] = peers
+	}
+}
+
+// sharedPeer is the net.Conn of one session on a SharedConn.
+type sharedPeer struct {
+	shared  *SharedConn
+	key     netip.AddrPort
+	raddr   *net.UDPAddr
+	in      chan []byte
+	closing chan struct{}
+
+	mu       sync.Mutex
+	closed   bool
+	deadline time.Time
+	changed  chan struct{} // closed when deadline is set
+	ids      [64]uint32    // IDs of the latest requests, for deliver
+	next     int
+}
+
+func (p *sharedPeer) Read(b []byte) (int, error) {
+	for {
+		p.mu.Lock()
+		deadline, changed := p.deadline, p.changed
+		p.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+		n, err, again := 0, error(nil), false
+		select {
+		case msg := <-p.in:
+			n = copy(b, msg)
+		case <-timeout:
+			err = os.ErrDeadlineExceeded
+		case <-changed:
+			again = true
+		case <-p.closing:
+			err = net.ErrClosed
+		case <-p.shared.done:
+			err = net.ErrClosed
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if !again {
+			return n, err
+		}
+	}
+}
+
+func (p *sharedPeer) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	if id, ok := peekMessageID(b); ok {
+		p.ids[p.next] = id
+		p.next = (p.next + 1) % len(p.ids)
+	}
+	p.mu.Unlock()
+	return p.shared.conn.WriteTo(b, p.raddr)
+}
+
+// sent reports whether id is that of one of the latest requests of p.
+func (p *sharedPeer) sent(id uint32) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, sent := range p.ids {
+		if sent == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *sharedPeer) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.closing)
+	p.mu.Unlock()
+	p.shared.detach(p)
+	return nil
+}
+
+func (p *sharedPeer) LocalAddr() net.Addr {
+	return p.shared.conn.LocalAddr()
+}
+
+func (p *sharedPeer) RemoteAddr() net.Addr {
+	return p.raddr
+}
+
+func (p *sharedPeer) SetDeadline(t time.Time) error {
+	return p.SetReadDeadline(t)
+}
+
+func (p *sharedPeer) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deadline = t
+	close(p.changed)
+	p.changed = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline does nothing: writes to the shared socket do not block
+// on one agent.
+func (p *sharedPeer) SetWriteDeadline(t time.Time) error {
+	return nil
+}
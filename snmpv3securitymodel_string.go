@@ -9,11 +9,12 @@ func _() {
 	// Re-run the stringer command to generate them again.
 	var x [1]struct{}
 	_ = x[UserSecurityModel-3]
+	_ = x[TransportSecurityModel-4]
 }
 
-const _SnmpV3SecurityModel_name = "UserSecurityModel"
+const _SnmpV3SecurityModel_name = "UserSecurityModelTransportSecurityModel"
 
-var _SnmpV3SecurityModel_index = [...]uint8{0, 17}
+var _SnmpV3SecurityModel_index = [...]uint8{0, 17, 39}
 
 func (i SnmpV3SecurityModel) String() string {
 	i -= 3
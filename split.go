@@ -0,0 +1,152 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// v3HeaderAllowance is the room left in a request for the SNMPv3 header,
+// security parameters, scoped PDU context and encryption padding when
+// splitting by MaxPDUSize: more than a USM header with 32 octet engine ID
+// and user name and a 48 octet HMAC-SHA-512 digest needs.
+const v3HeaderAllowance = 256
+
+// getSplit implements Get when MaxPDUSize is set: oids are sent in as many
+// requests as needed to stay within MaxOids and MaxPDUSize, requests the
+// agent answers with tooBig are split in half and resent, and the results
+// are merged in the order of oids.
+func (x *GoSNMP) getSplit(ctx context.Context, oids []string) (*SnmpPacket, error) {
+	pdus := make([]SnmpPDU, 0, len(oids))
+	for _, oid := range oids {
+		pdus = append(pdus, SnmpPDU{Name: oid, Type: Null, Value: nil})
+	}
+	chunks, err := x.splitPDUs(pdus)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *SnmpPacket
+	offset := 0
+	for _, chunk := range chunks {
+		response, err := x.getChunk(ctx, chunk)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = response
+			result.Variables = append(make([]SnmpPDU, 0, len(pdus)), response.Variables...)
+		} else {
+			result.Variables = append(result.Variables, response.Variables...)
+			result.varbindsLen += response.varbindsLen
+		}
+		if response.Error != NoError {
+			// report the first failure, indexed into the whole request
+			result.Error = response.Error
+			if result.ErrorIndex, err = shiftErrorIndex(response, offset); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+		offset += len(chunk)
+	}
+	return result, nil
+}
+
+// getChunk sends one Get for pdus, splitting it further while the agent
+// answers tooBig.
+func (x *GoSNMP) getChunk(ctx context.Context, pdus []SnmpPDU) (*SnmpPacket, error) {
+	response, err := x.sendContext(ctx, x.mkSnmpPacket(GetRequest, pdus, 0, 0), true)
+	if err != nil || response.Error != TooBig || len(pdus) == 1 {
+		return response, err
+	}
+	x.Logger.Printf("Get of %d oids was tooBig, splitting", len(pdus))
+
+	half := len(pdus) / 2
+	first, err := x.getChunk(ctx, pdus[:half])
+	if err != nil || first.Error != NoError {
+		return first, err
+	}
+	second, err := x.getChunk(ctx, pdus[half:])
+	if err != nil {
+		return nil, err
+	}
+	first.Variables = append(first.Variables, second.Variables...)
+	first.varbindsLen += second.varbindsLen
+	first.Error = second.Error
+	if first.ErrorIndex, err = shiftErrorIndex(second, half); err != nil {
+		return nil, err
+	}
+	return first, nil
+}
+
+// shiftErrorIndex returns the error index of response, the answer to the
+// part of a request starting offset variables in, as an index into the
+// whole request. An error is returned when it no longer fits the octet
+// the error index has in a PDU.
+func shiftErrorIndex(response *SnmpPacket, offset int) (uint8, error) {
+	if response.ErrorIndex == 0 {
+		return 0, nil
+	}
+	index := int(response.ErrorIndex) + offset
+	if index > math.MaxUint8 {
+		return 0, fmt.Errorf("agent reported %v for variable %d, past the error indexes of a PDU", response.Error, index)
+	}
+	return uint8(index), nil
+}
+
+// splitPDUs groups pdus into requests of at most MaxOids variables whose
+// encoding fits in MaxPDUSize octets.
+func (x *GoSNMP) splitPDUs(pdus []SnmpPDU) ([][]SnmpPDU, error) {
+	// the encoding of an empty request, with the varbind list length
+	// allowed its longest form
+	overhead, err := x.mkSnmpPacket(GetRequest, nil, 0, 0).requestOverhead()
+	if err != nil {
+		return nil, err
+	}
+	if x.Version == Version3 {
+		overhead += v3HeaderAllowance
+	}
+
+	var chunks [][]SnmpPDU
+	start, size := 0, overhead
+	for i := range pdus {
+		varbind, err := marshalVarbind(&pdus[i])
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal %s: %w", pdus[i].Name, err)
+		}
+		if overhead+len(varbind) > x.MaxPDUSize {
+			return nil, fmt.Errorf("oid %s does not fit in MaxPDUSize (%d)", pdus[i].Name, x.MaxPDUSize)
+		}
+		if i > start && (i-start == x.MaxOids || size+len(varbind) > x.MaxPDUSize) {
+			chunks = append(chunks, pdus[start:i])
+			start, size = i, overhead
+		}
+		size += len(varbind)
+	}
+	if start < len(pdus) || len(pdus) == 0 {
+		// no pdus are sent as one empty request, as without MaxPDUSize
+		chunks = append(chunks, pdus[start:])
+	}
+	return chunks, nil
+}
+
+// requestOverhead returns the encoded size of packet, which must have no
+// variables, as an SNMPv1/v2c message plus room for the sequence lengths
+// to grow to their longest form.
+func (packet *SnmpPacket) requestOverhead() (int, error) {
+	empty := *packet
+	if empty.Version == Version3 {
+		empty.Version = Version2c
+	}
+	msg, err := empty.marshalMsg()
+	if err != nil {
+		return 0, err
+	}
+	// message, PDU and varbind list lengths may each need 3 more octets
+	return len(msg) + 3*3, nil
+}
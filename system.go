@@ -0,0 +1,77 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"fmt"
+	"time"
+)
+
+// The SNMPv2-MIB system group scalars read by the Sys* methods.
+const (
+	sysDescrOID    = ".1.3.6.1.2.1.1.1.0"
+	sysObjectIDOID = ".1.3.6.1.2.1.1.2.0"
+	sysUpTimeOID   = ".1.3.6.1.2.1.1.3.0"
+	sysNameOID     = ".1.3.6.1.2.1.1.5.0"
+)
+
+// SysDescr returns sysDescr.0, the agent's description of the device.
+func (x *GoSNMP) SysDescr() (string, error) {
+	pdu, err := x.getScalar(sysDescrOID)
+	if err != nil {
+		return "", err
+	}
+	return pdu.Text()
+}
+
+// SysObjectID returns sysObjectID.0, the vendor's OID for the kind of
+// device, eg ".1.3.6.1.4.1.9.1.1208".
+func (x *GoSNMP) SysObjectID() (string, error) {
+	pdu, err := x.getScalar(sysObjectIDOID)
+	if err != nil {
+		return "", err
+	}
+	return pdu.OID()
+}
+
+// SysUpTime returns sysUpTime.0, the time since the agent (re)started,
+// converted from hundredths of a second.
+func (x *GoSNMP) SysUpTime() (time.Duration, error) {
+	pdu, err := x.getScalar(sysUpTimeOID)
+	if err != nil {
+		return 0, err
+	}
+	return pdu.Duration()
+}
+
+// SysName returns sysName.0, the administratively assigned name of the
+// device.
+func (x *GoSNMP) SysName() (string, error) {
+	pdu, err := x.getScalar(sysNameOID)
+	if err != nil {
+		return "", err
+	}
+	return pdu.Text()
+}
+
+// getScalar gets the one variable oid, failing if the agent reports an
+// error or has no such variable.
+func (x *GoSNMP) getScalar(oid string) (SnmpPDU, error) {
+	result, err := x.Get([]string{oid})
+	if err != nil {
+		return SnmpPDU{}, err
+	}
+	if result.Error != NoError {
+		return SnmpPDU{}, fmt.Errorf("get %s: %s", oid, result.Error)
+	}
+	if len(result.Variables) != 1 {
+		return SnmpPDU{}, fmt.Errorf("get %s: %d variables in response", oid, len(result.Variables))
+	}
+	pdu := result.Variables[0]
+	if pdu.IsException() {
+		return SnmpPDU{}, fmt.Errorf("get %s: %s", oid, pdu.Type)
+	}
+	return pdu, nil
+}
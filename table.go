@@ -0,0 +1,422 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WalkTable walks the conceptual table whose entry is entryOid (eg ifEntry
+// ".1.3.6.1.2.1.2.2.1") and returns its cells grouped by row. The map key is
+// the row index, the part of the OID after the column number (eg "3" for
+// ifDescr.3, or "10.0.0.1" for a table indexed by an IpAddress). Each row
+// holds the cells the agent returned, in column order; columns missing from
+// a row (sparse tables) are simply absent.
+//
+// BulkWalk is used, or Walk for SNMPv1.
+func (x *GoSNMP) WalkTable(entryOid string) (map[string][]SnmpPDU, error) {
+	return x.WalkTableContext(x.Context, entryOid)
+}
+
+// WalkTableContext is WalkTable bound to ctx instead of x.Context.
+func (x *GoSNMP) WalkTableContext(ctx context.Context, entryOid string) (map[string][]SnmpPDU, error) {
+	requestType := GetBulkRequest
+	if x.Version == Version1 {
+		requestType = GetNextRequest
+	}
+	prefix := x.OIDFormat.Format(strings.Trim(entryOid, ".")) + "."
+
+	rows := make(map[string][]SnmpPDU)
+	err := x.walk(ctx, requestType, entryOid, func(pdu SnmpPDU) error {
+		if _, index, ok := tableCell(prefix, pdu.Name); ok {
+			rows[index] = append(rows[index], pdu)
+		}
+		return nil
+	})
+	return rows, err
+}
+
+// BulkWalkTable walks the conceptual table tableOid (eg ifTable
+// ".1.3.6.1.2.1.2.2", whose entry is tableOid.1) and returns its cells by
+// row index and then by column OID, eg
+//
+//	table["3"][".1.3.6.1.2.1.2.2.1.2"] // ifDescr.3
+//
+// Row indexes are the whole OID suffix after the column, so multi-arc and
+// composite indexes come as they are on the wire: "10.0.0.1.161" for an
+// IpAddress and a port, or "3.101.116.104.48" for an integer and a
+// length-prefixed string. As with WalkTable, Walk is used for SNMPv1.
+func (x *GoSNMP) BulkWalkTable(tableOid string) (map[string]map[string]SnmpPDU, error) {
+	return x.BulkWalkTableContext(x.Context, tableOid)
+}
+
+// BulkWalkTableContext is BulkWalkTable bound to ctx instead of x.Context.
+func (x *GoSNMP) BulkWalkTableContext(ctx context.Context, tableOid string) (map[string]map[string]SnmpPDU, error) {
+	entryOid := "." + strings.Trim(tableOid, ".") + ".1"
+	table, err := x.WalkTableContext(ctx, entryOid)
+	rows := make(map[string]map[string]SnmpPDU, len(table))
+	for index, cells := range table {
+		row := make(map[string]SnmpPDU, len(cells))
+		for _, pdu := range cells {
+			row[strings.TrimSuffix(pdu.Name, "."+index)] = pdu
+		}
+		rows[index] = row
+	}
+	return rows, err
+}
+
+// UnmarshalTable walks the conceptual table whose entry is entryOid and
+// stores its rows in the slice pointed to by rows, ordered by row index.
+// The slice elements are structs (or pointers to structs) whose fields are
+// tagged with the column number they hold:
+//
+//	type ifRow struct {
+//		Index  string `snmp:"index"` // the row index, eg "3"
+//		Descr  string `snmp:"2"`
+//		Type   int    `snmp:"3"`
+//		Speed  uint64 `snmp:"5"`
+//		Status int    `snmp:"8"`
+//	}
+//
+//	var rows []ifRow
+//	err := g.UnmarshalTable(".1.3.6.1.2.1.2.2.1", &rows)
+//
+// Supported field types are the int and uint kinds, string, []byte,
+// float32 and float64, interface{} (set to SnmpPDU.Value) and SnmpPDU.
+// Integers are converted with SnmpPDU.Int and SnmpPDU.Uint64, and strings
+// with SnmpPDU.Text. Fields for columns missing from a row keep their
+// zero value, and columns without a field are ignored.
+func (x *GoSNMP) UnmarshalTable(entryOid string, rows interface{}) error {
+	return x.UnmarshalTableContext(x.Context, entryOid, rows)
+}
+
+// UnmarshalTableContext is UnmarshalTable bound to ctx instead of x.Context.
+func (x *GoSNMP) UnmarshalTableContext(ctx context.Context, entryOid string, rows interface{}) error {
+	out := reflect.ValueOf(rows)
+	if out.Kind() != reflect.Pointer || out.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("rows must be a pointer to a slice, not %T", rows)
+	}
+	slice := out.Elem()
+	elemType := slice.Type().Elem()
+	structType := elemType
+	if structType.Kind() == reflect.Pointer {
+		structType = structType.Elem()
+	}
+	fields, indexField, err := tableFields(structType)
+	if err != nil {
+		return err
+	}
+
+	table, err := x.WalkTableContext(ctx, entryOid)
+	if err != nil {
+		return err
+	}
+	indexes := make([]string, 0, len(table))
+	for index := range table {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return oidLess(indexes[i], indexes[j]) })
+
+	prefix := x.OIDFormat.Format(strings.Trim(entryOid, ".")) + "."
+	result := reflect.MakeSlice(slice.Type(), 0, len(indexes))
+	for _, index := range indexes {
+		row := reflect.New(structType).Elem()
+		if indexField != nil {
+			row.FieldByIndex(indexField).SetString(index)
+		}
+		for _, pdu := range table[index] {
+			column, _, _ := tableCell(prefix, pdu.Name)
+			field, ok := fields[column]
+			if !ok {
+				continue
+			}
+			if err := setTableField(row.FieldByIndex(field), pdu); err != nil {
+				return fmt.Errorf("row %s column %d (%s.%s): %w", index, column, structType.Name(), structType.FieldByIndex(field).Name, err)
+			}
+		}
+		if elemType.Kind() == reflect.Pointer {
+			row = row.Addr()
+		}
+		result = reflect.Append(result, row)
+	}
+	slice.Set(result)
+	return nil
+}
+
+// tableCell splits the name of a table cell under prefix (the entry OID
+// with a trailing dot) into its column number and row index.
+func tableCell(prefix, name string) (column int, index string, ok bool) {
+	rest := strings.TrimPrefix(name, prefix)
+	if rest == name {
+		return 0, "", false
+	}
+	col, index, found := strings.Cut(rest, ".")
+	if !found || index == "" {
+		return 0, "", false
+	}
+	column, err := strconv.Atoi(col)
+	if err != nil {
+		return 0, "", false
+	}
+	return column, index, true
+}
+
+// tableFields maps the column numbers in the snmp tags of t to field
+// indexes, and returns the index of the string field tagged "index".
+func tableFields(t reflect.Type) (map[int][]int, []int, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("rows must hold structs, not %s", t)
+	}
+	fields := make(map[int][]int)
+	var indexField []int
+	for _, f := range reflect.VisibleFields(t) {
+		tag, ok := f.Tag.Lookup("snmp")
+		if !ok || tag == "-" {
+			continue
+		}
+		if !f.IsExported() {
+			return nil, nil, fmt.Errorf("field %s.%s is tagged but not exported", t.Name(), f.Name)
+		}
+		if tag == "index" {
+			if f.Type.Kind() != reflect.String {
+				return nil, nil, fmt.Errorf("index field %s.%s must be a string", t.Name(), f.Name)
+			}
+			indexField = f.Index
+			continue
+		}
+		column, err := strconv.Atoi(tag)
+		if err != nil || column <= 0 {
+			return nil, nil, fmt.Errorf("field %s.%s: invalid snmp tag %q", t.Name(), f.Name, tag)
+		}
+		if _, dup := fields[column]; dup {
+			return nil, nil, fmt.Errorf("field %s.%s: column %d is already mapped", t.Name(), f.Name, column)
+		}
+		fields[column] = f.Index
+	}
+	return fields, indexField, nil
+}
+
+var snmpPDUType = reflect.TypeOf(SnmpPDU{})
+
+// setTableField stores the value of pdu in field.
+func setTableField(field reflect.Value, pdu SnmpPDU) error {
+	if field.Type() == snmpPDUType {
+		field.Set(reflect.ValueOf(pdu))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.Interface:
+		if pdu.Value != nil {
+			field.Set(reflect.ValueOf(pdu.Value))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := pdu.Int()
+		if err != nil {
+			return err
+		}
+		if field.OverflowInt(int64(v)) {
+			return fmt.Errorf("%d overflows %s: %w", v, field.Type(), ErrIntegerTooLarge)
+		}
+		field.SetInt(int64(v))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := pdu.Uint64()
+		if err != nil {
+			return err
+		}
+		if field.OverflowUint(v) {
+			return fmt.Errorf("%d overflows %s: %w", v, field.Type(), ErrIntegerTooLarge)
+		}
+		field.SetUint(v)
+	case reflect.String:
+		v, err := pdu.Text()
+		if err != nil {
+			return err
+		}
+		field.SetString(v)
+	case reflect.Float32, reflect.Float64:
+		switch v := pdu.Value.(type) {
+		case float32:
+			field.SetFloat(float64(v))
+		case float64:
+			field.SetFloat(v)
+		default:
+			return pdu.wrongType("a float")
+		}
+	case reflect.Slice:
+		v, ok := pdu.Value.([]byte)
+		if field.Type().Elem().Kind() != reflect.Uint8 || !ok {
+			return pdu.wrongType(field.Type().String())
+		}
+		field.SetBytes(append([]byte(nil), v...))
+	default:
+		return errors.New("unsupported field type " + field.Type().String())
+	}
+	return nil
+}
+
+// oidLess orders dotted OIDs (or OID suffixes such as row indexes)
+// numerically, arc by arc.
+func oidLess(a, b string) bool {
+	// compared an arc at a time in place, as sorting tables calls this
+	// n log n times
+	a, b = strings.Trim(a, "."), strings.Trim(b, ".")
+	for {
+		aArc, aRest, aMore := strings.Cut(a, ".")
+		bArc, bRest, bMore := strings.Cut(b, ".")
+		if aArc != bArc {
+			ai, aErr := strconv.ParseUint(aArc, 10, 64)
+			bi, bErr := strconv.ParseUint(bArc, 10, 64)
+			if aErr != nil || bErr != nil {
+				return aArc < bArc
+			}
+			if ai != bi {
+				return ai < bi
+			}
+		}
+		if !aMore || !bMore {
+			return !aMore && bMore
+		}
+		a, b = aRest, bRest
+	}
+}
+
+// IndexSyntax is the syntax of an object of the INDEX clause of a table,
+// for DecodeIndex.
+type IndexSyntax int
+
+const (
+	// IndexInteger is an INTEGER, Unsigned32 or other integer index, one
+	// arc, decoded as a uint32.
+	IndexInteger IndexSyntax = iota
+	// IndexIPAddress is an IpAddress, four arcs, decoded as a net.IP.
+	IndexIPAddress
+	// IndexMACAddress is a MacAddress or other fixed size OCTET STRING of
+	// six octets, an arc each, decoded as a net.HardwareAddr.
+	IndexMACAddress
+	// IndexString is a variable size OCTET STRING, its length and then an
+	// arc per octet, decoded as a []byte.
+	IndexString
+	// IndexImpliedString is an IMPLIED OCTET STRING, which must be last:
+	// the remaining arcs, one per octet, decoded as a []byte.
+	IndexImpliedString
+	// IndexOID is an OBJECT IDENTIFIER, its number of arcs and then the
+	// arcs, decoded as a string with a leading dot.
+	IndexOID
+	// IndexImpliedOID is an IMPLIED OBJECT IDENTIFIER, which must be last:
+	// the remaining arcs, decoded as a string with a leading dot.
+	IndexImpliedOID
+)
+
+// DecodeIndex decodes the index of leafOID, a cell of the table column
+// columnOID, as the objects of its INDEX clause (RFC 2578 section 7.7),
+// given in order by syntaxes, eg for ipNetToMediaTable, indexed by an
+// ifIndex and an IpAddress:
+//
+//	index, err := gosnmp.DecodeIndex(".1.3.6.1.2.1.4.22.1.2",
+//		".1.3.6.1.2.1.4.22.1.2.3.10.0.0.1", gosnmp.IndexInteger, gosnmp.IndexIPAddress)
+//	// index is []interface{}{uint32(3), net.IP{10, 0, 0, 1}}
+//
+// Without syntaxes each arc of the index is decoded as an IndexInteger.
+// It is an error for the index to have arcs left over or too few.
+func DecodeIndex(columnOID, leafOID string, syntaxes ...IndexSyntax) ([]interface{}, error) {
+	prefix := AddLeadingDot(strings.TrimSuffix(columnOID, ".")) + "."
+	rest := strings.TrimPrefix(AddLeadingDot(leafOID), prefix)
+	if rest == AddLeadingDot(leafOID) || rest == "" {
+		return nil, fmt.Errorf("%s is not a cell of column %s", leafOID, columnOID)
+	}
+	var arcs []uint32
+	for _, s := range strings.Split(rest, ".") {
+		arc, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid index arc %q of %s", s, leafOID)
+		}
+		arcs = append(arcs, uint32(arc))
+	}
+	if len(syntaxes) == 0 {
+		index := make([]interface{}, len(arcs))
+		for i, arc := range arcs {
+			index[i] = arc
+		}
+		return index, nil
+	}
+
+	index := make([]interface{}, 0, len(syntaxes))
+	for i, syntax := range syntaxes {
+		var n int // arcs of the value, after any length
+		switch syntax {
+		case IndexInteger:
+			n = 1
+		case IndexIPAddress:
+			n = net.IPv4len
+		case IndexMACAddress:
+			n = 6
+		case IndexString, IndexOID:
+			if len(arcs) == 0 {
+				return nil, fmt.Errorf("index of %s ends before the length of object %d", leafOID, i+1)
+			}
+			if arcs[0] > uint32(len(arcs)-1) {
+				return nil, fmt.Errorf("index of %s ends within object %d, of length %d", leafOID, i+1, arcs[0])
+			}
+			n, arcs = int(arcs[0]), arcs[1:]
+		case IndexImpliedString, IndexImpliedOID:
+			if i != len(syntaxes)-1 {
+				return nil, fmt.Errorf("IMPLIED index object %d is not the last", i+1)
+			}
+			n = len(arcs)
+		default:
+			return nil, fmt.Errorf("unknown index syntax %d", syntax)
+		}
+		if n > len(arcs) {
+			return nil, fmt.Errorf("index of %s ends within object %d", leafOID, i+1)
+		}
+		value, err := decodeIndexValue(syntax, arcs[:n])
+		if err != nil {
+			return nil, fmt.Errorf("index object %d of %s: %w", i+1, leafOID, err)
+		}
+		index = append(index, value)
+		arcs = arcs[n:]
+	}
+	if len(arcs) != 0 {
+		return nil, fmt.Errorf("index of %s has %d arcs past its objects", leafOID, len(arcs))
+	}
+	return index, nil
+}
+
+// decodeIndexValue decodes arcs, the value of an index object of syntax
+// less any length.
+func decodeIndexValue(syntax IndexSyntax, arcs []uint32) (interface{}, error) {
+	switch syntax {
+	case IndexInteger:
+		return arcs[0], nil
+	case IndexOID, IndexImpliedOID:
+		var b strings.Builder
+		for _, arc := range arcs {
+			b.WriteByte('.')
+			b.WriteString(strconv.FormatUint(uint64(arc), 10))
+		}
+		return b.String(), nil
+	}
+	octets := make([]byte, len(arcs))
+	for i, arc := range arcs {
+		if arc > 255 {
+			return nil, fmt.Errorf("arc %d is not an octet", arc)
+		}
+		octets[i] = byte(arc)
+	}
+	switch syntax {
+	case IndexIPAddress:
+		return net.IP(octets), nil
+	case IndexMACAddress:
+		return net.HardwareAddr(octets), nil
+	}
+	return octets, nil
+}
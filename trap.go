@@ -7,6 +7,7 @@ package gosnmp
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 	"sync"
@@ -29,6 +30,11 @@ import (
 //
 // See also Listen() and examples for creating an NMS.
 //
+// For SNMPv3 the sender of a trap is authoritative. If AuthoritativeEngineID
+// is empty it is set to an engine ID made from the local address, see
+// EngineIDFromIP, and the trap is sent without discovery. Set it, to the
+// engine ID the receiver has the user for, to send with another.
+//
 // NOTE: the trap code is currently unreliable when working with snmpv3 - pull requests welcome
 func (x *GoSNMP) SendTrap(trap SnmpTrap) (result *SnmpPacket, err error) {
 	var pdutype PDUType
@@ -86,6 +92,13 @@ func (x *GoSNMP) SendTrap(trap SnmpTrap) (result *SnmpPacket, err error) {
 	}
 
 	packetOut := x.mkSnmpPacket(pdutype, trap.Variables, 0, 0)
+	if usm, ok := packetOut.SecurityParameters.(*UsmSecurityParameters); ok && pdutype == SNMPv2Trap &&
+		x.Version == Version3 && usm.AuthoritativeEngineID == "" && x.Conn != nil {
+		// the default engine ID is the trap's alone, x is left as it is
+		if usm.AuthoritativeEngineID, err = x.defaultTrapEngineID(); err != nil {
+			return nil, err
+		}
+	}
 	if x.Version == Version1 {
 		packetOut.Enterprise = trap.Enterprise
 		packetOut.AgentAddress = trap.AgentAddress
@@ -99,6 +112,30 @@ func (x *GoSNMP) SendTrap(trap SnmpTrap) (result *SnmpPacket, err error) {
 	return x.send(packetOut, trap.IsInform)
 }
 
+// SendInform sends trap as a confirmed InformRequest (SNMPv2c or SNMPv3).
+//
+// The inform is retransmitted up to Retries times until the receiver
+// acknowledges it with a Response PDU echoing the request-id, which is
+// returned. If no acknowledgement arrives a timeout error is returned.
+//
+// For SNMPv3 the receiver, not the sender, is authoritative. Leave
+// AuthoritativeEngineID empty and the receiver's engine ID, boots and time
+// are discovered before the authenticated inform is sent.
+func (x *GoSNMP) SendInform(trap SnmpTrap) (result *SnmpPacket, err error) {
+	if x.Version == Version1 {
+		return nil, fmt.Errorf("function SendInform doesn't support %s", x.Version)
+	}
+	trap.IsInform = true
+	result, err = x.SendTrap(trap)
+	if err != nil {
+		return result, err
+	}
+	if result.PDUType != GetResponse {
+		return result, fmt.Errorf("inform not acknowledged, got %s instead of a response", result.PDUType)
+	}
+	return result, nil
+}
+
 //
 // Receiving Traps ie GoSNMP acting as an NMS (Network Management
 // Station).
@@ -106,6 +143,111 @@ func (x *GoSNMP) SendTrap(trap SnmpTrap) (result *SnmpPacket, err error) {
 // GoSNMP.unmarshal() currently only handles SNMPv2Trap
 //
 
+const (
+	snmpTrapOID        = ".1.3.6.1.6.3.1.1.4.1.0"
+	snmpTrapEnterprise = ".1.3.6.1.6.3.1.1.4.3.0"
+	snmpTraps          = ".1.3.6.1.6.3.1.1.5"
+	snmpTrapAddress    = ".1.3.6.1.6.3.18.1.3.0"
+	snmpTrapCommunity  = ".1.3.6.1.6.3.18.1.4.0"
+)
+
+// TrapOID returns the snmpTrapOID.0 of packet, a received trap or inform,
+// which names the notification. For an SNMPv1 trap it is made from the
+// enterprise and the generic and specific trap numbers as in RFC 2576
+// 3.1, eg .1.3.6.1.6.3.1.1.5.3 for a linkDown trap.
+func (packet *SnmpPacket) TrapOID() (string, bool) {
+	if packet.PDUType == Trap {
+		if packet.GenericTrap == 6 { // enterpriseSpecific
+			if packet.Enterprise == "" {
+				return "", false
+			}
+			return fmt.Sprintf(".%s.0.%d", strings.Trim(packet.Enterprise, "."), packet.SpecificTrap), true
+		}
+		return fmt.Sprintf("%s.%d", snmpTraps, packet.GenericTrap+1), true
+	}
+	for _, pdu := range packet.Variables {
+		if pdu.Name == snmpTrapOID {
+			oid, err := pdu.OID()
+			return oid, err == nil
+		}
+	}
+	return "", false
+}
+
+// TrapUptime returns the sysUpTime.0 of packet, a received trap or inform,
+// in hundredths of a second; for an SNMPv1 trap its time-stamp.
+func (packet *SnmpPacket) TrapUptime() (uint32, bool) {
+	if packet.PDUType == Trap {
+		return uint32(packet.Timestamp), true
+	}
+	for _, pdu := range packet.Variables {
+		if pdu.Name == sysUpTimeOID {
+			ticks, ok := pdu.Value.(uint32)
+			return ticks, ok && pdu.Type == TimeTicks
+		}
+	}
+	return 0, false
+}
+
+// TrapVariables returns the variables of packet, a received trap or
+// inform, other than the sysUpTime.0 and snmpTrapOID.0 that come first in
+// SNMPv2 notifications.
+func (packet *SnmpPacket) TrapVariables() []SnmpPDU {
+	vars := make([]SnmpPDU, 0, len(packet.Variables))
+	for _, pdu := range packet.Variables {
+		if packet.PDUType != Trap && (pdu.Name == sysUpTimeOID || pdu.Name == snmpTrapOID) {
+			continue
+		}
+		vars = append(vars, pdu)
+	}
+	return vars
+}
+
+// ToV2Trap returns packet, a received trap, as an SNMPv2c SNMPv2Trap, so
+// SNMPv1 and SNMPv2 traps can be handled alike. An SNMPv1 trap is
+// translated as in RFC 2576 3.1: its variables follow sysUpTime.0, set to
+// the time-stamp, and snmpTrapOID.0, see TrapOID, and are followed by
+// snmpTrapAddress.0, snmpTrapCommunity.0 and snmpTrapEnterprise.0 holding
+// the agent-addr, community and enterprise unless already present. Other
+// packets are returned as they are.
+func (packet *SnmpPacket) ToV2Trap() *SnmpPacket {
+	if packet.PDUType != Trap {
+		return packet
+	}
+	vars := make([]SnmpPDU, 0, len(packet.Variables)+5)
+	vars = append(vars, SnmpPDU{Name: sysUpTimeOID, Type: TimeTicks, Value: uint32(packet.Timestamp)})
+	if oid, ok := packet.TrapOID(); ok {
+		vars = append(vars, SnmpPDU{Name: snmpTrapOID, Type: ObjectIdentifier, Value: oid})
+	}
+	vars = append(vars, packet.Variables...)
+
+	appendMissing := func(pdu SnmpPDU) {
+		for _, v := range packet.Variables {
+			if v.Name == pdu.Name {
+				return
+			}
+		}
+		vars = append(vars, pdu)
+	}
+	if packet.AgentAddress != "" {
+		appendMissing(SnmpPDU{Name: snmpTrapAddress, Type: IPAddress, Value: packet.AgentAddress})
+	}
+	if packet.Community != "" {
+		appendMissing(SnmpPDU{Name: snmpTrapCommunity, Type: OctetString, Value: []byte(packet.Community)})
+	}
+	if packet.Enterprise != "" {
+		appendMissing(SnmpPDU{Name: snmpTrapEnterprise, Type: ObjectIdentifier,
+			Value: "." + strings.Trim(packet.Enterprise, ".")})
+	}
+
+	v2 := *packet
+	v2.Version = Version2c
+	v2.PDUType = SNMPv2Trap
+	v2.Variables = vars
+	v2.SnmpTrap = SnmpTrap{}
+	return &v2
+}
+
 type MsgData struct {
 	data   []byte
 	remote *net.UDPAddr
@@ -120,6 +262,9 @@ type TrapListener struct {
 	sync.Mutex
 
 	// Params is a reference to the TrapListener's "parent" GoSNMP instance.
+	// To accept SNMPv3 traps from several users and engines, add them to
+	// Params.BkUsmMap; the matching user is in the SecurityParameters of
+	// each trap handed to OnNewTrap.
 	Params *GoSNMP
 
 	Concurrency int
@@ -258,6 +403,15 @@ func (t *TrapListener) listenUDP(addr string) error {
 				if err != nil {
 					t.Params.Logger.Printf("TrapListener: error in msgData unmarshall %s\n", err)
 				}
+				// We are authoritative for informs, so answer engine ID
+				// discovery probes with a report instead of handing them on.
+				if engineID, ok := t.engineIDDiscovery(traps); ok {
+					atomic.AddUint32(&t.usmStatsUnknownEngineIDsCount, 1)
+					if err := t.reportAuthoritativeEngineID(traps, engineID, msgData.remote); err != nil {
+						t.Params.Logger.Printf("TrapListener: error in discovery report %s\n", err)
+					}
+					continue
+				}
 				if traps != nil {
 					// Here we assume that t.OnNewTrap will not alter the contents
 					// of the PDU (per documentation, because Go does not have
@@ -328,16 +482,40 @@ func (t *TrapListener) listenUDP(addr string) error {
 	}
 }
 
+// engineIDDiscovery reports whether trap is a reportable SNMPv3 request for
+// an engine ID other than ours (RFC 3414 4), i.e. a sender discovering our
+// snmpEngineID, boots and time before sending an inform. It also returns our
+// engine ID.
+func (t *TrapListener) engineIDDiscovery(trap *SnmpPacket) (string, bool) {
+	if trap == nil || trap.Version != Version3 || trap.MsgFlags&Reportable == 0 {
+		return "", false
+	}
+	local, ok := t.Params.SecurityParameters.(*UsmSecurityParameters)
+	if !ok || local.AuthoritativeEngineID == "" {
+		return "", false
+	}
+	remote, ok := trap.SecurityParameters.(*UsmSecurityParameters)
+	if !ok || remote.AuthoritativeEngineID == local.AuthoritativeEngineID {
+		return "", false
+	}
+	return local.AuthoritativeEngineID, true
+}
+
 func (t *TrapListener) reportAuthoritativeEngineID(trap *SnmpPacket, snmpEngineID string, addr *net.UDPAddr) error {
 	newSecurityParams, ok := trap.SecurityParameters.Copy().(*UsmSecurityParameters)
 	if !ok {
 		return errors.New("unable to cast SecurityParams to UsmSecurityParameters")
 	}
 	newSecurityParams.AuthoritativeEngineID = snmpEngineID
+	if local, ok := t.Params.SecurityParameters.(*UsmSecurityParameters); ok {
+		newSecurityParams.AuthoritativeEngineBoots = local.AuthoritativeEngineBoots
+		newSecurityParams.AuthoritativeEngineTime = local.AuthoritativeEngineTime
+	}
 	reportPacket := trap
 	reportPacket.PDUType = Report
 	reportPacket.MsgFlags &= AuthPriv
 	reportPacket.SecurityParameters = newSecurityParams
+	reportPacket.ContextEngineID = snmpEngineID
 	reportPacket.Variables = []SnmpPDU{
 		{
 			Name:  usmStatsUnknownEngineIDs,
@@ -349,26 +527,27 @@ func (t *TrapListener) reportAuthoritativeEngineID(trap *SnmpPacket, snmpEngineI
 }
 
 func (t *TrapListener) handleTCPRequest(conn net.Conn) {
-	// Make a buffer to hold incoming data.
-	buf := make([]byte, 4096)
-	// Read the incoming connection into the buffer.
-	reqLen, err := conn.Read(buf)
-	if err != nil {
-		t.Params.Logger.Printf("TrapListener: error in read %s\n", err)
-		return
-	}
-
-	msg := buf[:reqLen]
-	traps, err := t.Params.UnmarshalTrap(msg, false)
-	if err != nil {
-		t.Params.Logger.Printf("TrapListener: error in read %s\n", err)
-		return
-	}
+	// Close the connection when you're done with it.
+	defer conn.Close()
 	// TODO: lying for backward compatibility reason - create UDP Address ... not nice
 	r, _ := net.ResolveUDPAddr("", conn.RemoteAddr().String())
-	t.OnNewTrap(traps, r)
-	// Close the connection when you're done with it.
-	conn.Close()
+	// A sender may reuse the connection for several messages.
+	for {
+		msg, err := readBERMessage(conn, rxBufSize)
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			t.Params.Logger.Printf("TrapListener: error in read %s\n", err)
+			return
+		}
+
+		traps, err := t.Params.UnmarshalTrap(msg, false)
+		if err != nil {
+			t.Params.Logger.Printf("TrapListener: error in read %s\n", err)
+			return
+		}
+		t.OnNewTrap(traps, r)
+	}
 }
 
 func (t *TrapListener) listenTCP(addr string) error {
@@ -57,6 +57,7 @@ var testsUnmarshalTrap = []struct {
 }
 
 func TestUnmarshalTrap(t *testing.T) {
+	keepDefault(t)
 	Default.Logger = NewLogger(log.New(io.Discard, "", 0))
 
 SANITY:
@@ -83,6 +84,87 @@ SANITY:
 	}
 }
 
+func TestTrapAccessors(t *testing.T) {
+	v2 := &SnmpPacket{
+		Version: Version2c,
+		PDUType: SNMPv2Trap,
+		Variables: []SnmpPDU{
+			{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(4200)},
+			{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: ObjectIdentifier, Value: ".1.3.6.1.6.3.1.1.5.3"},
+			{Name: ".1.3.6.1.2.1.2.2.1.1.2", Type: Integer, Value: 2},
+		},
+	}
+	oid, ok := v2.TrapOID()
+	require.True(t, ok)
+	require.Equal(t, ".1.3.6.1.6.3.1.1.5.3", oid)
+	uptime, ok := v2.TrapUptime()
+	require.True(t, ok)
+	require.Equal(t, uint32(4200), uptime)
+	require.Equal(t, v2.Variables[2:], v2.TrapVariables())
+
+	_, ok = (&SnmpPacket{PDUType: SNMPv2Trap}).TrapOID()
+	require.False(t, ok)
+	_, ok = (&SnmpPacket{PDUType: SNMPv2Trap}).TrapUptime()
+	require.False(t, ok)
+
+	v1 := &SnmpPacket{
+		Version: Version1,
+		PDUType: Trap,
+		SnmpTrap: SnmpTrap{Enterprise: trapTestEnterpriseOid, AgentAddress: trapTestAgentAddress,
+			GenericTrap: 2, Timestamp: trapTestTimestamp},
+		Variables: []SnmpPDU{{Name: ".1.3.6.1.2.1.2.2.1.1.2", Type: Integer, Value: 2}},
+	}
+	oid, ok = v1.TrapOID()
+	require.True(t, ok)
+	require.Equal(t, ".1.3.6.1.6.3.1.1.5.3", oid) // linkDown
+	uptime, ok = v1.TrapUptime()
+	require.True(t, ok)
+	require.Equal(t, uint32(trapTestTimestamp), uptime)
+	require.Equal(t, v1.Variables, v1.TrapVariables())
+
+	v1.GenericTrap, v1.SpecificTrap = trapTestGenericTrap, trapTestSpecificTrap
+	oid, ok = v1.TrapOID()
+	require.True(t, ok)
+	require.Equal(t, ".1.2.1234.0.55", oid)
+}
+
+func TestToV2Trap(t *testing.T) {
+	v1 := &SnmpPacket{
+		Version:   Version1,
+		Community: "public",
+		PDUType:   Trap,
+		SnmpTrap: SnmpTrap{Enterprise: trapTestEnterpriseOid, AgentAddress: trapTestAgentAddress,
+			GenericTrap: trapTestGenericTrap, SpecificTrap: trapTestSpecificTrap, Timestamp: trapTestTimestamp},
+		Variables: []SnmpPDU{{Name: trapTestOid, Type: OctetString, Value: []byte(trapTestPayload)}},
+	}
+	v2 := v1.ToV2Trap()
+	require.Equal(t, Version2c, v2.Version)
+	require.Equal(t, SNMPv2Trap, v2.PDUType)
+	require.Equal(t, "public", v2.Community)
+	require.Equal(t, []SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(trapTestTimestamp)},
+		{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: ObjectIdentifier, Value: ".1.2.1234.0.55"},
+		{Name: trapTestOid, Type: OctetString, Value: []byte(trapTestPayload)},
+		{Name: ".1.3.6.1.6.3.18.1.3.0", Type: IPAddress, Value: trapTestAgentAddress},
+		{Name: ".1.3.6.1.6.3.18.1.4.0", Type: OctetString, Value: []byte("public")},
+		{Name: ".1.3.6.1.6.3.1.1.4.3.0", Type: ObjectIdentifier, Value: trapTestEnterpriseOid},
+	}, v2.Variables)
+
+	// the accessors agree on both forms
+	oid1, _ := v1.TrapOID()
+	oid2, _ := v2.TrapOID()
+	require.Equal(t, oid1, oid2)
+	uptime1, _ := v1.TrapUptime()
+	uptime2, _ := v2.TrapUptime()
+	require.Equal(t, uptime1, uptime2)
+	require.Equal(t, v1.TrapVariables(), v2.TrapVariables()[:1])
+
+	// v1 is unchanged, and SNMPv2 traps are kept as they are
+	require.Equal(t, Trap, v1.PDUType)
+	require.Len(t, v1.Variables, 1)
+	require.Same(t, v2, v2.ToV2Trap())
+}
+
 func genericV3Trap() []byte {
 	return []byte{
 		0x30, 0x81, 0xd7, 0x02, 0x01, 0x03, 0x30, 0x11, 0x02, 0x04, 0x62, 0xaf,
@@ -154,6 +236,17 @@ func makeTestTrapHandler(t *testing.T, done chan int, version SnmpVersion) func(
 // run. Tests should be avoiding use of global state where possible (and, if
 // possible, use of global state other than possibly loggers should be
 // eliminated entirely).
+// keepDefault restores the settings of Default the test changes, through
+// the listener Params it shares, once the test is done.
+func keepDefault(t *testing.T) {
+	version, msgFlags, securityModel := Default.Version, Default.MsgFlags, Default.SecurityModel
+	securityParameters, logger := Default.SecurityParameters, Default.Logger
+	t.Cleanup(func() {
+		Default.Version, Default.MsgFlags, Default.SecurityModel = version, msgFlags, securityModel
+		Default.SecurityParameters, Default.Logger = securityParameters, logger
+	})
+}
+
 func TestRestoreGlobals(t *testing.T) {
 	Default.Version = Version2c
 	Default.SecurityModel = 0
@@ -168,6 +261,7 @@ func TestSendTrapBasic(t *testing.T) {
 	defer tl.Close()
 
 	tl.OnNewTrap = makeTestTrapHandler(t, done, Version2c)
+	keepDefault(t)
 	tl.Params = Default
 
 	// listener goroutine
@@ -234,6 +328,7 @@ func TestSendInformBasic(t *testing.T) {
 	defer tl.Close()
 
 	tl.OnNewTrap = makeTestTrapHandler(t, done, Version2c)
+	keepDefault(t)
 	tl.Params = Default
 
 	// listener goroutine
@@ -306,6 +401,89 @@ func TestSendInformBasic(t *testing.T) {
 	}
 }
 
+// informAcker acknowledges every inform except the first drop ones and
+// reports how many it received.
+func informAcker(t *testing.T, x *GoSNMP, srvr *net.UDPConn, drop int, received chan<- int) {
+	buf := make([]byte, 1500)
+	for n := 1; ; n++ {
+		l, addr, err := srvr.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		received <- n
+		if n <= drop {
+			continue
+		}
+
+		reqPkt := new(SnmpPacket)
+		cursor, err := x.unmarshalHeader(buf[:l], reqPkt)
+		if err == nil {
+			err = x.unmarshalPayload(buf[:l], cursor, reqPkt)
+		}
+		if err != nil || reqPkt.PDUType != InformRequest {
+			t.Errorf("expected an inform, got %v (%v)", reqPkt.PDUType, err)
+			return
+		}
+		rspPkt := x.mkSnmpPacket(GetResponse, reqPkt.Variables, 0, 0)
+		rspPkt.RequestID = reqPkt.RequestID
+		outBuf, err := rspPkt.marshalMsg()
+		if err != nil {
+			t.Errorf("ERR: %s", err)
+			return
+		}
+		srvr.WriteTo(outBuf, addr)
+	}
+}
+
+func TestSendInformRetransmit(t *testing.T) {
+	for _, test := range []struct {
+		drop    int
+		retries int
+		acked   bool
+	}{
+		{drop: 0, retries: 0, acked: true},
+		{drop: 2, retries: 2, acked: true},
+		{drop: 3, retries: 2, acked: false},
+	} {
+		srvr, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP(trapTestAddress)})
+		require.NoError(t, err)
+		defer srvr.Close()
+
+		ts := &GoSNMP{
+			Target:    trapTestAddress,
+			Port:      uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+			Community: "public",
+			Version:   Version2c,
+			Timeout:   100 * time.Millisecond,
+			Retries:   test.retries,
+			MaxOids:   MaxOids,
+		}
+		require.NoError(t, ts.Connect())
+		defer ts.Conn.Close()
+
+		received := make(chan int, 10)
+		go informAcker(t, ts, srvr, test.drop, received)
+
+		trap := SnmpTrap{Variables: []SnmpPDU{{Name: trapTestOid, Type: OctetString, Value: trapTestPayload}}}
+		resp, err := ts.SendInform(trap)
+		if !test.acked {
+			require.ErrorContains(t, err, "timeout")
+			require.Len(t, received, test.retries+1)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, GetResponse, resp.PDUType)
+		require.Equal(t, []byte(trapTestPayload), resp.Variables[1].Value)
+		require.Len(t, received, test.drop+1)
+	}
+}
+
+func TestSendInformV1(t *testing.T) {
+	ts := &GoSNMP{Version: Version1}
+	_, err := ts.SendInform(SnmpTrap{})
+	require.Error(t, err)
+}
+
 // test the listener is not blocked if Listening is not used
 func TestSendTrapWithoutWaitingOnListen(t *testing.T) {
 	done := make(chan int)
@@ -314,6 +492,7 @@ func TestSendTrapWithoutWaitingOnListen(t *testing.T) {
 	defer tl.Close()
 
 	tl.OnNewTrap = makeTestTrapHandler(t, done, Version2c)
+	keepDefault(t)
 	tl.Params = Default
 
 	errch := make(chan error)
@@ -393,6 +572,7 @@ func TestSendV1Trap(t *testing.T) {
 	defer tl.Close()
 
 	tl.OnNewTrap = makeTestTrapHandler(t, done, Version1)
+	keepDefault(t)
 	tl.Params = Default
 
 	// listener goroutine
@@ -469,6 +649,7 @@ func TestSendV3TrapNoAuthNoPriv(t *testing.T) {
 	}
 
 	tl.OnNewTrap = makeTestTrapHandler(t, done, Version3)
+	keepDefault(t)
 	tl.Params = Default
 	tl.Params.Version = Version3
 	tl.Params.SecurityParameters = sp
@@ -555,6 +736,7 @@ func TestSendV3TrapMD5AuthNoPriv(t *testing.T) {
 	}
 
 	tl.OnNewTrap = makeTestTrapHandler(t, done, Version3)
+	keepDefault(t)
 	tl.Params = Default
 	tl.Params.Version = Version3
 	tl.Params.SecurityParameters = sp
@@ -641,6 +823,7 @@ func TestSendV3TrapSHAAuthNoPriv(t *testing.T) {
 	}
 
 	tl.OnNewTrap = makeTestTrapHandler(t, done, Version3)
+	keepDefault(t)
 	tl.Params = Default
 	tl.Params.Version = Version3
 	tl.Params.SecurityParameters = sp
@@ -728,6 +911,7 @@ func TestSendV3TrapSHAAuthDESPriv(t *testing.T) {
 	}
 
 	tl.OnNewTrap = makeTestTrapHandler(t, done, Version3)
+	keepDefault(t)
 	tl.Params = Default
 	tl.Params.Version = Version3
 	tl.Params.SecurityParameters = sp
@@ -816,6 +1000,7 @@ func TestSendV3TrapSHAAuthAESPriv(t *testing.T) {
 	}
 
 	tl.OnNewTrap = makeTestTrapHandler(t, done, Version3)
+	keepDefault(t)
 	tl.Params = Default
 	tl.Params.Version = Version3
 	tl.Params.SecurityParameters = sp
@@ -904,6 +1089,7 @@ func TestSendV3TrapSHAAuthAES192Priv(t *testing.T) {
 	}
 
 	tl.OnNewTrap = makeTestTrapHandler(t, done, Version3)
+	keepDefault(t)
 	tl.Params = Default
 	tl.Params.Version = Version3
 	tl.Params.SecurityParameters = sp
@@ -991,6 +1177,7 @@ func TestSendV3TrapSHAAuthAES192CPriv(t *testing.T) {
 	}
 
 	tl.OnNewTrap = makeTestTrapHandler(t, done, Version3)
+	keepDefault(t)
 	tl.Params = Default
 	tl.Params.Version = Version3
 	tl.Params.SecurityParameters = sp
@@ -1077,6 +1264,7 @@ func TestSendV3TrapSHAAuthAES256Priv(t *testing.T) {
 	}
 
 	tl.OnNewTrap = makeTestTrapHandler(t, done, Version3)
+	keepDefault(t)
 	tl.Params = Default
 	tl.Params.Version = Version3
 	tl.Params.SecurityParameters = sp
@@ -1164,6 +1352,7 @@ func TestSendV3TrapSHAAuthAES256CPriv(t *testing.T) {
 	}
 
 	tl.OnNewTrap = makeTestTrapHandler(t, done, Version3)
+	keepDefault(t)
 	tl.Params = Default
 	tl.Params.Version = Version3
 	tl.Params.SecurityParameters = sp
@@ -1249,9 +1438,10 @@ func TestSendV3EngineIdDiscovery(t *testing.T) {
 		AuthoritativeEngineTime:  1,
 		AuthoritativeEngineID:    authorativeEngineID,
 	}
+	keepDefault(t)
 	tl.Params = Default
 	tl.Params.Version = Version3
-	tl.Params.SecurityParameters = sp
+	tl.Params.SecurityParameters = sp.Copy() // the listener goroutine has its own
 	tl.Params.SecurityModel = UserSecurityModel
 	tl.Params.MsgFlags = AuthPriv
 
@@ -1306,3 +1496,157 @@ func TestSendV3EngineIdDiscovery(t *testing.T) {
 	require.Equal(t, result.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineID, authorativeEngineID, "invalid authoritativeEngineID")
 	require.Equal(t, result.PDUType, Report, "invalid received PDUType")
 }
+
+func TestSendV3InformSHAAuthAESPriv(t *testing.T) {
+	done := make(chan int)
+
+	tl := NewTrapListener()
+	defer tl.Close()
+
+	receiverEngineID := string([]byte{0x80, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04})
+	tl.OnNewTrap = makeTestTrapHandler(t, done, Version3)
+	keepDefault(t)
+	tl.Params = Default
+	tl.Params.Version = Version3
+	tl.Params.SecurityParameters = &UsmSecurityParameters{
+		UserName:                 "test",
+		AuthenticationProtocol:   SHA,
+		AuthenticationPassphrase: "password",
+		PrivacyProtocol:          AES,
+		PrivacyPassphrase:        "password",
+		AuthoritativeEngineBoots: 7,
+		AuthoritativeEngineTime:  100,
+		AuthoritativeEngineID:    receiverEngineID,
+	}
+	tl.Params.SecurityModel = UserSecurityModel
+	tl.Params.MsgFlags = AuthPriv
+
+	// listener goroutine
+	errch := make(chan error)
+	go func() {
+		err := tl.Listen(net.JoinHostPort(trapTestAddress, trapTestPortString))
+		if err != nil {
+			errch <- err
+		}
+	}()
+
+	// Wait until the listener is ready.
+	select {
+	case <-tl.Listening():
+	case err := <-errch:
+		t.Fatalf("error in listen: %v", err)
+	}
+
+	// the sender does not know the receiver's engine, it has to discover it
+	sp := &UsmSecurityParameters{
+		UserName:                 "test",
+		AuthenticationProtocol:   SHA,
+		AuthenticationPassphrase: "password",
+		PrivacyProtocol:          AES,
+		PrivacyPassphrase:        "password",
+	}
+	ts := &GoSNMP{
+		Target:             trapTestAddress,
+		Port:               trapTestPort,
+		Version:            Version3,
+		Timeout:            time.Duration(2) * time.Second,
+		Retries:            3,
+		MaxOids:            MaxOids,
+		SecurityModel:      UserSecurityModel,
+		SecurityParameters: sp,
+		MsgFlags:           AuthPriv,
+	}
+	require.NoError(t, ts.Connect())
+	defer ts.Conn.Close()
+
+	trap := SnmpTrap{
+		Variables: []SnmpPDU{{Name: trapTestOid, Type: OctetString, Value: trapTestPayload}},
+	}
+	resp, err := ts.SendInform(trap)
+	require.NoError(t, err)
+	require.Equal(t, GetResponse, resp.PDUType)
+
+	// wait for response from handler
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for inform to be received")
+	}
+
+	require.Equal(t, receiverEngineID, sp.AuthoritativeEngineID)
+	require.Equal(t, uint32(7), sp.AuthoritativeEngineBoots)
+}
+
+func TestUnmarshalTrapUserTable(t *testing.T) {
+	logger := NewLogger(log.New(io.Discard, "", 0))
+	engine1 := string([]byte{0x80, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04})
+	engine2 := string([]byte{0x80, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03, 0x05})
+	users := []struct {
+		flags SnmpV3MsgFlags
+		sp    *UsmSecurityParameters
+	}{
+		{AuthPriv, &UsmSecurityParameters{UserName: "alice", AuthenticationProtocol: SHA, AuthenticationPassphrase: "alicepassword",
+			PrivacyProtocol: AES, PrivacyPassphrase: "alicepassword", AuthoritativeEngineID: engine1}},
+		{AuthPriv, &UsmSecurityParameters{UserName: "bob", AuthenticationProtocol: MD5, AuthenticationPassphrase: "bobpassword",
+			PrivacyProtocol: DES, PrivacyPassphrase: "bobpassword", AuthoritativeEngineID: engine2}},
+		{AuthNoPriv, &UsmSecurityParameters{UserName: "carol", AuthenticationProtocol: SHA256, AuthenticationPassphrase: "carolpassword",
+			AuthoritativeEngineID: engine2}},
+	}
+
+	receiver := &GoSNMP{
+		Version:            Version3,
+		SecurityModel:      UserSecurityModel,
+		SecurityParameters: &UsmSecurityParameters{Logger: logger},
+		Logger:             logger,
+	}
+	for _, u := range users {
+		require.NoError(t, receiver.BkUsmMap.AddBkUsm(u.flags, u.sp.Copy().(*UsmSecurityParameters)))
+	}
+
+	// encode a trap as sender would, with sp as its security parameters
+	encode := func(flags SnmpV3MsgFlags, usp *UsmSecurityParameters) []byte {
+		sp := usp.Copy().(*UsmSecurityParameters)
+		sender := &GoSNMP{
+			Version:            Version3,
+			SecurityModel:      UserSecurityModel,
+			MsgFlags:           flags,
+			SecurityParameters: sp,
+			Logger:             logger,
+		}
+		require.NoError(t, sp.InitSecurityKeys())
+		pdus := []SnmpPDU{{Name: trapTestOid, Type: OctetString, Value: trapTestPayload}}
+		out, err := sender.SnmpEncodePacket(SNMPv2Trap, pdus, 0, 0)
+		require.NoError(t, err)
+		return out
+	}
+
+	for _, u := range users {
+		trap, err := receiver.UnmarshalTrap(encode(u.flags, u.sp), true)
+		require.NoError(t, err, u.sp.UserName)
+		usp := trap.SecurityParameters.(*UsmSecurityParameters)
+		require.Equal(t, u.sp.UserName, usp.UserName)
+		require.Equal(t, u.sp.AuthoritativeEngineID, usp.AuthoritativeEngineID)
+		require.Equal(t, []byte(trapTestPayload), trap.Variables[0].Value)
+	}
+
+	unknownUser := users[0].sp.Copy().(*UsmSecurityParameters)
+	unknownUser.UserName = "mallory"
+	_, err := receiver.UnmarshalTrap(encode(AuthPriv, unknownUser), true)
+	require.ErrorIs(t, err, ErrUnknownUsername)
+
+	unknownEngine := users[0].sp.Copy().(*UsmSecurityParameters)
+	unknownEngine.AuthoritativeEngineID = engine2
+	_, err = receiver.UnmarshalTrap(encode(AuthPriv, unknownEngine), true)
+	require.ErrorIs(t, err, ErrUnknownEngineID)
+
+	wrongPassword := users[0].sp.Copy().(*UsmSecurityParameters)
+	wrongPassword.AuthenticationPassphrase = "notalicepassword"
+	_, err = receiver.UnmarshalTrap(encode(AuthPriv, wrongPassword), true)
+	require.ErrorIs(t, err, ErrWrongDigest)
+
+	// a configured authPriv user may not downgrade to noAuthNoPriv
+	downgrade := users[0].sp.Copy().(*UsmSecurityParameters)
+	downgrade.AuthenticationProtocol, downgrade.PrivacyProtocol = NoAuth, NoPriv
+	_, err = receiver.UnmarshalTrap(encode(NoAuthNoPriv, downgrade), true)
+	require.Error(t, err)
+}
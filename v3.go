@@ -10,10 +10,13 @@ package gosnmp
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"runtime"
+	"strings"
+	"sync/atomic"
 )
 
 // SnmpV3MsgFlags contains various message flags to describe Authentication, Privacy, and whether a report PDU must be sent.
@@ -32,9 +35,10 @@ const (
 // SnmpV3SecurityModel describes the security model used by a SnmpV3 connection
 type SnmpV3SecurityModel uint8
 
-// UserSecurityModel is the only SnmpV3SecurityModel currently implemented.
+// Implemented SnmpV3SecurityModel values.
 const (
-	UserSecurityModel SnmpV3SecurityModel = 3
+	UserSecurityModel      SnmpV3SecurityModel = 3
+	TransportSecurityModel SnmpV3SecurityModel = 4 // RFC 5591, use with Transport "tls"
 )
 
 //go:generate stringer -type=SnmpV3SecurityModel
@@ -62,8 +66,14 @@ type SnmpV3SecurityParameters interface {
 
 func (x *GoSNMP) validateParametersV3() error {
 	// update following code if you implement a new security model
-	if x.SecurityModel != UserSecurityModel {
-		return errors.New("the SNMPV3 User Security Model is the only SNMPV3 security model currently implemented")
+	switch x.SecurityModel {
+	case UserSecurityModel:
+	case TransportSecurityModel:
+		if !strings.HasPrefix(x.Transport, tlsTransport) {
+			return errors.New("the SNMPV3 Transport Security Model requires the tls transport")
+		}
+	default:
+		return errors.New("the SNMPV3 User and Transport Security Models are the only SNMPV3 security models currently implemented")
 	}
 	if x.SecurityParameters == nil {
 		return errors.New("SNMPV3 SecurityParameters must be set")
@@ -72,6 +82,89 @@ func (x *GoSNMP) validateParametersV3() error {
 	return x.SecurityParameters.validate(x.MsgFlags)
 }
 
+// SwitchUser makes sp, at security level msgFlags, the SNMPv3 USM user
+// of the requests that follow, eg to poll one agent as several users or to
+// reach a proxy with the user it expects. sp is copied. Unless it names an
+// engine of its own, the authoritative engine already discovered by x is
+// kept, so switching costs no discovery round trip, and keys already
+// localized to that engine come from the password cache.
+//
+// Do not switch users while a request is in flight; use a GoSNMP per user
+// for concurrent requests.
+func (x *GoSNMP) SwitchUser(msgFlags SnmpV3MsgFlags, sp *UsmSecurityParameters) error {
+	if x.Version != Version3 || x.SecurityModel != UserSecurityModel {
+		return errors.New("SwitchUser needs an SNMPv3 User Security Model session")
+	}
+	if sp == nil {
+		return errors.New("SwitchUser needs security parameters")
+	}
+	next, err := x.usmUser(msgFlags, sp)
+	if err != nil {
+		return err
+	}
+	x.MsgFlags = msgFlags | Reportable
+	x.SecurityParameters = next
+	return nil
+}
+
+// usmUser returns a copy of sp ready for requests at security level
+// msgFlags, with the authoritative engine of x unless it names its own.
+func (x *GoSNMP) usmUser(msgFlags SnmpV3MsgFlags, sp *UsmSecurityParameters) (*UsmSecurityParameters, error) {
+	next := sp.Copy().(*UsmSecurityParameters)
+	if cur, ok := x.SecurityParameters.(*UsmSecurityParameters); ok && next.AuthoritativeEngineID == "" {
+		cur.mu.Lock()
+		next.AuthoritativeEngineID = cur.AuthoritativeEngineID
+		next.AuthoritativeEngineBoots = cur.AuthoritativeEngineBoots
+		next.AuthoritativeEngineTime = cur.AuthoritativeEngineTime
+		cur.mu.Unlock()
+	}
+	if err := next.validate(msgFlags); err != nil {
+		return nil, err
+	}
+	if err := next.init(x.Logger); err != nil {
+		return nil, err
+	}
+	if next.AuthoritativeEngineID != "" {
+		if err := next.InitSecurityKeys(); err != nil {
+			return nil, err
+		}
+	}
+	return next, nil
+}
+
+// GetNoAuthNoPriv is GetNoAuthNoPrivContext bound to x.Context.
+func (x *GoSNMP) GetNoAuthNoPriv(userName string, oids []string) (result *SnmpPacket, err error) {
+	return x.GetNoAuthNoPrivContext(x.Context, userName, oids)
+}
+
+// GetNoAuthNoPrivContext sends an SNMP GET request for oids at
+// noAuthNoPriv as userName, which needs no passphrases, eg for the objects
+// an agent that otherwise requires authPriv lets any user read. The user
+// and security level of x are left as they are, so other requests may be
+// in flight meanwhile; the authoritative engine x has discovered is
+// reused, and one discovered for the request is kept, as by SwitchUser.
+func (x *GoSNMP) GetNoAuthNoPrivContext(ctx context.Context, userName string, oids []string) (result *SnmpPacket, err error) {
+	if x.Version != Version3 || x.SecurityModel != UserSecurityModel {
+		return nil, errors.New("GetNoAuthNoPriv needs an SNMPv3 User Security Model session")
+	}
+	if len(oids) > x.MaxOids {
+		return nil, fmt.Errorf("oid count (%d) is greater than MaxOids (%d)",
+			len(oids), x.MaxOids)
+	}
+	sp, err := x.usmUser(NoAuthNoPriv, &UsmSecurityParameters{UserName: userName})
+	if err != nil {
+		return nil, err
+	}
+	pdus := make([]SnmpPDU, 0, len(oids))
+	for _, oid := range oids {
+		pdus = append(pdus, SnmpPDU{Name: oid, Type: Null, Value: nil})
+	}
+	packetOut := x.mkSnmpPacket(GetRequest, pdus, 0, 0)
+	packetOut.MsgFlags = NoAuthNoPriv | Reportable
+	packetOut.SecurityParameters = sp
+	return x.sendContext(ctx, packetOut, true)
+}
+
 // authenticate the marshalled result of a snmp version 3 packet
 func (packet *SnmpPacket) authenticate(msg []byte) ([]byte, error) {
 	defer func() {
@@ -95,10 +188,15 @@ func (packet *SnmpPacket) authenticate(msg []byte) ([]byte, error) {
 }
 
 func (x *GoSNMP) testAuthentication(packet []byte, result *SnmpPacket, useResponseSecurityParameters bool) error {
+	return x.testAuthenticationAs(x.MsgFlags, x.SecurityParameters, packet, result, useResponseSecurityParameters)
+}
+
+// testAuthenticationAs is testAuthentication for messages to the user sp
+// at security level msgFlags rather than that of x.
+func (x *GoSNMP) testAuthenticationAs(msgFlags SnmpV3MsgFlags, sp SnmpV3SecurityParameters, packet []byte, result *SnmpPacket, useResponseSecurityParameters bool) error {
 	if x.Version != Version3 {
 		return fmt.Errorf("testAuthentication called with non Version3 connection")
 	}
-	msgFlags := x.MsgFlags
 	if useResponseSecurityParameters {
 		msgFlags = result.MsgFlags
 	}
@@ -109,13 +207,17 @@ func (x *GoSNMP) testAuthentication(packet []byte, result *SnmpPacket, useRespon
 		if useResponseSecurityParameters {
 			authentic, err = result.SecurityParameters.isAuthentic(packet, result)
 		} else {
-			authentic, err = x.SecurityParameters.isAuthentic(packet, result)
+			authentic, err = sp.isAuthentic(packet, result)
 		}
 		if err != nil {
 			return err
 		}
 		if !authentic {
-			return fmt.Errorf("incoming packet is not authentic, discarding")
+			if !x.InsecureSkipAuthVerify {
+				return fmt.Errorf("%w: incoming packet is not authentic, discarding", ErrWrongDigest)
+			}
+			result.AuthErr = fmt.Errorf("%w: incoming packet is not authentic", ErrWrongDigest)
+			x.Logger.Printf("WARNING: %s, keeping it as InsecureSkipAuthVerify is set", result.AuthErr)
 		}
 	}
 
@@ -123,52 +225,77 @@ func (x *GoSNMP) testAuthentication(packet []byte, result *SnmpPacket, useRespon
 }
 
 func (x *GoSNMP) initPacket(packetOut *SnmpPacket) error {
-	if x.MsgFlags&AuthPriv > AuthNoPriv {
+	if packetOut.SecurityModel == TransportSecurityModel && packetOut.ContextEngineID == "" {
+		// there is no engine discovery in TSM, let the agent fill in its own
+		packetOut.ContextEngineID = localEngineID
+	}
+	if packetOut.MsgFlags&AuthPriv > AuthNoPriv {
 		return x.SecurityParameters.InitPacket(packetOut)
 	}
 
 	return nil
 }
 
+// negotiateInitialSecurityParameters readies packetOut for sending,
+// discovering the agent's engine first if need be, which it reports.
+//
 // http://tools.ietf.org/html/rfc2574#section-2.2.3 This code does not
 // check if the last message received was more than 150 seconds ago The
 // snmpds that this code was tested on emit an 'out of time window'
 // error with the new time and this code will retransmit when that is
 // received.
-func (x *GoSNMP) negotiateInitialSecurityParameters(packetOut *SnmpPacket) error {
+func (x *GoSNMP) negotiateInitialSecurityParameters(ctx context.Context, packetOut *SnmpPacket) (discovered bool, err error) {
 	if x.Version != Version3 || packetOut.Version != Version3 {
-		return fmt.Errorf("negotiateInitialSecurityParameters called with non Version3 connection or packet")
+		return false, fmt.Errorf("negotiateInitialSecurityParameters called with non Version3 connection or packet")
 	}
 
 	if x.SecurityModel != packetOut.SecurityModel {
-		return fmt.Errorf("connection security model does not match security model defined in packet")
+		return false, fmt.Errorf("connection security model does not match security model defined in packet")
 	}
 
-	if discoveryPacket := packetOut.SecurityParameters.discoveryRequired(); discoveryPacket != nil {
-		discoveryPacket.ContextName = x.ContextName
-		result, err := x.sendOneRequest(discoveryPacket, true)
+	if _, err := x.loadCachedEngine(packetOut); err != nil {
+		return false, err
+	}
 
-		if err != nil {
-			return err
+	if discoveryPacket := packetOut.SecurityParameters.discoveryRequired(); discoveryPacket != nil {
+		if err := x.discoverEngine(ctx, discoveryPacket, packetOut); err != nil {
+			return false, err
 		}
+		return true, nil
+	}
+	err = packetOut.SecurityParameters.InitSecurityKeys()
+	if err == nil {
+		return false, err
+	}
 
-		err = x.storeSecurityParameters(result)
-		if err != nil {
-			return err
-		}
+	return false, nil
+}
 
-		err = x.updatePktSecurityParameters(packetOut)
-		if err != nil {
-			return err
-		}
-	} else {
-		err := packetOut.SecurityParameters.InitSecurityKeys()
-		if err == nil {
-			return err
-		}
+// discoverEngine sends discoveryPacket and stores the engine parameters of
+// the agent's answer in x and packetOut.
+func (x *GoSNMP) discoverEngine(ctx context.Context, discoveryPacket *SnmpPacket, packetOut *SnmpPacket) error {
+	discoveryPacket.ContextName = x.ContextName
+	result, err := x.sendOneRequestContext(x.discoveryContext(ctx), discoveryPacket, true)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	err = x.storeSecurityParameters(result)
+	if err != nil {
+		return err
+	}
+	x.storeCachedEngine()
+
+	return x.updatePktSecurityParameters(packetOut)
+}
+
+// discoveryContext returns ctx carrying the retries of engine discovery,
+// DiscoveryRetries if set.
+func (x *GoSNMP) discoveryContext(ctx context.Context) context.Context {
+	if x.DiscoveryRetries > 0 {
+		return context.WithValue(ctx, requestRetriesKey{}, x.DiscoveryRetries)
+	}
+	return ctx
 }
 
 // save the connection security parameters after a request/response
@@ -184,6 +311,9 @@ func (x *GoSNMP) storeSecurityParameters(result *SnmpPacket) error {
 	if x.ContextEngineID == "" {
 		x.ContextEngineID = result.SecurityParameters.getDefaultContextEngineID()
 	}
+	if result.MsgMaxSize != 0 {
+		atomic.StoreUint32(&x.agentMaxMsgSize, result.MsgMaxSize)
+	}
 
 	return x.SecurityParameters.setSecurityParameters(result.SecurityParameters)
 }
@@ -226,8 +356,10 @@ func (packet *SnmpPacket) marshalV3(buf *bytes.Buffer) (*bytes.Buffer, error) {
 	if err != nil {
 		return emptyBuffer, err
 	}
-	packet.Logger.Printf("Marshal V3 SecurityParameters len=%d. Eaten Last 4 Bytes=%v",
-		len(securityParameters), securityParameters[len(securityParameters)-4:])
+	if len(securityParameters) >= 4 {
+		packet.Logger.Printf("Marshal V3 SecurityParameters len=%d. Eaten Last 4 Bytes=%v",
+			len(securityParameters), securityParameters[len(securityParameters)-4:])
+	}
 
 	buf.Write([]byte{byte(OctetString)})
 	secParamLen, err := marshalLength(len(securityParameters))
@@ -288,18 +420,16 @@ func (packet *SnmpPacket) marshalV3Header() ([]byte, error) {
 
 // marshal and encrypt (if necessary) a snmp version 3 Scoped PDU
 func (packet *SnmpPacket) marshalV3ScopedPDU() ([]byte, error) {
-	var b []byte
+	buf := getBuffer()
+	defer putBuffer(buf)
 
-	scopedPdu, err := packet.prepareV3ScopedPDU()
-	if err != nil {
+	if err := packet.prepareV3ScopedPDU(buf); err != nil {
 		return nil, err
 	}
-	pduLen, err := marshalLength(len(scopedPdu))
+	scopedPdu, err := wrapBuffer(byte(Sequence), buf)
 	if err != nil {
 		return nil, err
 	}
-	b = append([]byte{byte(Sequence)}, pduLen...)
-	scopedPdu = append(b, scopedPdu...)
 	if packet.MsgFlags&AuthPriv > AuthNoPriv {
 		scopedPdu, err = packet.SecurityParameters.encryptPacket(scopedPdu)
 		if err != nil {
@@ -310,32 +440,32 @@ func (packet *SnmpPacket) marshalV3ScopedPDU() ([]byte, error) {
 	return scopedPdu, nil
 }
 
-// prepare the plain text of a snmp version 3 Scoped PDU
-func (packet *SnmpPacket) prepareV3ScopedPDU() ([]byte, error) {
-	var buf bytes.Buffer
-
+// prepare the plain text of a snmp version 3 Scoped PDU in buf
+func (packet *SnmpPacket) prepareV3ScopedPDU(buf *bytes.Buffer) error {
 	// ContextEngineID
 	idlen, err := marshalLength(len(packet.ContextEngineID))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	buf.Write(append([]byte{byte(OctetString)}, idlen...))
+	buf.WriteByte(byte(OctetString))
+	buf.Write(idlen)
 	buf.WriteString(packet.ContextEngineID)
 
 	// ContextName
 	namelen, err := marshalLength(len(packet.ContextName))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	buf.Write(append([]byte{byte(OctetString)}, namelen...))
+	buf.WriteByte(byte(OctetString))
+	buf.Write(namelen)
 	buf.WriteString(packet.ContextName)
 
 	data, err := packet.marshalPDU()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	buf.Write(data)
-	return buf.Bytes(), nil
+	return nil
 }
 
 func (x *GoSNMP) unmarshalV3Header(packet []byte,
@@ -345,10 +475,13 @@ func (x *GoSNMP) unmarshalV3Header(packet []byte,
 		return 0, fmt.Errorf("invalid SNMPV3 Header")
 	}
 
-	_, cursorTmp, err := parseLength(packet[cursor:])
+	headerLength, cursorTmp, err := parseLength(packet[cursor:])
 	if err != nil {
 		return 0, err
 	}
+	if cursor+headerLength > len(packet) {
+		return 0, fmt.Errorf("error parsing SNMPV3 header: truncated packet, length %d at %d of %d", headerLength, cursor, len(packet))
+	}
 	cursor += cursorTmp
 	if cursor > len(packet) {
 		return 0, errors.New("error parsing SNMPV3 message ID: truncted packet")
@@ -413,10 +546,14 @@ func (x *GoSNMP) unmarshalV3Header(packet []byte,
 	if PDUType(packet[cursor]) != PDUType(OctetString) {
 		return 0, errors.New("invalid SNMPV3 Security Parameters")
 	}
-	_, cursorTmp, err = parseLength(packet[cursor:])
+	secParamsLength, cursorTmp, err := parseLength(packet[cursor:])
 	if err != nil {
 		return 0, err
 	}
+	if cursor+secParamsLength > len(packet) {
+		return 0, fmt.Errorf("error parsing SNMPV3 Security Parameters: truncated packet, length %d at %d of %d", secParamsLength, cursor, len(packet))
+	}
+	secParamsEnd := cursor + secParamsLength
 	cursor += cursorTmp
 	if cursor > len(packet) {
 		return 0, errors.New("error parsing SNMPV3 message ID: truncted packet")
@@ -425,8 +562,11 @@ func (x *GoSNMP) unmarshalV3Header(packet []byte,
 		response.SecurityParameters = &UsmSecurityParameters{Logger: x.Logger}
 	}
 
-	x.bkGetSecurityParameters(packet, cursor, response)
-	cursor, err = response.SecurityParameters.unmarshal(response.MsgFlags, packet, cursor)
+	if err = x.bkGetSecurityParameters(packet, cursor, response); err != nil {
+		return 0, err
+	}
+	// the security parameters may not run past their OCTET STRING
+	cursor, err = response.SecurityParameters.unmarshal(response.MsgFlags, packet[:secParamsEnd], cursor)
 	if err != nil {
 		return 0, err
 	}
@@ -504,28 +644,41 @@ func (x *GoSNMP) decryptPacket(packet []byte, cursor int, response *SnmpPacket)
 	return packet, cursor, nil
 }
 
-func (x *GoSNMP) bkGetSecurityParameters(packet []byte, cursor int, response *SnmpPacket) {
+// bkGetSecurityParameters picks the BkUsmMap entry matching the
+// msgUserName and msgAuthoritativeEngineID of packet, so one listener can
+// verify and decrypt messages from many users and engines. Once a table is
+// configured, messages from anyone not in it are rejected.
+func (x *GoSNMP) bkGetSecurityParameters(packet []byte, cursor int, response *SnmpPacket) error {
 	if len(x.BkUsmMap.usmMap) == 0 {
-		return
+		return nil
 	}
-	cpSp := response.SecurityParameters.Copy()
-	cpPacket := packet
-	cpCusrsor := cursor
-	_, err := cpSp.unmarshal(NoAuthNoPriv, cpPacket, cpCusrsor)
+	cpSp, ok := response.SecurityParameters.Copy().(*UsmSecurityParameters)
+	if !ok {
+		return nil
+	}
+	cpSp.Logger = x.Logger
+	// only peek at the names here, keys are derived from the table entry
+	cpSp.AuthenticationProtocol, cpSp.PrivacyProtocol = NoAuth, NoPriv
+	_, err := cpSp.unmarshal(NoAuthNoPriv, packet, cursor)
 	if err != nil {
-		x.Logger.Printf("bk parse username error %v", err)
-	}
-	username := cpSp.(*UsmSecurityParameters).UserName
-	engineID := cpSp.(*UsmSecurityParameters).AuthoritativeEngineID
-	if username != "" && engineID != "" {
-		bkUsm := x.BkUsmMap.GetBkUsmByUserNameEngineID(username, engineID)
-		if bkUsm == nil {
-			x.Logger.Printf("can not match username[%s] engineID[%s] in bkusmmap", username, engineID)
-			return
+		return fmt.Errorf("bk parse username error: %w", err)
+	}
+	username := cpSp.UserName
+	engineID := cpSp.AuthoritativeEngineID
+	if username == "" || engineID == "" {
+		// engine ID discovery, nothing to authenticate yet
+		return nil
+	}
+	bkUsm := x.BkUsmMap.GetBkUsmByUserNameEngineID(username, engineID)
+	if bkUsm == nil {
+		if x.BkUsmMap.hasUserName(username) {
+			return fmt.Errorf("%w: user %q is not configured for engine %x", ErrUnknownEngineID, username, engineID)
 		}
-		bkUsm.Logger = x.Logger
-		response.MsgFlags = bkUsm.MsgFlag
-		response.SecurityParameters = bkUsm.UsmSecurityParameters
-		//x.SecurityParameters = bkUsm.UsmSecurityParameters
+		return fmt.Errorf("%w: %q (engine %x)", ErrUnknownUsername, username, engineID)
 	}
+	sp := bkUsm.UsmSecurityParameters.Copy().(*UsmSecurityParameters)
+	sp.Logger = x.Logger
+	response.MsgFlags = bkUsm.MsgFlag | response.MsgFlags&Reportable
+	response.SecurityParameters = sp
+	return nil
 }
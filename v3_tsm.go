@@ -0,0 +1,174 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// localEngineID is the RFC 5343 contextEngineID a command generator may use
+// when it does not know the agent's snmpEngineID; the agent treats it as its
+// own. TSM has no discovery exchange, so this is the default context engine.
+const localEngineID = "\x80\x00\x00\x00\x06"
+
+// TsmSecurityParameters implements SnmpV3SecurityParameters for the Transport
+// Security Model (RFC 5591). Authentication and privacy are provided by the
+// TLS transport (RFC 6353), so messages carry an empty msgSecurityParameters
+// and are neither signed nor encrypted by gosnmp. Use it with Transport "tls"
+// and SecurityModel TransportSecurityModel.
+type TsmSecurityParameters struct {
+	// SecurityName is the local principal the session runs as. RFC 6353
+	// derives it from our certificate on the agent side; it is kept here
+	// for logging and for callers that manage several sessions.
+	SecurityName string
+
+	Logger Logger
+}
+
+// Description returns the security name.
+func (sp *TsmSecurityParameters) Description() string {
+	return "tsm,securityName=" + sp.SecurityName
+}
+
+// SafeString returns a logging safe (no secrets) string of the TsmSecurityParameters
+func (sp *TsmSecurityParameters) SafeString() string {
+	return fmt.Sprintf("SecurityName:%s", sp.SecurityName)
+}
+
+// Log logs security parameter information to the provided GoSNMP Logger
+func (sp *TsmSecurityParameters) Log() {
+	if sp.Logger.enabled() {
+		sp.Logger.Printf("SECURITY PARAMETERS:%s", sp.SafeString())
+	}
+}
+
+// Copy method for TsmSecurityParameters used to copy a SnmpV3SecurityParameters without knowing it's implementation
+func (sp *TsmSecurityParameters) Copy() SnmpV3SecurityParameters {
+	return &TsmSecurityParameters{SecurityName: sp.SecurityName, Logger: sp.Logger}
+}
+
+// InitPacket is a no-op, there are no per-packet parameters in TSM.
+func (sp *TsmSecurityParameters) InitPacket(packet *SnmpPacket) error {
+	return nil
+}
+
+// InitSecurityKeys is a no-op, the keys live in the TLS session.
+func (sp *TsmSecurityParameters) InitSecurityKeys() error {
+	return nil
+}
+
+// validate accepts every security level: TLS always provides authPriv,
+// which satisfies any level requested in msgFlags.
+func (sp *TsmSecurityParameters) validate(flags SnmpV3MsgFlags) error {
+	return nil
+}
+
+func (sp *TsmSecurityParameters) init(log Logger) error {
+	sp.Logger = log
+	return nil
+}
+
+func (sp *TsmSecurityParameters) discoveryRequired() *SnmpPacket {
+	return nil
+}
+
+func (sp *TsmSecurityParameters) getDefaultContextEngineID() string {
+	return localEngineID
+}
+
+func (sp *TsmSecurityParameters) setSecurityParameters(in SnmpV3SecurityParameters) error {
+	tsm, ok := in.(*TsmSecurityParameters)
+	if !ok || tsm == nil {
+		return fmt.Errorf("param SnmpV3SecurityParameters is not of type *TsmSecurityParameters")
+	}
+	sp.SecurityName = tsm.SecurityName
+	return nil
+}
+
+// msgSecurityParameters is a zero-length OCTET STRING (RFC 5591 4.2)
+func (sp *TsmSecurityParameters) marshal(flags SnmpV3MsgFlags) ([]byte, error) {
+	return []byte{}, nil
+}
+
+func (sp *TsmSecurityParameters) unmarshal(flags SnmpV3MsgFlags, packet []byte, cursor int) (int, error) {
+	return cursor, nil
+}
+
+func (sp *TsmSecurityParameters) authenticate(packet []byte) error {
+	return nil
+}
+
+func (sp *TsmSecurityParameters) isAuthentic(packetBytes []byte, packet *SnmpPacket) (bool, error) {
+	return true, nil
+}
+
+func (sp *TsmSecurityParameters) encryptPacket(scopedPdu []byte) ([]byte, error) {
+	return scopedPdu, nil
+}
+
+func (sp *TsmSecurityParameters) decryptPacket(packet []byte, cursor int) ([]byte, error) {
+	return packet, nil
+}
+
+// TLSSecurityName maps a certificate to a tmSecurityName the way the
+// snmpTlstmCertSANAny and snmpTlstmCertCommonName map types of RFC 6353
+// do: the first rfc822Name, dNSName or iPAddress subjectAltName, falling
+// back to the subject CommonName. Domain parts are lower-cased and IP
+// addresses are rendered as in RFC 6353 5.1 (dotted quad for IPv4, 32
+// lower-case hex digits for IPv6).
+func TLSSecurityName(cert *x509.Certificate) (string, error) {
+	if cert == nil {
+		return "", errors.New("no certificate")
+	}
+	for _, email := range cert.EmailAddresses {
+		if at := strings.LastIndexByte(email, '@'); at >= 0 {
+			return email[:at+1] + strings.ToLower(email[at+1:]), nil
+		}
+	}
+	for _, name := range cert.DNSNames {
+		return strings.ToLower(name), nil
+	}
+	for _, ip := range cert.IPAddresses {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.String(), nil
+		}
+		return hex.EncodeToString(ip.To16()), nil
+	}
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName, nil
+	}
+	return "", errors.New("certificate has no identity usable as a security name")
+}
+
+// PeerSecurityName returns the tmSecurityName of the agent at the other end
+// of a "tls" transport, derived from its certificate by TLSSecurityName.
+func (x *GoSNMP) PeerSecurityName() (string, error) {
+	tlsConn, ok := x.Conn.(*tls.Conn)
+	if !ok {
+		return "", errors.New("not connected over TLS")
+	}
+	peers := tlsConn.ConnectionState().PeerCertificates
+	if len(peers) == 0 {
+		return "", errors.New("agent presented no certificate")
+	}
+	return TLSSecurityName(peers[0])
+}
+
+// dialTLS connects Transport "tls"/"tls4"/"tls6": TLS over TCP as in
+// RFC 6353. DTLS over UDP is not available in the standard library.
+func (x *GoSNMP) dialTLS(addr string, localAddr net.Addr) (net.Conn, error) {
+	// with no ServerName set, tls.Dialer verifies the agent against the host in addr
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: x.Timeout, LocalAddr: localAddr, Control: x.Control},
+		Config:    x.TLSConfig,
+	}
+	return dialer.DialContext(x.Context, tcp+strings.TrimPrefix(x.Transport, tlsTransport), addr)
+}
@@ -0,0 +1,194 @@
+// Copyright 2012 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// tsmTestCert issues a certificate for tmpl signed by parent (self-signed
+// when parent is nil).
+func tsmTestCert(t *testing.T, tmpl *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := tmpl, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestTLSSecurityName(t *testing.T) {
+	tests := []struct {
+		cert *x509.Certificate
+		name string
+	}{
+		{&x509.Certificate{EmailAddresses: []string{"Ops@Example.COM"}, DNSNames: []string{"a.example"}}, "Ops@example.com"},
+		{&x509.Certificate{DNSNames: []string{"Agent.Example"}}, "agent.example"},
+		{&x509.Certificate{IPAddresses: []net.IP{net.ParseIP("192.0.2.1")}}, "192.0.2.1"},
+		{&x509.Certificate{IPAddresses: []net.IP{net.ParseIP("2001:db8::1")}}, "20010db8000000000000000000000001"},
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "router1"}}, "router1"},
+	}
+	for _, test := range tests {
+		name, err := TLSSecurityName(test.cert)
+		require.NoError(t, err)
+		require.Equal(t, test.name, name)
+	}
+
+	_, err := TLSSecurityName(&x509.Certificate{})
+	require.Error(t, err)
+}
+
+func TestTSMRequiresTLS(t *testing.T) {
+	x := &GoSNMP{
+		Version:            Version3,
+		Transport:          udp,
+		SecurityModel:      TransportSecurityModel,
+		SecurityParameters: &TsmSecurityParameters{},
+		Logger:             NewLogger(log.New(io.Discard, "", 0)),
+	}
+	require.Error(t, x.validateParameters())
+}
+
+func TestTSMOverTLS(t *testing.T) {
+	ca := tsmTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	server := tsmTestCert(t, &x509.Certificate{
+		DNSNames:    []string{"agent.example"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	client := tsmTestCert(t, &x509.Certificate{
+		EmailAddresses: []string{"manager@example.com"},
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+
+	l, err := tls.Listen("tcp4", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    roots,
+	})
+	require.NoError(t, err)
+	defer l.Close()
+
+	agent := &GoSNMP{
+		Version:            Version3,
+		SecurityModel:      TransportSecurityModel,
+		MsgFlags:           AuthPriv,
+		SecurityParameters: &TsmSecurityParameters{},
+		Logger:             NewLogger(log.New(io.Discard, "", 0)),
+	}
+	type seen struct {
+		securityName    string
+		contextEngineID string
+		emptySecParams  bool
+	}
+	seenCh := make(chan seen, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tlsConn := conn.(*tls.Conn)
+		if tlsConn.Handshake() != nil {
+			return
+		}
+		securityName, _ := TLSSecurityName(tlsConn.ConnectionState().PeerCertificates[0])
+
+		req, err := readBERMessage(conn, rxBufSize)
+		if err != nil {
+			return
+		}
+		reqPkt := &SnmpPacket{SecurityParameters: &TsmSecurityParameters{Logger: agent.Logger}}
+		cursor, err := agent.unmarshalHeader(req, reqPkt)
+		if err != nil {
+			return
+		}
+		// the header ends with msgSecurityModel 4 and an empty msgSecurityParameters
+		emptySecParams := bytes.HasSuffix(req[:cursor], []byte{byte(Integer), 1, 4, byte(OctetString), 0})
+		req, cursor, err = agent.decryptPacket(req, cursor, reqPkt)
+		if err != nil {
+			return
+		}
+		if agent.unmarshalPayload(req, cursor, reqPkt) != nil {
+			return
+		}
+		seenCh <- seen{securityName, reqPkt.ContextEngineID, emptySecParams}
+
+		rspPkt := agent.mkSnmpPacket(GetResponse, []SnmpPDU{{Name: ".1.2", Type: Integer, Value: 42}}, 0, 0)
+		rspPkt.Version = Version3
+		rspPkt.MsgID = reqPkt.MsgID
+		rspPkt.RequestID = reqPkt.RequestID
+		rspPkt.ContextEngineID = "agent"
+		outBuf, err := rspPkt.marshalMsg()
+		if err != nil {
+			return
+		}
+		conn.Write(outBuf)
+	}()
+
+	x := &GoSNMP{
+		Version:            Version3,
+		Transport:          "tls",
+		Target:             "127.0.0.1",
+		Port:               uint16(l.Addr().(*net.TCPAddr).Port),
+		Timeout:            time.Second,
+		SecurityModel:      TransportSecurityModel,
+		MsgFlags:           AuthPriv,
+		SecurityParameters: &TsmSecurityParameters{SecurityName: "manager@example.com"},
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{client},
+			RootCAs:      roots,
+		},
+		Logger: NewLogger(log.New(io.Discard, "", 0)),
+	}
+	require.NoError(t, x.Connect())
+	defer x.Conn.Close()
+	// the local engine ID defaults per request, validating leaves x alone
+	require.Empty(t, x.ContextEngineID)
+
+	peer, err := x.PeerSecurityName()
+	require.NoError(t, err)
+	require.Equal(t, "agent.example", peer)
+
+	result, err := x.Get([]string{".1.2"})
+	require.NoError(t, err)
+	require.Equal(t, 42, result.Variables[0].Value)
+
+	s := <-seenCh
+	require.Equal(t, "manager@example.com", s.securityName)
+	require.Equal(t, localEngineID, s.contextEngineID)
+	require.True(t, s.emptySecParams)
+}
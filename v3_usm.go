@@ -25,6 +25,7 @@ import (
 	"errors"
 	"fmt"
 	"hash"
+	"io"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -64,66 +65,59 @@ func (authProtocol SnmpV3AuthProtocol) HashType() crypto.Hash {
 	}
 }
 
+// authParamsLen returns the number of octets of the truncated HMAC carried in
+// msgAuthenticationParameters, as defined by RFC 3414 and RFC 7860.
+func (authProtocol SnmpV3AuthProtocol) authParamsLen() int {
+	switch authProtocol {
+	case MD5, SHA:
+		return 12
+	case SHA224:
+		return 16
+	case SHA256:
+		return 24
+	case SHA384:
+		return 32
+	case SHA512:
+		return 48
+	default:
+		return 0
+	}
+}
+
+// macVarbinds holds the zeroed msgAuthenticationParameters placeholder of each
+// SnmpV3AuthProtocol, sized by authParamsLen. It is indexed by the protocol.
+//
 //nolint:gochecknoglobals
-var macVarbinds = [][]byte{
-	{},
-	{byte(OctetString), 0},
-	{byte(OctetString), 12,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0},
-	{byte(OctetString), 12,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0},
-	{byte(OctetString), 16,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0},
-	{byte(OctetString), 24,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0},
-	{byte(OctetString), 32,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0},
-	{byte(OctetString), 48,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0,
-		0, 0, 0, 0}}
+var macVarbinds = func() [][]byte {
+	mv := make([][]byte, SHA512+1)
+	mv[0] = []byte{}
+	for authProtocol := NoAuth; authProtocol <= SHA512; authProtocol++ {
+		n := authProtocol.authParamsLen()
+		mv[authProtocol] = append([]byte{byte(OctetString), byte(n)}, make([]byte, n)...)
+	}
+	return mv
+}()
 
 // SnmpV3PrivProtocol is the privacy protocol in use by an private SnmpV3 connection.
 type SnmpV3PrivProtocol uint8
 
 // NoPriv, DES implemented, AES planned
 // Changed: AES192, AES256, AES192C, AES256C added
+//
+// AES192 and AES256 extend the localized key as described in
+// draft-blumenthal-aes-usm-04 (net-snmp's default). AES192C and AES256C use the
+// Reeder key extension instead, which is what Cisco devices implement. The two
+// variants produce different keys from the same passphrase, so they must match
+// the agent's implementation or decryption fails.
 const (
-	NoPriv  SnmpV3PrivProtocol = 1
-	DES     SnmpV3PrivProtocol = 2
-	AES     SnmpV3PrivProtocol = 3
-	AES192  SnmpV3PrivProtocol = 4 // Blumenthal-AES192
-	AES256  SnmpV3PrivProtocol = 5 // Blumenthal-AES256
-	AES192C SnmpV3PrivProtocol = 6 // Reeder-AES192
-	AES256C SnmpV3PrivProtocol = 7 // Reeder-AES256
+	NoPriv    SnmpV3PrivProtocol = 1
+	DES       SnmpV3PrivProtocol = 2
+	AES       SnmpV3PrivProtocol = 3
+	AES192    SnmpV3PrivProtocol = 4 // Blumenthal-AES192
+	AES256    SnmpV3PrivProtocol = 5 // Blumenthal-AES256
+	AES192C   SnmpV3PrivProtocol = 6 // Reeder-AES192
+	AES256C   SnmpV3PrivProtocol = 7 // Reeder-AES256
+	TripleDES SnmpV3PrivProtocol = 8 // Reeder-3DES-EDE
 )
 
 //go:generate stringer -type=SnmpV3PrivProtocol
@@ -135,6 +129,11 @@ type UsmSecurityParameters struct {
 	localAESSalt uint64
 	localDESSalt uint32
 
+	// AuthoritativeEngineID, AuthoritativeEngineBoots and
+	// AuthoritativeEngineTime are those of the authoritative engine, the
+	// agent's for requests and informs. They are learnt by discovery
+	// before the first request unless AuthoritativeEngineID is set, see
+	// WithV3Engine.
 	AuthoritativeEngineID    string
 	AuthoritativeEngineBoots uint32
 	AuthoritativeEngineTime  uint32
@@ -148,9 +147,28 @@ type UsmSecurityParameters struct {
 	AuthenticationPassphrase string
 	PrivacyPassphrase        string
 
+	// AuthenticationPassphraseBytes and PrivacyPassphraseBytes may be set
+	// instead of the string passphrases so they can be wiped: once keys
+	// have been localized to the agent's engine the slices are zeroed and
+	// set to nil, and they never enter the password cache. The caller
+	// should not keep other copies. Keys for a later change of engine are
+	// then unavailable, so a GoSNMP using them serves only one agent.
+	AuthenticationPassphraseBytes []byte
+	PrivacyPassphraseBytes        []byte
+
+	// SecretKey and PrivacyKey are the authentication and privacy keys
+	// localized to AuthoritativeEngineID. They are derived from the
+	// passphrases unless set, eg from LocalizeKey and LocalizePrivKey.
 	SecretKey  []byte
 	PrivacyKey []byte
 
+	// SaltRand is the source of the initial privacy salt, which is then
+	// incremented per message: a vetted entropy source where an audit asks
+	// for one, or a seeded generator to reproduce encrypted messages in
+	// tests. Salts must not repeat under a key, so never use a predictable
+	// source in production. (default: crypto/rand)
+	SaltRand io.Reader
+
 	Logger Logger
 }
 
@@ -199,6 +217,8 @@ func (sp *UsmSecurityParameters) Description() string {
 		sb.WriteString(",priv=AES192C")
 	case AES256C:
 		sb.WriteString(",priv=AES256C")
+	case TripleDES:
+		sb.WriteString(",priv=3DES")
 	}
 	sb.WriteString(",privPass=")
 	sb.WriteString(sp.PrivacyPassphrase)
@@ -224,7 +244,9 @@ func (sp *UsmSecurityParameters) SafeString() string {
 func (sp *UsmSecurityParameters) Log() {
 	sp.mu.Lock()
 	defer sp.mu.Unlock()
-	sp.Logger.Printf("SECURITY PARAMETERS:%s", sp.SafeString())
+	if sp.Logger.enabled() {
+		sp.Logger.Printf("SECURITY PARAMETERS:%s", sp.SafeString())
+	}
 }
 
 // Copy method for UsmSecurityParameters used to copy a SnmpV3SecurityParameters without knowing it's implementation
@@ -241,11 +263,15 @@ func (sp *UsmSecurityParameters) Copy() SnmpV3SecurityParameters {
 		PrivacyProtocol:          sp.PrivacyProtocol,
 		AuthenticationPassphrase: sp.AuthenticationPassphrase,
 		PrivacyPassphrase:        sp.PrivacyPassphrase,
-		SecretKey:                sp.SecretKey,
-		PrivacyKey:               sp.PrivacyKey,
-		localDESSalt:             sp.localDESSalt,
-		localAESSalt:             sp.localAESSalt,
-		Logger:                   sp.Logger,
+		// shared, so wiping them after deriving keys covers the copies
+		AuthenticationPassphraseBytes: sp.AuthenticationPassphraseBytes,
+		PrivacyPassphraseBytes:        sp.PrivacyPassphraseBytes,
+		SecretKey:                     sp.SecretKey,
+		PrivacyKey:                    sp.PrivacyKey,
+		localDESSalt:                  sp.localDESSalt,
+		localAESSalt:                  sp.localAESSalt,
+		SaltRand:                      sp.SaltRand,
+		Logger:                        sp.Logger,
 	}
 }
 
@@ -264,37 +290,112 @@ func (sp *UsmSecurityParameters) InitSecurityKeys() error {
 func (sp *UsmSecurityParameters) InitSecurityKeysNoLock() error {
 	var err error
 
+	authPassphrase := secret{s: sp.AuthenticationPassphrase, b: sp.AuthenticationPassphraseBytes}
+	privPassphrase := secret{s: sp.PrivacyPassphrase, b: sp.PrivacyPassphraseBytes}
 	if sp.AuthenticationProtocol > NoAuth && len(sp.SecretKey) == 0 {
-		sp.SecretKey, err = genlocalkey(sp.AuthenticationProtocol,
-			sp.AuthenticationPassphrase,
+		if len(authPassphrase.b) > 0 && authPassphrase.wiped() {
+			return errors.New("securityParameters.AuthenticationPassphraseBytes has been wiped")
+		}
+		sp.SecretKey, err = localKey(sp.AuthenticationProtocol,
+			authPassphrase,
 			sp.AuthoritativeEngineID)
 		if err != nil {
 			return err
 		}
 	}
 	if sp.PrivacyProtocol > NoPriv && len(sp.PrivacyKey) == 0 {
-		switch sp.PrivacyProtocol {
-		// Changed: The Output of SHA1 is a 20 octets array, therefore for AES128 (16 octets) either key extension algorithm can be used.
-		case AES, AES192, AES256, AES192C, AES256C:
-			// Use abstract AES key localization algorithms.
-			sp.PrivacyKey, err = genlocalPrivKey(sp.PrivacyProtocol, sp.AuthenticationProtocol,
-				sp.PrivacyPassphrase,
-				sp.AuthoritativeEngineID)
-			if err != nil {
-				return err
-			}
-		default:
-			sp.PrivacyKey, err = genlocalkey(sp.AuthenticationProtocol,
-				sp.PrivacyPassphrase,
-				sp.AuthoritativeEngineID)
-			if err != nil {
-				return err
-			}
+		if len(privPassphrase.b) > 0 && privPassphrase.wiped() {
+			return errors.New("securityParameters.PrivacyPassphraseBytes has been wiped")
+		}
+		sp.PrivacyKey, err = localizePrivKey(sp.PrivacyProtocol, sp.AuthenticationProtocol,
+			privPassphrase,
+			sp.AuthoritativeEngineID)
+		if err != nil {
+			return err
 		}
 	}
+	// keys localized to the agent's engine are all that is needed from now
+	if sp.AuthoritativeEngineID != "" {
+		zero(sp.AuthenticationPassphraseBytes)
+		zero(sp.PrivacyPassphraseBytes)
+		sp.AuthenticationPassphraseBytes = nil
+		sp.PrivacyPassphraseBytes = nil
+	}
 	return nil
 }
 
+// LocalizeKey returns the authentication key for passphrase localized to
+// engineID (RFC 3414 A.2), as used for SecretKey. Deriving it hashes a
+// megabyte, so callers opening many sessions to one agent can compute it
+// once and set SecretKey, together with AuthoritativeEngineID, instead of
+// AuthenticationPassphrase.
+func LocalizeKey(passphrase string, engineID string, authProtocol SnmpV3AuthProtocol) ([]byte, error) {
+	if authProtocol <= NoAuth {
+		return nil, fmt.Errorf("LocalizeKey: no key for authentication protocol %s", authProtocol)
+	}
+	if passphrase == "" || engineID == "" {
+		return nil, errors.New("LocalizeKey: passphrase and engineID are required")
+	}
+	return genlocalkey(authProtocol, passphrase, engineID)
+}
+
+// LocalizePrivKey returns the privacy key for passphrase localized to
+// engineID, as used for PrivacyKey, including the key extension
+// privProtocol requires. See LocalizeKey.
+func LocalizePrivKey(passphrase string, engineID string, authProtocol SnmpV3AuthProtocol, privProtocol SnmpV3PrivProtocol) ([]byte, error) {
+	if authProtocol <= NoAuth || privProtocol <= NoPriv {
+		return nil, fmt.Errorf("LocalizePrivKey: no key for protocols %s and %s", authProtocol, privProtocol)
+	}
+	if passphrase == "" || engineID == "" {
+		return nil, errors.New("LocalizePrivKey: passphrase and engineID are required")
+	}
+	return localizePrivKey(privProtocol, authProtocol, secret{s: passphrase}, engineID)
+}
+
+func localizePrivKey(privProtocol SnmpV3PrivProtocol, authProtocol SnmpV3AuthProtocol, password secret, engineID string) ([]byte, error) {
+	switch privProtocol {
+	// Changed: The Output of SHA1 is a 20 octets array, therefore for AES128 (16 octets) either key extension algorithm can be used.
+	case AES, AES192, AES256, AES192C, AES256C, TripleDES:
+		// Use abstract AES key localization algorithms.
+		return localPrivKey(privProtocol, authProtocol, password, engineID)
+	default:
+		return localKey(authProtocol, password, engineID)
+	}
+}
+
+// secret is a passphrase from a string field or, when that is empty,
+// from a []byte field that is wiped after use. Byte passphrases bypass the
+// password cache, whose keys would keep them in memory.
+type secret struct {
+	s string
+	b []byte
+}
+
+func (p secret) bytes() []byte {
+	if p.s != "" {
+		return []byte(p.s)
+	}
+	return p.b
+}
+
+func (p secret) cacheKey(authProtocol SnmpV3AuthProtocol) string {
+	return cacheKey(authProtocol, p.s)
+}
+
+// wiped reports whether p is a byte passphrase that has already been zeroed
+// (possibly through a copy of the security parameters) or is empty.
+func (p secret) wiped() bool {
+	if p.s != "" {
+		return false
+	}
+	for _, c := range p.b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func (sp *UsmSecurityParameters) setSecurityParameters(in SnmpV3SecurityParameters) error {
 	var insp *UsmSecurityParameters
 	var err error
@@ -307,9 +408,27 @@ func (sp *UsmSecurityParameters) setSecurityParameters(in SnmpV3SecurityParamete
 	}
 
 	if sp.AuthoritativeEngineID != insp.AuthoritativeEngineID {
+		authPassphrase := secret{s: sp.AuthenticationPassphrase, b: sp.AuthenticationPassphraseBytes}
+		privPassphrase := secret{s: sp.PrivacyPassphrase, b: sp.PrivacyPassphraseBytes}
+		// keys supplied already localized, or whose byte passphrases were
+		// wiped, cannot follow the agent to a new engine
+		if (sp.AuthenticationProtocol > NoAuth && authPassphrase.wiped() && len(sp.SecretKey) > 0) ||
+			(sp.PrivacyProtocol > NoPriv && privPassphrase.wiped() && len(sp.PrivacyKey) > 0) {
+			return fmt.Errorf("localized keys were set for engine %x but the agent's engine is %x",
+				sp.AuthoritativeEngineID, insp.AuthoritativeEngineID)
+		}
 		sp.AuthoritativeEngineID = insp.AuthoritativeEngineID
 		sp.SecretKey = nil
 		sp.PrivacyKey = nil
+		if insp.UserName == sp.UserName &&
+			insp.AuthenticationProtocol == sp.AuthenticationProtocol &&
+			insp.PrivacyProtocol == sp.PrivacyProtocol &&
+			(authPassphrase.s == "" || privPassphrase.s == "") {
+			// a copy sharing byte passphrases the original has wiped
+			// after localizing them to this engine takes its keys
+			sp.SecretKey = insp.SecretKey
+			sp.PrivacyKey = insp.PrivacyKey
+		}
 
 		err = sp.InitSecurityKeysNoLock()
 		if err != nil {
@@ -345,13 +464,13 @@ func (sp *UsmSecurityParameters) validate(flags SnmpV3MsgFlags) error {
 	}
 
 	if sp.PrivacyProtocol > NoPriv && len(sp.PrivacyKey) == 0 {
-		if sp.PrivacyPassphrase == "" {
+		if sp.PrivacyPassphrase == "" && len(sp.PrivacyPassphraseBytes) == 0 {
 			return fmt.Errorf("securityParameters.PrivacyPassphrase is required when a privacy protocol is specified")
 		}
 	}
 
 	if sp.AuthenticationProtocol > NoAuth && len(sp.SecretKey) == 0 {
-		if sp.AuthenticationPassphrase == "" {
+		if sp.AuthenticationPassphrase == "" && len(sp.AuthenticationPassphraseBytes) == 0 {
 			return fmt.Errorf("securityParameters.AuthenticationPassphrase is required when an authentication protocol is specified")
 		}
 	}
@@ -364,17 +483,21 @@ func (sp *UsmSecurityParameters) init(log Logger) error {
 
 	sp.Logger = log
 
+	saltRand := sp.SaltRand
+	if saltRand == nil {
+		saltRand = crand.Reader
+	}
 	switch sp.PrivacyProtocol {
 	case AES, AES192, AES256, AES192C, AES256C:
 		salt := make([]byte, 8)
-		_, err = crand.Read(salt)
+		_, err = io.ReadFull(saltRand, salt)
 		if err != nil {
 			return fmt.Errorf("error creating a cryptographically secure salt: %w", err)
 		}
 		sp.localAESSalt = binary.BigEndian.Uint64(salt)
-	case DES:
+	case DES, TripleDES:
 		salt := make([]byte, 4)
-		_, err = crand.Read(salt)
+		_, err = io.ReadFull(saltRand, salt)
 		if err != nil {
 			return fmt.Errorf("error creating a cryptographically secure salt: %w", err)
 		}
@@ -412,15 +535,16 @@ func PasswordCaching(enable bool) {
 	passwordKeyHashMutex.Unlock()
 }
 
-func hashPassword(hash hash.Hash, password string) ([]byte, error) {
+func hashPassword(hash hash.Hash, password []byte) ([]byte, error) {
 	if len(password) == 0 {
 		return []byte{}, errors.New("hashPassword: password is empty")
 	}
 	var pi int // password index
+	chunk := make([]byte, 64)
+	defer zero(chunk)
 	for i := 0; i < 1048576; i += 64 {
-		var chunk []byte
 		for e := 0; e < 64; e++ {
-			chunk = append(chunk, password[pi%len(password)])
+			chunk[e] = password[pi%len(password)]
 			pi++
 		}
 		if _, err := hash.Write(chunk); err != nil {
@@ -428,12 +552,22 @@ func hashPassword(hash hash.Hash, password string) ([]byte, error) {
 		}
 	}
 	hashed := hash.Sum(nil)
+	hash.Reset()
 	return hashed, nil
 }
 
-// Common passwordToKey algorithm, "caches" the result to avoid extra computation each reuse
-func cachedPasswordToKey(hash hash.Hash, cacheKey string, password string) ([]byte, error) {
-	cacheDisable := passwordCacheDisable.Load()
+// zero overwrites b, used to scrub key material derived from wipeable
+// passphrases.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Common passwordToKey algorithm, "caches" the result to avoid extra computation each reuse.
+// An empty cacheKey bypasses the cache.
+func cachedPasswordToKey(hash hash.Hash, cacheKey string, password []byte) ([]byte, error) {
+	cacheDisable := passwordCacheDisable.Load() || cacheKey == ""
 	if !cacheDisable {
 		passwordKeyHashMutex.RLock()
 		value := passwordKeyHashCache[cacheKey]
@@ -459,10 +593,19 @@ func cachedPasswordToKey(hash hash.Hash, cacheKey string, password string) ([]by
 }
 
 func hMAC(hash crypto.Hash, cacheKey string, password string, engineID string) ([]byte, error) {
+	return hMACBytes(hash, cacheKey, []byte(password), engineID)
+}
+
+// hMACBytes is hMAC for a password held in a byte slice. Without a cacheKey
+// the intermediate password hash is zeroed once used.
+func hMACBytes(hash crypto.Hash, cacheKey string, password []byte, engineID string) ([]byte, error) {
 	hashed, err := cachedPasswordToKey(hash.New(), cacheKey, password)
 	if err != nil {
 		return []byte{}, nil
 	}
+	if cacheKey == "" {
+		defer zero(hashed)
+	}
 
 	local := hash.New()
 	_, err = local.Write(hashed)
@@ -485,7 +628,7 @@ func hMAC(hash crypto.Hash, cacheKey string, password string, engineID string) (
 }
 
 func cacheKey(authProtocol SnmpV3AuthProtocol, passphrase string) string {
-	if passwordCacheDisable.Load() {
+	if passwordCacheDisable.Load() || passphrase == "" {
 		return ""
 	}
 	var cacheKey = make([]byte, 1+len(passphrase))
@@ -499,17 +642,21 @@ func cacheKey(authProtocol SnmpV3AuthProtocol, passphrase string) string {
 // Many vendors, including Cisco, use the 3DES key extension algorithm to extend the privacy keys that are too short when using AES,AES192 and AES256.
 // Previously implemented in net-snmp and pysnmp libraries.
 // Tested for AES128 and AES256
-func extendKeyReeder(authProtocol SnmpV3AuthProtocol, password string, engineID string) ([]byte, error) {
+func extendKeyReeder(authProtocol SnmpV3AuthProtocol, password secret, engineID string) ([]byte, error) {
 	var key []byte
 	var err error
 
-	key, err = hMAC(authProtocol.HashType(), cacheKey(authProtocol, password), password, engineID)
+	key, err = hMACBytes(authProtocol.HashType(), password.cacheKey(authProtocol), password.bytes(), engineID)
 
 	if err != nil {
 		return nil, err
 	}
 
-	newkey, err := hMAC(authProtocol.HashType(), cacheKey(authProtocol, string(key)), string(key), engineID)
+	extension := secret{s: string(key)}
+	if password.s == "" {
+		extension = secret{b: key}
+	}
+	newkey, err := hMACBytes(authProtocol.HashType(), extension.cacheKey(authProtocol), key, engineID)
 
 	return append(key, newkey...), err
 }
@@ -518,12 +665,11 @@ func extendKeyReeder(authProtocol SnmpV3AuthProtocol, password string, engineID
 // https://tools.ietf.org/html/draft-blumenthal-aes-usm-04#page-7
 // Not many vendors use this algorithm.
 // Previously implemented in the net-snmp and pysnmp libraries.
-// TODO: Not tested
-func extendKeyBlumenthal(authProtocol SnmpV3AuthProtocol, password string, engineID string) ([]byte, error) {
+func extendKeyBlumenthal(authProtocol SnmpV3AuthProtocol, password secret, engineID string) ([]byte, error) {
 	var key []byte
 	var err error
 
-	key, err = hMAC(authProtocol.HashType(), cacheKey(authProtocol, password), password, engineID)
+	key, err = hMACBytes(authProtocol.HashType(), password.cacheKey(authProtocol), password.bytes(), engineID)
 
 	if err != nil {
 		return nil, err
@@ -536,6 +682,10 @@ func extendKeyBlumenthal(authProtocol SnmpV3AuthProtocol, password string, engin
 
 // Changed: New function to calculate the Privacy Key for abstract AES
 func genlocalPrivKey(privProtocol SnmpV3PrivProtocol, authProtocol SnmpV3AuthProtocol, password string, engineID string) ([]byte, error) {
+	return localPrivKey(privProtocol, authProtocol, secret{s: password}, engineID)
+}
+
+func localPrivKey(privProtocol SnmpV3PrivProtocol, authProtocol SnmpV3AuthProtocol, password secret, engineID string) ([]byte, error) {
 	var keylen int
 	var localPrivKey []byte
 	var err error
@@ -545,19 +695,20 @@ func genlocalPrivKey(privProtocol SnmpV3PrivProtocol, authProtocol SnmpV3AuthPro
 		keylen = 16
 	case AES192, AES192C:
 		keylen = 24
-	case AES256, AES256C:
+	case AES256, AES256C, TripleDES:
+		// 3DES-EDE uses the first 24 octets as K1|K2|K3 and the last 8 as the pre-IV
 		keylen = 32
 	}
 
 	switch privProtocol {
-	case AES, AES192C, AES256C:
+	case AES, AES192C, AES256C, TripleDES:
 		localPrivKey, err = extendKeyReeder(authProtocol, password, engineID)
 
 	case AES192, AES256:
 		localPrivKey, err = extendKeyBlumenthal(authProtocol, password, engineID)
 
 	default:
-		localPrivKey, err = genlocalkey(authProtocol, password, engineID)
+		localPrivKey, err = localKey(authProtocol, password, engineID)
 	}
 
 	if err != nil {
@@ -572,11 +723,15 @@ func genlocalPrivKey(privProtocol SnmpV3PrivProtocol, authProtocol SnmpV3AuthPro
 	return localPrivKey[:keylen], nil
 }
 
-func genlocalkey(authProtocol SnmpV3AuthProtocol, passphrase string, engineID string) ([]byte, error) {
+func genlocalkey(authProtocol SnmpV3AuthProtocol, password string, engineID string) ([]byte, error) {
+	return localKey(authProtocol, secret{s: password}, engineID)
+}
+
+func localKey(authProtocol SnmpV3AuthProtocol, password secret, engineID string) ([]byte, error) {
 	var secretKey []byte
 	var err error
 
-	secretKey, err = hMAC(authProtocol.HashType(), cacheKey(authProtocol, passphrase), passphrase, engineID)
+	secretKey, err = hMACBytes(authProtocol.HashType(), password.cacheKey(authProtocol), password.bytes(), engineID)
 
 	if err != nil {
 		return []byte{}, err
@@ -746,7 +901,7 @@ func digestRFC3414(h SnmpV3AuthProtocol, packet []byte, authKey []byte) ([]byte,
 		return []byte{}, err
 	}
 
-	return h2.Sum(nil)[:12], nil
+	return h2.Sum(nil)[:h.authParamsLen()], nil
 }
 
 func (sp *UsmSecurityParameters) authenticate(packet []byte) error {
@@ -781,12 +936,41 @@ func (sp *UsmSecurityParameters) isAuthentic(packetBytes []byte, packet *SnmpPac
 		return false, err
 	}
 
-	for k, v := range []byte(packetSecParams.AuthenticationParameters) {
-		if msgDigest[k] != v {
-			return false, nil
-		}
+	paramsLen := packetSecParams.AuthenticationProtocol.authParamsLen()
+	if len(packetSecParams.AuthenticationParameters) != paramsLen || len(msgDigest) < paramsLen {
+		return false, nil
 	}
-	return true, nil
+	return hmac.Equal(msgDigest[:paramsLen], []byte(packetSecParams.AuthenticationParameters)), nil
+}
+
+// desCipher returns the CBC block cipher and IV for the DES (RFC 3414 8.1.1.1)
+// and 3DES-EDE (draft-reeder-snmpv3-usm-3desede 5.1.1.1) privacy protocols.
+// Both split the localized key into the cipher key followed by an 8 octet
+// pre-IV, which is XORed with the salt to form the IV.
+func (sp *UsmSecurityParameters) desCipher() (cipher.Block, []byte, error) {
+	keyLen := 8
+	newCipher := des.NewCipher //nolint:gosec
+	if sp.PrivacyProtocol == TripleDES {
+		keyLen = 24
+		newCipher = des.NewTripleDESCipher //nolint:gosec
+	}
+	if len(sp.PrivacyKey) < keyLen+des.BlockSize {
+		return nil, nil, fmt.Errorf("privacy key too short for %s: %d octets", sp.PrivacyProtocol, len(sp.PrivacyKey))
+	}
+	if len(sp.PrivacyParameters) < des.BlockSize {
+		return nil, nil, fmt.Errorf("privacy parameters too short for %s: %d octets", sp.PrivacyProtocol, len(sp.PrivacyParameters))
+	}
+
+	preiv := sp.PrivacyKey[keyLen : keyLen+des.BlockSize]
+	iv := make([]byte, des.BlockSize)
+	for i := 0; i < len(iv); i++ {
+		iv[i] = preiv[i] ^ sp.PrivacyParameters[i]
+	}
+	block, err := newCipher(sp.PrivacyKey[:keyLen])
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, iv, nil
 }
 
 func (sp *UsmSecurityParameters) encryptPacket(scopedPdu []byte) ([]byte, error) {
@@ -812,17 +996,12 @@ func (sp *UsmSecurityParameters) encryptPacket(scopedPdu []byte) ([]byte, error)
 		}
 		b = append([]byte{byte(OctetString)}, pduLen...)
 		scopedPdu = append(b, ciphertext...) //nolint:gocritic
-	case DES:
-		preiv := sp.PrivacyKey[8:]
-		var iv [8]byte
-		for i := 0; i < len(iv); i++ {
-			iv[i] = preiv[i] ^ sp.PrivacyParameters[i]
-		}
-		block, err := des.NewCipher(sp.PrivacyKey[:8]) //nolint:gosec
+	case DES, TripleDES:
+		block, iv, err := sp.desCipher()
 		if err != nil {
 			return nil, err
 		}
-		mode := cipher.NewCBCEncrypter(block, iv[:])
+		mode := cipher.NewCBCEncrypter(block, iv)
 
 		pad := make([]byte, des.BlockSize-len(scopedPdu)%des.BlockSize)
 		scopedPdu = append(scopedPdu, pad...)
@@ -866,20 +1045,15 @@ func (sp *UsmSecurityParameters) decryptPacket(packet []byte, cursor int) ([]byt
 		stream.XORKeyStream(plaintext, packet[cursorTmp:])
 		copy(packet[cursor:], plaintext)
 		packet = packet[:cursor+len(plaintext)]
-	case DES:
+	case DES, TripleDES:
 		if len(packet[cursorTmp:])%des.BlockSize != 0 {
 			return nil, errors.New("error decrypting ScopedPDU: not multiple of des block size")
 		}
-		preiv := sp.PrivacyKey[8:]
-		var iv [8]byte
-		for i := 0; i < len(iv); i++ {
-			iv[i] = preiv[i] ^ sp.PrivacyParameters[i]
-		}
-		block, err := des.NewCipher(sp.PrivacyKey[:8]) //nolint:gosec
+		block, iv, err := sp.desCipher()
 		if err != nil {
 			return nil, err
 		}
-		mode := cipher.NewCBCDecrypter(block, iv[:])
+		mode := cipher.NewCBCDecrypter(block, iv)
 
 		plaintext := make([]byte, len(packet[cursorTmp:]))
 		mode.CryptBlocks(plaintext, packet[cursorTmp:])
@@ -893,7 +1067,8 @@ func (sp *UsmSecurityParameters) decryptPacket(packet []byte, cursor int) ([]byt
 
 // marshal a snmp version 3 security parameters field for the User Security Model
 func (sp *UsmSecurityParameters) marshal(flags SnmpV3MsgFlags) ([]byte, error) {
-	var buf bytes.Buffer
+	buf := getBuffer()
+	defer putBuffer(buf)
 	var err error
 
 	// msgAuthoritativeEngineID
@@ -940,14 +1115,7 @@ func (sp *UsmSecurityParameters) marshal(flags SnmpV3MsgFlags) ([]byte, error) {
 	}
 
 	// wrap security parameters in a sequence
-	paramLen, err := marshalLength(buf.Len())
-	if err != nil {
-		return nil, err
-	}
-	tmpseq := append([]byte{byte(Sequence)}, paramLen...)
-	tmpseq = append(tmpseq, buf.Bytes()...)
-
-	return tmpseq, nil
+	return wrapBuffer(byte(Sequence), buf)
 }
 
 func (sp *UsmSecurityParameters) unmarshal(flags SnmpV3MsgFlags, packet []byte, cursor int) (int, error) {
@@ -960,14 +1128,15 @@ func (sp *UsmSecurityParameters) unmarshal(flags SnmpV3MsgFlags, packet []byte,
 	if PDUType(packet[cursor]) != Sequence {
 		return 0, errors.New("error parsing SNMPV3 User Security Model parameters")
 	}
-	_, cursorTmp, err := parseLength(packet[cursor:])
+	length, cursorTmp, err := parseLength(packet[cursor:])
 	if err != nil {
 		return 0, err
 	}
-	cursor += cursorTmp
-	if cursorTmp > len(packet) {
+	if cursor+length > len(packet) {
 		return 0, errors.New("error parsing SNMPV3 User Security Model parameters: truncated packet")
 	}
+	packet = packet[:cursor+length]
+	cursor += cursorTmp
 
 	rawMsgAuthoritativeEngineID, count, err := parseRawField(sp.Logger, packet[cursor:], "msgAuthoritativeEngineID")
 	if err != nil {
@@ -1033,6 +1202,10 @@ func (sp *UsmSecurityParameters) unmarshal(flags SnmpV3MsgFlags, packet []byte,
 		if sp.AuthenticationProtocol <= NoAuth {
 			return 0, errors.New("error parsing SNMPv3 User Security Model: authentication parameters are not configured to parse incoming authenticated message")
 		}
+		if count != len(macVarbinds[sp.AuthenticationProtocol]) {
+			return 0, fmt.Errorf("error parsing SNMPv3 User Security Model: msgAuthenticationParameters encoded in %d octets, want %d for %s",
+				count, len(macVarbinds[sp.AuthenticationProtocol]), sp.AuthenticationProtocol)
+		}
 		copy(packet[cursor+2:cursor+len(macVarbinds[sp.AuthenticationProtocol])], macVarbinds[sp.AuthenticationProtocol][2:])
 	}
 	cursor += count
@@ -5,10 +5,21 @@
 package gosnmp
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
+	"fmt"
+	"hash"
 	"io"
 	"log"
+	mrand "math/rand"
+	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -229,6 +240,281 @@ func TestIsAuthenticaSHA512(t *testing.T) {
 	require.True(t, authentic, "Packet was not considered to be authentic")
 }
 
+// localizationEngineID is the engine ID used by the key localization sample
+// in RFC 3414 Appendix A.3, which net-snmp's test suite also uses.
+func localizationEngineID(t *testing.T) string {
+	engineID, err := hex.DecodeString("000000000000000000000002")
+	require.NoError(t, err, "EngineId decoding failed.")
+
+	return string(engineID)
+}
+
+var testsLocalizedKeys = []struct {
+	authProtocol SnmpV3AuthProtocol
+	hash         func() hash.Hash
+	key          string
+	paramsLen    int
+}{
+	{SHA224, sha256.New224, "0bd8827c6e29f8065e08e09237f177e410f69b90e1782be682075674", 16},
+	{SHA256, sha256.New, "8982e0e549e866db361a6b625d84cccc11162d453ee8ce3a6445c2d6776f0f8b", 24},
+	{SHA384, sha512.New384, "3b298f16164a11184279d5432bf169e2d2a48307de02b3d3f7e2b4f36eb6f0455a53689a3937eea07319a633d2ccba78", 32},
+	{SHA512, sha512.New, "22a5a36cedfcc085807a128d7bc6c2382167ad6c0dbc5fdff856740f3d84c099ad1ea87a8db096714d9788bd544047c9021e4229ce27e4c0a69250adfcffbb0b", 48},
+}
+
+func TestLocalizedKeys(t *testing.T) {
+	for _, test := range testsLocalizedKeys {
+		t.Run(test.authProtocol.String(), func(t *testing.T) {
+			correctKey, err := hex.DecodeString(test.key)
+			require.NoError(t, err, "Correct key initialization failed.")
+
+			key, err := genlocalkey(test.authProtocol, "maplesyrup", localizationEngineID(t))
+			require.NoError(t, err, "Generation of key failed")
+			require.Equal(t, correctKey, key, "Wrong key generated")
+		})
+	}
+}
+
+// TestAuthenticationRFC7860 marshals an authenticated request, then checks
+// that msgAuthenticationParameters carries the HMAC-SHA-2 truncation required
+// by RFC 7860 and that the packet verifies.
+func TestAuthenticationRFC7860(t *testing.T) {
+	for _, test := range testsLocalizedKeys {
+		t.Run(test.authProtocol.String(), func(t *testing.T) {
+			testAuthenticationRFC7860(t, test.authProtocol, test.hash, test.key, test.paramsLen)
+		})
+	}
+}
+
+func testAuthenticationRFC7860(t *testing.T, authProtocol SnmpV3AuthProtocol, h func() hash.Hash, key string, paramsLen int) {
+	logger := NewLogger(log.New(io.Discard, "", 0))
+
+	correctKey, err := hex.DecodeString(key)
+	require.NoError(t, err, "Correct key initialization failed.")
+
+	sp := &UsmSecurityParameters{
+		AuthoritativeEngineBoots: 1,
+		AuthoritativeEngineID:    localizationEngineID(t),
+		AuthoritativeEngineTime:  1234,
+		UserName:                 "usr-" + strings.ToLower(authProtocol.String()) + "-none",
+		AuthenticationProtocol:   authProtocol,
+		PrivacyProtocol:          NoPriv,
+		AuthenticationPassphrase: "maplesyrup",
+		Logger:                   logger,
+	}
+	require.NoError(t, sp.InitSecurityKeys(), "Generation of key failed")
+	require.Equal(t, correctKey, sp.SecretKey, "Wrong key generated")
+
+	packet := &SnmpPacket{
+		Version:            Version3,
+		MsgFlags:           AuthNoPriv | Reportable,
+		SecurityModel:      UserSecurityModel,
+		SecurityParameters: sp,
+		PDUType:            GetRequest,
+		MsgID:              1,
+		RequestID:          1,
+		Logger:             logger,
+		Variables:          []SnmpPDU{{Name: ".1.3.6.1.2.1.1.1.0", Type: Null}},
+	}
+	srcPacket, err := packet.marshalMsg()
+	require.NoError(t, err, "Marshalling of packet failed")
+
+	x := &GoSNMP{Version: Version3, SecurityModel: UserSecurityModel, Logger: logger}
+	result := &SnmpPacket{
+		SecurityParameters: &UsmSecurityParameters{
+			AuthenticationProtocol:   authProtocol,
+			PrivacyProtocol:          NoPriv,
+			AuthenticationPassphrase: "maplesyrup",
+			Logger:                   logger,
+		},
+	}
+	// unmarshalHeader blanks msgAuthenticationParameters in place, leaving the
+	// bytes the digest was calculated over.
+	_, err = x.unmarshalHeader(srcPacket, result)
+	require.NoError(t, err, "Unmarshalling of packet failed")
+
+	rsp, err := castUsmSecParams(result.SecurityParameters)
+	require.NoError(t, err)
+	require.Len(t, rsp.AuthenticationParameters, paramsLen, "Wrong msgAuthenticationParameters length")
+
+	mac := hmac.New(h, correctKey)
+	_, err = mac.Write(srcPacket)
+	require.NoError(t, err)
+	require.Equal(t, mac.Sum(nil)[:paramsLen], []byte(rsp.AuthenticationParameters), "Wrong message authentication parameters.")
+
+	authentic, err := sp.isAuthentic(srcPacket, result)
+	require.NoError(t, err, "Authentication check of key failed")
+	require.True(t, authentic, "Packet was not considered to be authentic")
+}
+
+var testsLocalizedPrivKeys = []struct {
+	privProtocol SnmpV3PrivProtocol
+	authProtocol SnmpV3AuthProtocol
+	key          string
+}{
+	{AES, SHA, "6695febc9288e36282235fc7151f1284"},
+	{AES192, MD5, "526f5eed9fcce26f8964c2930787d82bfa24a92467426c2f"},
+	{AES192, SHA, "6695febc9288e36282235fc7151f128497b38f3f505e07eb"},
+	{AES256, MD5, "526f5eed9fcce26f8964c2930787d82bfa24a92467426c2f4b09192be10dfaec"},
+	{AES256, SHA, "6695febc9288e36282235fc7151f128497b38f3f505e07eb9af25568fa1f5dbe"},
+	{AES192C, MD5, "526f5eed9fcce26f8964c2930787d82b79eff44a90650ee0"},
+	{AES192C, SHA, "6695febc9288e36282235fc7151f128497b38f3f9b8b6d78"},
+	{AES256C, MD5, "526f5eed9fcce26f8964c2930787d82b79eff44a90650ee0a3a40abfac5acc12"},
+	{AES256C, SHA, "6695febc9288e36282235fc7151f128497b38f3f9b8b6d78936ba6e7d19dfd9c"},
+	{DES, MD5, "526f5eed9fcce26f8964c2930787d82b"},
+	{TripleDES, MD5, "526f5eed9fcce26f8964c2930787d82b79eff44a90650ee0a3a40abfac5acc12"},
+	{TripleDES, SHA, "6695febc9288e36282235fc7151f128497b38f3f9b8b6d78936ba6e7d19dfd9c"},
+}
+
+func TestLocalizedPrivKeys(t *testing.T) {
+	for _, test := range testsLocalizedPrivKeys {
+		t.Run(test.privProtocol.String()+"/"+test.authProtocol.String(), func(t *testing.T) {
+			correctKey, err := hex.DecodeString(test.key)
+			require.NoError(t, err, "Correct key initialization failed.")
+
+			sp := UsmSecurityParameters{
+				AuthoritativeEngineID:    localizationEngineID(t),
+				AuthenticationProtocol:   test.authProtocol,
+				PrivacyProtocol:          test.privProtocol,
+				AuthenticationPassphrase: "maplesyrup",
+				PrivacyPassphrase:        "maplesyrup",
+				Logger:                   NewLogger(log.New(io.Discard, "", 0)),
+			}
+			require.NoError(t, sp.InitSecurityKeys(), "Generation of key failed")
+			require.Equal(t, correctKey, sp.PrivacyKey, "Wrong key generated")
+		})
+	}
+}
+
+func TestLocalizeKey(t *testing.T) {
+	// RFC 3414 A.3.1 and A.3.2
+	engineID := string([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2})
+	key, err := LocalizeKey("maplesyrup", engineID, MD5)
+	require.NoError(t, err)
+	require.Equal(t, "526f5eed9fcce26f8964c2930787d82b", hex.EncodeToString(key))
+	key, err = LocalizeKey("maplesyrup", engineID, SHA)
+	require.NoError(t, err)
+	require.Equal(t, "6695febc9288e36282235fc7151f128497b38f3f", hex.EncodeToString(key))
+
+	for _, test := range testsLocalizedPrivKeys {
+		key, err := LocalizePrivKey("maplesyrup", localizationEngineID(t), test.authProtocol, test.privProtocol)
+		require.NoError(t, err)
+		require.Equal(t, test.key, hex.EncodeToString(key), "%s/%s", test.privProtocol, test.authProtocol)
+	}
+
+	_, err = LocalizeKey("maplesyrup", engineID, NoAuth)
+	require.Error(t, err)
+	_, err = LocalizeKey("", engineID, SHA)
+	require.Error(t, err)
+	_, err = LocalizePrivKey("maplesyrup", engineID, SHA, NoPriv)
+	require.Error(t, err)
+
+	// injected keys are used as they are and not derived again
+	authKey, err := LocalizeKey("maplesyrup", engineID, SHA)
+	require.NoError(t, err)
+	privKey, err := LocalizePrivKey("maplesyrup", engineID, SHA, AES)
+	require.NoError(t, err)
+	sp := &UsmSecurityParameters{
+		UserName:               "user",
+		AuthoritativeEngineID:  engineID,
+		AuthenticationProtocol: SHA,
+		PrivacyProtocol:        AES,
+		SecretKey:              authKey,
+		PrivacyKey:             privKey,
+	}
+	require.NoError(t, sp.validate(AuthPriv))
+	require.NoError(t, sp.InitSecurityKeys())
+	require.Equal(t, authKey, sp.SecretKey)
+	require.Equal(t, privKey, sp.PrivacyKey)
+
+	// and cannot follow the agent to another engine
+	err = sp.setSecurityParameters(&UsmSecurityParameters{AuthoritativeEngineID: "other"})
+	require.Error(t, err)
+}
+
+func TestPassphraseBytes(t *testing.T) {
+	engineID := string([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2})
+	// a passphrase not used elsewhere, so the cache cannot hold it already
+	const passphrase = "wipe-me-passphrase"
+	authKey, err := LocalizeKey(passphrase, engineID, SHA)
+	require.NoError(t, err)
+	privKey, err := LocalizePrivKey(passphrase, engineID, SHA, AES256)
+	require.NoError(t, err)
+
+	passwordKeyHashMutex.RLock()
+	cached := len(passwordKeyHashCache)
+	passwordKeyHashMutex.RUnlock()
+
+	authPass, privPass := []byte(passphrase), []byte(passphrase)
+	sp := &UsmSecurityParameters{
+		UserName:                      "user",
+		AuthenticationProtocol:        SHA,
+		PrivacyProtocol:               AES256,
+		AuthenticationPassphraseBytes: authPass,
+		PrivacyPassphraseBytes:        privPass,
+	}
+	require.NoError(t, sp.validate(AuthPriv))
+	packetCopy := sp.Copy()
+
+	// the agent's engine is learnt: keys are derived and the passphrases wiped
+	require.NoError(t, sp.setSecurityParameters(&UsmSecurityParameters{AuthoritativeEngineID: engineID}))
+	require.Equal(t, authKey, sp.SecretKey)
+	require.Equal(t, privKey, sp.PrivacyKey)
+	require.Nil(t, sp.AuthenticationPassphraseBytes)
+	require.Nil(t, sp.PrivacyPassphraseBytes)
+	require.Equal(t, make([]byte, len(passphrase)), authPass)
+	require.Equal(t, make([]byte, len(passphrase)), privPass)
+
+	passwordKeyHashMutex.RLock()
+	require.Len(t, passwordKeyHashCache, cached, "byte passphrases must not be cached")
+	passwordKeyHashMutex.RUnlock()
+
+	// copies sharing the wiped passphrases take the keys
+	require.NoError(t, packetCopy.setSecurityParameters(sp))
+	require.Equal(t, authKey, packetCopy.(*UsmSecurityParameters).SecretKey)
+	require.Equal(t, privKey, packetCopy.(*UsmSecurityParameters).PrivacyKey)
+
+	// but nothing is left to localize keys for another engine
+	require.Error(t, sp.setSecurityParameters(&UsmSecurityParameters{AuthoritativeEngineID: "other"}))
+	wiped := &UsmSecurityParameters{
+		UserName:                      "user",
+		AuthoritativeEngineID:         engineID,
+		AuthenticationProtocol:        SHA,
+		AuthenticationPassphraseBytes: make([]byte, 8),
+	}
+	require.Error(t, wiped.InitSecurityKeys())
+}
+
+func TestEncryptDecryptScopedPDU(t *testing.T) {
+	// SEQUENCE { contextEngineID, contextName, GetRequest sysDescr.0 }
+	scopedPdu, err := hex.DecodeString("302e040e80004fb805636c6f75644dab22cc0400a01a02023ced020100020100300e300c06082b060102010101000500")
+	require.NoError(t, err, "Scoped PDU decoding failed.")
+
+	for _, test := range testsLocalizedPrivKeys {
+		t.Run(test.privProtocol.String()+"/"+test.authProtocol.String(), func(t *testing.T) {
+			sp := UsmSecurityParameters{
+				AuthoritativeEngineID:    localizationEngineID(t),
+				AuthoritativeEngineBoots: 1,
+				AuthoritativeEngineTime:  1234,
+				AuthenticationProtocol:   test.authProtocol,
+				PrivacyProtocol:          test.privProtocol,
+				AuthenticationPassphrase: "maplesyrup",
+				PrivacyPassphrase:        "maplesyrup",
+				PrivacyParameters:        []byte{0, 1, 2, 3, 4, 5, 6, 7},
+				Logger:                   NewLogger(log.New(io.Discard, "", 0)),
+			}
+			require.NoError(t, sp.InitSecurityKeys(), "Generation of key failed")
+
+			encrypted, err := sp.encryptPacket(append([]byte(nil), scopedPdu...))
+			require.NoError(t, err, "Encryption of scoped PDU failed")
+			require.NotEqual(t, scopedPdu, encrypted[2:], "Scoped PDU was not encrypted")
+
+			decrypted, err := sp.decryptPacket(encrypted, 0)
+			require.NoError(t, err, "Decryption of scoped PDU failed")
+			require.Equal(t, scopedPdu, decrypted[:len(scopedPdu)], "Wrong decrypted scoped PDU")
+		})
+	}
+}
+
 func BenchmarkSingleHash(b *testing.B) {
 	SetPwdCache()
 
@@ -249,3 +535,984 @@ func BenchmarkSingleHash(b *testing.B) {
 	b.Logf("cache size %d", len(passwordKeyHashCache))
 	passwordKeyHashMutex.RUnlock()
 }
+
+// usmAgent answers SNMPv3 noAuthNoPriv requests on conn: discovery probes
+// (no engine ID) with a usmStatsUnknownEngineIDs report, anything else with
+// an empty response. It counts the probes and reports boots from *boots.
+func usmAgent(t *testing.T, conn net.PacketConn, engineID string, boots *atomic.Uint32, probes *atomic.Int32) {
+	logger := NewLogger(log.New(io.Discard, "", 0))
+	agent := &GoSNMP{Version: Version3, SecurityModel: UserSecurityModel, Logger: logger}
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := &SnmpPacket{SecurityParameters: &UsmSecurityParameters{Logger: logger}, Logger: logger}
+		msg := buf[:n]
+		cursor, err := agent.unmarshalHeader(msg, req)
+		if err == nil {
+			msg, cursor, err = agent.decryptPacket(msg, cursor, req)
+		}
+		if err == nil {
+			err = agent.unmarshalPayload(msg, cursor, req)
+		}
+		if err != nil {
+			t.Errorf("agent: %v", err)
+			return
+		}
+
+		rsp := &SnmpPacket{
+			Version:       Version3,
+			MsgFlags:      NoAuthNoPriv,
+			SecurityModel: UserSecurityModel,
+			SecurityParameters: &UsmSecurityParameters{
+				AuthoritativeEngineID:    engineID,
+				AuthoritativeEngineBoots: boots.Load(),
+				AuthoritativeEngineTime:  100,
+				UserName:                 req.SecurityParameters.(*UsmSecurityParameters).UserName,
+				Logger:                   logger,
+			},
+			ContextEngineID: engineID,
+			PDUType:         GetResponse,
+			MsgID:           req.MsgID,
+			RequestID:       req.RequestID,
+			MsgMaxSize:      rxBufSize,
+			Logger:          logger,
+		}
+		reqSp := req.SecurityParameters.(*UsmSecurityParameters)
+		if reqSp.AuthoritativeEngineID == "" {
+			probes.Add(1)
+			rsp.PDUType = Report
+			rsp.Variables = []SnmpPDU{{Name: usmStatsUnknownEngineIDs, Type: Counter32, Value: uint32(1)}}
+		} else if reqSp.AuthoritativeEngineBoots != boots.Load() {
+			// the agent restarted since the manager last heard from it
+			rsp.PDUType = Report
+			rsp.Variables = []SnmpPDU{{Name: usmStatsNotInTimeWindows, Type: Counter32, Value: uint32(1)}}
+		} else {
+			// answer from the context asked for, naming it in every value
+			rsp.ContextEngineID = req.ContextEngineID
+			rsp.ContextName = req.ContextName
+			for _, v := range req.Variables {
+				value := hex.EncodeToString([]byte(req.ContextEngineID)) + "/" + req.ContextName
+				rsp.Variables = append(rsp.Variables, SnmpPDU{Name: v.Name, Type: OctetString, Value: value})
+			}
+		}
+		out, err := rsp.marshalMsg()
+		if err != nil {
+			t.Errorf("agent: %v", err)
+			return
+		}
+		conn.WriteTo(out, addr)
+	}
+}
+
+func TestEngineCache(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	engineID := "\x80\x00\x1f\x88\x04cache"
+	var boots atomic.Uint32
+	var probes atomic.Int32
+	boots.Store(1)
+	go usmAgent(t, conn, engineID, &boots, &probes)
+
+	cache := NewEngineCache()
+	session := func() *GoSNMP {
+		g := &GoSNMP{
+			Version:            Version3,
+			Target:             "127.0.0.1",
+			Port:               uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+			Timeout:            time.Second,
+			MaxOids:            MaxOids,
+			SecurityModel:      UserSecurityModel,
+			MsgFlags:           NoAuthNoPriv,
+			SecurityParameters: &UsmSecurityParameters{UserName: "user"},
+			EngineCache:        cache,
+			Logger:             NewLogger(log.New(io.Discard, "", 0)),
+		}
+		require.NoError(t, g.Connect())
+		t.Cleanup(func() { g.Conn.Close() })
+		return g
+	}
+
+	first := session()
+	_, err = first.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	require.NoError(t, err)
+	require.Equal(t, int32(1), probes.Load())
+	require.Equal(t, 1, cache.Len())
+
+	// a second connection to the same agent skips discovery
+	second := session()
+	_, err = second.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	require.NoError(t, err)
+	require.Equal(t, int32(1), probes.Load())
+	usm := second.SecurityParameters.(*UsmSecurityParameters)
+	require.Equal(t, engineID, usm.AuthoritativeEngineID)
+	require.Equal(t, engineID, second.ContextEngineID)
+
+	// the agent restarted: the new boots value replaces the cached one
+	boots.Store(2)
+	_, err = second.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	require.NoError(t, err)
+	e, ok := cache.get(second.engineCacheKey())
+	require.True(t, ok)
+	require.Equal(t, uint32(2), e.boots)
+
+	third := session()
+	_, err = third.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	require.NoError(t, err)
+	require.Equal(t, int32(1), probes.Load())
+	require.Equal(t, uint32(2), third.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineBoots)
+
+	// a failed request drops the entry
+	conn.Close()
+	fourth := session()
+	fourth.Timeout = 50 * time.Millisecond
+	_, err = fourth.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	require.Error(t, err)
+	require.Equal(t, 0, cache.Len())
+}
+
+func TestAutoReconnect(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	var boots atomic.Uint32
+	var probes atomic.Int32
+	go usmAgent(t, conn, "\x80\x00\x1f\x88\x04reconnect", &boots, &probes)
+
+	g := &GoSNMP{
+		Version:            Version3,
+		Target:             "127.0.0.1",
+		Port:               uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:            time.Second,
+		MaxOids:            MaxOids,
+		SecurityModel:      UserSecurityModel,
+		MsgFlags:           NoAuthNoPriv,
+		SecurityParameters: &UsmSecurityParameters{UserName: "user"},
+		Logger:             NewLogger(log.New(io.Discard, "", 0)),
+	}
+	require.NoError(t, g.Connect())
+	t.Cleanup(func() { g.Conn.Close() })
+	_, err = g.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	require.NoError(t, err)
+	require.Equal(t, int32(1), probes.Load())
+
+	// a broken socket fails the request unless AutoReconnect is set
+	g.Conn.Close()
+	_, err = g.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	require.ErrorIs(t, err, net.ErrClosed)
+
+	g.AutoReconnect = true
+	_, err = g.Get([]string{".1.3.6.1.2.1.1.3.0"})
+	require.NoError(t, err)
+	require.Equal(t, int32(2), probes.Load(), "discovery must run again")
+}
+
+func TestAuthParamsPlaceholder(t *testing.T) {
+	for authProtocol, want := range map[SnmpV3AuthProtocol]int{MD5: 12, SHA: 12, SHA224: 16, SHA256: 24, SHA384: 32, SHA512: 48} {
+		key, err := LocalizeKey("maplesyrup", "engine", authProtocol)
+		require.NoError(t, err)
+		sp := &UsmSecurityParameters{
+			AuthoritativeEngineID:  "engine",
+			UserName:               "user",
+			AuthenticationProtocol: authProtocol,
+			SecretKey:              key,
+		}
+		params, err := sp.marshal(AuthNoPriv)
+		require.NoError(t, err)
+		placeholder := append([]byte{byte(OctetString), byte(want)}, make([]byte, want)...)
+		require.True(t, bytes.Contains(params, placeholder), "%s: %x", authProtocol, params)
+
+		// the digest fills the placeholder exactly, leaving what follows alone
+		trailer := []byte{0xa5, 0xa5, 0xa5, 0xa5}
+		packet := append(append([]byte{}, params...), trailer...)
+		digest, err := sp.calcPacketDigest(packet)
+		require.NoError(t, err)
+		require.NoError(t, sp.authenticate(packet))
+		idx := bytes.Index(params, placeholder) + 2
+		require.Equal(t, digest[:want], packet[idx:idx+want], authProtocol.String())
+		require.Equal(t, params[idx+want:], packet[idx+want:len(params)], authProtocol.String())
+		require.Equal(t, trailer, packet[len(params):], authProtocol.String())
+	}
+}
+
+func TestMaxMsgSize(t *testing.T) {
+	x := &GoSNMP{
+		Version:       Version3,
+		SecurityModel: UserSecurityModel,
+		MsgFlags:      NoAuthNoPriv,
+		SecurityParameters: &UsmSecurityParameters{
+			UserName:              "user",
+			AuthoritativeEngineID: "engine",
+			Logger:                NewLogger(log.New(io.Discard, "", 0)),
+		},
+		Logger:     NewLogger(log.New(io.Discard, "", 0)),
+		MaxOids:    MaxOids,
+		MaxMsgSize: 1400,
+	}
+	require.NoError(t, x.validateParameters())
+
+	msg, err := x.mkSnmpPacket(GetRequest, []SnmpPDU{{Name: ".1.3.6.1.2.1.1.1.0", Type: Null}}, 0, 0).marshalMsg()
+	require.NoError(t, err)
+	packet, err := x.SnmpDecodePacket(msg)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1400), packet.MsgMaxSize)
+
+	// the default advertises the receive buffer size
+	x.MaxMsgSize = 0
+	msg, err = x.mkSnmpPacket(GetRequest, nil, 0, 0).marshalMsg()
+	require.NoError(t, err)
+	packet, err = x.SnmpDecodePacket(msg)
+	require.NoError(t, err)
+	require.Equal(t, uint32(rxBufSize), packet.MsgMaxSize)
+
+	// responses are sized as they were received
+	response := func(size int) *SnmpPacket {
+		vars := []SnmpPDU{{Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: OctetString, Value: make([]byte, size)}}
+		msg, err := x.mkSnmpPacket(GetResponse, vars, 0, 0).marshalMsg()
+		require.NoError(t, err)
+		packet, err := x.SnmpDecodePacket(msg)
+		require.NoError(t, err)
+		return packet
+	}
+	vars := response(90)
+	require.Equal(t, 2+12+92, vars.varbindsLen) // one VarBind of an OID and 90 octets
+
+	// with no limit known max-repetitions is left alone
+	require.Equal(t, uint32(50), x.capRepetitions(50, vars))
+
+	// the agent's msgMaxSize is remembered and caps max-repetitions
+	packet.MsgMaxSize = 1472
+	require.NoError(t, x.storeSecurityParameters(packet))
+	require.Equal(t, 1472, x.msgSizeLimit())
+	reps := x.capRepetitions(50, vars)
+	require.Less(t, reps, uint32(50))
+	require.Greater(t, reps, uint32(1))
+
+	// as does a smaller MaxMsgSize, down to one repetition
+	x.MaxMsgSize = 484
+	require.Equal(t, 484, x.msgSizeLimit())
+	require.Less(t, x.capRepetitions(50, vars), reps)
+	require.Equal(t, uint32(1), x.capRepetitions(50, response(1000)))
+
+	for _, size := range []uint32{483, rxBufSize + 1} {
+		x.MaxMsgSize = size
+		require.Error(t, x.validateParameters(), size)
+	}
+}
+
+func TestDeterministicRand(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// capture the request of a session seeded with seed, left unanswered
+	capture := func(seed int64) []byte {
+		g := &GoSNMP{
+			Version:       Version3,
+			Target:        "127.0.0.1",
+			Port:          uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+			Timeout:       50 * time.Millisecond,
+			MaxOids:       MaxOids,
+			SecurityModel: UserSecurityModel,
+			MsgFlags:      AuthPriv,
+			SecurityParameters: &UsmSecurityParameters{
+				UserName:                 "user",
+				AuthoritativeEngineID:    "engine",
+				AuthoritativeEngineBoots: 1,
+				AuthoritativeEngineTime:  100,
+				AuthenticationProtocol:   SHA,
+				AuthenticationPassphrase: "authpass",
+				PrivacyProtocol:          AES,
+				PrivacyPassphrase:        "privpass",
+				SaltRand:                 mrand.New(mrand.NewSource(seed)),
+			},
+			ContextEngineID: "engine",
+			Rand:            mrand.New(mrand.NewSource(seed)),
+			Logger:          NewLogger(log.New(io.Discard, "", 0)),
+		}
+		require.NoError(t, g.Connect())
+		defer g.Close()
+		_, err := g.Get([]string{".1.3.6.1.2.1.1.3.0"})
+		require.Error(t, err)
+
+		buf := make([]byte, rxBufSize)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		return buf[:n]
+	}
+
+	first := capture(1)
+	require.Equal(t, first, capture(1))
+	require.NotEqual(t, first, capture(2))
+}
+
+func TestV3Contexts(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	engineID := "\x80\x00\x1f\x88\x04proxy"
+	var boots atomic.Uint32
+	var probes atomic.Int32
+	go usmAgent(t, conn, engineID, &boots, &probes)
+
+	g, err := NewGoSNMP("127.0.0.1",
+		WithPort(uint16(conn.LocalAddr().(*net.UDPAddr).Port)),
+		WithTimeout(time.Second),
+		WithV3User("user", NoAuth, "", NoPriv, ""),
+		WithV3Context("", "vrf-a"),
+		WithLogger(NewLogger(log.New(io.Discard, "", 0))))
+	require.NoError(t, err)
+	require.NoError(t, g.Connect())
+	defer g.Close()
+
+	get := func() string {
+		result, err := g.Get([]string{".1.3.6.1.2.1.1.5.0"})
+		require.NoError(t, err)
+		require.Len(t, result.Variables, 1)
+		return string(result.Variables[0].Value.([]byte))
+	}
+
+	// an empty contextEngineID defaults to the authoritative engine
+	require.Equal(t, hex.EncodeToString([]byte(engineID))+"/vrf-a", get())
+	require.Equal(t, engineID, g.ContextEngineID)
+
+	// the same session polls a second context
+	g.ContextName = "vrf-b"
+	require.Equal(t, hex.EncodeToString([]byte(engineID))+"/vrf-b", get())
+
+	// and one behind the agent, with its own contextEngineID
+	g.ContextEngineID = "\x80\x00\x1f\x88\x04behind"
+	require.Equal(t, hex.EncodeToString([]byte(g.ContextEngineID))+"/vrf-b", get())
+	require.Equal(t, int32(1), probes.Load())
+}
+
+func TestSwitchUser(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	engineID := "\x80\x00\x1f\x88\x04users"
+	var boots atomic.Uint32
+	var probes atomic.Int32
+	go usmAgent(t, conn, engineID, &boots, &probes)
+
+	g, err := NewGoSNMP("127.0.0.1",
+		WithPort(uint16(conn.LocalAddr().(*net.UDPAddr).Port)),
+		WithTimeout(time.Second),
+		WithV3User("first", NoAuth, "", NoPriv, ""),
+		WithLogger(NewLogger(log.New(io.Discard, "", 0))))
+	require.NoError(t, err)
+	require.NoError(t, g.Connect())
+	defer g.Close()
+
+	user := func() string {
+		result, err := g.Get([]string{".1.3.6.1.2.1.1.5.0"})
+		require.NoError(t, err)
+		return result.SecurityParameters.(*UsmSecurityParameters).UserName
+	}
+	require.Equal(t, "first", user())
+
+	// the second user reuses the engine discovered for the first
+	second := &UsmSecurityParameters{UserName: "second"}
+	require.NoError(t, g.SwitchUser(NoAuthNoPriv, second))
+	require.Equal(t, "second", user())
+	require.Equal(t, int32(1), probes.Load())
+	require.Empty(t, second.AuthoritativeEngineID, "the caller's parameters are copied")
+
+	// keys of an authenticated user are localized to that engine at once
+	auth := &UsmSecurityParameters{UserName: "third", AuthenticationProtocol: SHA,
+		AuthenticationPassphrase: "authpassphrase", PrivacyProtocol: AES, PrivacyPassphrase: "privpassphrase"}
+	require.NoError(t, g.SwitchUser(AuthPriv, auth))
+	usm := g.SecurityParameters.(*UsmSecurityParameters)
+	require.Equal(t, engineID, usm.AuthoritativeEngineID)
+	require.NotEmpty(t, usm.SecretKey)
+	require.NotEmpty(t, usm.PrivacyKey)
+	require.Equal(t, AuthPriv|Reportable, g.MsgFlags)
+
+	require.Error(t, g.SwitchUser(AuthNoPriv, &UsmSecurityParameters{UserName: "fourth"}))
+	require.Equal(t, "third", g.SecurityParameters.(*UsmSecurityParameters).UserName)
+}
+
+func TestNotInTimeWindowResync(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	var boots atomic.Uint32
+	var probes atomic.Int32
+	boots.Store(7)
+	go usmAgent(t, conn, "\x80\x00\x1f\x88\x04reboot", &boots, &probes)
+
+	cache := NewEngineCache()
+	g, err := NewGoSNMP("127.0.0.1",
+		WithPort(uint16(conn.LocalAddr().(*net.UDPAddr).Port)),
+		WithTimeout(time.Second),
+		WithV3User("user", NoAuth, "", NoPriv, ""),
+		WithLogger(NewLogger(log.New(io.Discard, "", 0))))
+	require.NoError(t, err)
+	g.EngineCache = cache
+	require.NoError(t, g.Connect())
+	defer g.Close()
+
+	result, err := g.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.NoError(t, err)
+	require.Equal(t, GetResponse, result.PDUType)
+
+	// after a reboot the request is answered with notInTimeWindow, and
+	// retried once with the boots and time of the report
+	boots.Store(8)
+	result, err = g.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.NoError(t, err)
+	require.Equal(t, GetResponse, result.PDUType)
+	require.Len(t, result.Variables, 1)
+	require.Equal(t, uint32(8), g.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineBoots)
+	e, ok := cache.get(g.engineCacheKey())
+	require.True(t, ok)
+	require.Equal(t, uint32(8), e.boots)
+	require.Equal(t, int32(1), probes.Load())
+
+	// an agent still out of its time window after the retry fails the request
+	stubborn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer stubborn.Close()
+	var reports atomic.Int32
+	go func() {
+		logger := NewLogger(log.New(io.Discard, "", 0))
+		agent := &GoSNMP{Version: Version3, SecurityModel: UserSecurityModel, Logger: logger}
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := stubborn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := &SnmpPacket{SecurityParameters: &UsmSecurityParameters{Logger: logger}, Logger: logger}
+			if _, err = agent.unmarshalHeader(buf[:n], req); err != nil {
+				t.Errorf("agent: %v", err)
+				return
+			}
+			reports.Add(1)
+			rsp := &SnmpPacket{
+				Version:       Version3,
+				MsgFlags:      NoAuthNoPriv,
+				SecurityModel: UserSecurityModel,
+				SecurityParameters: &UsmSecurityParameters{
+					AuthoritativeEngineID:    "\x80\x00\x1f\x88\x04stubborn",
+					AuthoritativeEngineBoots: uint32(reports.Load()),
+					UserName:                 "user",
+					Logger:                   logger,
+				},
+				PDUType:   Report,
+				MsgID:     req.MsgID,
+				Variables: []SnmpPDU{{Name: usmStatsNotInTimeWindows, Type: Counter32, Value: uint32(1)}},
+				Logger:    logger,
+			}
+			out, err := rsp.marshalMsg()
+			if err != nil {
+				t.Errorf("agent: %v", err)
+				return
+			}
+			stubborn.WriteTo(out, addr)
+		}
+	}()
+	g.Port = uint16(stubborn.LocalAddr().(*net.UDPAddr).Port)
+	g.EngineCache = nil
+	require.NoError(t, g.Connect())
+	g.SecurityParameters = &UsmSecurityParameters{UserName: "user", AuthoritativeEngineID: "\x80\x00\x1f\x88\x04stubborn"}
+	_, err = g.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.ErrorIs(t, err, ErrNotInTimeWindow)
+	require.Equal(t, int32(2), reports.Load(), "the request is retried once")
+}
+
+func TestReportError(t *testing.T) {
+	report := func(name string, count uint32) *SnmpPacket {
+		return &SnmpPacket{Version: Version3, PDUType: Report,
+			Variables: []SnmpPDU{{Name: name, Type: Counter32, Value: count}}}
+	}
+	for name, want := range map[string]error{
+		usmStatsUnsupportedSecLevels: ErrUnknownSecurityLevel,
+		usmStatsUnknownUserNames:     ErrUnknownUsername,
+		usmStatsWrongDigests:         ErrWrongDigest,
+		usmStatsDecryptionErrors:     ErrDecryption,
+		snmpInvalidMsgs:              ErrInvalidMsgs,
+		".1.3.6.1.4.1.99999.1.0":     ErrUnknownReportPDU,
+	} {
+		err := newReportError(report(name, 42))
+		require.ErrorIs(t, err, want, name)
+		var reportErr *ReportError
+		require.ErrorAs(t, err, &reportErr)
+		require.Equal(t, name, reportErr.Name)
+		require.Equal(t, uint32(42), reportErr.Count)
+		require.Contains(t, err.Error(), name)
+	}
+	require.Equal(t, "wrong digest: check the authentication protocol and passphrase (.1.3.6.1.6.3.15.1.1.5.0 = 3)",
+		newReportError(report(usmStatsWrongDigests, 3)).Error())
+}
+
+// authTrap returns an authenticated SNMPv3 trap from user "u", and a
+// receiver of them.
+func authTrap(t testing.TB, privProtocol SnmpV3PrivProtocol) ([]byte, *GoSNMP) {
+	logger := NewLogger(log.New(io.Discard, "", 0))
+	sp := &UsmSecurityParameters{
+		AuthoritativeEngineID:    "\x80\x00\x1f\x88\x04gosnmp",
+		AuthoritativeEngineBoots: 1,
+		AuthoritativeEngineTime:  1234,
+		UserName:                 "u",
+		AuthenticationProtocol:   SHA,
+		AuthenticationPassphrase: "maplesyrup",
+		PrivacyProtocol:          privProtocol,
+		PrivacyPassphrase:        "maplesyrup",
+		Logger:                   logger,
+	}
+	flags := AuthNoPriv
+	if privProtocol != NoPriv {
+		flags = AuthPriv
+		sp.PrivacyParameters = []byte{0, 1, 2, 3, 4, 5, 6, 7}
+	}
+	require.NoError(t, sp.InitSecurityKeys())
+	trap := &SnmpPacket{
+		Version:            Version3,
+		MsgFlags:           flags,
+		SecurityModel:      UserSecurityModel,
+		SecurityParameters: sp,
+		PDUType:            SNMPv2Trap,
+		MsgID:              1,
+		RequestID:          1,
+		Logger:             logger,
+		Variables:          []SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: uint32(1)}},
+	}
+	msg, err := trap.marshalMsg()
+	require.NoError(t, err)
+	return msg, &GoSNMP{
+		Version:            Version3,
+		MsgFlags:           flags,
+		SecurityModel:      UserSecurityModel,
+		SecurityParameters: sp.Copy(),
+		Logger:             logger,
+	}
+}
+
+func TestUnmarshalShortAuthParams(t *testing.T) {
+	msg, x := authTrap(t, NoPriv)
+	_, err := x.UnmarshalTrap(append([]byte(nil), msg...), false)
+	require.NoError(t, err)
+
+	// end the message after a msgAuthenticationParameters of one octet
+	i := bytes.Index(msg, []byte{0x04, 0x01, 'u'}) + 3
+	require.Equal(t, []byte{byte(OctetString), 12}, msg[i:i+2])
+	short := append(msg[:i:i], byte(OctetString), 1, 0)
+	short[1] = byte(len(short) - 2)
+	short[25], short[27] = byte(len(short)-26), byte(len(short)-28)
+	_, err = x.UnmarshalTrap(short, false)
+	require.ErrorContains(t, err, "msgAuthenticationParameters encoded in 3 octets, want 14 for SHA")
+}
+
+func FuzzUnmarshalTrapV3(f *testing.F) {
+	msg, x := authTrap(f, NoPriv)
+	f.Add(msg)
+	msg, y := authTrap(f, AES)
+	f.Add(msg)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = x.UnmarshalTrap(append([]byte(nil), data...), false)
+		_, _ = y.UnmarshalTrap(append([]byte(nil), data...), false)
+	})
+}
+
+func TestUnmarshalTruncatedV3(t *testing.T) {
+	msg, x := authTrap(t, AES)
+	_, err := x.UnmarshalTrap(append([]byte(nil), msg...), false)
+	require.NoError(t, err)
+	require.Less(t, len(msg), 130)
+
+	// cut short, with the message length fixed to match
+	for i := 3; i < len(msg); i++ {
+		short := append([]byte(nil), msg[:i]...)
+		short[1] = byte(i - 2)
+		_, err := x.UnmarshalTrap(short, false)
+		require.Error(t, err, "cut at %d", i)
+	}
+
+	// lengths running past what holds them: the msgSecurityParameters
+	// OCTET STRING, then the UsmSecurityParameters SEQUENCE within it
+	require.Equal(t, byte(OctetString), msg[24])
+	require.Equal(t, byte(Sequence), msg[26])
+	for _, i := range []int{25, 27} {
+		bad := append([]byte(nil), msg...)
+		bad[i] = 0x7f
+		_, err := x.UnmarshalTrap(bad, false)
+		require.ErrorContains(t, err, "truncated packet", "length at %d", i)
+	}
+}
+
+func TestEngineIDConstructors(t *testing.T) {
+	id, err := EngineIDFromIP(8072, net.IPv4(192, 0, 2, 1))
+	require.NoError(t, err)
+	require.Equal(t, "\x80\x00\x1f\x88\x01\xc0\x00\x02\x01", id)
+
+	id, err = EngineIDFromIP(9, net.ParseIP("2001:db8::1"))
+	require.NoError(t, err)
+	require.Equal(t, "\x80\x00\x00\x09\x02\x20\x01\x0d\xb8"+strings.Repeat("\x00", 11)+"\x01", id)
+
+	id, err = EngineIDFromMAC(8072, net.HardwareAddr{0, 0x1b, 0x21, 0xaa, 0xbb, 0xcc})
+	require.NoError(t, err)
+	require.Equal(t, "\x80\x00\x1f\x88\x03\x00\x1b\x21\xaa\xbb\xcc", id)
+
+	id, err = EngineIDFromText(8072, "gosnmp")
+	require.NoError(t, err)
+	require.Equal(t, "\x80\x00\x1f\x88\x04gosnmp", id)
+
+	_, err = EngineIDFromText(8072, strings.Repeat("x", 28))
+	require.Error(t, err)
+	_, err = EngineIDFromText(8072, "")
+	require.Error(t, err)
+	_, err = EngineIDFromMAC(8072, net.HardwareAddr{1, 2, 3})
+	require.Error(t, err)
+	_, err = EngineIDFromIP(8072, nil)
+	require.Error(t, err)
+	_, err = EngineIDFromIP(1<<31, net.IPv4(192, 0, 2, 1))
+	require.Error(t, err)
+}
+
+func TestSendTrapDefaultEngineID(t *testing.T) {
+	srvr, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer srvr.Close()
+
+	x := &GoSNMP{
+		Version:       Version3,
+		Target:        "127.0.0.1",
+		Port:          uint16(srvr.LocalAddr().(*net.UDPAddr).Port),
+		Timeout:       time.Second,
+		MsgFlags:      AuthNoPriv,
+		SecurityModel: UserSecurityModel,
+		SecurityParameters: &UsmSecurityParameters{
+			UserName:                 "u",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "maplesyrup",
+		},
+	}
+	require.NoError(t, x.Connect())
+	defer x.Close()
+	_, err = x.SendTrap(SnmpTrap{Variables: []SnmpPDU{{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: ObjectIdentifier, Value: ".1.3.6.1.6.3.1.1.5.1"}}})
+	require.NoError(t, err)
+
+	// the first message is the trap, not a discovery request
+	buf := make([]byte, rxBufSize)
+	require.NoError(t, srvr.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err := srvr.ReadFrom(buf)
+	require.NoError(t, err)
+
+	want, err := EngineIDFromIP(8072, net.IPv4(127, 0, 0, 1))
+	require.NoError(t, err)
+	receiver := &GoSNMP{
+		Version:       Version3,
+		MsgFlags:      AuthNoPriv,
+		SecurityModel: UserSecurityModel,
+		SecurityParameters: &UsmSecurityParameters{
+			AuthoritativeEngineID:    want,
+			UserName:                 "u",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "maplesyrup",
+		},
+		Logger: NewLogger(log.New(io.Discard, "", 0)),
+	}
+	trap, err := receiver.UnmarshalTrap(buf[:n], false)
+	require.NoError(t, err)
+	require.Equal(t, SNMPv2Trap, trap.PDUType)
+	require.Equal(t, want, trap.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineID)
+
+	// the caller's parameters are left as they were, eg for an inform
+	require.Empty(t, x.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineID)
+}
+
+func TestKnownEngineSkipsDiscovery(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	var boots atomic.Uint32
+	var probes atomic.Int32
+	boots.Store(7)
+	engineID := "\x80\x00\x1f\x88\x04known"
+	go usmAgent(t, conn, engineID, &boots, &probes)
+
+	for _, known := range []uint32{7, 6} {
+		g, err := NewGoSNMP("127.0.0.1",
+			WithPort(uint16(conn.LocalAddr().(*net.UDPAddr).Port)),
+			WithTimeout(time.Second),
+			WithV3User("user", NoAuth, "", NoPriv, ""),
+			WithV3Engine(engineID, known, 100),
+			WithLogger(NewLogger(log.New(io.Discard, "", 0))))
+		require.NoError(t, err)
+		require.NoError(t, g.Connect())
+
+		// boots 6 is out of date: the notInTimeWindow report resyncs
+		result, err := g.Get([]string{".1.3.6.1.2.1.1.5.0"})
+		require.NoError(t, err, "boots %d", known)
+		require.Equal(t, GetResponse, result.PDUType)
+		require.Equal(t, uint32(7), g.SecurityParameters.(*UsmSecurityParameters).AuthoritativeEngineBoots)
+		require.Equal(t, int32(0), probes.Load(), "no discovery probe")
+		g.Close()
+	}
+
+	_, err = NewGoSNMP("127.0.0.1", WithV3Engine(engineID, 1, 1))
+	require.Error(t, err)
+}
+
+func TestInsecureSkipAuthVerify(t *testing.T) {
+	msg, x := authTrap(t, NoPriv)
+	trap, err := x.UnmarshalTrap(append([]byte(nil), msg...), false)
+	require.NoError(t, err)
+	require.NoError(t, trap.AuthErr)
+
+	// the sysUpTime value, covered by the digest, is changed
+	tampered := append([]byte(nil), msg...)
+	tampered[len(tampered)-1]++
+	_, err = x.UnmarshalTrap(append([]byte(nil), tampered...), false)
+	require.ErrorIs(t, err, ErrWrongDigest)
+
+	x.InsecureSkipAuthVerify = true
+	trap, err = x.UnmarshalTrap(append([]byte(nil), tampered...), false)
+	require.NoError(t, err)
+	require.ErrorIs(t, trap.AuthErr, ErrWrongDigest)
+	require.Equal(t, uint32(2), trap.Variables[0].Value)
+}
+
+func TestGetNoAuthNoPriv(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	engineID := "\x80\x00\x1f\x88\x04public"
+	var boots atomic.Uint32
+	var probes atomic.Int32
+	go usmAgent(t, conn, engineID, &boots, &probes)
+
+	g, err := NewGoSNMP("127.0.0.1",
+		WithPort(uint16(conn.LocalAddr().(*net.UDPAddr).Port)),
+		WithTimeout(time.Second),
+		WithV3User("admin", SHA, "authpassphrase", AES, "privpassphrase"),
+		WithLogger(NewLogger(log.New(io.Discard, "", 0))))
+	require.NoError(t, err)
+	require.NoError(t, g.Connect())
+	defer g.Close()
+	admin := g.SecurityParameters
+
+	result, err := g.GetNoAuthNoPriv("guest", []string{".1.3.6.1.2.1.1.5.0"})
+	require.NoError(t, err)
+	require.Equal(t, "guest", result.SecurityParameters.(*UsmSecurityParameters).UserName)
+	require.Equal(t, NoAuthNoPriv, result.MsgFlags&AuthPriv)
+	require.Equal(t, int32(1), probes.Load())
+
+	// the authPriv user is left for the requests that follow, with the
+	// engine discovered meanwhile
+	require.Same(t, admin, g.SecurityParameters)
+	require.Equal(t, AuthPriv|Reportable, g.MsgFlags)
+	require.Equal(t, engineID, admin.(*UsmSecurityParameters).AuthoritativeEngineID)
+
+	_, err = g.GetNoAuthNoPriv("", []string{".1.3.6.1.2.1.1.5.0"})
+	require.Error(t, err)
+	require.Same(t, admin, g.SecurityParameters)
+
+	// several users at once, each answered as itself
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func(user string) {
+			result, err := g.GetNoAuthNoPriv(user, []string{".1.3.6.1.2.1.1.5.0"})
+			if err == nil && result.SecurityParameters.(*UsmSecurityParameters).UserName != user {
+				err = fmt.Errorf("%s answered as %s", user, result.SecurityParameters.(*UsmSecurityParameters).UserName)
+			}
+			errs <- err
+		}(fmt.Sprintf("guest%d", i))
+	}
+	for i := 0; i < cap(errs); i++ {
+		require.NoError(t, <-errs)
+	}
+	require.Same(t, admin, g.SecurityParameters)
+	require.Equal(t, int32(1), probes.Load())
+}
+
+// lossyRelay relays messages to agent and back, passing on the nth the
+// manager sends only if forward(n).
+func lossyRelay(t *testing.T, agent net.Addr, forward func(n int) bool) net.PacketConn {
+	front, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	back, err := net.Dial("udp4", agent.String())
+	require.NoError(t, err)
+	var client atomic.Value
+	go func() {
+		defer back.Close()
+		buf := make([]byte, rxBufSize)
+		for n := 1; ; n++ {
+			size, addr, err := front.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			client.Store(addr)
+			if forward(n) {
+				_, _ = back.Write(buf[:size])
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, rxBufSize)
+		for {
+			size, err := back.Read(buf)
+			if err != nil {
+				return
+			}
+			_, _ = front.WriteTo(buf[:size], client.Load().(net.Addr))
+		}
+	}()
+	return front
+}
+
+func TestDiscoverySharesRequestBudget(t *testing.T) {
+	agentConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer agentConn.Close()
+	var boots atomic.Uint32
+	var probes atomic.Int32
+	go usmAgent(t, agentConn, "\x80\x00\x1f\x88\x04budget", &boots, &probes)
+
+	// lose the first discovery probe and every request after the second,
+	// the one discovery succeeds with
+	front := lossyRelay(t, agentConn.LocalAddr(), func(n int) bool { return n == 2 })
+	defer front.Close()
+
+	timeout := 300 * time.Millisecond
+	g, err := NewGoSNMP("127.0.0.1",
+		WithPort(uint16(front.LocalAddr().(*net.UDPAddr).Port)),
+		WithTimeout(timeout), WithRetries(1),
+		WithV3User("poller", NoAuth, "", NoPriv, ""),
+		WithLogger(NewLogger(log.New(io.Discard, "", 0))))
+	require.NoError(t, err)
+	require.NoError(t, g.Connect())
+	defer g.Close()
+
+	start := time.Now()
+	_, err = g.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	elapsed := time.Since(start)
+	require.ErrorContains(t, err, "request timeout")
+	require.Equal(t, int32(1), probes.Load(), "discovery succeeded on its retry")
+	// the time the request alone may take, not a timeout more for the
+	// discovery probe lost
+	budget := g.requestBudget(g.Context)
+	require.Equal(t, timeout+2*timeout, budget, "a timeout and an exponential retry")
+	require.Less(t, elapsed, budget+timeout/2)
+}
+
+func TestReportRetransmitSharesRequestBudget(t *testing.T) {
+	agentConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer agentConn.Close()
+	var boots atomic.Uint32
+	var probes atomic.Int32
+	boots.Store(1)
+	go usmAgent(t, agentConn, "\x80\x00\x1f\x88\x04report", &boots, &probes)
+
+	// after discovery and a first request, lose a request, pass on its
+	// retry, answered with a notInTimeWindows report, and lose the rest
+	front := lossyRelay(t, agentConn.LocalAddr(), func(n int) bool { return n <= 2 || n == 4 })
+	defer front.Close()
+
+	timeout := 300 * time.Millisecond
+	g, err := NewGoSNMP("127.0.0.1",
+		WithPort(uint16(front.LocalAddr().(*net.UDPAddr).Port)),
+		WithTimeout(timeout), WithRetries(1),
+		WithV3User("poller", NoAuth, "", NoPriv, ""),
+		WithLogger(NewLogger(log.New(io.Discard, "", 0))))
+	require.NoError(t, err)
+	require.NoError(t, g.Connect())
+	defer g.Close()
+
+	_, err = g.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.NoError(t, err)
+	boots.Store(2)
+
+	start := time.Now()
+	_, err = g.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	elapsed := time.Since(start)
+	require.ErrorIs(t, err, ErrNotInTimeWindow)
+	// the retransmission has what the request left, not a budget anew
+	require.Less(t, elapsed, g.requestBudget(g.Context)+timeout/2)
+}
+
+func TestDiscoveryRetries(t *testing.T) {
+	agentConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer agentConn.Close()
+	var boots atomic.Uint32
+	var probes atomic.Int32
+	go usmAgent(t, agentConn, "\x80\x00\x1f\x88\x04retries", &boots, &probes)
+
+	// lose two discovery probes, then answer all but the fifth message
+	var sent atomic.Int32
+	front := lossyRelay(t, agentConn.LocalAddr(), func(n int) bool {
+		sent.Store(int32(n))
+		return n > 2 && n != 5
+	})
+	defer front.Close()
+
+	g, err := NewGoSNMP("127.0.0.1",
+		WithPort(uint16(front.LocalAddr().(*net.UDPAddr).Port)),
+		WithTimeout(100*time.Millisecond), WithRetries(0), WithDiscoveryRetries(3),
+		WithV3User("poller", NoAuth, "", NoPriv, ""),
+		WithLogger(NewLogger(log.New(io.Discard, "", 0))))
+	require.NoError(t, err)
+	require.NoError(t, g.Connect())
+	defer g.Close()
+
+	_, err = g.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.NoError(t, err, "discovery retried past the lost probes")
+	require.Equal(t, int32(4), sent.Load())
+
+	// the lost request is not retried
+	_, err = g.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	require.ErrorContains(t, err, "request timeout")
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, int32(5), sent.Load())
+
+	require.Error(t, WithDiscoveryRetries(-1)(g))
+}
+
+func TestDiscoveryRetriesBudget(t *testing.T) {
+	agentConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer agentConn.Close()
+	var boots atomic.Uint32
+	var probes atomic.Int32
+	boots.Store(1)
+	go usmAgent(t, agentConn, "\x80\x00\x1f\x88\x04both", &boots, &probes)
+
+	// lose a discovery probe and two requests, the agent restarting
+	// meanwhile; the third is answered with a notInTimeWindows report and
+	// the retransmission after it is lost
+	front := lossyRelay(t, agentConn.LocalAddr(), func(n int) bool {
+		if n == 3 {
+			boots.Store(2)
+		}
+		return n == 2 || n == 5
+	})
+	defer front.Close()
+
+	timeout := 150 * time.Millisecond
+	g, err := NewGoSNMP("127.0.0.1",
+		WithPort(uint16(front.LocalAddr().(*net.UDPAddr).Port)),
+		WithTimeout(timeout), WithRetries(2), WithDiscoveryRetries(1),
+		WithV3User("poller", NoAuth, "", NoPriv, ""),
+		WithLogger(NewLogger(log.New(io.Discard, "", 0))))
+	require.NoError(t, err)
+	g.ExponentialTimeout = false
+	require.NoError(t, g.Connect())
+	defer g.Close()
+
+	start := time.Now()
+	_, err = g.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	elapsed := time.Since(start)
+	require.ErrorIs(t, err, ErrNotInTimeWindow)
+	// discovery and request have their budgets together, no more
+	budget := g.requestBudget(g.discoveryContext(g.Context)) + g.requestBudget(g.Context)
+	require.Equal(t, 5*timeout, budget)
+	require.Less(t, elapsed, budget+timeout/2)
+	require.Greater(t, elapsed, budget-timeout/2)
+}
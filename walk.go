@@ -5,11 +5,41 @@
 package gosnmp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-func (x *GoSNMP) walk(getRequestType PDUType, rootOid string, walkFn WalkFunc) error {
+// ErrOIDNotIncreasing is returned by the walks when the agent answers with
+// an OID that does not come after the one before it, as some buggy agents
+// do, which would otherwise walk forever. Set AppOpts "c" to walk such an
+// agent anyway.
+var ErrOIDNotIncreasing = errors.New("OID not increasing")
+
+// OIDCheckPolicy is what the walks do with OIDs that do not increase, see
+// GoSNMP.OIDCheck.
+type OIDCheckPolicy int
+
+const (
+	// OIDCheckStrict fails the walk with ErrOIDNotIncreasing.
+	OIDCheckStrict OIDCheckPolicy = iota
+	// OIDCheckTolerant skips the variable and walks on from the greatest
+	// OID received, for agents that repeat a row now and then. A response
+	// with no OID past that one is asked again once; if the next one has
+	// none either the walk fails with ErrOIDNotIncreasing.
+	OIDCheckTolerant
+	// OIDCheckOff reports every variable, leaving it to the WalkFunc to
+	// end the walk of an agent that loops.
+	OIDCheckOff
+)
+
+func (x *GoSNMP) walk(ctx context.Context, getRequestType PDUType, rootOid string, walkFn WalkFunc) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	if rootOid == "" || rootOid == "." {
 		rootOid = baseOid
 	}
@@ -17,6 +47,8 @@ func (x *GoSNMP) walk(getRequestType PDUType, rootOid string, walkFn WalkFunc) e
 	if !strings.HasPrefix(rootOid, ".") {
 		rootOid = string(".") + rootOid
 	}
+	// compared with the names of responses, which are in x.OIDFormat
+	rootOid = x.OIDFormat.Format(strings.TrimSuffix(rootOid, "."))
 
 	oid := rootOid
 	requests := 0
@@ -24,19 +56,22 @@ func (x *GoSNMP) walk(getRequestType PDUType, rootOid string, walkFn WalkFunc) e
 	if maxReps == 0 {
 		maxReps = defaultMaxRepetitions
 	}
+	ceiling := maxReps // adapted within this walk only, see AdaptiveBulkSize
 
-	// AppOpt 'c: do not check returned OIDs are increasing'
-	checkIncreasing := true
-	if x.AppOpts != nil {
-		if _, ok := x.AppOpts["c"]; ok {
-			if getRequestType == GetBulkRequest || getRequestType == GetNextRequest {
-				checkIncreasing = false
-			}
-		}
+	if getRequestType == GetBulkRequest && x.Version == Version1 {
+		// SNMPv1 has no GETBULK
+		getRequestType = GetNextRequest
 	}
 
+	// AppOpt 'c: do not check returned OIDs are increasing'
+	check := x.oidCheck()
+	stalled := false // the last response had nothing new, for OIDCheckTolerant
+
 RequestLoop:
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		requests++
 
 		var response *SnmpPacket
@@ -44,11 +79,12 @@ RequestLoop:
 
 		switch getRequestType {
 		case GetBulkRequest:
-			response, err = x.GetBulk([]string{oid}, uint8(x.NonRepeaters), maxReps)
+			atomic.StoreUint32(&x.bulkRepetitions, maxReps)
+			response, err = x.GetBulkContext(ctx, []string{oid}, uint8(x.NonRepeaters), maxReps)
 		case GetNextRequest:
-			response, err = x.GetNext([]string{oid})
+			response, err = x.GetNextContext(ctx, []string{oid})
 		case GetRequest:
-			response, err = x.Get([]string{oid})
+			response, err = x.GetContext(ctx, []string{oid})
 		default:
 			response, err = nil, fmt.Errorf("unsupported request type: %d", getRequestType)
 		}
@@ -60,6 +96,24 @@ RequestLoop:
 			break RequestLoop
 		}
 
+		if getRequestType == GetBulkRequest && response.Error != NoError {
+			// Ask for fewer repetitions while the response is tooBig, and
+			// walk with GETNEXT if the agent fails GETBULK otherwise. The
+			// resent request stands in for this one.
+			requests--
+			if response.Error == TooBig && maxReps > 1 {
+				if x.AdaptiveBulkSize > 0 && ceiling >= maxReps {
+					ceiling = maxReps - 1
+				}
+				maxReps /= 2
+				x.Logger.Printf("BulkWalk tooBig, retrying with max-repetitions %d", maxReps)
+				continue RequestLoop
+			}
+			x.Logger.Printf("BulkWalk failed with %s, walking with GetNext", response.Error)
+			getRequestType = GetNextRequest
+			continue RequestLoop
+		}
+
 		switch response.Error {
 		case TooBig:
 			x.Logger.Print("Walk terminated with TooBig")
@@ -119,8 +173,12 @@ RequestLoop:
 			x.Logger.Print("Walk completed with NoError")
 		}
 
+		prev := oid
 		for i, pdu := range response.Variables {
-			if pdu.Type == EndOfMibView || pdu.Type == NoSuchObject || pdu.Type == NoSuchInstance {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if pdu.IsException() {
 				x.Logger.Printf("BulkWalk terminated with type 0x%x", pdu.Type)
 				break RequestLoop
 			}
@@ -144,9 +202,16 @@ RequestLoop:
 				break RequestLoop
 			}
 
-			if checkIncreasing && pdu.Name == oid {
-				return fmt.Errorf("OID not increasing: %s", pdu.Name)
+			if check != OIDCheckOff && !oidLess(prev, pdu.Name) {
+				if check == OIDCheckStrict {
+					return fmt.Errorf("%w: %s after %s", ErrOIDNotIncreasing, pdu.Name, prev)
+				}
+				if x.Logger.enabled() {
+					x.Logger.Printf("Walk skipped %s, not after %s", pdu.Name, prev)
+				}
+				continue
 			}
+			prev = pdu.Name
 
 			// Report our pdu
 			if err := walkFn(pdu); err != nil {
@@ -154,14 +219,235 @@ RequestLoop:
 			}
 		}
 		// Save last oid for next request
-		oid = response.Variables[len(response.Variables)-1].Name
+		if check == OIDCheckOff {
+			oid = response.Variables[len(response.Variables)-1].Name
+		} else if prev != oid {
+			oid, stalled = prev, false
+		} else if stalled {
+			return fmt.Errorf("%w: nothing after %s", ErrOIDNotIncreasing, oid)
+		} else {
+			stalled = true
+		}
+		if getRequestType == GetBulkRequest {
+			if x.AdaptiveBulkSize > 0 {
+				maxReps = x.adaptRepetitions(maxReps, ceiling, response)
+			}
+			maxReps = x.capRepetitions(maxReps, response)
+		}
 	}
 	x.Logger.Printf("BulkWalk completed in %d requests", requests)
 	return nil
 }
 
-func (x *GoSNMP) walkAll(getRequestType PDUType, rootOid string) (results []SnmpPDU, err error) {
-	err = x.walk(getRequestType, rootOid, func(dataUnit SnmpPDU) error {
+func (x *GoSNMP) walkRange(ctx context.Context, startOid, stopOid string, walkFn WalkFunc) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	oid, stopOid := "."+strings.Trim(startOid, "."), "."+strings.Trim(stopOid, ".")
+	if !oidLess(oid, stopOid) {
+		return fmt.Errorf("stop OID %s does not come after start OID %s", stopOid, oid)
+	}
+
+	requests := 0
+	check, stalled := x.oidCheck(), false
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		requests++
+		response, err := x.GetNextContext(ctx, []string{oid})
+		if err != nil {
+			return err
+		}
+		if response.Error != NoError {
+			// SNMPv1 agents answer noSuchName past the end of the MIB
+			x.Logger.Printf("WalkRange terminated with %s", response.Error)
+			break
+		}
+		if len(response.Variables) == 0 {
+			break
+		}
+		pdu := response.Variables[0]
+		if pdu.IsException() || !oidLess(pdu.Name, stopOid) {
+			break
+		}
+		if check != OIDCheckOff && !oidLess(oid, pdu.Name) {
+			if check == OIDCheckStrict || stalled {
+				return fmt.Errorf("%w: %s after %s", ErrOIDNotIncreasing, pdu.Name, oid)
+			}
+			if x.Logger.enabled() {
+				x.Logger.Printf("WalkRange skipped %s, not after %s", pdu.Name, oid)
+			}
+			stalled = true
+			continue
+		}
+		stalled = false
+		if err := walkFn(pdu); err != nil {
+			return err
+		}
+		oid = pdu.Name
+	}
+	x.Logger.Printf("WalkRange completed in %d requests", requests)
+	return nil
+}
+
+// oidCheck returns the OIDCheck policy of x, which AppOpts "c" turns off.
+func (x *GoSNMP) oidCheck() OIDCheckPolicy {
+	if _, ok := x.AppOpts["c"]; ok {
+		return OIDCheckOff
+	}
+	return x.OIDCheck
+}
+
+// msgSizeLimit returns the smaller of MaxMsgSize and the msgMaxSize the
+// agent advertised, or 0 if neither is known.
+func (x *GoSNMP) msgSizeLimit() int {
+	limit := int(x.MaxMsgSize)
+	if agent := int(atomic.LoadUint32(&x.agentMaxMsgSize)); agent != 0 && (limit == 0 || agent < limit) {
+		limit = agent
+	}
+	return limit
+}
+
+// BulkRepetitions returns the max-repetitions of the latest GetBulk request
+// of BulkWalk*, which with AdaptiveBulkSize is the value it adapted to, or
+// 0 before the first. With walks running concurrently it is that of
+// whichever sent last; the request of each is passed to OnResponse.
+func (x *GoSNMP) BulkRepetitions() uint32 {
+	return atomic.LoadUint32(&x.bulkRepetitions)
+}
+
+// adaptRepetitions returns the max-repetitions for the GetBulk following
+// one of maxReps answered with response: as many variables of the size of
+// its own as fit in AdaptiveBulkSize, but at most twice maxReps, as the
+// next ones may be larger, and at most ceiling.
+func (x *GoSNMP) adaptRepetitions(maxReps, ceiling uint32, response *SnmpPacket) uint32 {
+	overhead, err := x.mkSnmpPacket(GetResponse, nil, 0, 0).requestOverhead()
+	if err != nil {
+		return maxReps
+	}
+	if x.Version == Version3 {
+		overhead += v3HeaderAllowance
+	}
+	fit := uint32(repetitionsFitting(x.AdaptiveBulkSize-overhead, response))
+	if fit == 0 {
+		return maxReps
+	}
+	if fit > 2*maxReps {
+		fit = 2 * maxReps
+	}
+	if fit > ceiling {
+		fit = ceiling
+	}
+	if fit != maxReps {
+		x.Logger.Printf("adapting max-repetitions from %d to %d for AdaptiveBulkSize %d", maxReps, fit, x.AdaptiveBulkSize)
+	}
+	return fit
+}
+
+// repetitionsFitting returns how many variables the size of those of
+// response on average fit in room octets, at least 1, or 0 if it cannot
+// tell. The size is that of the VarBinds as they were received, rather
+// than of each marshalled again.
+func repetitionsFitting(room int, response *SnmpPacket) int {
+	if len(response.Variables) == 0 || response.varbindsLen <= 0 {
+		return 0
+	}
+	fit := room * len(response.Variables) / response.varbindsLen
+	if fit < 1 {
+		fit = 1
+	}
+	return fit
+}
+
+// capRepetitions lowers maxReps so that a GetBulk response of variables
+// the size of those of response fits in msgSizeLimit.
+func (x *GoSNMP) capRepetitions(maxReps uint32, response *SnmpPacket) uint32 {
+	limit := x.msgSizeLimit()
+	if limit == 0 {
+		return maxReps
+	}
+	fit := repetitionsFitting(limit-v3HeaderAllowance, response)
+	if fit == 0 {
+		return maxReps
+	}
+	if uint32(fit) < maxReps {
+		x.Logger.Printf("lowering max-repetitions to %d to fit msgMaxSize %d", fit, limit)
+		return uint32(fit)
+	}
+	return maxReps
+}
+
+func (x *GoSNMP) walkAllParallel(ctx context.Context, rootOids []string, concurrency int) (map[string][]SnmpPDU, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if concurrency <= 0 || concurrency > len(rootOids) {
+		concurrency = len(rootOids)
+	}
+	if x.mux == nil {
+		// not set up by Connect(): requests cannot share the socket
+		concurrency = 1
+	}
+	var (
+		mu      sync.Mutex
+		results = make(map[string][]SnmpPDU, len(rootOids))
+		errs    []error
+		wg      sync.WaitGroup
+	)
+	slots := make(chan struct{}, concurrency)
+	for _, rootOid := range rootOids {
+		slots <- struct{}{}
+		mu.Lock()
+		failed := len(errs) > 0
+		mu.Unlock()
+		if failed {
+			break
+		}
+		wg.Add(1)
+		go func(rootOid string) {
+			defer func() { <-slots; wg.Done() }()
+			pdus, err := x.walkAll(ctx, GetBulkRequest, rootOid)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("walk of %s: %w", rootOid, err))
+				return
+			}
+			results[rootOid] = pdus
+		}(rootOid)
+	}
+	wg.Wait()
+	return results, errors.Join(errs...)
+}
+
+func (x *GoSNMP) walkChan(ctx context.Context, getRequestType PDUType, rootOid string) (<-chan SnmpPDU, func() error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	pdus := make(chan SnmpPDU)
+	done := make(chan struct{})
+	var err error
+	go func() {
+		defer close(done)
+		defer close(pdus)
+		err = x.walk(ctx, getRequestType, rootOid, func(pdu SnmpPDU) error {
+			select {
+			case pdus <- pdu:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return pdus, func() error {
+		<-done
+		return err
+	}
+}
+
+func (x *GoSNMP) walkAll(ctx context.Context, getRequestType PDUType, rootOid string) (results []SnmpPDU, err error) {
+	err = x.walk(ctx, getRequestType, rootOid, func(dataUnit SnmpPDU) error {
 		results = append(results, dataUnit)
 		return nil
 	})